@@ -0,0 +1,83 @@
+package h265_transcoder
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// loopbackNetworks are networks unit transcoders connect to rtsp server from
+var loopbackNetworks = conf.IPNetworks{
+	{IP: net.IPv4(127, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+}
+
+// newSecret returns random key unit credentials are derived from
+func newSecret() []byte {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// unitCredential returns rtsp server credential of unit transcoder,
+// it's derived from instance secret, so it's the same for the unit until service restart
+func (instance *Instance) unitCredential(id string) *url.Userinfo {
+	mac := hmac.New(sha256.New, instance.secret)
+	mac.Write([]byte(id))
+	sum := hex.EncodeToString(mac.Sum(nil))
+
+	return url.UserPassword("unit-"+sum[:16], sum[16:])
+}
+
+// unitUser returns rtsp server user of unit transcoder, which is allowed to publish unit and renditions paths
+// and to read unit ingest path from loopback only
+func unitUser(src Source) conf.AuthInternalUser {
+	pass, _ := src.user.Password()
+
+	u := conf.AuthInternalUser{
+		User: conf.Credential(src.user.Username()),
+		Pass: conf.Credential(pass),
+		IPs:  loopbackNetworks,
+		Permissions: []conf.AuthInternalUserPermission{
+			{Action: conf.AuthActionPublish, Path: src.id},
+		},
+	}
+
+	for _, r := range src.renditions {
+		u.Permissions = append(u.Permissions, conf.AuthInternalUserPermission{Action: conf.AuthActionPublish, Path: renditionPath(src.id, r.name)})
+	}
+
+	if src.ingest {
+		u.Permissions = append(u.Permissions, conf.AuthInternalUserPermission{Action: conf.AuthActionRead, Path: ingestPath(src.id)})
+	}
+
+	return u
+}
+
+// validateUsers checks that regular expressions of permission paths compile
+func validateUsers(users conf.AuthInternalUsers) error {
+	for _, u := range users {
+		for _, p := range u.Permissions {
+			if !strings.HasPrefix(p.Path, "~") {
+				continue
+			}
+
+			_, err := regexp.Compile(p.Path[1:])
+			if err != nil {
+				return fmt.Errorf("user '%s': invalid path '%s': %w", u.User, p.Path, err)
+			}
+		}
+	}
+
+	return nil
+}
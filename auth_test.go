@@ -0,0 +1,398 @@
+package h265_transcoder
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fearpro13/h265_transcoder/mediamtx/auth"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/core"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestUnitCredentialArgs(t *testing.T) {
+	instance := &Instance{secret: []byte("secret")}
+
+	source, err := NewSource("1", "rtsp://0.0.0.0:9222/1/ingest", "rtsp://0.0.0.0:9222/1", DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.ingest = true
+	source.user = instance.unitCredential("1")
+
+	if source.user.String() != instance.unitCredential("1").String() || source.user.String() == instance.unitCredential("2").String() {
+		t.Fatal("unit credential must be stable and unique")
+	}
+
+	args, err := source.Args()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userinfo := source.user.String()
+	if !slices.Contains(args, "rtsp://"+userinfo+"@0.0.0.0:9222/1/ingest") || !slices.Contains(args, "rtsp://"+userinfo+"@0.0.0.0:9222/1") {
+		t.Fatalf("credential is not added: %v", args)
+	}
+
+	// reported urls are kept as is
+	if source.from.User != nil || source.to.User != nil {
+		t.Fatal("source urls are modified")
+	}
+
+	// pulled source is not given unit credential
+	source.ingest = false
+	args, _ = source.Args()
+	if !slices.Contains(args, "rtsp://0.0.0.0:9222/1/ingest") {
+		t.Fatalf("credential is added to pulled source: %v", args)
+	}
+}
+
+func TestInternalUsers(t *testing.T) {
+	port := test.FreeTCPPort(t)
+
+	h := core.NewRtspHandler(context.Background(), uint16(port), false)
+	h.SetInternalUsers([]conf.AuthInternalUser{{
+		User:        "any",
+		Permissions: []conf.AuthInternalUserPermission{{Action: conf.AuthActionRead, Path: "~^cam[0-9]$"}},
+	}})
+
+	err := h.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	for _, p := range []string{"cam1", "cam2"} {
+		err = h.AddPath(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publish := func(path string, user *url.Userinfo) error {
+		u := url.URL{Scheme: "rtsp", Host: fmt.Sprintf("127.0.0.1:%d", port), Path: "/" + path, User: user}
+
+		c := gortsplib.Client{}
+		err := c.StartRecording(u.String(), &description.Session{Medias: []*description.Media{test.MediaH264}})
+		if err == nil {
+			c.Close()
+		}
+		return err
+	}
+
+	instance := &Instance{secret: newSecret()}
+	src, err := NewSource("cam1", "rtsp://10.0.0.5/stream1", "", DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.user = instance.unitCredential("cam1")
+
+	if publish("cam1", nil) == nil {
+		t.Fatal("anonymous publisher has not been rejected")
+	}
+
+	if publish("cam1", src.user) == nil {
+		t.Fatal("unknown user has not been rejected")
+	}
+
+	h.SetServiceUser("cam1", unitUser(src))
+
+	err = publish("cam1", src.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if publish("cam2", src.user) == nil {
+		t.Fatal("unit user has published another unit")
+	}
+
+	h.RemoveServiceUser("cam1")
+
+	if publish("cam1", src.user) == nil {
+		t.Fatal("removed user has not been rejected")
+	}
+}
+
+// setUnitUser allows path of unit id to be published with returned credential, like unit transcoders are
+func setUnitUser(h *core.RtspHandler, id string) string {
+	src := Source{id: id, user: url.UserPassword("unit-"+id, "secret")}
+	h.SetServiceUser(id, unitUser(src))
+	return src.user.String()
+}
+
+func TestDefaultUsers(t *testing.T) {
+	h := core.NewRtspHandler(context.Background(), uint16(test.FreeTCPPort(t)), false)
+
+	err := h.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	for _, c := range []struct {
+		action  conf.AuthAction
+		path    string
+		allowed bool
+	}{
+		{conf.AuthActionRead, "cam1", true},
+		{conf.AuthActionPlayback, "cam1", true},
+		{conf.AuthActionPublish, "cam1/ingest", true},
+		{conf.AuthActionPublish, "cam1", false},
+		{conf.AuthActionPublish, "cam1/720p", false},
+		{conf.AuthActionAPI, APIScopeAdmin, true},
+		{conf.AuthActionMetrics, "", true},
+	} {
+		err = h.Authenticate(&auth.Request{IP: net.ParseIP("192.168.1.5"), Action: c.action, Path: c.path})
+		if (err == nil) != c.allowed {
+			t.Errorf("%s %s: unexpected result %v", c.action, c.path, err)
+		}
+	}
+}
+
+func TestLoadConfigUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+
+	err := os.WriteFile(path, []byte(`
+authInternalUsers:
+  - user: camera
+    pass: secret
+    ips: [10.0.0.0/24]
+    permissions:
+      - action: publish
+        path: ~/ingest$
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := cfg.InternalUsers()
+	if len(users) != 1 || users[0].User != "camera" || !users[0].IPs.Contains(net.ParseIP("10.0.0.7")) ||
+		users[0].Permissions[0].Action != conf.AuthActionPublish {
+		t.Fatalf("unexpected users %+v", users)
+	}
+
+	if len((&Config{}).InternalUsers()) != len(core.DefaultUsers) {
+		t.Fatal("missing users must allow anyone")
+	}
+
+	err = os.WriteFile(path, []byte(`
+authInternalUsers:
+  - user: any
+    permissions:
+      - action: read
+        path: ~cam(
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadConfig(path, Config{})
+	if err == nil {
+		t.Fatal("invalid path expression must be rejected")
+	}
+
+	err = os.WriteFile(path, []byte(`
+authMethod: jwt
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadConfig(path, Config{})
+	if err == nil {
+		t.Fatal("jwt method without key set must be rejected")
+	}
+
+	cfg, err = LoadConfig(path, Config{AuthJWTJWKS: "https://sso.local/jwks"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.AuthOptions().Method != conf.AuthMethodJWT {
+		t.Fatalf("unexpected auth method %v", cfg.AuthOptions().Method)
+	}
+}
+
+// startAuthHandler starts rtsp server with authentication method, path cam1 is published by unit transcoder user
+func startAuthHandler(t *testing.T, opts core.AuthOptions) (string, func()) {
+	port := test.FreeTCPPort(t)
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+
+	h := core.NewRtspHandler(context.Background(), uint16(port), false)
+	h.Auth = opts
+
+	err := h.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = h.AddPath("cam1")
+	if err != nil {
+		h.Stop()
+		t.Fatal(err)
+	}
+
+	instance := &Instance{secret: newSecret()}
+	src, err := NewSource("cam1", "rtsp://10.0.0.5/stream1", "", DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.user = instance.unitCredential("cam1")
+	h.SetServiceUser("cam1", unitUser(src))
+
+	// unit credential is accepted without external server
+	u := url.URL{Scheme: "rtsp", Host: addr, Path: "/cam1", User: src.user}
+	c := &gortsplib.Client{}
+	err = c.StartRecording(u.String(), &description.Session{Medias: []*description.Media{test.MediaH264}})
+	if err != nil {
+		h.Stop()
+		t.Fatal(err)
+	}
+
+	return addr, func() {
+		c.Close()
+		h.Stop()
+	}
+}
+
+func TestHTTPAuth(t *testing.T) {
+	var (
+		requests []map[string]any
+		mutex    sync.Mutex
+	)
+
+	as := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		mutex.Lock()
+		requests = append(requests, req)
+		mutex.Unlock()
+
+		if req["user"] != "viewer" || req["password"] != "viewerpass" || req["action"] != "read" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer as.Close()
+
+	addr, stop := startAuthHandler(t, core.AuthOptions{Method: conf.AuthMethodHTTP, HTTPAddress: as.URL})
+	defer stop()
+
+	// the first request of client is sent without credentials
+	mutex.Lock()
+	for _, req := range requests {
+		if req["user"] != "" {
+			t.Fatalf("unit publisher has been checked by server: %v", req)
+		}
+	}
+	mutex.Unlock()
+
+	_, err := probeVideoCodec("rtsp://viewer:wrongpass@"+addr+"/cam1", 5*time.Second)
+	if err == nil {
+		t.Fatal("wrong password has been accepted")
+	}
+
+	codec, err := probeVideoCodec("rtsp://viewer:viewerpass@"+addr+"/cam1", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if codec != "H264" {
+		t.Fatalf("unexpected codec %s", codec)
+	}
+
+	mutex.Lock()
+	last := requests[len(requests)-1]
+	mutex.Unlock()
+
+	if last["path"] != "cam1" || last["protocol"] != "rtsp" || last["ip"] != "127.0.0.1" {
+		t.Fatalf("unexpected request %v", last)
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]any{{
+		"kty": "RSA",
+		"kid": "test",
+		"alg": "RS256",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+
+	ks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(jwks)
+	}))
+	defer ks.Close()
+
+	addr, stop := startAuthHandler(t, core.AuthOptions{Method: conf.AuthMethodJWT, JWTJWKS: ks.URL})
+	defer stop()
+
+	token := func(path string, signer *rsa.PrivateKey) string {
+		tk := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"sub":                  "viewer",
+			"exp":                  time.Now().Add(time.Hour).Unix(),
+			"mediamtx_permissions": []map[string]string{{"action": "read", "path": path}},
+		})
+		tk.Header["kid"] = "test"
+
+		signed, err := tk.SignedString(signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	_, err = probeVideoCodec("rtsp://"+addr+"/cam1", 5*time.Second)
+	if err == nil {
+		t.Fatal("reader without JWT has been accepted")
+	}
+
+	_, err = probeVideoCodec("rtsp://"+addr+"/cam1?jwt="+token("cam2", key), 5*time.Second)
+	if err == nil {
+		t.Fatal("JWT of another path has been accepted")
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = probeVideoCodec("rtsp://"+addr+"/cam1?jwt="+token("cam1", other), 5*time.Second)
+	if err == nil {
+		t.Fatal("JWT signed by unknown key has been accepted")
+	}
+
+	_, err = probeVideoCodec("rtsp://"+addr+"/cam1?jwt="+token("cam1", key), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
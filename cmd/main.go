@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fearpro13/h265_transcoder"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/core"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -21,6 +25,54 @@ func main() {
 	httpPort := flag.Uint64("http_port", 8222, "Http listening port")
 	ffmpegPath := flag.String("ex", "", "ffmpeg executable path")
 	udpFlag := flag.Bool("udp", false, "allow udp usage")
+	rtpAddress := flag.String("rtp_address", core.DefaultRTPAddress, "Listening address of rtsp server UDP RTP packets, its port must be even")
+	rtcpAddress := flag.String("rtcp_address", core.DefaultRTCPAddress, "Listening address of rtsp server UDP RTCP packets, its port must follow RTP port")
+	multicast := flag.Bool("multicast", false, "allow rtsp UDP-multicast readers")
+	multicastIPRange := flag.String("multicast_ip_range", "224.1.0.0/16", "Range of multicast groups assigned to paths")
+	multicastRTPPort := flag.Int("multicast_rtp_port", 8002, "Port of multicast RTP packets, must be even")
+	multicastRTCPPort := flag.Int("multicast_rtcp_port", 8003, "Port of multicast RTCP packets, must follow RTP port")
+	profilesPath := flag.String("profiles", "", "encoding profiles JSON file path")
+	retryAfter := flag.Int("retry_after", 10, "Seconds before first restart of failed unit, doubled on every consecutive failure, 0 disables restarts")
+	retryMax := flag.Int("retry_max", 300, "Maximum seconds between restarts of failed unit, 0 means unlimited")
+	stableAfter := flag.Int("stable_after", 120, "Seconds of unit running before its consecutive failures counter is reset")
+	statePath := flag.String("state", "", "Units state file path, units are restored from it on start")
+	stallTimeout := flag.Int("stall_timeout", 30, "Seconds without received frames before unit is considered stalled and restarted, 0 disables")
+	onDemandStartTimeout := flag.Int("on_demand_start_timeout", 20, "Seconds readers of on-demand unit wait for its transcoder to start publishing")
+	onDemandCloseAfter := flag.Int("on_demand_close_after", 10, "Seconds on-demand unit keeps running after its last reader leaves")
+	configPath := flag.String("config", "", "YAML configuration file path, reloaded on SIGHUP or file change")
+	hlsPort := flag.Uint64("hls_port", 0, "Http listening port of HLS server, 0 disables")
+	hlsAllowOrigin := flag.String("hls_allow_origin", "*", "Access-Control-Allow-Origin header of HLS server responses, credentials are allowed for explicit origin only")
+	hlsVariant := flag.String("hls_variant", "lowLatency", "HLS variant: mpegts, fmp4 or lowLatency")
+	recordPath := flag.String("record_path", "", "Units recording segments path template without extension, empty disables recording")
+	recordFormat := flag.String("record_format", "fmp4", "Recording segments format: fmp4 or mpegts")
+	recordSegmentDuration := flag.Int("record_segment_duration", 3600, "Seconds of recording segment")
+	recordDeleteAfter := flag.Int("record_delete_after", 86400, "Seconds recording segments are kept, 0 keeps them forever")
+	playbackPort := flag.Uint64("playback_port", 0, "Http listening port of recordings playback server, 0 disables")
+	playbackAllowOrigin := flag.String("playback_allow_origin", "*", "Access-Control-Allow-Origin header of playback server responses")
+	webrtcPort := flag.Uint64("webrtc_port", 0, "Http listening port of WebRTC(WHEP) server, 0 disables")
+	webrtcAllowOrigin := flag.String("webrtc_allow_origin", "*", "Access-Control-Allow-Origin header of WebRTC server responses")
+	webrtcUDPPort := flag.Uint64("webrtc_udp_port", 8189, "UDP port shared by every WebRTC session")
+	webrtcAdditionalHosts := flag.String("webrtc_additional_hosts", "", "Comma separated IPs or hostnames advertised to WebRTC clients besides IPs of interfaces")
+	rtmpPort := flag.Uint64("rtmp_port", 0, "Listening port of RTMP server, 0 disables")
+	srtPort := flag.Uint64("srt_port", 0, "UDP listening port of SRT server, 0 disables")
+	srtReadPassphrase := flag.String("srt_read_passphrase", "", "Passphrase of SRT readers connections encryption, 10 to 79 characters, empty disables encryption")
+
+	authMethod := flag.String("auth_method", "internal", "Authentication method of readers, publishers and control API: internal, http or jwt")
+	authHTTPAddress := flag.String("auth_http_address", "", "Url called with every reader, publisher and control API request when auth_method is http")
+	authJWTJWKS := flag.String("auth_jwt_jwks", "", "Url of JWT key set when auth_method is jwt")
+
+	encryption := flag.String("encryption", "no", "RTSPS server: no disables it, optional serves RTSP as well, strict limits RTSP server to loopback")
+	rtspsPort := flag.Uint64("rtsps_port", 9322, "Listening port of RTSPS server")
+	serverCert := flag.String("server_cert", "", "RTSPS server certificate PEM file path, reloaded on change")
+	serverKey := flag.String("server_key", "", "RTSPS server key PEM file path, reloaded on change")
+
+	apiEncryption := flag.Bool("api_encryption", false, "Serve control API over HTTPS")
+	apiServerCert := flag.String("api_server_cert", "", "Control API certificate PEM file path, reloaded on change")
+	apiServerKey := flag.String("api_server_key", "", "Control API key PEM file path, reloaded on change")
+	apiLocalhostOnly := flag.Bool("api_localhost_only", false, "Allow control API and metrics requests from localhost only")
+	apiTrustedProxies := flag.String("api_trusted_proxies", "", "Comma separated IPs or networks of proxies allowed to pass control API client address with X-Forwarded-For")
+
+	ingest := flag.String("ingest", "rtsp", "How transcoder outputs get into unit paths: rtsp or pipe(not supported on Windows)")
 
 	flag.Parse()
 
@@ -35,10 +87,111 @@ func main() {
 		}
 	}
 
-	os.Exit(run(*rtspPort, *httpPort, *ffmpegPath, *gpuArg, *udpFlag))
+	var variant conf.HLSVariant
+	err := variant.UnmarshalJSON([]byte(strconv.Quote(*hlsVariant)))
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	var format conf.RecordFormat
+	err = format.UnmarshalJSON([]byte(strconv.Quote(*recordFormat)))
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	var enc conf.Encryption
+	err = enc.UnmarshalJSON([]byte(strconv.Quote(*encryption)))
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	var method conf.AuthMethod
+	err = method.UnmarshalJSON([]byte(strconv.Quote(*authMethod)))
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	var trustedProxies conf.IPNetworks
+	proxies, _ := json.Marshal(splitList(*apiTrustedProxies))
+	err = trustedProxies.UnmarshalJSON(proxies)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	cfg := h265_transcoder.Config{
+		RTSPPort:     uint16(*rtspPort),
+		HTTPPort:     uint16(*httpPort),
+		FFMpegPath:   *ffmpegPath,
+		UDP:          *udpFlag,
+		RTPAddress:   strings.TrimSpace(*rtpAddress),
+		RTCPAddress:  strings.TrimSpace(*rtcpAddress),
+		State:        *statePath,
+		StallTimeout: conf.StringDuration(time.Duration(*stallTimeout) * time.Second),
+		RetryAfter:   conf.StringDuration(time.Duration(*retryAfter) * time.Second),
+		RetryMax:     conf.StringDuration(time.Duration(*retryMax) * time.Second),
+		StableAfter:  conf.StringDuration(time.Duration(*stableAfter) * time.Second),
+
+		OnDemandStartTimeout: conf.StringDuration(time.Duration(*onDemandStartTimeout) * time.Second),
+		OnDemandCloseAfter:   conf.StringDuration(time.Duration(*onDemandCloseAfter) * time.Second),
+
+		HLSPort:            uint16(*hlsPort),
+		HLSVariant:         variant,
+		HLSAllowOrigin:     *hlsAllowOrigin,
+		HLSSegmentCount:    7,
+		HLSSegmentDuration: conf.StringDuration(time.Second),
+		HLSPartDuration:    conf.StringDuration(200 * time.Millisecond),
+
+		RecordPath:            strings.TrimSpace(*recordPath),
+		RecordFormat:          format,
+		RecordPartDuration:    conf.StringDuration(time.Second),
+		RecordSegmentDuration: conf.StringDuration(time.Duration(*recordSegmentDuration) * time.Second),
+		RecordDeleteAfter:     conf.StringDuration(time.Duration(*recordDeleteAfter) * time.Second),
+
+		PlaybackPort:        uint16(*playbackPort),
+		PlaybackAllowOrigin: *playbackAllowOrigin,
+
+		WebRTCPort:            uint16(*webrtcPort),
+		WebRTCAllowOrigin:     *webrtcAllowOrigin,
+		WebRTCUDPPort:         uint16(*webrtcUDPPort),
+		WebRTCAdditionalHosts: splitList(*webrtcAdditionalHosts),
+
+		RTMPPort: uint16(*rtmpPort),
+
+		SRTPort:           uint16(*srtPort),
+		SRTReadPassphrase: *srtReadPassphrase,
+
+		Multicast:         *multicast,
+		MulticastIPRange:  strings.TrimSpace(*multicastIPRange),
+		MulticastRTPPort:  *multicastRTPPort,
+		MulticastRTCPPort: *multicastRTCPPort,
+
+		Encryption: enc,
+		RTSPSPort:  uint16(*rtspsPort),
+		ServerCert: strings.TrimSpace(*serverCert),
+		ServerKey:  strings.TrimSpace(*serverKey),
+
+		Ingest: h265_transcoder.IngestMode(strings.TrimSpace(*ingest)),
+
+		AuthMethod:      method,
+		AuthHTTPAddress: strings.TrimSpace(*authHTTPAddress),
+		AuthJWTJWKS:     strings.TrimSpace(*authJWTJWKS),
+
+		APIEncryption:     *apiEncryption,
+		APIServerCert:     strings.TrimSpace(*apiServerCert),
+		APIServerKey:      strings.TrimSpace(*apiServerKey),
+		APITrustedProxies: trustedProxies,
+		APILocalhostOnly:  *apiLocalhostOnly,
+	}
+
+	os.Exit(run(cfg, *gpuArg, *profilesPath, strings.TrimSpace(*configPath)))
 }
 
-func run(rtspPort uint64, httpPort uint64, ffmpegPath string, useGpu bool, allowUdp bool) int {
+func run(flagsCfg h265_transcoder.Config, useGpu bool, profilesPath string, configPath string) int {
 	if useGpu {
 		log.Println("Using GPU HW Acceleration")
 		h265_transcoder.TranscodeUseGPU = true
@@ -47,12 +200,37 @@ func run(rtspPort uint64, httpPort uint64, ffmpegPath string, useGpu bool, allow
 		return 1
 	}
 
+	cfg := &flagsCfg
+	if configPath == "" {
+		err := cfg.Validate()
+		if err != nil {
+			log.Printf("config: %s\n", err)
+			return 1
+		}
+	} else {
+		var err error
+		cfg, err = h265_transcoder.LoadConfig(configPath, flagsCfg)
+		if err != nil {
+			log.Printf("config: %s\n", err)
+			return 1
+		}
+
+		log.Printf("configuration file: %s\n", configPath)
+	}
+
+	allowUdp := cfg.UDP
+	ffmpegPath := cfg.FFMpegPath
+
 	if allowUdp {
 		log.Println("Rtsp server UDP connections are enabled")
 	} else {
 		log.Println("Rtsp server UDP connections are disabled")
 	}
 
+	if cfg.Multicast {
+		log.Printf("Rtsp server UDP-multicast readers are enabled, groups are taken from %s\n", cfg.MulticastIPRange)
+	}
+
 	ffmpegPath = strings.TrimSpace(ffmpegPath)
 	if ffmpegPath == "" {
 		log.Println("ffmpeg executable path is required")
@@ -72,10 +250,63 @@ func run(rtspPort uint64, httpPort uint64, ffmpegPath string, useGpu bool, allow
 	osig := make(chan os.Signal, 1)
 	signal.Notify(osig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
 	ctx, ctxF := context.WithCancel(context.Background())
 	defer ctxF()
 
-	instance := h265_transcoder.NewInstance(ctx, uint16(rtspPort), uint16(httpPort), 10, allowUdp)
+	instance := h265_transcoder.NewInstance(ctx, cfg.RTSPPort, cfg.HTTPPort, cfg.Backoff(), time.Duration(cfg.StallTimeout), allowUdp)
+
+	profilesPath = strings.TrimSpace(profilesPath)
+	if profilesPath != "" {
+		profiles, err := h265_transcoder.LoadProfiles(profilesPath)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+
+		for _, p := range profiles {
+			err = instance.AddProfile(p)
+			if err != nil {
+				log.Println(err)
+				return 1
+			}
+
+			log.Printf("encoding profile '%s' loaded\n", p.Name)
+		}
+	}
+
+	// profiles must be known before units are restored from state
+	for _, p := range cfg.Profiles {
+		err := instance.AddProfile(p)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+
+		log.Printf("encoding profile '%s' loaded\n", p.Name)
+	}
+
+	instance.SetHLS(cfg.HLSOptions())
+	instance.SetRecord(cfg.RecordOptions())
+	instance.SetPlayback(cfg.PlaybackOptions())
+	instance.SetWebRTC(cfg.WebRTCOptions())
+	instance.SetRTMP(cfg.RTMPOptions())
+	instance.SetSRT(cfg.SRTOptions())
+	instance.SetRTSPS(cfg.RTSPSOptions())
+	instance.SetIngest(cfg.Ingest)
+	instance.SetAuth(cfg.AuthOptions())
+	instance.SetInternalUsers(cfg.InternalUsers())
+	instance.SetControl(cfg.ControlOptions())
+	instance.SetUDP(cfg.UDPOptions())
+	instance.SetOnDemandTimeouts(time.Duration(cfg.OnDemandStartTimeout), time.Duration(cfg.OnDemandCloseAfter))
+
+	statePath := strings.TrimSpace(cfg.State)
+	if statePath != "" {
+		instance.SetStateFile(statePath)
+		log.Printf("units state file: %s\n", statePath)
+	}
 
 	err := instance.Start()
 	if err != nil {
@@ -84,12 +315,54 @@ func run(rtspPort uint64, httpPort uint64, ffmpegPath string, useGpu bool, allow
 		return 1
 	}
 
-	select {
-	case <-osig:
-	case <-instance.Done:
+	instance.ApplyConfig(cfg)
+
+	var changes <-chan struct{}
+	if configPath != "" {
+		changes = h265_transcoder.WatchConfigFile(ctx, configPath, 2*time.Second)
+	}
+
+	for {
+		select {
+		case <-osig:
+			return 0
+		case <-instance.Done:
+			return 0
+		case <-hup:
+		case <-changes:
+		}
+
+		if configPath == "" {
+			continue
+		}
+
+		newCfg, err := h265_transcoder.LoadConfig(configPath, flagsCfg)
+		if err != nil {
+			log.Printf("config: reload failed, keeping current configuration: %s\n", err)
+			continue
+		}
+
+		log.Printf("config: reloading %s\n", configPath)
+
+		keys := h265_transcoder.RestartRequired(cfg, newCfg)
+		if len(keys) > 0 {
+			log.Printf("config: changes of %s require service restart\n", strings.Join(keys, ", "))
+		}
+
+		instance.ApplyConfig(newCfg)
+		cfg = newCfg
 	}
+}
 
-	return 0
+// splitList returns non-empty trimmed items of comma separated list
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 func testRunFFMpeg(path string) error {
@@ -0,0 +1,696 @@
+package h265_transcoder
+
+import (
+	"context"
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/conf/yaml"
+	"fearpro13/h265_transcoder/mediamtx/core"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/gohlslib"
+)
+
+// Config is the service configuration, declared by flags and optionally by a YAML file
+type Config struct {
+	RTSPPort     uint16              `json:"rtspPort"`
+	HTTPPort     uint16              `json:"httpPort"`
+	FFMpegPath   string              `json:"ffmpeg"`
+	UDP          bool                `json:"udp"`
+	State        string              `json:"state"`
+	StallTimeout conf.StringDuration `json:"stallTimeout"`
+	RetryAfter   conf.StringDuration `json:"retryAfter"`
+	RetryMax     conf.StringDuration `json:"retryMax"`
+	StableAfter  conf.StringDuration `json:"stableAfter"`
+	Profiles     []Profile           `json:"profiles"`
+	Units        []UnitConfig        `json:"units"`
+
+	OnDemandStartTimeout conf.StringDuration `json:"onDemandStartTimeout"`
+	OnDemandCloseAfter   conf.StringDuration `json:"onDemandCloseAfter"`
+
+	// HLSPort 0 disables HLS server
+	HLSPort            uint16              `json:"hlsPort"`
+	HLSVariant         conf.HLSVariant     `json:"hlsVariant"`
+	HLSSegmentCount    int                 `json:"hlsSegmentCount"`
+	HLSSegmentDuration conf.StringDuration `json:"hlsSegmentDuration"`
+	HLSPartDuration    conf.StringDuration `json:"hlsPartDuration"`
+	HLSAlwaysRemux     bool                `json:"hlsAlwaysRemux"`
+	HLSAllowOrigin     string              `json:"hlsAllowOrigin"`
+
+	// RecordPath is a template of unit recording segments path without extension, empty disables recording
+	RecordPath            string              `json:"recordPath"`
+	RecordFormat          conf.RecordFormat   `json:"recordFormat"`
+	RecordPartDuration    conf.StringDuration `json:"recordPartDuration"`
+	RecordSegmentDuration conf.StringDuration `json:"recordSegmentDuration"`
+	// RecordDeleteAfter 0 keeps recordings forever
+	RecordDeleteAfter conf.StringDuration `json:"recordDeleteAfter"`
+
+	// PlaybackPort 0 disables playback server
+	PlaybackPort        uint16 `json:"playbackPort"`
+	PlaybackAllowOrigin string `json:"playbackAllowOrigin"`
+
+	// WebRTCPort 0 disables WebRTC server
+	WebRTCPort        uint16 `json:"webrtcPort"`
+	WebRTCAllowOrigin string `json:"webrtcAllowOrigin"`
+	// WebRTCUDPPort is shared by every WebRTC session, 0 picks a random port
+	WebRTCUDPPort         uint16   `json:"webrtcUDPPort"`
+	WebRTCAdditionalHosts []string `json:"webrtcAdditionalHosts"`
+
+	// RTMPPort 0 disables RTMP server
+	RTMPPort uint16 `json:"rtmpPort"`
+
+	// SRTPort 0 disables SRT server
+	SRTPort uint16 `json:"srtPort"`
+	// SRTReadPassphrase encrypts SRT connections of readers, empty disables encryption
+	SRTReadPassphrase string `json:"srtReadPassphrase"`
+
+	// RTPAddress and RTCPAddress are listeners of RTSP UDP transport, ports must be consecutive with even RTP port
+	RTPAddress  string `json:"rtpAddress"`
+	RTCPAddress string `json:"rtcpAddress"`
+	// Multicast enables UDP-multicast transport of RTSP server, every path being read gets a group from MulticastIPRange
+	Multicast         bool   `json:"multicast"`
+	MulticastIPRange  string `json:"multicastIPRange"`
+	MulticastRTPPort  int    `json:"multicastRTPPort"`
+	MulticastRTCPPort int    `json:"multicastRTCPPort"`
+
+	// Encryption no disables RTSPS server, strict limits RTSP server to loopback
+	Encryption conf.Encryption `json:"encryption"`
+	RTSPSPort  uint16          `json:"rtspsPort"`
+	// ServerCert and ServerKey are RTSPS certificate and key files, they are reloaded on change
+	ServerCert string `json:"serverCert"`
+	ServerKey  string `json:"serverKey"`
+
+	// Ingest defines how transcoder outputs get into unit paths, see IngestMode
+	Ingest IngestMode `json:"ingest"`
+
+	// AuthMethod is internal, http or jwt
+	AuthMethod conf.AuthMethod `json:"authMethod"`
+	// AuthInternalUsers are allowed to read and publish unit paths with internal method, nil means core.DefaultUsers
+	AuthInternalUsers conf.AuthInternalUsers `json:"authInternalUsers"`
+	// AuthHTTPAddress is called with every request when AuthMethod is http
+	AuthHTTPAddress string                           `json:"authHTTPAddress"`
+	AuthHTTPExclude conf.AuthInternalUserPermissions `json:"authHTTPExclude"`
+	// AuthJWTJWKS is url of key set which JWTs are verified with when AuthMethod is jwt
+	AuthJWTJWKS string `json:"authJWTJWKS"`
+
+	// APIEncryption enables HTTPS of control server with APIServerCert and APIServerKey, they are reloaded on change
+	APIEncryption bool   `json:"apiEncryption"`
+	APIServerCert string `json:"apiServerCert"`
+	APIServerKey  string `json:"apiServerKey"`
+	// APITrustedProxies are allowed to pass client address of control server requests with X-Forwarded-For header
+	APITrustedProxies conf.IPNetworks `json:"apiTrustedProxies"`
+	// APILocalhostOnly allows control server requests from loopback addresses only
+	APILocalhostOnly bool `json:"apiLocalhostOnly"`
+}
+
+func (c *Config) Backoff() Backoff {
+	return Backoff{
+		Initial:     time.Duration(c.RetryAfter),
+		Max:         time.Duration(c.RetryMax),
+		StableAfter: time.Duration(c.StableAfter),
+	}
+}
+
+// HLSOptions returns HLS server settings, server is disabled when HLSPort is 0
+func (c *Config) HLSOptions() core.HLSOptions {
+	if c.HLSPort == 0 {
+		return core.HLSOptions{}
+	}
+
+	return core.HLSOptions{
+		Address:         fmt.Sprintf(":%d", c.HLSPort),
+		Variant:         c.HLSVariant,
+		SegmentCount:    c.HLSSegmentCount,
+		SegmentDuration: c.HLSSegmentDuration,
+		PartDuration:    c.HLSPartDuration,
+		AlwaysRemux:     c.HLSAlwaysRemux,
+		AllowOrigin:     c.HLSAllowOrigin,
+	}
+}
+
+// RecordOptions returns recording settings, recording is disabled when RecordPath is empty
+func (c *Config) RecordOptions() core.RecordOptions {
+	if c.RecordPath == "" {
+		return core.RecordOptions{}
+	}
+
+	return core.RecordOptions{
+		Path:            c.RecordPath,
+		Format:          c.RecordFormat,
+		PartDuration:    c.RecordPartDuration,
+		SegmentDuration: c.RecordSegmentDuration,
+		DeleteAfter:     c.RecordDeleteAfter,
+	}
+}
+
+// PlaybackOptions returns playback server settings, server is disabled when PlaybackPort is 0 or recording is disabled
+func (c *Config) PlaybackOptions() core.PlaybackOptions {
+	if c.PlaybackPort == 0 || c.RecordPath == "" {
+		return core.PlaybackOptions{}
+	}
+
+	return core.PlaybackOptions{
+		Address:     fmt.Sprintf(":%d", c.PlaybackPort),
+		AllowOrigin: c.PlaybackAllowOrigin,
+	}
+}
+
+// WebRTCOptions returns WebRTC server settings, server is disabled when WebRTCPort is 0
+func (c *Config) WebRTCOptions() core.WebRTCOptions {
+	if c.WebRTCPort == 0 {
+		return core.WebRTCOptions{}
+	}
+
+	return core.WebRTCOptions{
+		Address:         fmt.Sprintf(":%d", c.WebRTCPort),
+		AllowOrigin:     c.WebRTCAllowOrigin,
+		LocalUDPAddress: fmt.Sprintf(":%d", c.WebRTCUDPPort),
+		AdditionalHosts: c.WebRTCAdditionalHosts,
+	}
+}
+
+// RTMPOptions returns RTMP server settings, server is disabled when RTMPPort is 0
+func (c *Config) RTMPOptions() core.RTMPOptions {
+	if c.RTMPPort == 0 {
+		return core.RTMPOptions{}
+	}
+
+	return core.RTMPOptions{
+		Address: fmt.Sprintf(":%d", c.RTMPPort),
+	}
+}
+
+// SRTOptions returns SRT server settings, server is disabled when SRTPort is 0
+func (c *Config) SRTOptions() core.SRTOptions {
+	if c.SRTPort == 0 {
+		return core.SRTOptions{}
+	}
+
+	return core.SRTOptions{
+		Address:        fmt.Sprintf(":%d", c.SRTPort),
+		ReadPassphrase: c.SRTReadPassphrase,
+	}
+}
+
+// AuthOptions returns authentication method settings, users of internal method are returned by InternalUsers
+func (c *Config) AuthOptions() core.AuthOptions {
+	return core.AuthOptions{
+		Method:      c.AuthMethod,
+		HTTPAddress: c.AuthHTTPAddress,
+		HTTPExclude: c.AuthHTTPExclude,
+		JWTJWKS:     c.AuthJWTJWKS,
+	}
+}
+
+// InternalUsers returns users allowed to read and publish unit paths
+func (c *Config) InternalUsers() conf.AuthInternalUsers {
+	if c.AuthInternalUsers == nil {
+		return core.DefaultUsers
+	}
+	return c.AuthInternalUsers
+}
+
+// RTSPSOptions returns RTSPS server settings, server is disabled when Encryption is no
+func (c *Config) RTSPSOptions() core.RTSPSOptions {
+	if c.Encryption == conf.EncryptionNo {
+		return core.RTSPSOptions{}
+	}
+
+	return core.RTSPSOptions{
+		Encryption: c.Encryption,
+		Address:    fmt.Sprintf(":%d", c.RTSPSPort),
+		ServerCert: c.ServerCert,
+		ServerKey:  c.ServerKey,
+	}
+}
+
+// UDPOptions returns UDP transports settings of RTSP server, multicast is disabled unless Multicast is set
+func (c *Config) UDPOptions() core.UDPOptions {
+	opts := core.UDPOptions{
+		RTPAddress:  c.RTPAddress,
+		RTCPAddress: c.RTCPAddress,
+	}
+
+	if c.Multicast {
+		opts.MulticastIPRange = c.MulticastIPRange
+		opts.MulticastRTPPort = c.MulticastRTPPort
+		opts.MulticastRTCPPort = c.MulticastRTCPPort
+	}
+
+	return opts
+}
+
+// validateRTPPorts checks that RTP and RTCP addresses have consecutive ports with even RTP port
+func validateRTPPorts(rtpAddress string, rtcpAddress string) error {
+	rtpPort, err := addressPort(rtpAddress)
+	if err != nil {
+		return fmt.Errorf("rtpAddress: %w", err)
+	}
+
+	rtcpPort, err := addressPort(rtcpAddress)
+	if err != nil {
+		return fmt.Errorf("rtcpAddress: %w", err)
+	}
+
+	if rtpPort%2 != 0 || rtcpPort != rtpPort+1 {
+		return errors.New("rtpAddress port must be even and rtcpAddress port must follow it")
+	}
+
+	return nil
+}
+
+func addressPort(address string) (int, error) {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(port)
+}
+
+// ControlOptions returns control server settings, certificate is dropped when APIEncryption is disabled
+func (c *Config) ControlOptions() ControlOptions {
+	opts := ControlOptions{TrustedProxies: c.APITrustedProxies, LocalhostOnly: c.APILocalhostOnly}
+
+	if c.APIEncryption {
+		opts.ServerCert = c.APIServerCert
+		opts.ServerKey = c.APIServerKey
+	}
+
+	return opts
+}
+
+func (c *Config) Validate() error {
+	err := c.Backoff().Validate()
+	if err != nil {
+		return err
+	}
+
+	if c.HLSPort != 0 {
+		lowLatency := c.HLSVariant != conf.HLSVariant(gohlslib.MuxerVariantMPEGTS) &&
+			c.HLSVariant != conf.HLSVariant(gohlslib.MuxerVariantFMP4)
+
+		if lowLatency && c.HLSSegmentCount < 7 {
+			return errors.New("low-latency HLS requires hlsSegmentCount of at least 7")
+		}
+
+		if c.HLSSegmentCount < 3 {
+			return errors.New("hlsSegmentCount must be at least 3")
+		}
+	}
+
+	if c.RecordPath != "" {
+		err := validateRecordPath(c.RecordPath)
+		if err != nil {
+			return err
+		}
+
+		if c.RecordPartDuration <= 0 || c.RecordSegmentDuration < c.RecordPartDuration {
+			return errors.New("recordPartDuration must be positive and not longer than recordSegmentDuration")
+		}
+
+		if c.RecordDeleteAfter < 0 {
+			return errors.New("recordDeleteAfter can not be negative")
+		}
+	}
+
+	if c.SRTReadPassphrase != "" && (len(c.SRTReadPassphrase) < 10 || len(c.SRTReadPassphrase) > 79) {
+		return errors.New("srtReadPassphrase must be between 10 and 79 characters")
+	}
+
+	if c.Encryption != conf.EncryptionNo {
+		if c.ServerCert == "" || c.ServerKey == "" {
+			return errors.New("serverCert and serverKey are required by encryption")
+		}
+
+		if c.RTSPSPort == 0 || c.RTSPSPort == c.RTSPPort {
+			return errors.New("rtspsPort must be set and differ from rtspPort")
+		}
+	}
+
+	if c.UDP {
+		rtpAddress, rtcpAddress := c.RTPAddress, c.RTCPAddress
+		if rtpAddress == "" {
+			rtpAddress = core.DefaultRTPAddress
+		}
+		if rtcpAddress == "" {
+			rtcpAddress = core.DefaultRTCPAddress
+		}
+
+		err := validateRTPPorts(rtpAddress, rtcpAddress)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Multicast {
+		_, ipNet, err := net.ParseCIDR(c.MulticastIPRange)
+		if err != nil || !ipNet.IP.IsMulticast() {
+			return fmt.Errorf("multicastIPRange '%s' is not a multicast network", c.MulticastIPRange)
+		}
+
+		if c.MulticastRTPPort <= 0 || c.MulticastRTPPort%2 != 0 || c.MulticastRTCPPort != c.MulticastRTPPort+1 {
+			return errors.New("multicastRTPPort must be even and multicastRTCPPort must follow it")
+		}
+
+		if c.Encryption == conf.EncryptionStrict {
+			return errors.New("strict encryption can't be used with multicast")
+		}
+	}
+
+	if c.APIEncryption && (c.APIServerCert == "" || c.APIServerKey == "") {
+		return errors.New("apiServerCert and apiServerKey are required by apiEncryption")
+	}
+
+	if c.Encryption == conf.EncryptionStrict && c.UDP {
+		return errors.New("strict encryption can't be used with UDP transport")
+	}
+
+	err = c.Ingest.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = validateUsers(c.AuthInternalUsers)
+	if err != nil {
+		return err
+	}
+
+	switch c.AuthMethod {
+	case conf.AuthMethodHTTP:
+		if !strings.HasPrefix(c.AuthHTTPAddress, "http://") && !strings.HasPrefix(c.AuthHTTPAddress, "https://") {
+			return errors.New("authHTTPAddress must be a http or https url when authMethod is http")
+		}
+
+	case conf.AuthMethodJWT:
+		if !strings.HasPrefix(c.AuthJWTJWKS, "http://") && !strings.HasPrefix(c.AuthJWTJWKS, "https://") {
+			return errors.New("authJWTJWKS must be a http or https url when authMethod is jwt")
+		}
+	}
+
+	for _, p := range c.Profiles {
+		err := p.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	ids := make(map[string]struct{}, len(c.Units))
+
+	for _, u := range c.Units {
+		err := u.Validate()
+		if err != nil {
+			return fmt.Errorf("unit '%s': %w", u.ID, err)
+		}
+
+		if _, exist := ids[u.ID]; exist {
+			return fmt.Errorf("unit '%s' is declared twice", u.ID)
+		}
+		ids[u.ID] = struct{}{}
+	}
+
+	return nil
+}
+
+// validateRecordPath checks that segments of different units do not mix and segment start time could be read from path
+func validateRecordPath(path string) error {
+	if !strings.Contains(path, "%path") {
+		return errors.New("recordPath must contain %path")
+	}
+
+	if strings.Contains(path, "%s") {
+		return nil
+	}
+
+	for _, v := range []string{"%Y", "%m", "%d", "%H", "%M", "%S"} {
+		if !strings.Contains(path, v) {
+			return fmt.Errorf("recordPath must contain either %%s or %s", v)
+		}
+	}
+
+	return nil
+}
+
+// LoadConfig reads YAML configuration file on top of defaults, fields missing in file keep default values
+func LoadConfig(path string, defaults Config) (*Config, error) {
+	byts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := defaults
+	cfg.Profiles = nil
+	cfg.Units = nil
+
+	err = yaml.Load(byts, &cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// WatchConfigFile notifies when file modification time or size changes
+func WatchConfigFile(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	stat := func() (time.Time, int64) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		lastMod, lastSize := stat()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			mod, size := stat()
+			if mod.Equal(lastMod) && size == lastSize {
+				continue
+			}
+
+			lastMod, lastSize = mod, size
+
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changes
+}
+
+// reloadableKeys are configuration keys applied by ApplyConfig, changes of the rest require service restart
+var reloadableKeys = map[string]struct{}{
+	"stallTimeout":      {},
+	"retryAfter":        {},
+	"retryMax":          {},
+	"stableAfter":       {},
+	"profiles":          {},
+	"units":             {},
+	"authInternalUsers": {},
+}
+
+// RestartRequired returns keys of settings which differ between configurations and are applied on service restart only
+func RestartRequired(oldCfg *Config, newCfg *Config) []string {
+	var keys []string
+
+	oldValue := reflect.ValueOf(oldCfg).Elem()
+	newValue := reflect.ValueOf(newCfg).Elem()
+
+	for i := 0; i < oldValue.NumField(); i++ {
+		key, _, _ := strings.Cut(oldValue.Type().Field(i).Tag.Get("json"), ",")
+		if _, reloadable := reloadableKeys[key]; reloadable {
+			continue
+		}
+
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// ApplyConfig registers configuration profiles and users, applies supervision settings and diffs configuration units
+// against previously applied ones, so only added, removed or changed units are touched.
+// Units created through control API are updated only when profiles they use change.
+func (instance *Instance) ApplyConfig(cfg *Config) {
+	instance.m.Lock()
+	instance.backoff = cfg.Backoff()
+	instance.stallTimeout = time.Duration(cfg.StallTimeout)
+
+	oldProfiles := make(map[string]Profile, len(instance.profiles))
+	for name, p := range instance.profiles {
+		oldProfiles[name] = p
+	}
+	instance.m.Unlock()
+
+	instance.SetInternalUsers(cfg.InternalUsers())
+
+	instance.applyConfigProfiles(cfg.Profiles)
+
+	newUnits := make(map[string]UnitConfig, len(cfg.Units))
+	for _, u := range cfg.Units {
+		newUnits[u.ID] = u
+	}
+
+	instance.m.Lock()
+	oldUnits := instance.configUnits
+	instance.configUnits = newUnits
+	instance.m.Unlock()
+
+	for id, oldConf := range oldUnits {
+		newConf, exist := newUnits[id]
+
+		if !exist {
+			// unit has been deleted from configuration
+			if instance.removeConfigUnit(id) {
+				log.Printf("config: unit #%s: removed\n", id)
+			}
+			continue
+		}
+
+		if reflect.DeepEqual(oldConf, newConf) && !instance.profilesChanged(newConf, oldProfiles) {
+			continue
+		}
+
+		// configuration has changed, transcoder is replaced keeping unit readers
+		u := instance.GetUnit(id)
+		if u == nil || !u.managed {
+			_, err := instance.addUnit(newConf, true)
+			if err != nil {
+				log.Printf("config: unit #%s: update failed: %s\n", id, err)
+			} else {
+				log.Printf("config: unit #%s: added\n", id)
+			}
+			continue
+		}
+
+		_, err := instance.updateUnit(*u, newConf)
+		if err != nil {
+			log.Printf("config: unit #%s: update failed: %s\n", id, err)
+		} else {
+			log.Printf("config: unit #%s: updated\n", id)
+		}
+	}
+
+	for id, newConf := range newUnits {
+		if _, exist := oldUnits[id]; exist {
+			continue
+		}
+
+		_, err := instance.addUnit(newConf, true)
+		if err != nil {
+			log.Printf("config: unit #%s: add failed: %s\n", id, err)
+		} else {
+			log.Printf("config: unit #%s: added\n", id)
+		}
+	}
+
+	// units created through API get changed profiles as well, configuration units are already updated
+	for _, u := range instance.unitsList() {
+		if u.managed || !instance.profilesChanged(u.conf, oldProfiles) {
+			continue
+		}
+
+		_, err := instance.updateUnit(u, u.conf)
+		if err != nil {
+			log.Printf("config: unit #%s: profile has changed, update failed: %s\n", u.id, err)
+		} else {
+			log.Printf("config: unit #%s: updated with changed profile\n", u.id)
+		}
+	}
+}
+
+// applyConfigProfiles registers configuration profiles, profiles deleted from configuration are removed,
+// default profile is reset to built-in one
+func (instance *Instance) applyConfigProfiles(profiles []Profile) {
+	newProfiles := make(map[string]struct{}, len(profiles))
+	for _, p := range profiles {
+		err := instance.AddProfile(p)
+		if err != nil {
+			log.Printf("config: %s\n", err)
+			continue
+		}
+		newProfiles[p.Name] = struct{}{}
+	}
+
+	instance.m.Lock()
+	defer instance.m.Unlock()
+
+	for name := range instance.configProfiles {
+		if _, exist := newProfiles[name]; exist {
+			continue
+		}
+
+		if name == DefaultProfileName {
+			instance.profiles[name] = DefaultProfile
+		} else {
+			delete(instance.profiles, name)
+		}
+
+		log.Printf("config: profile '%s': removed\n", name)
+	}
+
+	instance.configProfiles = newProfiles
+}
+
+// removeConfigUnit removes unit only if it is declared by configuration file
+func (instance *Instance) removeConfigUnit(id string) bool {
+	u := instance.GetUnit(id)
+	if u == nil || !u.managed {
+		return false
+	}
+
+	err := instance.RemoveUnit(id)
+	if err != nil {
+		log.Printf("config: unit #%s: remove failed: %s\n", id, err)
+		return false
+	}
+
+	return true
+}
+
+// profilesChanged reports whether any profile used by unit differs from its previous version
+func (instance *Instance) profilesChanged(conf UnitConfig, oldProfiles map[string]Profile) bool {
+	for _, name := range conf.profileNames() {
+		newProfile, _ := instance.GetProfile(name)
+		if !reflect.DeepEqual(oldProfiles[name], newProfile) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func profileName(name string) string {
+	if name == "" {
+		return DefaultProfileName
+	}
+	return name
+}
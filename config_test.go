@@ -0,0 +1,229 @@
+package h265_transcoder
+
+import (
+	"context"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/playback"
+	"fearpro13/h265_transcoder/mediamtx/record"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+
+	err := os.WriteFile(path, []byte(`
+rtspPort: 10554
+retryAfter: 5s
+units:
+  - id: cam1
+    source: rtsp://127.0.0.1/cam1
+    profile: default
+    restart:
+      mode: on-failure
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path, Config{RTSPPort: 9222, HTTPPort: 8222})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.RTSPPort != 10554 || cfg.HTTPPort != 8222 {
+		t.Fatalf("unexpected ports %d %d", cfg.RTSPPort, cfg.HTTPPort)
+	}
+
+	if cfg.Backoff().Initial != 5*time.Second {
+		t.Fatalf("unexpected retryAfter %s", cfg.Backoff().Initial)
+	}
+
+	if len(cfg.Units) != 1 || cfg.Units[0].ID != "cam1" || cfg.Units[0].Restart.Mode != RestartOnFailure {
+		t.Fatalf("unexpected units %+v", cfg.Units)
+	}
+
+	err = os.WriteFile(path, []byte(`
+units:
+  - id: cam1
+    source: rtsp://127.0.0.1/cam1
+  - id: cam1
+    source: rtsp://127.0.0.1/cam2
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadConfig(path, Config{})
+	if err == nil {
+		t.Fatal("duplicate unit ids must be rejected")
+	}
+}
+
+func TestValidateRecordPath(t *testing.T) {
+	for path, valid := range map[string]bool{
+		"./recordings/%path/%Y-%m-%d_%H-%M-%S-%f": true,
+		"/var/rec/%path_%s":                       true,
+		"./recordings/%Y-%m-%d_%H-%M-%S":          false,
+		"./recordings/%path/%Y-%m-%d_%H-%M":       false,
+	} {
+		err := validateRecordPath(path)
+		if (err == nil) != valid {
+			t.Errorf("%s: unexpected result %v", path, err)
+		}
+	}
+
+	start := time.Date(2024, 3, 5, 7, 9, 11, 123456000, time.Local)
+	template := record.PathTemplate("./recordings/%path/%Y-%m-%d_%H-%M-%S-%f", "cam1", ".mp4")
+
+	encoded := record.Path{Start: start}.Encode(template)
+	if encoded != "./recordings/cam1/2024-03-05_07-09-11-123456.mp4" {
+		t.Fatalf("unexpected path %s", encoded)
+	}
+
+	var decoded record.Path
+	if !decoded.Decode("./recordings/%path/%Y-%m-%d_%H-%M-%S-%f.mp4", encoded) || !decoded.Start.Equal(start) {
+		t.Fatalf("unexpected decoded start %s", decoded.Start)
+	}
+}
+
+func TestPlaybackSegments(t *testing.T) {
+	cfg := Config{PlaybackPort: 9996, PlaybackAllowOrigin: "*"}
+	if cfg.PlaybackOptions().Address != "" {
+		t.Fatal("playback must be disabled without recording")
+	}
+
+	dir := t.TempDir()
+	cfg.RecordPath = filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")
+	cfg.RecordFormat = conf.RecordFormatFMP4
+
+	if cfg.PlaybackOptions().Address != ":9996" {
+		t.Fatalf("unexpected playback address %s", cfg.PlaybackOptions().Address)
+	}
+
+	for _, name := range []string{
+		"cam1/2024-03-05_08-00-00-000000.mp4",
+		"cam1/2024-03-05_07-00-00-000000.mp4",
+		"cam1/2024-03-05_09-00-00-000000.ts",
+		"cam10/2024-03-05_07-30-00-000000.mp4",
+	} {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segments, err := playback.FindSegments(&conf.Path{
+		RecordPath:   cfg.RecordPath,
+		RecordFormat: cfg.RecordFormat,
+	}, "cam1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(segments) != 2 || segments[0].Start.Hour() != 7 || segments[1].Start.Hour() != 8 {
+		t.Fatalf("unexpected segments %+v", segments)
+	}
+
+	_, err = playback.FindSegments(&conf.Path{
+		RecordPath:   cfg.RecordPath,
+		RecordFormat: cfg.RecordFormat,
+	}, "cam2")
+	if err != playback.ErrNoSegmentsFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestApplyConfigProfiles(t *testing.T) {
+	instance := NewInstance(context.Background(), 0, 0, DefaultBackoff, 1500*time.Millisecond, false)
+
+	small := DefaultProfile
+	small.Name = "small"
+	small.Height = 360
+
+	defaultOverride := DefaultProfile
+	defaultOverride.Height = 720
+
+	instance.ApplyConfig(&Config{Profiles: []Profile{small, defaultOverride}, StallTimeout: conf.StringDuration(1500 * time.Millisecond)})
+
+	if p, err := instance.GetProfile("small"); err != nil || p.Height != 360 {
+		t.Fatalf("profile is not added: %+v, %v", p, err)
+	}
+
+	if instance.stallTimeout != 1500*time.Millisecond {
+		t.Fatalf("stall timeout is truncated: %s", instance.stallTimeout)
+	}
+
+	instance.ApplyConfig(&Config{})
+
+	if _, err := instance.GetProfile("small"); err == nil {
+		t.Fatal("profile deleted from configuration is kept")
+	}
+
+	if p, _ := instance.GetProfile(DefaultProfileName); p.Height != DefaultProfile.Height {
+		t.Fatalf("default profile is not reset: %+v", p)
+	}
+}
+
+func TestApplyConfigProfilesToUnits(t *testing.T) {
+	instance := NewInstance(context.Background(), uint16(test.FreeTCPPort(t)), 0, DefaultBackoff, 0, false)
+
+	err := instance.rtspHandler.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.rtspHandler.Stop()
+
+	small := DefaultProfile
+	small.Name = "small"
+	small.Height = 360
+
+	instance.ApplyConfig(&Config{Profiles: []Profile{small}})
+
+	// on-demand unit is started without ffmpeg
+	_, err = instance.AddUnit(UnitConfig{ID: "cam1", Source: "rtsp://10.0.0.5/stream1", Profile: "small", OnDemand: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	small.Height = 240
+	instance.ApplyConfig(&Config{Profiles: []Profile{small}})
+
+	if u := instance.GetUnit("cam1"); u == nil || u.path.profile.Height != 240 {
+		t.Fatalf("profile change is not applied to unit created through API: %+v", u)
+	}
+
+	// unit is kept with its profile when the profile is deleted
+	instance.ApplyConfig(&Config{})
+
+	if u := instance.GetUnit("cam1"); u == nil || u.path.profile.Height != 240 {
+		t.Fatalf("unit of deleted profile is changed: %+v", u)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	oldCfg := &Config{RTSPPort: 9222, HLSPort: 8888, StallTimeout: conf.StringDuration(time.Second)}
+
+	newCfg := *oldCfg
+	newCfg.StallTimeout = conf.StringDuration(2 * time.Second)
+	newCfg.Profiles = []Profile{DefaultProfile}
+
+	if keys := RestartRequired(oldCfg, &newCfg); len(keys) != 0 {
+		t.Fatalf("reloadable settings require restart: %v", keys)
+	}
+
+	newCfg.HLSPort = 8889
+	newCfg.APILocalhostOnly = true
+
+	keys := RestartRequired(oldCfg, &newCfg)
+	if strings.Join(keys, ",") != "hlsPort,apiLocalhostOnly" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
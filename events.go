@@ -0,0 +1,119 @@
+package h265_transcoder
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventUnitCreated        EventType = "unit.created"
+	EventUnitStarted        EventType = "unit.started"
+	EventUnitReady          EventType = "unit.ready"
+	EventUnitStalled        EventType = "unit.stalled"
+	EventUnitRestarted      EventType = "unit.restarted"
+	EventUnitFailed         EventType = "unit.failed"
+	EventUnitRemoved        EventType = "unit.removed"
+	EventReaderConnected    EventType = "reader.connected"
+	EventReaderDisconnected EventType = "reader.disconnected"
+	EventFFMpegExit         EventType = "ffmpeg.exit"
+)
+
+// Event is a unit lifecycle event, ID is increasing by one with every event
+type Event struct {
+	ID   uint64         `json:"id"`
+	Type EventType      `json:"type"`
+	Time time.Time      `json:"time"`
+	Unit string         `json:"unit,omitempty"`
+	Path string         `json:"path,omitempty"`
+	Data map[string]any `json:"data,omitempty"`
+}
+
+// DefaultEventHistory is how many last events are kept for resuming clients
+const DefaultEventHistory = 1000
+
+// eventSubscriberQueue is how many events subscriber could fall behind before it's dropped
+const eventSubscriberQueue = 256
+
+// EventBus delivers events to subscribers and keeps bounded history of last events
+type EventBus struct {
+	mutex       sync.Mutex
+	lastID      uint64
+	history     []Event
+	size        int
+	subscribers map[chan Event]struct{}
+}
+
+func NewEventBus(size int) *EventBus {
+	return &EventBus{
+		size:        size,
+		subscribers: map[chan Event]struct{}{},
+	}
+}
+
+// Publish sends event to every subscriber, subscriber which does not keep up is dropped by closing its channel
+func (b *EventBus) Publish(typ EventType, unit string, path string, data map[string]any) Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lastID++
+
+	e := Event{
+		ID:   b.lastID,
+		Type: typ,
+		Time: time.Now(),
+		Unit: unit,
+		Path: path,
+		Data: data,
+	}
+
+	if len(b.history) >= b.size {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:len(b.history)-1]
+	}
+	b.history = append(b.history, e)
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return e
+}
+
+// Subscribe returns kept events following lastID and channel of next events, which has to be released by Unsubscribe.
+// lastID 0 means no event has been received yet, such subscriber gets next events only.
+// Whole history is returned when lastID is unknown, i.e. issued before restart.
+func (b *EventBus) Subscribe(lastID uint64) ([]Event, chan Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var missed []Event
+	if lastID != 0 {
+		for _, e := range b.history {
+			if e.ID > lastID || lastID > b.lastID {
+				missed = append(missed, e)
+			}
+		}
+	}
+
+	ch := make(chan Event, eventSubscriberQueue)
+	b.subscribers[ch] = struct{}{}
+
+	return missed, ch
+}
+
+func (b *EventBus) Unsubscribe(ch chan Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,158 @@
+package h265_transcoder
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBusHistory(t *testing.T) {
+	b := NewEventBus(3)
+
+	for i := 0; i < 5; i++ {
+		b.Publish(EventUnitStarted, "cam1", "", nil)
+	}
+
+	// new subscriber gets next events only
+	missed, ch := b.Subscribe(0)
+	b.Unsubscribe(ch)
+	if len(missed) != 0 {
+		t.Fatalf("unexpected events of new subscriber: %v", missed)
+	}
+
+	// history keeps only the last events
+	missed, ch = b.Subscribe(1)
+	b.Unsubscribe(ch)
+	if len(missed) != 3 || missed[0].ID != 3 || missed[2].ID != 5 {
+		t.Fatalf("unexpected history: %v", missed)
+	}
+
+	missed, ch = b.Subscribe(4)
+	b.Unsubscribe(ch)
+	if len(missed) != 1 || missed[0].ID != 5 {
+		t.Fatalf("unexpected resumed events: %v", missed)
+	}
+
+	// id issued before restart
+	missed, ch = b.Subscribe(100)
+	b.Unsubscribe(ch)
+	if len(missed) != 3 {
+		t.Fatalf("unexpected events of unknown id: %v", missed)
+	}
+}
+
+func TestEventBusSlowSubscriber(t *testing.T) {
+	b := NewEventBus(DefaultEventHistory)
+
+	_, ch := b.Subscribe(0)
+
+	for i := 0; i <= eventSubscriberQueue; i++ {
+		b.Publish(EventReaderConnected, "cam1", "cam1", nil)
+	}
+
+	received := 0
+	for range ch {
+		received++
+	}
+
+	if received != eventSubscriberQueue {
+		t.Fatalf("unexpected received events count %d", received)
+	}
+
+	// already dropped
+	b.Unsubscribe(ch)
+}
+
+func TestEventsStream(t *testing.T) {
+	ctx, ctxF := context.WithCancel(context.Background())
+	defer ctxF()
+
+	cs := NewControlServer(ctx, 0)
+	cs.Events = NewEventBus(DefaultEventHistory)
+
+	cs.Events.Publish(EventUnitCreated, "cam1", "", nil)
+	cs.Events.Publish(EventUnitCreated, "cam2", "", nil)
+	cs.Events.Publish(EventUnitStarted, "cam1", "", map[string]any{"copyVideo": true})
+
+	s := httptest.NewServer(cs.hs.Handler)
+	defer s.Close()
+
+	req, err := http.NewRequest(http.MethodGet, s.URL+"/events?unit=cam1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "1")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", res.Header.Get("Content-Type"))
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cs.Events.Publish(EventFFMpegExit, "cam1", "", map[string]any{"code": 1, "stderr": []string{"error"}})
+	}()
+
+	r := bufio.NewReader(res.Body)
+
+	var lines []string
+	for len(lines) < 4 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line != "" && !strings.HasPrefix(line, "data: ") {
+			lines = append(lines, line)
+		}
+	}
+
+	expected := []string{"id: 3", "event: unit.started", "id: 4", "event: ffmpeg.exit"}
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Fatalf("unexpected events: %v", lines)
+	}
+}
+
+func TestEventsStreamLive(t *testing.T) {
+	ctx, ctxF := context.WithCancel(context.Background())
+	defer ctxF()
+
+	cs := NewControlServer(ctx, 0)
+	cs.Events = NewEventBus(DefaultEventHistory)
+
+	cs.Events.Publish(EventUnitCreated, "cam1", "", nil)
+
+	s := httptest.NewServer(cs.hs.Handler)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cs.Events.Publish(EventUnitStarted, "cam1", "", nil)
+	}()
+
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// event published before client connected is not replayed
+	if line != "id: 2\n" {
+		t.Fatalf("unexpected first line %q", line)
+	}
+}
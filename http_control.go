@@ -2,25 +2,68 @@ package h265_transcoder
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fearpro13/h265_transcoder/mediamtx/auth"
+	"fearpro13/h265_transcoder/mediamtx/certloader"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/logger"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 )
 
-type OnCreate func(id string, source string) (Source, error)
+type OnCreate func(conf UnitConfig) (Source, error)
 type OnStop func(id string) error
+type OnUpdate func(id string, patch UnitPatch) (Source, error)
 type OnStatus func(id string) map[string]any
 type OnStatusAll func() map[string]any
+type OnProbe func(source string, timeout time.Duration) (*ProbeResult, error)
+type OnMetrics func(w io.Writer) error
+type OnAuthenticate func(req *auth.Request) error
+
+// scopes of control API, they are paths of api permission, permission without path grants both
+const (
+	APIScopeRead  = "read"
+	APIScopeAdmin = "admin"
+)
+
+// apiAuthPause delays response to wrong credentials
+var apiAuthPause = auth.PauseAfterError
+
+// ControlOptions are control server HTTPS and client address settings
+type ControlOptions struct {
+	// ServerCert and ServerKey enable HTTPS, they are reloaded on change
+	ServerCert string
+	ServerKey  string
+	// TrustedProxies are allowed to pass client address with X-Forwarded-For header
+	TrustedProxies conf.IPNetworks
+	// LocalhostOnly rejects requests of clients with non-loopback address before their credentials are checked
+	LocalhostOnly bool
+}
 
 type ControlServer struct {
 	hs *http.Server
 	OnCreate
 	OnStop
+	OnUpdate
 	OnStatus
 	OnStatusAll
+	OnProbe
+	OnMetrics
+	// OnAuthenticate checks every request, nil allows anyone to do anything
+	OnAuthenticate
+	Options ControlOptions
+	// Events are streamed to GET /events clients
+	Events  *EventBus
 	running atomic.Bool
 	ctxF    context.CancelFunc
 	ctx     context.Context
@@ -70,28 +113,103 @@ func NewControlServer(pCtx context.Context, httpPort uint16) *ControlServer {
 			}
 		}()
 
+		if !controlServer.authorize(writer, request) {
+			return
+		}
+
 		handler.ServeHTTP(writer, request)
 	})
 
 	handler.HandleFunc("POST /create", func(w http.ResponseWriter, r *http.Request) {
 		decoder := json.NewDecoder(r.Body)
 
-		data := map[string]any{}
+		conf := UnitConfig{}
 
-		err := decoder.Decode(&data)
+		err := decoder.Decode(&conf)
+		if err != nil || conf.ID == "" || conf.Source == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		_ = r.Body.Close()
+
+		encoder := json.NewEncoder(w)
+
+		var addedSource Source
+		addedSource, err = controlServer.OnCreate(conf)
 		if err != nil {
+			w.Header().Add("Content-Type", "application/json")
 			w.WriteHeader(http.StatusBadRequest)
+
+			_ = encoder.Encode(map[string]string{
+				"source":  addedSource.to.String(),
+				"message": err.Error(),
+			})
+
 			return
 		}
 
-		id, ok := data["id"].(string)
-		if !ok {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = encoder.Encode(map[string]string{
+			"source": addedSource.to.String(),
+		})
+
+	})
+
+	handler.HandleFunc("POST /probe", func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+
+		req := struct {
+			Source  string              `json:"source"`
+			Timeout conf.StringDuration `json:"timeout"`
+		}{Timeout: conf.StringDuration(DefaultProbeReadTimeout)}
+
+		err := decoder.Decode(&req)
+		if err != nil || req.Source == "" || req.Timeout <= 0 {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		source, ok := data["source"].(string)
-		if !ok {
+		_ = r.Body.Close()
+
+		encoder := json.NewEncoder(w)
+
+		res, err := controlServer.OnProbe(req.Source, time.Duration(req.Timeout))
+		if err != nil {
+			reason := ProbeFailed
+			var probeErr *ProbeError
+			if errors.As(err, &probeErr) {
+				reason = probeErr.Reason
+				err = probeErr.Err
+			}
+
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+
+			_ = encoder.Encode(map[string]string{
+				"source":  req.Source,
+				"reason":  string(reason),
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = encoder.Encode(res)
+	})
+
+	handler.HandleFunc("PATCH /{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		decoder := json.NewDecoder(r.Body)
+
+		patch := UnitPatch{}
+
+		err := decoder.Decode(&patch)
+		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -100,14 +218,15 @@ func NewControlServer(pCtx context.Context, httpPort uint16) *ControlServer {
 
 		encoder := json.NewEncoder(w)
 
-		var addedSource Source
-		addedSource, err = controlServer.OnCreate(id, source)
+		var updatedSource Source
+		updatedSource, err = controlServer.OnUpdate(id, patch)
 		if err != nil {
 			w.Header().Add("Content-Type", "application/json")
 			w.WriteHeader(http.StatusBadRequest)
 
 			_ = encoder.Encode(map[string]string{
-				"source": addedSource.to.String(),
+				"source":  updatedSource.to.String(),
+				"message": err.Error(),
 			})
 
 			return
@@ -116,9 +235,8 @@ func NewControlServer(pCtx context.Context, httpPort uint16) *ControlServer {
 		w.Header().Add("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		_ = encoder.Encode(map[string]string{
-			"source": addedSource.to.String(),
+			"source": updatedSource.to.String(),
 		})
-
 	})
 
 	handler.HandleFunc("POST /{id}/stop", func(w http.ResponseWriter, r *http.Request) {
@@ -141,6 +259,31 @@ func NewControlServer(pCtx context.Context, httpPort uint16) *ControlServer {
 		w.WriteHeader(http.StatusOK)
 	})
 
+	recordHandler := func(record bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			id := r.PathValue("id")
+
+			_, err := controlServer.OnUpdate(id, UnitPatch{Record: &record})
+			if err != nil {
+				w.Header().Add("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+
+				encoder := json.NewEncoder(w)
+
+				_ = encoder.Encode(map[string]string{
+					"message": err.Error(),
+				})
+
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+
+	handler.HandleFunc("POST /{id}/record/start", recordHandler(true))
+	handler.HandleFunc("POST /{id}/record/stop", recordHandler(false))
+
 	handler.HandleFunc("GET /{id}/status", func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 
@@ -171,18 +314,250 @@ func NewControlServer(pCtx context.Context, httpPort uint16) *ControlServer {
 		_ = encoder.Encode(statusAll)
 	})
 
+	handler.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		err := controlServer.OnMetrics(w)
+		if err != nil {
+			log.Printf("http_control: metrics: %s\n", err.Error())
+		}
+	})
+
+	handler.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		controlServer.streamEvents(w, r)
+	})
+
 	return controlServer
 }
 
+// eventsKeepAlive is how often comment is sent to idle events clients, so proxies do not close connection
+const eventsKeepAlive = 15 * time.Second
+
+// streamEvents writes events as server-sent events until client leaves, server stops or client falls behind.
+// Client resumes with Last-Event-ID header or lastEventId query, new client gets only events published after it connects.
+// Events could be filtered by unit query.
+func (s *ControlServer) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	var lastID uint64
+	if lastEventID != "" {
+		var err error
+		lastID, err = strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"message": "invalid last event id"})
+			return
+		}
+	}
+
+	unit := r.URL.Query().Get("unit")
+
+	missed, ch := s.Events.Subscribe(lastID)
+	defer s.Events.Unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(e Event) error {
+		if unit != "" && e.Unit != unit {
+			return nil
+		}
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		return err
+	}
+
+	for _, e := range missed {
+		if write(e) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, ok := <-ch:
+			// client has fallen behind, it resumes from the last received event
+			if !ok {
+				return
+			}
+
+			if write(e) != nil {
+				return
+			}
+
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+
+		case <-r.Context().Done():
+			return
+
+		case <-s.ctx.Done():
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+// clientIP returns address of request client, X-Forwarded-For header is used only when request comes from trusted proxy
+func clientIP(r *http.Request, trusted conf.IPNetworks) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !trusted.Contains(ip) {
+		return ip
+	}
+
+	// client is the rightmost address which is not a trusted proxy
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		fip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if fip == nil {
+			break
+		}
+
+		ip = fip
+		if !trusted.Contains(ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// authorize checks credentials of request with api action, GET requests require read scope and the rest admin scope.
+// Credentials are taken from basic auth, bearer token is used as password and as JWT.
+func (s *ControlServer) authorize(w http.ResponseWriter, r *http.Request) bool {
+	ip := clientIP(r, s.Options.TrustedProxies)
+
+	if s.Options.LocalhostOnly && (ip == nil || !ip.IsLoopback()) {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": "control API is allowed from localhost only"})
+		return false
+	}
+
+	if s.OnAuthenticate == nil {
+		return true
+	}
+
+	req := &auth.Request{
+		IP:     ip,
+		Action: conf.AuthActionAPI,
+		Path:   APIScopeAdmin,
+		Query:  r.URL.RawQuery,
+	}
+
+	if r.Method == http.MethodGet {
+		req.Path = APIScopeRead
+	}
+
+	if r.URL.Path == "/metrics" {
+		req.Action = conf.AuthActionMetrics
+		req.Path = ""
+	}
+
+	user, pass, hasCredentials := r.BasicAuth()
+	if hasCredentials {
+		req.User = user
+		req.Pass = pass
+	} else if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		hasCredentials = true
+		req.Pass = token
+
+		query, _ := url.ParseQuery(r.URL.RawQuery)
+		if !query.Has("jwt") {
+			query.Set("jwt", token)
+		}
+		req.Query = query.Encode()
+	}
+
+	err := s.OnAuthenticate(req)
+	if err == nil {
+		return true
+	}
+
+	if hasCredentials || r.URL.Query().Has("jwt") {
+		log.Printf("http_control: %s %s from %s: %s\n", r.Method, r.URL.Path, req.IP, err)
+
+		select {
+		case <-time.After(apiAuthPause):
+		case <-r.Context().Done():
+		}
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="h265_transcoder"`)
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "authentication failed"})
+
+	return false
+}
+
+// Log implements logger.Writer
+func (s *ControlServer) Log(_ logger.Level, format string, args ...interface{}) {
+	log.Printf("http_control: "+format+"\n", args...)
+}
+
 func (s *ControlServer) Start() error {
 	if s.running.Load() {
 		return errors.New("already started")
 	}
 
+	var certLoader *certloader.CertLoader
+	if s.Options.ServerCert != "" {
+		certLoader = &certloader.CertLoader{
+			CertPath: s.Options.ServerCert,
+			KeyPath:  s.Options.ServerKey,
+			Parent:   s,
+		}
+
+		err := certLoader.Initialize()
+		if err != nil {
+			return fmt.Errorf("control server certificate: %w", err)
+		}
+
+		s.hs.TLSConfig = &tls.Config{GetCertificate: certLoader.GetCertificate}
+	}
+
 	s.running.Store(true)
 	go func() {
-		log.Printf("Control server[HTTP] listening on %s\n", s.hs.Addr)
-		err := s.hs.ListenAndServe()
+		var err error
+		if certLoader != nil {
+			log.Printf("Control server[HTTPS] listening on %s\n", s.hs.Addr)
+			err = s.hs.ListenAndServeTLS("", "")
+			certLoader.Close()
+		} else {
+			log.Printf("Control server[HTTP] listening on %s\n", s.hs.Addr)
+			err = s.hs.ListenAndServe()
+		}
 		if err != nil {
 			_ = s.Stop()
 		}
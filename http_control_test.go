@@ -0,0 +1,253 @@
+package h265_transcoder
+
+import (
+	"context"
+	"crypto/tls"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/core"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestControlAuth(t *testing.T) {
+	apiAuthPause = 0
+
+	h := core.NewRtspHandler(context.Background(), uint16(test.FreeTCPPort(t)), false)
+	h.SetInternalUsers(conf.AuthInternalUsers{
+		{User: "admin", Pass: "adminpass", Permissions: conf.AuthInternalUserPermissions{{Action: conf.AuthActionAPI}}},
+		{User: "viewer", Pass: "viewerpass", Permissions: conf.AuthInternalUserPermissions{{Action: conf.AuthActionAPI, Path: APIScopeRead}}},
+		{Pass: "monitortoken", Permissions: conf.AuthInternalUserPermissions{{Action: conf.AuthActionMetrics}}},
+		{
+			User:        "any",
+			IPs:         conf.IPNetworks{{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)}},
+			Permissions: conf.AuthInternalUserPermissions{{Action: conf.AuthActionAPI}},
+		},
+	})
+
+	err := h.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs := NewControlServer(ctx, 0)
+	cs.OnAuthenticate = h.Authenticate
+	cs.Options.TrustedProxies = loopbackNetworks
+	cs.OnStatusAll = func() map[string]any { return map[string]any{} }
+	cs.OnStop = func(id string) error { return nil }
+	cs.OnMetrics = func(w io.Writer) error { return nil }
+
+	s := httptest.NewServer(cs.hs.Handler)
+	defer s.Close()
+
+	do := func(method string, path string, setup func(r *http.Request)) int {
+		r, err := http.NewRequest(method, s.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if setup != nil {
+			setup(r)
+		}
+
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+
+		return res.StatusCode
+	}
+
+	basic := func(user string, pass string) func(r *http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, pass) }
+	}
+
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	forwarded := func(addr string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("X-Forwarded-For", addr) }
+	}
+
+	for _, c := range []struct {
+		name   string
+		method string
+		path   string
+		setup  func(r *http.Request)
+		status int
+	}{
+		{"anonymous status", http.MethodGet, "/status", nil, http.StatusUnauthorized},
+		{"wrong password", http.MethodGet, "/status", basic("admin", "wrong"), http.StatusUnauthorized},
+		{"admin status", http.MethodGet, "/status", basic("admin", "adminpass"), http.StatusOK},
+		{"admin stop", http.MethodPost, "/cam1/stop", basic("admin", "adminpass"), http.StatusOK},
+		{"viewer status", http.MethodGet, "/status", basic("viewer", "viewerpass"), http.StatusOK},
+		{"viewer stop", http.MethodPost, "/cam1/stop", basic("viewer", "viewerpass"), http.StatusUnauthorized},
+		{"token metrics", http.MethodGet, "/metrics", bearer("monitortoken"), http.StatusOK},
+		{"token status", http.MethodGet, "/status", bearer("monitortoken"), http.StatusUnauthorized},
+		{"admin metrics", http.MethodGet, "/metrics", basic("admin", "adminpass"), http.StatusUnauthorized},
+		{"forwarded office", http.MethodPost, "/cam1/stop", forwarded("10.1.2.3"), http.StatusOK},
+		{"forwarded outsider", http.MethodPost, "/cam1/stop", forwarded("10.1.2.3, 192.168.1.5"), http.StatusUnauthorized},
+	} {
+		status := do(c.method, c.path, c.setup)
+		if status != c.status {
+			t.Errorf("%s: unexpected status %d, expected %d", c.name, status, c.status)
+		}
+	}
+}
+
+func TestControlDefaultUsers(t *testing.T) {
+	apiAuthPause = 0
+
+	h := core.NewRtspHandler(context.Background(), uint16(test.FreeTCPPort(t)), false)
+
+	err := h.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	for _, c := range []struct {
+		localhostOnly bool
+		forwarded     string
+		status        int
+	}{
+		{false, "", http.StatusOK},
+		{false, "192.168.1.5", http.StatusOK},
+		{true, "", http.StatusOK},
+		{true, "192.168.1.5", http.StatusForbidden},
+	} {
+		cs := NewControlServer(context.Background(), 0)
+		cs.OnAuthenticate = h.Authenticate
+		cs.Options.TrustedProxies = loopbackNetworks
+		cs.Options.LocalhostOnly = c.localhostOnly
+		cs.OnStatusAll = func() map[string]any { return map[string]any{} }
+
+		s := httptest.NewServer(cs.hs.Handler)
+
+		r, err := http.NewRequest(http.MethodGet, s.URL+"/status", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		s.Close()
+
+		if res.StatusCode != c.status {
+			t.Errorf("localhost only %v, %q: unexpected status %d, expected %d", c.localhostOnly, c.forwarded, res.StatusCode, c.status)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := conf.IPNetworks{{IP: net.IPv4(10, 0, 0, 1).To4(), Mask: net.CIDRMask(32, 32)}}
+
+	for _, c := range []struct {
+		remote    string
+		forwarded string
+		ip        string
+	}{
+		{"192.168.1.5:4000", "1.2.3.4", "192.168.1.5"},
+		{"10.0.0.1:4000", "", "10.0.0.1"},
+		{"10.0.0.1:4000", "1.2.3.4", "1.2.3.4"},
+		{"10.0.0.1:4000", "6.6.6.6, 1.2.3.4, 10.0.0.1", "1.2.3.4"},
+		{"10.0.0.1:4000", "invalid, 1.2.3.4", "1.2.3.4"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/status", nil)
+		r.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+
+		ip := clientIP(r, trusted)
+		if ip.String() != c.ip {
+			t.Errorf("%s %q: unexpected client %s, expected %s", c.remote, c.forwarded, ip, c.ip)
+		}
+	}
+}
+
+func TestControlHTTPS(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "server.crt")
+	keyPath := filepath.Join(dir, "server.key")
+	writeTestCert(t, certPath, keyPath, 1)
+
+	port := test.FreeTCPPort(t)
+
+	cs := NewControlServer(context.Background(), uint16(port))
+	cs.Options = ControlOptions{ServerCert: certPath, ServerKey: keyPath}
+	cs.OnStatusAll = func() map[string]any { return map[string]any{} }
+
+	err := cs.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Stop()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	var res *http.Response
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(20 * time.Millisecond) {
+		res, err = client.Get(fmt.Sprintf("https://127.0.0.1:%d/status", port))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || res.TLS == nil {
+		t.Fatalf("unexpected response %d", res.StatusCode)
+	}
+
+	cs2 := NewControlServer(context.Background(), uint16(test.FreeTCPPort(t)))
+	cs2.Options = ControlOptions{ServerCert: filepath.Join(dir, "missing.crt"), ServerKey: keyPath}
+	if cs2.Start() == nil {
+		t.Fatal("missing certificate must be rejected")
+	}
+}
+
+func TestControlConfig(t *testing.T) {
+	cfg := Config{APIEncryption: true}
+	if cfg.Validate() == nil {
+		t.Fatal("api encryption without certificate must be rejected")
+	}
+
+	cfg.APIServerCert = "api.crt"
+	cfg.APIServerKey = "api.key"
+
+	err := cfg.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts := cfg.ControlOptions(); opts.ServerCert != "api.crt" || opts.ServerKey != "api.key" {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+
+	cfg.APIEncryption = false
+	if opts := cfg.ControlOptions(); opts.ServerCert != "" {
+		t.Fatal("HTTPS must be disabled")
+	}
+}
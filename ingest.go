@@ -0,0 +1,185 @@
+package h265_transcoder
+
+import (
+	"bufio"
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/core"
+	mmpegts "fearpro13/h265_transcoder/mediamtx/protocols/mpegts"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+)
+
+// IngestMode defines how transcoder outputs get into unit paths
+type IngestMode string
+
+const (
+	// IngestRTSP ffmpeg publishes outputs to rtsp server
+	IngestRTSP IngestMode = "rtsp"
+	// IngestPipe ffmpeg writes outputs as MPEG-TS to pipes, which are demuxed and published in-process,
+	// so unit paths could not be published by anyone else
+	IngestPipe IngestMode = "pipe"
+)
+
+// pipeIngestSupported is false on Windows, where ffmpeg could not be given pipes as extra file descriptors
+var pipeIngestSupported = runtime.GOOS != "windows"
+
+func (m IngestMode) Validate() error {
+	switch m {
+	case "", IngestRTSP:
+		return nil
+	case IngestPipe:
+		if !pipeIngestSupported {
+			return fmt.Errorf("ingest mode '%s' is not supported on %s", m, runtime.GOOS)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown ingest mode '%s'", m)
+	}
+}
+
+// file descriptors of ffmpeg pipes in pipe ingest mode, unit output is written to stdout
+const (
+	pipeProgressFD   = 3
+	pipeRenditionsFD = 4
+)
+
+// ingestPublisher publishes demuxed transcoder outputs into paths
+type ingestPublisher interface {
+	Publish(path string, desc *description.Session) (*core.Publisher, *stream.Stream, error)
+}
+
+// pipeIngest is a transcoder output read from ffmpeg pipe
+type pipeIngest struct {
+	path string
+	r    io.ReadCloser
+}
+
+// setupPipes passes progress and renditions pipes to ffmpeg, unit output is read from stdOut.
+// Returned writers are ffmpeg ends of pipes, which have to be closed once ffmpeg is started.
+func (t *Transcoder) setupPipes(cmd *exec.Cmd, stdOut io.ReadCloser) (io.ReadCloser, []pipeIngest, []*os.File, error) {
+	var readers []*os.File
+	var writers []*os.File
+
+	closeAll := func() {
+		for _, f := range append(readers, writers...) {
+			_ = f.Close()
+		}
+	}
+
+	for i := 0; i < 1+len(t.source.renditions); i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			closeAll()
+			return nil, nil, nil, err
+		}
+
+		readers = append(readers, r)
+		writers = append(writers, w)
+	}
+
+	// descriptors of extra files start with 3
+	cmd.ExtraFiles = writers
+
+	ingests := []pipeIngest{{path: t.source.id, r: stdOut}}
+	for i, r := range t.source.renditions {
+		ingests = append(ingests, pipeIngest{path: renditionPath(t.source.id, r.name), r: readers[1+i]})
+	}
+
+	return readers[0], ingests, writers, nil
+}
+
+func (t *Transcoder) runIngest(in pipeIngest) {
+	err := t.ingest(in)
+
+	if err != nil && t.running.Load() {
+		log.Println(fmt.Sprintf("transcoder #%s(%s): ingest of path '%s': %s", t.source.id, t.source.from.String(), in.path, err))
+
+		// ffmpeg would block on the pipe which is not read anymore
+		t.ctxF()
+	}
+}
+
+// ingest demuxes MPEG-TS of ffmpeg output and writes its units into path until ffmpeg exits or path closes publisher
+func (t *Transcoder) ingest(in pipeIngest) error {
+	defer in.r.Close()
+
+	r, err := mpegts.NewReader(bufio.NewReader(in.r))
+	if err != nil {
+		return pipeError(err)
+	}
+
+	var strm *stream.Stream
+
+	medias, err := mmpegts.ToStream(r, &strm)
+	if err != nil {
+		return err
+	}
+
+	pub, s, err := t.publisher.Publish(in.path, &description.Session{Medias: medias})
+	if err != nil {
+		return err
+	}
+	defer pub.Close()
+
+	if !t.addPublisher(pub) {
+		// transcoder has been stopped meanwhile
+		return nil
+	}
+
+	strm = s
+
+	for {
+		select {
+		case <-pub.Done():
+			return errors.New("publisher has been closed by path")
+		default:
+		}
+
+		err = r.Read()
+		if err != nil {
+			return pipeError(err)
+		}
+	}
+}
+
+// pipeError hides errors caused by ffmpeg exit, pipe is closed by ffmpeg or by cmd.Wait
+func pipeError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, os.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// addPublisher keeps publisher to be closed by Stop, false if transcoder is already stopped
+func (t *Transcoder) addPublisher(p *core.Publisher) bool {
+	t.publishersMutex.Lock()
+	defer t.publishersMutex.Unlock()
+
+	if !t.running.Load() {
+		return false
+	}
+
+	t.publishers = append(t.publishers, p)
+
+	return true
+}
+
+// closePublishers removes publishers from paths, so paths are free for the next transcoder as soon as Stop returns
+func (t *Transcoder) closePublishers() {
+	t.publishersMutex.Lock()
+	publishers := t.publishers
+	t.publishers = nil
+	t.publishersMutex.Unlock()
+
+	for _, p := range publishers {
+		p.Close()
+	}
+}
@@ -0,0 +1,167 @@
+package h265_transcoder
+
+import (
+	"context"
+	"fearpro13/h265_transcoder/mediamtx/core"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+)
+
+func TestPipeArgs(t *testing.T) {
+	source, err := NewSource("1", "rtsp://127.0.0.1:8554/in", "rtsp://0.0.0.0:9222/1", DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.copyVideo = true
+	source.pipe = true
+
+	to, _ := url.Parse("rtsp://0.0.0.0:9222/1/low")
+	source.renditions = []renditionOutput{{name: "low", to: *to, profile: Profile{Name: "low", VideoCodec: "libx264", CRF: 30, Audio: AudioDisabled}}}
+
+	args, err := source.Args()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"-y", "-fflags", "+igndts", "-rtsp_transport", "tcp", "-i", "rtsp://127.0.0.1:8554/in",
+		"-filter_complex", "[0:v:0]split=1[s0];[s0]null[r0]", "-map", "0:v:0", "-map", "0:a?",
+		"-c:a", "copy", "-c:v", "copy", "-max_muxing_queue_size", "1024",
+		"-f", "mpegts", "pipe:1",
+		"-map", "[r0]", "-map", "0:a?", "-an", "-c:v", "libx264", "-crf", "30", "-max_muxing_queue_size", "1024", "-bf", "0",
+		"-f", "mpegts", "pipe:4",
+	}
+
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("unexpected args: %v", args)
+	}
+}
+
+func TestPipeIngest(t *testing.T) {
+	port := test.FreeTCPPort(t)
+
+	h := core.NewRtspHandler(context.Background(), uint16(port), false)
+	err := h.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	err = h.AddInProcessPath("cam1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := NewSource("cam1", "rtsp://10.0.0.5/stream1", "", DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc := NewTranscoder(src)
+	tc.publisher = h
+	tc.running.Store(true)
+
+	r, w := io.Pipe()
+
+	ingestErr := make(chan error, 1)
+	go func() {
+		ingestErr <- tc.ingest(pipeIngest{path: "cam1", r: r})
+	}()
+
+	track := &mpegts.Track{Codec: &mpegts.CodecH264{}}
+	mw := mpegts.NewWriter(w, []*mpegts.Track{track})
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer w.Close()
+
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(40 * time.Millisecond):
+			}
+
+			pts := int64(i) * 3600
+			err := mw.WriteH264(track, pts, pts, true, [][]byte{test.FormatH264.SPS, test.FormatH264.PPS, {0x65, 0x88, 0x84}})
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	source := fmt.Sprintf("rtsp://127.0.0.1:%d/cam1", port)
+
+	var codec string
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(100 * time.Millisecond) {
+		codec, err = probeVideoCodec(source, 5*time.Second)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if codec != "H264" {
+		t.Fatalf("unexpected codec %s", codec)
+	}
+
+	received, ok := h.PathBytesReceived("cam1")
+	if !ok || received == 0 {
+		t.Fatal("nothing has been received")
+	}
+
+	// path published in-process rejects other publishers
+	c := gortsplib.Client{}
+	err = c.StartRecording(source, &description.Session{Medias: []*description.Media{test.MediaH264}})
+	if err == nil {
+		c.Close()
+		t.Fatal("external publisher has not been rejected")
+	}
+
+	tc.running.Store(false)
+	tc.closePublishers()
+
+	select {
+	case err = <-ingestErr:
+		if err == nil {
+			t.Fatal("expected ingest to be stopped by path")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ingest has not been stopped")
+	}
+}
+
+func TestIngestModeValidate(t *testing.T) {
+	supported := pipeIngestSupported
+	defer func() { pipeIngestSupported = supported }()
+
+	pipeIngestSupported = true
+
+	for _, m := range []IngestMode{"", IngestRTSP, IngestPipe} {
+		if m.Validate() != nil {
+			t.Errorf("%q has been rejected", m)
+		}
+	}
+
+	if IngestMode("udp").Validate() == nil {
+		t.Error("unknown mode has been accepted")
+	}
+
+	pipeIngestSupported = false
+
+	if IngestPipe.Validate() == nil {
+		t.Error("pipe mode has been accepted without pipes support")
+	}
+}
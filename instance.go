@@ -3,48 +3,194 @@ package h265_transcoder
 import (
 	"context"
 	"errors"
+	"fearpro13/h265_transcoder/mediamtx/conf"
 	"fearpro13/h265_transcoder/mediamtx/core"
+	"fearpro13/h265_transcoder/mediamtx/defs"
 	"fmt"
 	"log"
+	"net/url"
+	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-type Unit struct {
-	id   string
-	path Source
+type Instance struct {
+	rtspHandler  *core.RtspHandler
+	httpHandler  *ControlServer
+	transcoders  map[string]*Transcoder
+	units        map[string]Unit
+	profiles     map[string]Profile
+	health       map[string]*unitHealth
+	state        *StateStore
+	unrestored   map[string]UnitConfig
+	configUnits  map[string]UnitConfig
+	codecs       map[string]string
+	running      atomic.Bool
+	ctx          context.Context
+	ctxF         context.CancelFunc
+	Done         <-chan struct{}
+	backoff      Backoff
+	stallTimeout time.Duration
+	allowUdp     bool
+	pipeIngest   bool
+	events       *EventBus
+	// secret is a key unit credentials of rtsp server are derived from
+	secret []byte
+
+	onDemandStartTimeout time.Duration
+	onDemandCloseAfter   time.Duration
+
+	// configProfiles are names of profiles declared by configuration file
+	configProfiles map[string]struct{}
+	// starting are ids reserved by units being added
+	starting map[string]struct{}
+
+	m sync.Mutex
+	// saveM serializes state saves
+	saveM sync.Mutex
 }
 
-type Instance struct {
-	rtspHandler       *core.RtspHandler
-	httpHandler       *ControlServer
-	transcoders       map[string]*Transcoder
-	units             map[string]Unit
-	running           atomic.Bool
-	ctx               context.Context
-	ctxF              context.CancelFunc
-	Done              <-chan struct{}
-	retryAfterSeconds int
-	allowUdp          bool
-	m                 sync.Mutex
-}
-
-func NewInstance(pCtx context.Context, rtspPort uint16, httpPort uint16, retryAfterSeconds int, allowUdp bool) *Instance {
+// superviseInterval is how often units health is checked, restart delays are defined by Backoff
+const superviseInterval = time.Second
+
+// publishTimeout is how long started transcoder may not publish unit paths before unit is restarted
+const publishTimeout = 20 * time.Second
+
+// handoverTimeout is how long unit readers wait for new transcoder after unit update
+const handoverTimeout = 20 * time.Second
+
+func NewInstance(pCtx context.Context, rtspPort uint16, httpPort uint16, backoff Backoff, stallTimeout time.Duration, allowUdp bool) *Instance {
 	ctx, ctxF := context.WithCancel(pCtx)
 	return &Instance{
-		rtspHandler:       core.NewRtspHandler(ctx, rtspPort, allowUdp),
-		httpHandler:       NewControlServer(ctx, httpPort),
-		transcoders:       map[string]*Transcoder{},
-		units:             map[string]Unit{},
-		running:           atomic.Bool{},
-		ctx:               ctx,
-		ctxF:              ctxF,
-		Done:              ctx.Done(),
-		retryAfterSeconds: retryAfterSeconds,
-		allowUdp:          allowUdp,
-		m:                 sync.Mutex{},
+		rtspHandler:  core.NewRtspHandler(ctx, rtspPort, allowUdp),
+		httpHandler:  NewControlServer(ctx, httpPort),
+		transcoders:  map[string]*Transcoder{},
+		units:        map[string]Unit{},
+		profiles:     map[string]Profile{DefaultProfileName: DefaultProfile},
+		health:       map[string]*unitHealth{},
+		unrestored:   map[string]UnitConfig{},
+		starting:     map[string]struct{}{},
+		configUnits:  map[string]UnitConfig{},
+		codecs:       map[string]string{},
+		running:      atomic.Bool{},
+		ctx:          ctx,
+		ctxF:         ctxF,
+		Done:         ctx.Done(),
+		backoff:      backoff,
+		stallTimeout: stallTimeout,
+		allowUdp:     allowUdp,
+		events:       NewEventBus(DefaultEventHistory),
+		secret:       newSecret(),
+
+		onDemandStartTimeout: 20 * time.Second,
+		onDemandCloseAfter:   10 * time.Second,
+
+		m: sync.Mutex{},
+	}
+}
+
+// AddProfile registers an encoding profile, replacing the one with the same name
+func (instance *Instance) AddProfile(profile Profile) error {
+	err := profile.Validate()
+	if err != nil {
+		return err
 	}
+
+	instance.m.Lock()
+	instance.profiles[profile.Name] = profile
+	instance.m.Unlock()
+
+	return nil
+}
+
+func (instance *Instance) GetProfile(name string) (Profile, error) {
+	if name == "" {
+		name = DefaultProfileName
+	}
+
+	instance.m.Lock()
+	defer instance.m.Unlock()
+
+	p, exist := instance.profiles[name]
+	if !exist {
+		return Profile{}, fmt.Errorf("profile '%s' does not exist", name)
+	}
+
+	return p, nil
+}
+
+// SetStateFile enables units persistence, must be called before Start
+func (instance *Instance) SetStateFile(path string) {
+	instance.state = NewStateStore(path)
+}
+
+// SetHLS enables HLS server serving every unit and rendition, must be called before Start
+func (instance *Instance) SetHLS(opts core.HLSOptions) {
+	instance.rtspHandler.HLS = opts
+}
+
+// SetRecord enables recording of units with record flag, must be called before Start
+func (instance *Instance) SetRecord(opts core.RecordOptions) {
+	instance.rtspHandler.Record = opts
+}
+
+// SetPlayback enables playback server of recordings, must be called before Start
+func (instance *Instance) SetPlayback(opts core.PlaybackOptions) {
+	instance.rtspHandler.Playback = opts
+}
+
+// SetWebRTC enables WebRTC server serving every unit and rendition with WHEP, must be called before Start
+func (instance *Instance) SetWebRTC(opts core.WebRTCOptions) {
+	instance.rtspHandler.WebRTC = opts
+}
+
+// SetRTMP enables RTMP server serving every unit and rendition and accepting published unit sources, must be called before Start
+func (instance *Instance) SetRTMP(opts core.RTMPOptions) {
+	instance.rtspHandler.RTMP = opts
+}
+
+// SetSRT enables SRT server serving every unit and rendition as MPEG-TS, must be called before Start
+func (instance *Instance) SetSRT(opts core.SRTOptions) {
+	instance.rtspHandler.SRT = opts
+}
+
+// SetRTSPS enables RTSPS server serving and accepting every unit and rendition, must be called before Start
+func (instance *Instance) SetRTSPS(opts core.RTSPSOptions) {
+	instance.rtspHandler.RTSPS = opts
+}
+
+// SetIngest sets how transcoder outputs get into unit paths, must be called before Start
+func (instance *Instance) SetIngest(mode IngestMode) {
+	instance.pipeIngest = mode == IngestPipe
+}
+
+// SetAuth sets authentication method of readers and publishers, must be called before Start
+func (instance *Instance) SetAuth(opts core.AuthOptions) {
+	instance.rtspHandler.Auth = opts
+}
+
+// SetInternalUsers replaces users allowed to read and publish unit paths, unit transcoders get their own credentials
+func (instance *Instance) SetInternalUsers(users conf.AuthInternalUsers) {
+	instance.rtspHandler.SetInternalUsers(users)
+}
+
+// SetUDP sets UDP and UDP-multicast transports of rtsp server, must be called before Start
+func (instance *Instance) SetUDP(opts core.UDPOptions) {
+	instance.rtspHandler.UDP = opts
+}
+
+// SetControl sets control server HTTPS and client address settings, must be called before Start
+func (instance *Instance) SetControl(opts ControlOptions) {
+	instance.httpHandler.Options = opts
+}
+
+// SetOnDemandTimeouts sets how long readers wait for on-demand unit to start
+// and how long on-demand unit keeps running after the last reader leaves, must be called before Start
+func (instance *Instance) SetOnDemandTimeouts(startTimeout time.Duration, closeAfter time.Duration) {
+	instance.onDemandStartTimeout = startTimeout
+	instance.onDemandCloseAfter = closeAfter
 }
 
 func (instance *Instance) Start() error {
@@ -52,6 +198,12 @@ func (instance *Instance) Start() error {
 		return errors.New("instance already running")
 	}
 
+	instance.rtspHandler.OnDemandStart = instance.onDemandStart
+	instance.rtspHandler.OnDemandStop = instance.onDemandStop
+	instance.rtspHandler.OnPathReady = instance.onPathReady
+	instance.rtspHandler.OnReaderAdd = instance.onReaderAdd
+	instance.rtspHandler.OnReaderRemove = instance.onReaderRemove
+
 	err := instance.rtspHandler.Start()
 
 	if err != nil {
@@ -60,6 +212,9 @@ func (instance *Instance) Start() error {
 
 	instance.httpHandler.OnCreate = instance.AddUnit
 	instance.httpHandler.OnStop = instance.RemoveUnit
+	instance.httpHandler.OnUpdate = instance.UpdateUnit
+	instance.httpHandler.OnProbe = ProbeSource
+	instance.httpHandler.Events = instance.events
 
 	instance.httpHandler.OnStatus = func(id string) map[string]any {
 		u := instance.GetUnit(id)
@@ -67,52 +222,33 @@ func (instance *Instance) Start() error {
 			return nil
 		}
 
-		t, te := instance.transcoders[id]
-
-		var status string
-		if !te || t == nil {
-			return nil
-		} else {
-			status = t.Status()
-		}
-
-		return map[string]any{
-			"original": u.path.from.String(),
-			"source":   u.path.to.String(),
-			"status":   status,
-		}
+		return instance.unitStatus(*u)
 	}
 
 	instance.httpHandler.OnStatusAll = func() map[string]any {
-		res := make(map[string]any, len(instance.units))
-
-		for id, u := range instance.units {
-			t, te := instance.transcoders[id]
-
-			var status string
-			if !te || t == nil {
-				return nil
-			} else {
-				status = t.Status()
-			}
+		units := instance.unitsList()
+		res := make(map[string]any, len(units))
 
-			res[id] = map[string]any{
-				"original": u.path.from.String(),
-				"source":   u.path.to.String(),
-				"status":   status,
-			}
+		for _, u := range units {
+			res[u.id] = instance.unitStatus(u)
 		}
 
 		return res
 	}
 
+	instance.httpHandler.OnMetrics = instance.writeMetrics
+	instance.httpHandler.OnAuthenticate = instance.rtspHandler.Authenticate
+
 	err = instance.httpHandler.Start()
 	if err != nil {
+		instance.rtspHandler.Stop()
 		return err
 	}
 
 	instance.running.Store(true)
 
+	instance.restoreUnits()
+
 	go func() {
 		select {
 		case <-instance.ctx.Done():
@@ -125,53 +261,283 @@ func (instance *Instance) Start() error {
 	return nil
 }
 
+func (instance *Instance) unitStatus(u Unit) map[string]any {
+	renditions := make(map[string]any, len(u.path.renditions))
+	for _, r := range u.path.renditions {
+		bytesReceived, ready := instance.rtspHandler.PathBytesReceived(renditionPath(u.id, r.name))
+		rs := map[string]any{
+			"source":        r.to.String(),
+			"profile":       r.profile.Name,
+			"ready":         ready,
+			"bytesReceived": bytesReceived,
+			"rtspReaders":   instance.rtspReaders(renditionPath(u.id, r.name)),
+		}
+		if rtsps := instance.rtspsURL(renditionPath(u.id, r.name)); rtsps != "" {
+			rs["rtsps"] = rtsps
+		}
+		if hls := instance.hlsURL(renditionPath(u.id, r.name)); hls != "" {
+			rs["hls"] = hls
+		}
+		if whep := instance.whepURL(renditionPath(u.id, r.name)); whep != "" {
+			rs["whep"] = whep
+		}
+		if rtmp := instance.rtmpURL(renditionPath(u.id, r.name)); rtmp != "" {
+			rs["rtmp"] = rtmp
+		}
+		if srt := instance.srtURL(renditionPath(u.id, r.name)); srt != "" {
+			rs["srt"] = srt
+		}
+		renditions[r.name] = rs
+	}
+
+	readers := instance.rtspReaders(u.id)
+
+	instance.m.Lock()
+	defer instance.m.Unlock()
+
+	res := map[string]any{
+		"rtspReaders": readers,
+		"original":    u.path.from.String(),
+		"source":      u.path.to.String(),
+		"profile":     u.path.profile.Name,
+		"restart":     u.conf.Restart,
+		"onDemand":    u.conf.OnDemand,
+		"record":      u.conf.Record,
+		"status":      StatusStopped,
+		"progress":    nil,
+	}
+
+	if rtsps := instance.rtspsURL(u.id); rtsps != "" {
+		res["rtsps"] = rtsps
+	}
+
+	if hls := instance.hlsURL(u.id); hls != "" {
+		res["hls"] = hls
+	}
+
+	if whep := instance.whepURL(u.id); whep != "" {
+		res["whep"] = whep
+	}
+
+	if rtmp := instance.rtmpURL(u.id); rtmp != "" {
+		res["rtmp"] = rtmp
+	}
+
+	if srt := instance.srtURL(u.id); srt != "" {
+		res["srt"] = srt
+	}
+
+	if u.ingests() {
+		// source could be published with RTSP to the original url as well
+		if ingest := instance.rtmpURL(ingestPath(u.id)); ingest != "" {
+			res["ingest"] = ingest
+		}
+	}
+
+	res["mode"] = ModeAuto
+	if u.conf.Mode != "" {
+		res["mode"] = u.conf.Mode
+	}
+
+	if len(renditions) > 0 {
+		res["renditions"] = renditions
+	}
+
+	if codec, probed := instance.codecs[u.id]; probed {
+		res["sourceCodec"] = codec
+	}
+
+	t, te := instance.transcoders[u.id]
+	if te && t != nil {
+		res["status"] = t.Status()
+		res["progress"] = t.Progress()
+		res["activeMode"] = ModeTranscode
+		if t.source.copyVideo {
+			res["activeMode"] = ModeCopy
+		}
+	} else if u.conf.OnDemand {
+		res["status"] = StatusIdle
+	}
+
+	h, he := instance.health[u.id]
+	if he {
+		if h.terminal != "" {
+			res["status"] = h.terminal
+		}
+		res["health"] = h.status()
+	}
+
+	return res
+}
+
 func (instance *Instance) run() {
-	if instance.retryAfterSeconds > 0 {
-		ticker := time.NewTicker(time.Duration(instance.retryAfterSeconds) * time.Second)
-		defer func() {
-			ticker.Stop()
-			instance.ctxF()
-		}()
+	ticker := time.NewTicker(superviseInterval)
+	defer func() {
+		ticker.Stop()
+		instance.ctxF()
+	}()
 
-		for instance.running.Load() {
-			select {
-			case <-ticker.C:
-				if !instance.running.Load() {
-					return
-				}
-			case <-instance.ctx.Done():
-				return
-			case <-instance.httpHandler.Done:
-				return
-			case <-instance.rtspHandler.Done:
+	for instance.running.Load() {
+		select {
+		case <-ticker.C:
+			if !instance.running.Load() {
 				return
 			}
+		case <-instance.ctx.Done():
+			return
+		case <-instance.httpHandler.Done:
+			return
+		case <-instance.rtspHandler.Done:
+			return
+		}
+
+		for _, u := range instance.unitsList() {
+			instance.superviseUnit(u)
+		}
+	}
+}
 
-			for _, u := range instance.units {
-				t, te := instance.transcoders[u.id]
+// checkUnit returns reason if unit needs restart, failed is false when transcoder exited cleanly
+func (instance *Instance) checkUnit(u Unit, t *Transcoder) (reason string, failed bool) {
+	if t == nil {
+		return "transcoder is stopped, missing or broken", true
+	}
 
-				if !te || t == nil || t.status != StatusOk || !instance.rtspHandler.PathExist(u.id) {
-					var us string
-					if !te || t == nil {
-						us = "transcoder is stopped, missing or broken"
-					} else if !instance.rtspHandler.PathExist(u.id) {
-						us = fmt.Sprintf("receiving rtsp path(%s) is stoppped, missing or broken", u.path.to.String())
-					} else if t.status != StatusOk {
-						us = "transcoder is stopped"
-					}
+	switch t.Status() {
+	case StatusError:
+		return "transcoder exited with error", true
+	case StatusStopped:
+		return "transcoder is stopped", false
+	}
 
-					log.Printf("unit #%s: %s, restarting unit\n", u.id, us)
+	// transcoder is given time to start publishing
+	instance.m.Lock()
+	h, he := instance.health[u.id]
+	publishing := he && time.Since(h.started) >= publishTimeout
+	instance.m.Unlock()
 
-					err := instance.RestartUnit(u)
+	if publishing {
+		if !instance.rtspHandler.PathReady(u.id) {
+			return fmt.Sprintf("receiving rtsp path(%s) is stopped, missing or broken", u.path.to.String()), true
+		}
 
-					if err != nil {
-						log.Printf("unit #%s: unit restart failed: %s\n", u.id, err.Error())
-					}
-					continue
-				}
+		for _, r := range u.path.renditions {
+			if !instance.rtspHandler.PathReady(renditionPath(u.id, r.name)) {
+				return fmt.Sprintf("rendition rtsp path(%s) is stopped, missing or broken", r.to.String()), true
 			}
 		}
 	}
+
+	stalled, reason := instance.observeUnit(u, t)
+	if stalled {
+		instance.events.Publish(EventUnitStalled, u.id, "", map[string]any{"reason": reason})
+		return reason, true
+	}
+
+	return "", false
+}
+
+// superviseUnit applies unit restart policy, restarts are delayed by exponential backoff
+func (instance *Instance) superviseUnit(u Unit) {
+	instance.m.Lock()
+	t := instance.transcoders[u.id]
+	h, he := instance.health[u.id]
+	if !he || h.terminal != "" {
+		instance.m.Unlock()
+		return
+	}
+	nextAttempt := h.nextAttempt
+	instance.m.Unlock()
+
+	// restart already scheduled
+	if !nextAttempt.IsZero() {
+		if time.Now().Before(nextAttempt) {
+			return
+		}
+
+		instance.m.Lock()
+		reason := h.pendingReason
+		h.nextAttempt = time.Time{}
+		instance.m.Unlock()
+
+		log.Printf("unit #%s: %s, restarting unit\n", u.id, reason)
+
+		err := instance.RestartUnit(u, reason)
+		if err != nil {
+			log.Printf("unit #%s: unit restart failed: %s\n", u.id, err.Error())
+		}
+
+		return
+	}
+
+	reason, failed := instance.checkUnit(u, t)
+
+	// on-demand unit is started again by the next reader, restart policy is not applied
+	if u.conf.OnDemand {
+		if t != nil && reason != "" {
+			log.Printf("unit #%s: %s, unit is idle\n", u.id, reason)
+			instance.stopTranscoder(u.id)
+		}
+		return
+	}
+
+	instance.m.Lock()
+
+	if reason == "" {
+		h.stable(instance.backoff.StableAfter)
+		instance.m.Unlock()
+		return
+	}
+
+	policy := u.conf.Restart
+	if !instance.backoff.Enabled() {
+		policy.Mode = RestartNever
+	}
+
+	restart, terminal := policy.shouldRestart(failed, h.attempts)
+	if !restart {
+		h.terminate(terminal, reason)
+		instance.m.Unlock()
+
+		instance.events.Publish(EventUnitFailed, u.id, "", map[string]any{"status": terminal, "reason": reason})
+
+		log.Printf("unit #%s: %s, unit is %s\n", u.id, reason, terminal)
+
+		_ = instance.stopUnit(u)
+
+		return
+	}
+
+	delay := instance.backoff.Delay(h.attempts)
+	h.schedule(delay, reason)
+	instance.m.Unlock()
+
+	log.Printf("unit #%s: %s, restarting unit in %s\n", u.id, reason, delay.Truncate(time.Millisecond))
+
+	// do not leave broken or stalled ffmpeg running while waiting
+	_ = instance.stopUnit(u)
+}
+
+// observeUnit feeds watchdog with current unit counters and reports whether unit is stalled
+func (instance *Instance) observeUnit(u Unit, t *Transcoder) (bool, string) {
+	bytesReceived, _ := instance.rtspHandler.PathBytesReceived(u.id)
+
+	var frame uint64
+	if p := t.Progress(); p != nil {
+		frame = p.Frame
+	}
+
+	instance.m.Lock()
+	defer instance.m.Unlock()
+
+	h, he := instance.health[u.id]
+	if !he {
+		return false, ""
+	}
+
+	h.observe(bytesReceived, frame)
+
+	return h.stalled(instance.stallTimeout)
 }
 
 func (instance *Instance) Stop() error {
@@ -206,6 +572,9 @@ func (instance *Instance) Stop() error {
 }
 
 func (instance *Instance) GetUnit(id string) *Unit {
+	instance.m.Lock()
+	defer instance.m.Unlock()
+
 	u, exist := instance.units[id]
 	if !exist {
 		return nil
@@ -214,73 +583,692 @@ func (instance *Instance) GetUnit(id string) *Unit {
 	return &u
 }
 
-func (instance *Instance) AddUnit(id string, fromSource string) (Source, error) {
-	path := NewSource(id, fromSource, fmt.Sprintf("rtsp://0.0.0.0%s/%s", instance.rtspHandler.RtspAddr, id))
+func (instance *Instance) unitsList() []Unit {
+	instance.m.Lock()
+	defer instance.m.Unlock()
 
-	_, exist := instance.units[id]
-	if exist {
-		return path, errors.New("unit already exists")
+	units := make([]Unit, 0, len(instance.units))
+	for _, u := range instance.units {
+		units = append(units, u)
 	}
 
-	err := instance.rtspHandler.AddPath(id)
+	return units
+}
+
+func (instance *Instance) AddUnit(conf UnitConfig) (Source, error) {
+	path, err := instance.addUnit(conf, false)
 	if err != nil {
 		return path, err
 	}
 
-	ptc, e := instance.transcoders[id]
-	if e && ptc != nil {
-		_ = ptc.Stop()
+	instance.saveState()
+
+	return path, nil
+}
+
+// addUnit creates and starts unit, managed units are declared by configuration file and are not persisted in state
+func (instance *Instance) addUnit(conf UnitConfig, managed bool) (Source, error) {
+	err := instance.validateUnit(conf)
+	if err != nil {
+		return Source{}, err
 	}
 
-	tc := NewTranscoder(path)
+	profile, err := instance.GetProfile(conf.Profile)
+	if err != nil {
+		return Source{}, err
+	}
 
-	err = tc.Start(instance.ctx)
+	path, err := instance.unitSource(conf, profile)
 	if err != nil {
 		return path, err
 	}
 
+	// id is reserved until unit is started, so concurrent additions of the same unit fail
 	instance.m.Lock()
-	instance.transcoders[id] = tc
+	_, exist := instance.units[conf.ID]
+	_, starting := instance.starting[conf.ID]
+	_, unrestored := instance.unrestored[conf.ID]
+	if !exist && !starting && !unrestored {
+		instance.starting[conf.ID] = struct{}{}
+	}
+	instance.m.Unlock()
+
+	if exist || starting {
+		return path, errors.New("unit already exists")
+	}
 
-	instance.units[id] = Unit{
-		id:   id,
-		path: path,
+	if unrestored {
+		// unit kept in state would be overwritten by the new one on save
+		return path, errors.New("unit already exists in state, but is not restored, remove it first")
 	}
 
+	u := Unit{
+		id:      conf.ID,
+		path:    path,
+		conf:    conf,
+		managed: managed,
+	}
+
+	instance.events.Publish(EventUnitCreated, u.id, "", nil)
+
+	err = instance.startUnit(u, true)
+	if err != nil {
+		// existing paths are not removed, they are used by somebody else
+		if !errors.Is(err, errPathExists) {
+			_ = instance.stopUnit(u)
+		}
+		instance.events.Publish(EventUnitRemoved, u.id, "", map[string]any{"reason": err.Error()})
+
+		instance.m.Lock()
+		delete(instance.starting, u.id)
+		instance.m.Unlock()
+
+		return path, err
+	}
+
+	instance.m.Lock()
+	delete(instance.starting, u.id)
+	instance.units[u.id] = u
+	instance.health[u.id] = newUnitHealth()
 	instance.m.Unlock()
 
 	return path, nil
 }
 
+// validateUnit checks unit configuration against instance settings
+func (instance *Instance) validateUnit(conf UnitConfig) error {
+	err := conf.Validate()
+	if err != nil {
+		return err
+	}
+
+	if conf.Record && instance.rtspHandler.Record.Path == "" {
+		return errors.New("recording is disabled")
+	}
+
+	return nil
+}
+
+func (instance *Instance) unitSource(conf UnitConfig, profile Profile) (Source, error) {
+	from := conf.Source
+	if from == SourcePublisher {
+		// transcoder reads published source from ingest path
+		from = instance.pathURL(ingestPath(conf.ID))
+	}
+
+	src, err := NewSource(conf.ID, from, instance.pathURL(conf.ID), profile)
+	if err != nil {
+		return src, err
+	}
+
+	src.pipe = instance.pipeIngest
+	src.ingest = conf.Source == SourcePublisher
+	src.user = instance.unitCredential(conf.ID)
+
+	for _, r := range conf.Renditions {
+		rp, err := instance.GetProfile(r.ProfileName())
+		if err != nil {
+			return src, fmt.Errorf("rendition '%s': %w", r.Name, err)
+		}
+
+		to, err := url.Parse(instance.pathURL(renditionPath(conf.ID, r.Name)))
+		if err != nil {
+			return src, err
+		}
+
+		src.renditions = append(src.renditions, renditionOutput{name: r.Name, to: *to, profile: rp})
+	}
+
+	return src, nil
+}
+
+func (instance *Instance) pathURL(path string) string {
+	return fmt.Sprintf("rtsp://0.0.0.0%s/%s", instance.rtspHandler.RtspAddr, path)
+}
+
+// rtspReaders returns RTSP and RTSPS readers of the path with their transport, TCP, UDP or UDP-multicast
+func (instance *Instance) rtspReaders(path string) []map[string]any {
+	sessions := instance.rtspHandler.PathReaders(path)
+	readers := make([]map[string]any, 0, len(sessions))
+
+	for _, s := range sessions {
+		transport := ""
+		if s.Transport != nil {
+			transport = *s.Transport
+		}
+
+		readers = append(readers, map[string]any{
+			"id":         s.ID.String(),
+			"remoteAddr": s.RemoteAddr,
+			"transport":  transport,
+			"bytesSent":  s.BytesSent,
+		})
+	}
+
+	return readers
+}
+
+// rtspsURL returns RTSPS url of the path, empty if RTSPS server is disabled
+func (instance *Instance) rtspsURL(path string) string {
+	if instance.rtspHandler.RTSPS.Encryption == conf.EncryptionNo {
+		return ""
+	}
+	return fmt.Sprintf("rtsps://0.0.0.0%s/%s", instance.rtspHandler.RTSPS.Address, path)
+}
+
+// hlsURL returns HLS playlist url of the path, empty if HLS server is disabled
+func (instance *Instance) hlsURL(path string) string {
+	if instance.rtspHandler.HLS.Address == "" {
+		return ""
+	}
+	return fmt.Sprintf("http://0.0.0.0%s/%s/index.m3u8", instance.rtspHandler.HLS.Address, path)
+}
+
+// whepURL returns WHEP endpoint url of the path, empty if WebRTC server is disabled
+func (instance *Instance) whepURL(path string) string {
+	if instance.rtspHandler.WebRTC.Address == "" {
+		return ""
+	}
+	return fmt.Sprintf("http://0.0.0.0%s/%s/whep", instance.rtspHandler.WebRTC.Address, path)
+}
+
+// rtmpURL returns RTMP url of the path, empty if RTMP server is disabled
+func (instance *Instance) rtmpURL(path string) string {
+	if instance.rtspHandler.RTMP.Address == "" {
+		return ""
+	}
+	return fmt.Sprintf("rtmp://0.0.0.0%s/%s", instance.rtspHandler.RTMP.Address, path)
+}
+
+// srtURL returns SRT url of the path, empty if SRT server is disabled
+func (instance *Instance) srtURL(path string) string {
+	if instance.rtspHandler.SRT.Address == "" {
+		return ""
+	}
+	return fmt.Sprintf("srt://0.0.0.0%s?streamid=read:%s", instance.rtspHandler.SRT.Address, path)
+}
+
+// renditionPath returns rtsp path name of unit rendition
+func renditionPath(id string, name string) string {
+	return id + "/" + name
+}
+
+// ingestPath returns path name which published unit source is written to
+func ingestPath(id string) string {
+	return id + "/" + ingestName
+}
+
+// UpdateUnit changes unit source, profile or restart policy, unit path and its readers are kept
+func (instance *Instance) UpdateUnit(id string, patch UnitPatch) (Source, error) {
+	u := instance.GetUnit(id)
+	if u == nil {
+		return Source{}, errors.New("unit does not exist")
+	}
+
+	if u.managed {
+		return u.path, errors.New("unit is declared by configuration file, it could be changed only there")
+	}
+
+	path, err := instance.updateUnit(*u, patch.Apply(u.conf))
+	if err != nil {
+		return path, err
+	}
+
+	instance.saveState()
+
+	return path, nil
+}
+
+// updateUnit replaces unit transcoder, readers are handed over to the new transcoder if its tracks are the same
+func (instance *Instance) updateUnit(u Unit, conf UnitConfig) (Source, error) {
+	err := instance.validateUnit(conf)
+	if err != nil {
+		return u.path, err
+	}
+
+	profile, err := instance.GetProfile(conf.Profile)
+	if err != nil {
+		return u.path, err
+	}
+
+	path, err := instance.unitSource(conf, profile)
+	if err != nil {
+		return u.path, err
+	}
+
+	// recording is toggled without restarting transcoder
+	withoutRecord := conf
+	withoutRecord.Record = u.conf.Record
+	if reflect.DeepEqual(withoutRecord, u.conf) && reflect.DeepEqual(path, u.path) {
+		err = instance.rtspHandler.SetPathRecording(u.id, conf.Record)
+		if err != nil {
+			return u.path, err
+		}
+
+		u.conf = conf
+
+		instance.m.Lock()
+		instance.units[u.id] = u
+		instance.m.Unlock()
+
+		if conf.Record {
+			log.Printf("unit #%s: recording started\n", u.id)
+		} else {
+			log.Printf("unit #%s: recording stopped\n", u.id)
+		}
+
+		return u.path, nil
+	}
+
+	updated := Unit{
+		id:      u.id,
+		path:    path,
+		conf:    conf,
+		managed: u.managed,
+	}
+
+	instance.m.Lock()
+	_, running := instance.transcoders[u.id]
+	instance.m.Unlock()
+
+	switch {
+	case u.conf.OnDemand != conf.OnDemand:
+		// path has to be recreated in another mode
+		_ = instance.stopUnit(u)
+
+	case running:
+		err = instance.rtspHandler.HandoverPath(u.id, handoverTimeout)
+		if err != nil {
+			log.Printf("unit #%s: readers handover failed: %s\n", u.id, err)
+		}
+
+		for _, r := range u.path.renditions {
+			if !updated.hasRendition(r.name) {
+				continue
+			}
+
+			err = instance.rtspHandler.HandoverPath(renditionPath(u.id, r.name), handoverTimeout)
+			if err != nil {
+				log.Printf("unit #%s: rendition '%s' readers handover failed: %s\n", u.id, r.name, err)
+			}
+		}
+	}
+
+	// paths of removed renditions are closed with their readers
+	for _, r := range u.path.renditions {
+		if !updated.hasRendition(r.name) {
+			_ = instance.rtspHandler.RemovePath(renditionPath(u.id, r.name))
+		}
+	}
+
+	// publisher of unit source is kept as long as unit source is published
+	if u.ingests() && !updated.ingests() {
+		_ = instance.rtspHandler.RemovePath(ingestPath(u.id))
+	}
+
+	instance.m.Lock()
+	instance.units[u.id] = updated
+	// restart history and terminal status belong to previous settings
+	instance.health[u.id] = newUnitHealth()
+	delete(instance.codecs, u.id)
+	instance.m.Unlock()
+
+	log.Printf("unit #%s: updated, source: %s, profile: %s\n", u.id, conf.Source, profile.Name)
+
+	if conf.OnDemand && running && u.conf.OnDemand {
+		// on-demand unit is being read, new transcoder takes over its readers
+		err = instance.startTranscoder(updated)
+	} else {
+		err = instance.startUnit(updated, false)
+	}
+
+	if err != nil {
+		// unit is kept, supervisor restarts it according to restart policy
+		return updated.path, err
+	}
+
+	return updated.path, nil
+}
+
+// errPathExists is returned by startUnit when path of a new unit is already used
+var errPathExists = errors.New("path already exists")
+
+// startUnit creates unit path if needed and starts unit transcoder, on-demand unit transcoder is started by readers.
+// Paths of new unit must not exist, restarted and updated units keep their paths
+func (instance *Instance) startUnit(u Unit, isNew bool) error {
+	if isNew {
+		for _, p := range u.paths() {
+			if instance.rtspHandler.PathExist(p) {
+				return fmt.Errorf("'%s': %w", p, errPathExists)
+			}
+		}
+	}
+
+	if !instance.rtspHandler.PathExist(u.id) {
+		var err error
+		switch {
+		case u.conf.OnDemand && instance.pipeIngest:
+			err = instance.rtspHandler.AddOnDemandInProcessPath(u.id, instance.onDemandStartTimeout, instance.onDemandCloseAfter)
+		case u.conf.OnDemand:
+			err = instance.rtspHandler.AddOnDemandPath(u.id, instance.onDemandStartTimeout, instance.onDemandCloseAfter)
+		default:
+			err = instance.addOutputPath(u.id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if u.ingests() && !instance.rtspHandler.PathExist(ingestPath(u.id)) {
+		err := instance.rtspHandler.AddPath(ingestPath(u.id))
+		if err != nil {
+			return err
+		}
+	}
+
+	// only unit stream is recorded, renditions are not
+	err := instance.rtspHandler.SetPathRecording(u.id, u.conf.Record)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range u.path.renditions {
+		name := renditionPath(u.id, r.name)
+		if instance.rtspHandler.PathExist(name) {
+			continue
+		}
+
+		err := instance.addOutputPath(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if u.conf.OnDemand {
+		return nil
+	}
+
+	return instance.startTranscoder(u)
+}
+
+// addOutputPath adds path published by unit transcoder, in pipe ingest mode nobody else could publish it
+func (instance *Instance) addOutputPath(path string) error {
+	if instance.pipeIngest {
+		return instance.rtspHandler.AddInProcessPath(path)
+	}
+	return instance.rtspHandler.AddPath(path)
+}
+
+// startTranscoder replaces unit transcoder with a new one
+func (instance *Instance) startTranscoder(u Unit) error {
+	instance.rtspHandler.SetServiceUser(u.id, unitUser(u.path))
+
+	// probe before stopping previous transcoder, keeping readers gap short
+	tc := NewTranscoder(instance.resolveMode(u))
+	tc.publisher = instance.rtspHandler
+	tc.onExit = func(code int, stderr []string, stopped bool) {
+		instance.events.Publish(EventFFMpegExit, u.id, "", map[string]any{"code": code, "stderr": stderr, "stopped": stopped})
+	}
+
+	instance.m.Lock()
+	ptc, e := instance.transcoders[u.id]
+	instance.m.Unlock()
+
+	if e && ptc != nil {
+		_ = ptc.Stop()
+	}
+
+	err := tc.Start(instance.ctx)
+	if err != nil {
+		return err
+	}
+
+	instance.m.Lock()
+	instance.transcoders[u.id] = tc
+
+	h, he := instance.health[u.id]
+	if he {
+		h.reset()
+	}
+	instance.m.Unlock()
+
+	instance.events.Publish(EventUnitStarted, u.id, "", map[string]any{"copyVideo": tc.source.copyVideo})
+
+	return nil
+}
+
 func (instance *Instance) RemoveUnit(id string) error {
-	_, exist := instance.units[id]
-	if !exist {
+	instance.m.Lock()
+	_, unrestored := instance.unrestored[id]
+	delete(instance.unrestored, id)
+	instance.m.Unlock()
+
+	if unrestored {
+		instance.saveState()
+		return nil
+	}
+
+	u := instance.GetUnit(id)
+	if u == nil {
 		return errors.New("unit does not exist")
 	}
 
+	err := instance.stopUnit(*u)
+	if err != nil {
+		return err
+	}
+
+	instance.m.Lock()
+	delete(instance.units, id)
+	delete(instance.health, id)
+	delete(instance.codecs, id)
+	instance.m.Unlock()
+
+	instance.events.Publish(EventUnitRemoved, id, "", nil)
+
+	instance.saveState()
+
+	return nil
+}
+
+// stopUnit stops unit transcoder and removes its paths, keeping unit itself and its health history
+func (instance *Instance) stopUnit(u Unit) error {
+	instance.stopTranscoder(u.id)
+
+	for _, p := range u.paths() {
+		_ = instance.rtspHandler.RemovePath(p)
+	}
+
+	instance.rtspHandler.RemoveServiceUser(u.id)
+
+	return nil
+}
+
+// resolveMode chooses whether unit video is copied or transcoded, in auto mode source codec is probed until known
+func (instance *Instance) resolveMode(u Unit) Source {
+	src := u.path
+
+	switch u.conf.Mode {
+	case ModeCopy:
+		src.copyVideo = true
+		return src
+	case ModeTranscode:
+		return src
+	}
+
+	if !u.path.profile.keepsPicture() {
+		return src
+	}
+
+	instance.m.Lock()
+	codec, probed := instance.codecs[u.id]
+	instance.m.Unlock()
+
+	if !probed {
+		var err error
+		from := u.path.input()
+		codec, err = probeVideoCodec(from.String(), probeTimeout)
+		if err != nil {
+			log.Printf("unit #%s: source probe failed, video is transcoded: %s\n", u.id, err.Error())
+			return src
+		}
+
+		instance.m.Lock()
+		instance.codecs[u.id] = codec
+		instance.m.Unlock()
+
+		log.Printf("unit #%s: source video codec is %s\n", u.id, codec)
+	}
+
+	src.copyVideo = codec == "H264"
+
+	return src
+}
+
+func (instance *Instance) stopTranscoder(id string) {
+	instance.m.Lock()
 	t, e := instance.transcoders[id]
+	delete(instance.transcoders, id)
+	instance.m.Unlock()
+
 	if e && t != nil {
 		_ = t.Stop()
 	}
+}
 
-	_ = instance.rtspHandler.RemovePath(id)
+// onDemandStart is called by rtsp server when on-demand unit gets its first reader
+func (instance *Instance) onDemandStart(id string) {
+	u := instance.GetUnit(id)
+	if u == nil || !u.conf.OnDemand {
+		return
+	}
 
-	instance.m.Lock()
+	log.Printf("unit #%s: starting on demand\n", id)
 
-	delete(instance.transcoders, id)
-	delete(instance.units, id)
+	err := instance.startTranscoder(*u)
+	if err != nil {
+		log.Printf("unit #%s: on-demand start failed: %s\n", id, err.Error())
+	}
+}
 
+// onDemandStop is called by rtsp server when on-demand unit is not read anymore or has not started in time
+func (instance *Instance) onDemandStop(id string, reason string) {
+	u := instance.GetUnit(id)
+	if u == nil || !u.conf.OnDemand {
+		return
+	}
+
+	log.Printf("unit #%s: stopping on demand, %s\n", id, reason)
+
+	instance.stopTranscoder(id)
+}
+
+func (instance *Instance) RestartUnit(unit Unit, reason string) error {
+	_ = instance.stopUnit(unit)
+
+	instance.m.Lock()
+	h, he := instance.health[unit.id]
+	if he {
+		h.restarted(reason)
+	}
 	instance.m.Unlock()
 
-	return nil
+	instance.events.Publish(EventUnitRestarted, unit.id, "", map[string]any{"reason": reason})
+
+	return instance.startUnit(unit, false)
 }
 
-func (instance *Instance) RestartUnit(unit Unit) error {
-	_ = instance.RemoveUnit(unit.id)
-	_, err := instance.AddUnit(unit.id, unit.path.from.String())
+// unitOfPath returns id of unit which path is unit path, its rendition or ingest path, empty if there is no such unit
+func (instance *Instance) unitOfPath(path string) string {
+	instance.m.Lock()
+	defer instance.m.Unlock()
+
+	if _, exist := instance.units[path]; exist {
+		return path
+	}
+
+	if i := strings.LastIndexByte(path, '/'); i > 0 {
+		if _, exist := instance.units[path[:i]]; exist {
+			return path[:i]
+		}
+	}
+
+	return ""
+}
+
+// onPathReady is called by rtsp server when path publisher starts publishing
+func (instance *Instance) onPathReady(path string) {
+	instance.events.Publish(EventUnitReady, instance.unitOfPath(path), path, nil)
+}
+
+// onReaderAdd is called by rtsp server when path gets a reader of any protocol
+func (instance *Instance) onReaderAdd(path string, reader defs.APIPathSourceOrReader) {
+	instance.events.Publish(EventReaderConnected, instance.unitOfPath(path), path, map[string]any{"type": reader.Type, "id": reader.ID})
+}
+
+// onReaderRemove is called by rtsp server when path reader leaves
+func (instance *Instance) onReaderRemove(path string, reader defs.APIPathSourceOrReader) {
+	instance.events.Publish(EventReaderDisconnected, instance.unitOfPath(path), path, map[string]any{"type": reader.Type, "id": reader.ID})
+}
+
+// restoreUnits adds units from state file, units which could not be added are kept in state
+func (instance *Instance) restoreUnits() {
+	if instance.state == nil {
+		return
+	}
+
+	confs, invalid, err := instance.state.Load()
 	if err != nil {
-		return err
+		log.Printf("state: units are not restored: %s\n", err)
+		return
 	}
 
-	return nil
+	instance.m.Lock()
+	for _, conf := range invalid {
+		instance.unrestored[conf.ID] = conf
+	}
+	instance.m.Unlock()
+
+	for _, conf := range confs {
+		_, err = instance.addUnit(conf, false)
+		if err != nil {
+			log.Printf("unit #%s: restore failed: %s\n", conf.ID, err)
+
+			instance.m.Lock()
+			instance.unrestored[conf.ID] = conf
+			instance.m.Unlock()
+
+			continue
+		}
+
+		log.Printf("unit #%s: restored\n", conf.ID)
+	}
+}
+
+func (instance *Instance) saveState() {
+	if instance.state == nil {
+		return
+	}
+
+	// snapshot and write are not interleaved, otherwise older snapshot could be written last
+	instance.saveM.Lock()
+	defer instance.saveM.Unlock()
+
+	instance.m.Lock()
+	confs := make([]UnitConfig, 0, len(instance.units)+len(instance.unrestored))
+	for _, u := range instance.units {
+		if u.managed {
+			continue
+		}
+		confs = append(confs, u.conf)
+	}
+	for _, conf := range instance.unrestored {
+		confs = append(confs, conf)
+	}
+	instance.m.Unlock()
+
+	err := instance.state.Save(confs)
+	if err != nil {
+		log.Printf("state: save failed: %s\n", err)
+	}
 }
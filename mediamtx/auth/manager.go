@@ -36,7 +36,11 @@ type Protocol string
 
 // ProtocolRTSP protocols.
 const (
-	ProtocolRTSP Protocol = "rtsp"
+	ProtocolRTSP   Protocol = "rtsp"
+	ProtocolHLS    Protocol = "hls"
+	ProtocolWebRTC Protocol = "webrtc"
+	ProtocolRTMP   Protocol = "rtmp"
+	ProtocolSRT    Protocol = "srt"
 )
 
 // Request is an authentication request.
@@ -46,7 +50,7 @@ type Request struct {
 	IP     net.IP
 	Action conf.AuthAction
 
-	// only for ActionPublish, ActionRead, ActionPlayback
+	// only for ActionPublish, ActionRead, ActionPlayback, ActionAPI
 	Path        string
 	Protocol    Protocol
 	ID          *uuid.UUID
@@ -68,9 +72,11 @@ func (e Error) Error() string {
 func matchesPermission(perms []conf.AuthInternalUserPermission, req *Request) bool {
 	for _, perm := range perms {
 		if perm.Action == req.Action {
+			// path of api permission is a scope of control API
 			if perm.Action == conf.AuthActionPublish ||
 				perm.Action == conf.AuthActionRead ||
-				perm.Action == conf.AuthActionPlayback {
+				perm.Action == conf.AuthActionPlayback ||
+				perm.Action == conf.AuthActionAPI {
 				switch {
 				case perm.Path == "":
 					return true
@@ -100,8 +106,10 @@ type customClaims struct {
 
 // Manager is the authentication manager.
 type Manager struct {
-	Method          conf.AuthMethod
-	InternalUsers   []conf.AuthInternalUser
+	Method        conf.AuthMethod
+	InternalUsers []conf.AuthInternalUser
+	// ServiceUsers are users of clients started by the service itself, they are checked with every method
+	ServiceUsers    []conf.AuthInternalUser
 	HTTPAddress     string
 	HTTPExclude     []conf.AuthInternalUserPermission
 	JWTJWKS         string
@@ -121,6 +129,13 @@ func (m *Manager) ReloadInternalUsers(u []conf.AuthInternalUser) {
 	m.InternalUsers = u
 }
 
+// ReloadServiceUsers reloads ServiceUsers.
+func (m *Manager) ReloadServiceUsers(u []conf.AuthInternalUser) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.ServiceUsers = u
+}
+
 // Authenticate authenticates a request.
 func (m *Manager) Authenticate(req *Request) error {
 	err := m.authenticateInner(req)
@@ -146,6 +161,10 @@ func (m *Manager) authenticateInner(req *Request) error {
 		}
 	}
 
+	if m.authenticateService(req, &rtspAuthHeader) == nil {
+		return nil
+	}
+
 	switch m.Method {
 	case conf.AuthMethodInternal:
 		return m.authenticateInternal(req, &rtspAuthHeader)
@@ -171,6 +190,19 @@ func (m *Manager) authenticateInternal(req *Request, rtspAuthHeader *headers.Aut
 	return fmt.Errorf("authentication failed")
 }
 
+func (m *Manager) authenticateService(req *Request, rtspAuthHeader *headers.Authorization) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, u := range m.ServiceUsers {
+		if err := m.authenticateWithUser(req, rtspAuthHeader, &u); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("authentication failed")
+}
+
 func (m *Manager) authenticateWithUser(
 	req *Request,
 	rtspAuthHeader *headers.Authorization,
@@ -233,7 +265,10 @@ func (m *Manager) authenticateHTTP(req *Request) error {
 		Query:    req.Query,
 	})
 
-	res, err := http.Post(m.HTTPAddress, "application/json", bytes.NewReader(enc))
+	// requests are authenticated by path manager one by one, so unresponsive server must not block it for long
+	c := &http.Client{Timeout: m.ReadTimeout}
+
+	res, err := c.Post(m.HTTPAddress, "application/json", bytes.NewReader(enc))
 	if err != nil {
 		return fmt.Errorf("HTTP request failed: %w", err)
 	}
@@ -0,0 +1,136 @@
+// Package certloader contains a certificate loader which reloads certificate when its files change.
+package certloader
+
+import (
+	"context"
+	"crypto/tls"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCheckInterval = 2 * time.Second
+)
+
+// fileStamp is modification time and size of a file, zero value when file is missing.
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+func stat(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{mod: fi.ModTime(), size: fi.Size()}
+}
+
+func (s fileStamp) equal(o fileStamp) bool {
+	return s.mod.Equal(o.mod) && s.size == o.size
+}
+
+// CertLoader loads certificate and key pair and reloads it when files change.
+// Connections opened before reload keep the previous certificate.
+type CertLoader struct {
+	CertPath string
+	KeyPath  string
+	// CheckInterval is how often files are checked for changes, defaults to 2 seconds
+	CheckInterval time.Duration
+	Parent        logger.Writer
+
+	mutex     sync.RWMutex
+	cert      *tls.Certificate
+	certStamp fileStamp
+	keyStamp  fileStamp
+
+	ctx       context.Context
+	ctxCancel func()
+	done      chan struct{}
+}
+
+// Initialize loads certificate and starts watching its files.
+func (cl *CertLoader) Initialize() error {
+	if cl.CheckInterval == 0 {
+		cl.CheckInterval = defaultCheckInterval
+	}
+
+	cl.certStamp = stat(cl.CertPath)
+	cl.keyStamp = stat(cl.KeyPath)
+
+	cert, err := tls.LoadX509KeyPair(cl.CertPath, cl.KeyPath)
+	if err != nil {
+		return err
+	}
+	cl.cert = &cert
+
+	cl.ctx, cl.ctxCancel = context.WithCancel(context.Background())
+	cl.done = make(chan struct{})
+
+	go cl.run()
+
+	return nil
+}
+
+// Close stops watching certificate files.
+func (cl *CertLoader) Close() {
+	cl.ctxCancel()
+	<-cl.done
+}
+
+// Log implements logger.Writer.
+func (cl *CertLoader) Log(level logger.Level, format string, args ...interface{}) {
+	cl.Parent.Log(level, "[cert loader] "+format, args...)
+}
+
+// GetCertificate returns the current certificate, it's meant to be used as tls.Config.GetCertificate.
+func (cl *CertLoader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cl.mutex.RLock()
+	defer cl.mutex.RUnlock()
+	return cl.cert, nil
+}
+
+func (cl *CertLoader) run() {
+	defer close(cl.done)
+
+	ticker := time.NewTicker(cl.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cl.check()
+
+		case <-cl.ctx.Done():
+			return
+		}
+	}
+}
+
+// check reloads certificate if any of its files has changed, the previous certificate is kept when files are invalid
+func (cl *CertLoader) check() {
+	certStamp := stat(cl.CertPath)
+	keyStamp := stat(cl.KeyPath)
+
+	if certStamp.equal(cl.certStamp) && keyStamp.equal(cl.keyStamp) {
+		return
+	}
+
+	// invalid pair is reported once, files are loaded again on their next change
+	cl.certStamp = certStamp
+	cl.keyStamp = keyStamp
+
+	cert, err := tls.LoadX509KeyPair(cl.CertPath, cl.KeyPath)
+	if err != nil {
+		cl.Log(logger.Error, "certificate reload failed, previous certificate is kept: %v", err)
+		return
+	}
+
+	cl.mutex.Lock()
+	cl.cert = &cert
+	cl.mutex.Unlock()
+
+	cl.Log(logger.Info, "certificate reloaded")
+}
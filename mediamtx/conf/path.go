@@ -106,6 +106,13 @@ type Path struct {
 
 	// Redirect source
 	SourceRedirect string `json:"sourceRedirect"`
+
+	// Record
+	Record                bool           `json:"record"`
+	RecordPath            string         `json:"recordPath"`
+	RecordFormat          RecordFormat   `json:"recordFormat"`
+	RecordPartDuration    StringDuration `json:"recordPartDuration"`
+	RecordSegmentDuration StringDuration `json:"recordSegmentDuration"`
 }
 
 func (pconf *Path) setDefaults() {
@@ -116,6 +123,12 @@ func (pconf *Path) setDefaults() {
 
 	// Publisher source
 	pconf.OverridePublisher = true
+
+	// Record
+	pconf.RecordPath = "./recordings/%path/%Y-%m-%d_%H-%M-%S-%f"
+	pconf.RecordFormat = RecordFormatFMP4
+	pconf.RecordPartDuration = StringDuration(1 * time.Second)
+	pconf.RecordSegmentDuration = 3600 * StringDuration(time.Second)
 }
 
 func newPath(defaults *Path, partial *OptionalPath) *Path {
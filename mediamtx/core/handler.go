@@ -5,12 +5,20 @@ import (
 	"errors"
 	auth2 "fearpro13/h265_transcoder/mediamtx/auth"
 	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/hls"
 	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/playback"
+	"fearpro13/h265_transcoder/mediamtx/record"
+	"fearpro13/h265_transcoder/mediamtx/rtmp"
 	"fearpro13/h265_transcoder/mediamtx/rtsp"
+	"fearpro13/h265_transcoder/mediamtx/srt"
+	"fearpro13/h265_transcoder/mediamtx/webrtc"
 	"fmt"
 	"github.com/bluenviron/gortsplib/v4"
 	"github.com/bluenviron/gortsplib/v4/pkg/auth"
 	"github.com/pion/logging"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -21,16 +29,170 @@ type RtspHandler struct {
 	Done     <-chan struct{}
 	pm       *pathManager
 	rts      *rtsp.Server
+	rtss     *rtsp.Server
+	hls      *hls.Server
+	playback *playback.Server
+	webrtc   *webrtc.Server
+	rtmp     *rtmp.Server
+	srt      *srt.Server
+	cleaner  *record.Cleaner
 	running  atomic.Bool
 	RtspAddr string
 	useUdp   bool
+
+	// pathConfs is a copy of path manager configuration, path manager gets a new map on every change
+	pathConfs map[string]*conf.Path
+	confMutex sync.Mutex
+
+	// users are users of internal authentication method, serviceUsers are users of clients started by the service itself, like unit transcoders
+	users        []conf.AuthInternalUser
+	serviceUsers map[string]conf.AuthInternalUser
+	usersMutex   sync.Mutex
+
+	// OnDemandStart is called when on-demand path needs a publisher, must be set before Start
+	OnDemandStart func(path string)
+	// OnDemandStop is called when on-demand path publisher is not needed anymore, must be set before Start
+	OnDemandStop func(path string, reason string)
+	// OnPathReady is called when path publisher starts publishing or takes over path, must not block and must be set before Start
+	OnPathReady func(path string)
+	// OnReaderAdd and OnReaderRemove are called when path readers come and go, must not block and must be set before Start
+	OnReaderAdd    func(path string, reader defs.APIPathSourceOrReader)
+	OnReaderRemove func(path string, reader defs.APIPathSourceOrReader)
+
+	// HLS configures HLS server serving every path, must be set before Start
+	HLS HLSOptions
+	// Record configures recording of paths, must be set before Start
+	Record RecordOptions
+	// Playback configures playback server of recordings, must be set before Start
+	Playback PlaybackOptions
+	// WebRTC configures WHEP server serving every path, must be set before Start
+	WebRTC WebRTCOptions
+	// RTMP configures RTMP server serving and accepting every path, must be set before Start
+	RTMP RTMPOptions
+	// SRT configures SRT server serving every path, must be set before Start
+	SRT SRTOptions
+	// Auth configures authentication of readers and publishers, must be set before Start
+	Auth AuthOptions
+	// RTSPS configures RTSPS server serving and accepting every path, must be set before Start
+	RTSPS RTSPSOptions
+	// UDP configures UDP and UDP-multicast transports of RTSP server, must be set before Start
+	UDP UDPOptions
+}
+
+// default addresses of UDP transport listeners
+const (
+	DefaultRTPAddress  = ":6512"
+	DefaultRTCPAddress = ":6513"
+)
+
+// UDPOptions configures UDP transports of RTSP server, unicast UDP is enabled by useUdp of NewRtspHandler,
+// empty addresses are replaced by defaults
+type UDPOptions struct {
+	RTPAddress  string
+	RTCPAddress string
+	// MulticastIPRange enables UDP-multicast transport, every path being read gets its own group from the range
+	MulticastIPRange  string
+	MulticastRTPPort  int
+	MulticastRTCPPort int
+}
+
+// HLSOptions configures HLS server, server is disabled when Address is empty
+type HLSOptions struct {
+	Address         string
+	AllowOrigin     string
+	Variant         conf.HLSVariant
+	SegmentCount    int
+	SegmentDuration conf.StringDuration
+	PartDuration    conf.StringDuration
+	// AlwaysRemux starts HLS muxer as soon as path is ready, otherwise muxer is started by first request
+	AlwaysRemux bool
+}
+
+// RecordOptions configures recording, recording is disabled when Path is empty
+type RecordOptions struct {
+	// Path is a segment path template without extension, see record.Path
+	Path            string
+	Format          conf.RecordFormat
+	PartDuration    conf.StringDuration
+	SegmentDuration conf.StringDuration
+	// DeleteAfter is a retention period of segments, zero keeps segments forever
+	DeleteAfter conf.StringDuration
+}
+
+// PlaybackOptions configures playback server, server is disabled when Address is empty
+type PlaybackOptions struct {
+	Address     string
+	AllowOrigin string
+}
+
+// WebRTCOptions configures WebRTC server, server is disabled when Address is empty
+type WebRTCOptions struct {
+	Address     string
+	AllowOrigin string
+	// LocalUDPAddress is the address of UDP port shared by every WebRTC session
+	LocalUDPAddress string
+	// AdditionalHosts are IPs or hostnames advertised to browsers besides IPs of interfaces
+	AdditionalHosts []string
+}
+
+// RTMPOptions configures RTMP server, server is disabled when Address is empty
+type RTMPOptions struct {
+	Address string
+}
+
+// SRTOptions configures SRT server, server is disabled when Address is empty
+type SRTOptions struct {
+	Address string
+	// ReadPassphrase encrypts connections of readers, empty passphrase disables encryption
+	ReadPassphrase string
+}
+
+// DefaultUsers allow anyone to read and play back every path, to publish unit sources to ingest paths and to use control API.
+// Unit outputs are published by unit transcoders with their own service users
+var DefaultUsers = conf.AuthInternalUsers{
+	{
+		User: "any",
+		Pass: "any",
+		Permissions: []conf.AuthInternalUserPermission{
+			{Action: conf.AuthActionRead},
+			{Action: conf.AuthActionPublish, Path: "~/ingest$"},
+			{Action: conf.AuthActionPlayback},
+			{Action: conf.AuthActionAPI},
+			{Action: conf.AuthActionMetrics},
+		},
+	},
+}
+
+// RTSPSOptions configures RTSPS server, server is disabled when Encryption is no.
+// Strict encryption limits plain RTSP server to loopback connections, which are used by the service itself
+type RTSPSOptions struct {
+	Encryption conf.Encryption
+	Address    string
+	// ServerCert and ServerKey are PEM files paths, certificate is reloaded when they change
+	ServerCert string
+	ServerKey  string
+}
+
+// AuthOptions configures authentication method, users of internal method are set by SetInternalUsers
+type AuthOptions struct {
+	Method conf.AuthMethod
+	// HTTPAddress is called with every request when Method is http, request is allowed on 2xx response
+	HTTPAddress string
+	// HTTPExclude are actions and paths allowed without calling HTTPAddress
+	HTTPExclude []conf.AuthInternalUserPermission
+	// JWTJWKS is url of key set which JWTs passed with jwt query are verified with when Method is jwt
+	JWTJWKS string
 }
 
 func NewRtspHandler(ctx context.Context, rtspPort uint16, useUdp bool) *RtspHandler {
 	handler := &RtspHandler{
-		running:  atomic.Bool{},
-		RtspAddr: fmt.Sprintf(":%d", rtspPort),
-		useUdp:   useUdp,
+		running:   atomic.Bool{},
+		RtspAddr:  fmt.Sprintf(":%d", rtspPort),
+		useUdp:    useUdp,
+		pathConfs: map[string]*conf.Path{},
+
+		users:        DefaultUsers,
+		serviceUsers: map[string]conf.AuthInternalUser{},
 	}
 
 	handler.ctx, handler.ctxF = context.WithCancel(ctx)
@@ -49,28 +211,20 @@ func (h *RtspHandler) Start() error {
 		return err
 	}
 
+	// users changed while path manager is being created must not be lost
+	h.usersMutex.Lock()
+
 	pm := &pathManager{
 		logLevel: conf.LogLevel(logging.LogLevelDebug),
 		authManager: &auth2.Manager{
-			Method: 0,
-			InternalUsers: []conf.AuthInternalUser{
-				{
-					User: "any",
-					Pass: "any",
-					IPs:  nil,
-					Permissions: []conf.AuthInternalUserPermission{
-						{
-							Action: conf.AuthActionRead,
-							Path:   "",
-						},
-						{
-							Action: conf.AuthActionPublish,
-							Path:   "",
-						},
-					},
-				},
-			},
-			ReadTimeout: 5 * time.Second,
+			Method:          h.Auth.Method,
+			InternalUsers:   h.users,
+			ServiceUsers:    h.serviceUsersList(),
+			HTTPAddress:     h.Auth.HTTPAddress,
+			HTTPExclude:     h.Auth.HTTPExclude,
+			JWTJWKS:         h.Auth.JWTJWKS,
+			ReadTimeout:     5 * time.Second,
+			RTSPAuthMethods: []auth.ValidateMethod{auth.ValidateMethodBasic},
 		},
 		rtspAddress:       h.RtspAddr,
 		readTimeout:       conf.StringDuration(5 * time.Second),
@@ -79,10 +233,33 @@ func (h *RtspHandler) Start() error {
 		udpMaxPayloadSize: 2000,
 		pathConfs:         map[string]*conf.Path{},
 		parent:            l,
+		onDemandStart:     h.OnDemandStart,
+		onDemandStop:      h.OnDemandStop,
+		onPathReady:       h.OnPathReady,
+		onReaderAdd:       h.OnReaderAdd,
+		onReaderRemove:    h.OnReaderRemove,
 	}
 	pm.initialize()
 
 	h.pm = pm
+	h.usersMutex.Unlock()
+
+	// servers started before a failure are closed in reverse order, path manager is closed last
+	var closers []func()
+	ok := false
+	defer func() {
+		if ok {
+			return
+		}
+
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+
+		h.rts, h.rtss, h.hls, h.playback, h.webrtc, h.rtmp, h.srt = nil, nil, nil, nil, nil, nil, nil
+	}()
+
+	closers = append(closers, pm.close)
 
 	allowedProto := map[conf.Protocol]struct{}{
 		conf.Protocol(gortsplib.TransportTCP): {},
@@ -92,8 +269,26 @@ func (h *RtspHandler) Start() error {
 		allowedProto[conf.Protocol(gortsplib.TransportUDP)] = struct{}{}
 	}
 
+	useMulticast := h.UDP.MulticastIPRange != ""
+	if useMulticast {
+		allowedProto[conf.Protocol(gortsplib.TransportUDPMulticast)] = struct{}{}
+	}
+
+	rtpAddress, rtcpAddress := h.UDP.RTPAddress, h.UDP.RTCPAddress
+	if rtpAddress == "" {
+		rtpAddress = DefaultRTPAddress
+	}
+	if rtcpAddress == "" {
+		rtcpAddress = DefaultRTCPAddress
+	}
+
+	rtspAddr := h.RtspAddr
+	if h.RTSPS.Encryption == conf.EncryptionStrict {
+		rtspAddr = "127.0.0.1" + h.RtspAddr
+	}
+
 	rts := &rtsp.Server{
-		Address: h.RtspAddr,
+		Address: rtspAddr,
 		AuthMethods: []auth.ValidateMethod{
 			auth.ValidateMethodBasic,
 		},
@@ -101,12 +296,12 @@ func (h *RtspHandler) Start() error {
 		WriteTimeout:      conf.StringDuration(5 * time.Second),
 		WriteQueueSize:    512,
 		UseUDP:            h.useUdp,
-		UseMulticast:      false,
-		RTPAddress:        "0.0.0.0:6512",
-		RTCPAddress:       "0.0.0.0:6513",
-		MulticastIPRange:  "",
-		MulticastRTPPort:  0,
-		MulticastRTCPPort: 0,
+		UseMulticast:      useMulticast,
+		RTPAddress:        rtpAddress,
+		RTCPAddress:       rtcpAddress,
+		MulticastIPRange:  h.UDP.MulticastIPRange,
+		MulticastRTPPort:  h.UDP.MulticastRTPPort,
+		MulticastRTCPPort: h.UDP.MulticastRTCPPort,
 		IsTLS:             false,
 		ServerCert:        "",
 		ServerKey:         "",
@@ -117,13 +312,157 @@ func (h *RtspHandler) Start() error {
 	}
 
 	err = rts.Initialize()
-
 	if err != nil {
 		return err
 	}
 
 	h.rts = rts
+	closers = append(closers, rts.Close)
+
+	if h.RTSPS.Encryption != conf.EncryptionNo {
+		// UDP transport is not encrypted, so RTSPS server accepts TCP only
+		rtss := &rtsp.Server{
+			Address: h.RTSPS.Address,
+			AuthMethods: []auth.ValidateMethod{
+				auth.ValidateMethodBasic,
+			},
+			ReadTimeout:    conf.StringDuration(5 * time.Second),
+			WriteTimeout:   conf.StringDuration(5 * time.Second),
+			WriteQueueSize: 512,
+			IsTLS:          true,
+			ServerCert:     h.RTSPS.ServerCert,
+			ServerKey:      h.RTSPS.ServerKey,
+			RTSPAddress:    h.RTSPS.Address,
+			Protocols:      map[conf.Protocol]struct{}{conf.Protocol(gortsplib.TransportTCP): {}},
+			PathManager:    pm,
+			Parent:         l,
+		}
+
+		err = rtss.Initialize()
+		if err != nil {
+			return err
+		}
 
+		h.rtss = rtss
+		closers = append(closers, rtss.Close)
+	}
+
+	if h.HLS.Address != "" {
+		hs := &hls.Server{
+			Address:         h.HLS.Address,
+			AllowOrigin:     h.HLS.AllowOrigin,
+			AlwaysRemux:     h.HLS.AlwaysRemux,
+			Variant:         h.HLS.Variant,
+			SegmentCount:    h.HLS.SegmentCount,
+			SegmentDuration: h.HLS.SegmentDuration,
+			PartDuration:    h.HLS.PartDuration,
+			SegmentMaxSize:  50 * 1024 * 1024,
+			ReadTimeout:     conf.StringDuration(5 * time.Second),
+			WriteQueueSize:  512,
+			MuxerCloseAfter: conf.StringDuration(60 * time.Second),
+			PathManager:     pm,
+			Parent:          l,
+		}
+
+		err = hs.Initialize()
+		if err != nil {
+			return err
+		}
+
+		h.hls = hs
+		closers = append(closers, hs.Close)
+		pm.setHLSServer(hs)
+	}
+
+	if h.Playback.Address != "" {
+		ps := &playback.Server{
+			Address:     h.Playback.Address,
+			AllowOrigin: h.Playback.AllowOrigin,
+			ReadTimeout: conf.StringDuration(5 * time.Second),
+			PathManager: pm,
+			Parent:      l,
+		}
+
+		err = ps.Initialize()
+		if err != nil {
+			return err
+		}
+
+		h.playback = ps
+		closers = append(closers, ps.Close)
+	}
+
+	if h.WebRTC.Address != "" {
+		ws := &webrtc.Server{
+			Address:          h.WebRTC.Address,
+			AllowOrigin:      h.WebRTC.AllowOrigin,
+			LocalUDPAddress:  h.WebRTC.LocalUDPAddress,
+			AdditionalHosts:  h.WebRTC.AdditionalHosts,
+			HandshakeTimeout: conf.StringDuration(10 * time.Second),
+			ReadTimeout:      conf.StringDuration(5 * time.Second),
+			WriteQueueSize:   512,
+			PathManager:      pm,
+			Parent:           l,
+		}
+
+		err = ws.Initialize()
+		if err != nil {
+			return err
+		}
+
+		h.webrtc = ws
+		closers = append(closers, ws.Close)
+	}
+
+	if h.RTMP.Address != "" {
+		ms := &rtmp.Server{
+			Address:        h.RTMP.Address,
+			ReadTimeout:    conf.StringDuration(5 * time.Second),
+			WriteTimeout:   conf.StringDuration(5 * time.Second),
+			WriteQueueSize: 512,
+			PathManager:    pm,
+			Parent:         l,
+		}
+
+		err = ms.Initialize()
+		if err != nil {
+			return err
+		}
+
+		h.rtmp = ms
+		closers = append(closers, ms.Close)
+	}
+
+	if h.SRT.Address != "" {
+		ss := &srt.Server{
+			Address:           h.SRT.Address,
+			ReadTimeout:       conf.StringDuration(5 * time.Second),
+			WriteQueueSize:    512,
+			UDPMaxPayloadSize: 1472,
+			PathManager:       pm,
+			Parent:            l,
+		}
+
+		err = ss.Initialize()
+		if err != nil {
+			return err
+		}
+
+		h.srt = ss
+		closers = append(closers, ss.Close)
+	}
+
+	if h.Record.Path != "" && h.Record.DeleteAfter != 0 {
+		h.cleaner = &record.Cleaner{
+			PathFormat:  h.Record.Path,
+			Format:      h.Record.Format,
+			DeleteAfter: time.Duration(h.Record.DeleteAfter),
+			Parent:      l,
+		}
+		h.cleaner.Initialize()
+	}
+
+	ok = true
 	h.running.Store(true)
 
 	go func() {
@@ -145,17 +484,83 @@ func (h *RtspHandler) Stop() {
 	h.running.Store(false)
 	h.ctxF()
 
+	if h.hls != nil {
+		h.hls.Close()
+	}
+
+	if h.playback != nil {
+		h.playback.Close()
+	}
+
+	if h.webrtc != nil {
+		h.webrtc.Close()
+	}
+
+	if h.rtmp != nil {
+		h.rtmp.Close()
+	}
+
+	if h.srt != nil {
+		h.srt.Close()
+	}
+
 	h.pm.close()
 	h.rts.Close()
+
+	if h.rtss != nil {
+		h.rtss.Close()
+	}
+
+	if h.cleaner != nil {
+		h.cleaner.Close()
+	}
 }
 
 func (h *RtspHandler) PathExist(name string) bool {
-	_, e := h.pm.paths[name]
+	h.confMutex.Lock()
+	defer h.confMutex.Unlock()
+
+	_, e := h.pathConfs[name]
 
 	return e
 }
 
 func (h *RtspHandler) AddPath(path string) error {
+	return h.addPath(h.pathConf(path))
+}
+
+// AddOnDemandPath adds path which publisher is requested by OnDemandStart on first reader,
+// readers wait for publisher up to startTimeout, publisher is released by OnDemandStop after last reader leaves and closeAfter passes
+func (h *RtspHandler) AddOnDemandPath(path string, startTimeout time.Duration, closeAfter time.Duration) error {
+	return h.addPath(h.onDemandPathConf(path, startTimeout, closeAfter))
+}
+
+// AddInProcessPath adds path which is published by Publish only, RTSP and RTMP publishers are rejected
+func (h *RtspHandler) AddInProcessPath(path string) error {
+	pathConf := h.pathConf(path)
+	pathConf.Source = pathSourceInProcess
+
+	return h.addPath(pathConf)
+}
+
+// AddOnDemandInProcessPath adds on-demand path, see AddOnDemandPath, which is published by Publish only
+func (h *RtspHandler) AddOnDemandInProcessPath(path string, startTimeout time.Duration, closeAfter time.Duration) error {
+	pathConf := h.onDemandPathConf(path, startTimeout, closeAfter)
+	pathConf.Source = pathSourceInProcess
+
+	return h.addPath(pathConf)
+}
+
+func (h *RtspHandler) onDemandPathConf(path string, startTimeout time.Duration, closeAfter time.Duration) *conf.Path {
+	pathConf := h.pathConf(path)
+	pathConf.SourceOnDemand = true
+	pathConf.SourceOnDemandStartTimeout = conf.StringDuration(startTimeout)
+	pathConf.SourceOnDemandCloseAfter = conf.StringDuration(closeAfter)
+
+	return pathConf
+}
+
+func (h *RtspHandler) pathConf(path string) *conf.Path {
 	tTcp := gortsplib.TransportTCP
 
 	pathConf := &conf.Path{
@@ -170,31 +575,195 @@ func (h *RtspHandler) AddPath(path string) error {
 		RTSPRangeType:  0,
 		RTSPRangeStart: "",
 		SourceRedirect: "",
+
+		SRTReadPassphrase: h.SRT.ReadPassphrase,
+
+		RecordPath:            h.Record.Path,
+		RecordFormat:          h.Record.Format,
+		RecordPartDuration:    h.Record.PartDuration,
+		RecordSegmentDuration: h.Record.SegmentDuration,
 	}
 
-	currentConfs := h.pm.pathConfs
-	_, e := currentConfs[path]
+	return pathConf
+}
+
+func (h *RtspHandler) addPath(pathConf *conf.Path) error {
+	h.confMutex.Lock()
+	defer h.confMutex.Unlock()
+
+	_, e := h.pathConfs[pathConf.Name]
 	if e {
 		return errors.New("path already exist")
 	}
 
-	currentConfs[path] = pathConf
+	h.pathConfs[pathConf.Name] = pathConf
 
-	h.pm.ReloadPathConfs(currentConfs)
+	h.pm.ReloadPathConfs(h.copyPathConfs())
 
 	return nil
 }
 
 func (h *RtspHandler) RemovePath(path string) error {
-	currentConfs := h.pm.pathConfs
-	_, e := currentConfs[path]
+	h.confMutex.Lock()
+	defer h.confMutex.Unlock()
+
+	_, e := h.pathConfs[path]
+	if !e {
+		return errors.New("path does not exist")
+	}
+
+	delete(h.pathConfs, path)
+
+	h.pm.ReloadPathConfs(h.copyPathConfs())
+
+	return nil
+}
+
+// SetPathRecording starts or stops recording of path, recording is running while path has a publisher
+func (h *RtspHandler) SetPathRecording(path string, record bool) error {
+	if record && h.Record.Path == "" {
+		return errors.New("recording is disabled")
+	}
+
+	h.confMutex.Lock()
+	defer h.confMutex.Unlock()
+
+	pathConf, e := h.pathConfs[path]
 	if !e {
 		return errors.New("path does not exist")
 	}
 
-	delete(currentConfs, path)
+	if pathConf.Record == record {
+		return nil
+	}
+
+	// path manager detects changes by comparing configurations, so the one it holds must not be modified
+	newConf := *pathConf
+	newConf.Record = record
+	h.pathConfs[path] = &newConf
 
-	h.pm.ReloadPathConfs(currentConfs)
+	h.pm.ReloadPathConfs(h.copyPathConfs())
 
 	return nil
 }
+
+// copyPathConfs is needed because path manager compares new configuration with the one it holds
+func (h *RtspHandler) copyPathConfs() map[string]*conf.Path {
+	confs := make(map[string]*conf.Path, len(h.pathConfs))
+	for name, pathConf := range h.pathConfs {
+		confs[name] = pathConf
+	}
+
+	return confs
+}
+
+// PathBytesReceived returns bytes received from path publisher, false if path has no ready publisher
+func (h *RtspHandler) PathBytesReceived(name string) (uint64, bool) {
+	p, err := h.pm.APIPathsGet(name)
+	if err != nil || !p.Ready {
+		return 0, false
+	}
+
+	return p.BytesReceived, true
+}
+
+// PathReady returns true if path exists and is being published
+func (h *RtspHandler) PathReady(name string) bool {
+	p, err := h.pm.APIPathsGet(name)
+	return err == nil && p.Ready
+}
+
+// PathStats returns path statistics, false if path does not exist
+func (h *RtspHandler) PathStats(name string) (*defs.APIPath, bool) {
+	p, err := h.pm.APIPathsGet(name)
+	if err != nil {
+		return nil, false
+	}
+
+	return p, true
+}
+
+// PathReaders returns RTSP and RTSPS sessions reading the path, transport of multicast readers is UDP-multicast
+func (h *RtspHandler) PathReaders(name string) []*defs.APIRTSPSession {
+	var readers []*defs.APIRTSPSession
+
+	for _, srv := range []*rtsp.Server{h.rts, h.rtss} {
+		if srv == nil {
+			continue
+		}
+
+		sessions, err := srv.APISessionsList()
+		if err != nil {
+			continue
+		}
+
+		for _, s := range sessions.Items {
+			if s.Path == name && s.State == defs.APIRTSPSessionStateRead {
+				readers = append(readers, s)
+			}
+		}
+	}
+
+	return readers
+}
+
+// HandoverPath keeps path readers connected while path publisher is replaced
+func (h *RtspHandler) HandoverPath(name string, timeout time.Duration) error {
+	return h.pm.HandoverPublisher(name, timeout)
+}
+
+// Authenticate checks request by configured authentication method, it allows other servers to share users of paths
+func (h *RtspHandler) Authenticate(req *auth2.Request) error {
+	return h.pm.authManager.Authenticate(req)
+}
+
+// SetInternalUsers replaces users of internal authentication method, users of connected clients are checked on next request only
+func (h *RtspHandler) SetInternalUsers(users []conf.AuthInternalUser) {
+	h.usersMutex.Lock()
+	defer h.usersMutex.Unlock()
+
+	h.users = users
+
+	if h.pm != nil {
+		h.pm.authManager.ReloadInternalUsers(users)
+	}
+}
+
+// SetServiceUser adds or replaces user of a client started by the service, it's checked with every authentication method.
+// Name identifies user for RemoveServiceUser
+func (h *RtspHandler) SetServiceUser(name string, user conf.AuthInternalUser) {
+	h.usersMutex.Lock()
+	defer h.usersMutex.Unlock()
+
+	h.serviceUsers[name] = user
+	h.reloadServiceUsers()
+}
+
+func (h *RtspHandler) RemoveServiceUser(name string) {
+	h.usersMutex.Lock()
+	defer h.usersMutex.Unlock()
+
+	if _, e := h.serviceUsers[name]; !e {
+		return
+	}
+
+	delete(h.serviceUsers, name)
+	h.reloadServiceUsers()
+}
+
+// reloadServiceUsers passes service users to authentication manager once handler is started, usersMutex must be held
+func (h *RtspHandler) reloadServiceUsers() {
+	if h.pm != nil {
+		h.pm.authManager.ReloadServiceUsers(h.serviceUsersList())
+	}
+}
+
+// serviceUsersList usersMutex must be held
+func (h *RtspHandler) serviceUsersList() []conf.AuthInternalUser {
+	users := make([]conf.AuthInternalUser, 0, len(h.serviceUsers))
+	for _, u := range h.serviceUsers {
+		users = append(users, u)
+	}
+
+	return users
+}
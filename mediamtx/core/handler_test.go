@@ -0,0 +1,43 @@
+package core
+
+import (
+	"context"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestHandlerStartFailure(t *testing.T) {
+	port := test.FreeTCPPort(t)
+
+	// RTMP server can't listen on occupied port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	h := NewRtspHandler(context.Background(), uint16(port), false)
+	h.HLS = HLSOptions{Address: fmt.Sprintf("127.0.0.1:%d", test.FreeTCPPort(t)), SegmentCount: 7}
+	h.RTMP = RTMPOptions{Address: ln.Addr().String()}
+
+	err = h.Start()
+	if err == nil {
+		h.Stop()
+		t.Fatal("occupied port has not been rejected")
+	}
+
+	if h.rts != nil || h.hls != nil || h.rtmp != nil {
+		t.Fatal("servers started before failure are kept")
+	}
+
+	// servers started before failure have released their ports
+	h.RTMP = RTMPOptions{}
+
+	err = h.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Stop()
+}
@@ -5,6 +5,7 @@ import (
 	"fearpro13/h265_transcoder/mediamtx/conf"
 	"fearpro13/h265_transcoder/mediamtx/defs"
 	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/record"
 	"fearpro13/h265_transcoder/mediamtx/stream"
 	"fmt"
 	"strings"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/bluenviron/gortsplib/v4/pkg/base"
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
 )
 
 func emptyTimer() *time.Timer {
@@ -26,6 +28,11 @@ type pathParent interface {
 	pathReady(*path)
 	pathNotReady(*path)
 	closePath(*path)
+	onDemandPublisherStart(*path)
+	onDemandPublisherStop(*path, string)
+	publisherReady(*path)
+	readerAdded(*path, defs.Reader)
+	readerRemoved(*path, defs.Reader)
 }
 
 type pathOnDemandState int
@@ -57,6 +64,11 @@ type pathAPIPathsGetReq struct {
 	res  chan pathAPIPathsGetRes
 }
 
+type pathHandoverReq struct {
+	timeout time.Duration
+	res     chan struct{}
+}
+
 type path struct {
 	parentCtx         context.Context
 	logLevel          conf.LogLevel
@@ -85,6 +97,9 @@ type path struct {
 	onDemandPublisherState      pathOnDemandState
 	onDemandPublisherReadyTimer *time.Timer
 	onDemandPublisherCloseTimer *time.Timer
+	handoverPending             bool
+	handoverTimer               *time.Timer
+	recordAgent                 *record.Agent
 
 	// in
 	chReloadConf      chan *conf.Path
@@ -96,6 +111,7 @@ type path struct {
 	chAddReader       chan defs.PathAddReaderReq
 	chRemoveReader    chan defs.PathRemoveReaderReq
 	chAPIPathsGet     chan pathAPIPathsGetReq
+	chHandover        chan pathHandoverReq
 
 	// out
 	done chan struct{}
@@ -109,6 +125,7 @@ func (pa *path) initialize() {
 	pa.readers = make(map[defs.Reader]struct{})
 	pa.onDemandPublisherReadyTimer = emptyTimer()
 	pa.onDemandPublisherCloseTimer = emptyTimer()
+	pa.handoverTimer = emptyTimer()
 	pa.chReloadConf = make(chan *conf.Path)
 	pa.chDescribe = make(chan defs.PathDescribeReq)
 	pa.chAddPublisher = make(chan defs.PathAddPublisherReq)
@@ -118,6 +135,7 @@ func (pa *path) initialize() {
 	pa.chAddReader = make(chan defs.PathAddReaderReq)
 	pa.chRemoveReader = make(chan defs.PathRemoveReaderReq)
 	pa.chAPIPathsGet = make(chan pathAPIPathsGetReq)
+	pa.chHandover = make(chan pathHandoverReq)
 	pa.done = make(chan struct{})
 
 	pa.Log(logger.Debug, "created")
@@ -160,6 +178,7 @@ func (pa *path) run() {
 
 	pa.onDemandPublisherReadyTimer.Stop()
 	pa.onDemandPublisherCloseTimer.Stop()
+	pa.handoverTimer.Stop()
 
 	for _, req := range pa.describeRequestsOnHold {
 		req.Res <- defs.PathDescribeRes{Err: fmt.Errorf("terminated")}
@@ -195,6 +214,12 @@ func (pa *path) runInner() error {
 		case <-pa.onDemandPublisherCloseTimer.C:
 			pa.doOnDemandPublisherCloseTimer()
 
+		case <-pa.handoverTimer.C:
+			pa.doHandoverTimer()
+
+		case req := <-pa.chHandover:
+			pa.doHandover(req)
+
 		case newConf := <-pa.chReloadConf:
 			pa.doReloadConf(newConf)
 
@@ -262,10 +287,42 @@ func (pa *path) doOnDemandPublisherCloseTimer() {
 	pa.onDemandPublisherStop("not needed by anyone")
 }
 
+func (pa *path) doHandover(req pathHandoverReq) {
+	pa.handoverPending = true
+
+	pa.handoverTimer.Stop()
+	pa.handoverTimer = time.NewTimer(req.timeout)
+
+	close(req.res)
+}
+
+func (pa *path) doHandoverTimer() {
+	pa.handoverPending = false
+
+	// next publisher did not show up in time
+	if pa.source == nil && pa.stream != nil {
+		pa.Log(logger.Info, "publisher handover has timed out")
+		pa.setNotReady()
+
+		if pa.hasOnDemandPublisher() {
+			pa.onDemandPublisherStop("publisher handover has timed out")
+		}
+	}
+}
+
 func (pa *path) doReloadConf(newConf *conf.Path) {
 	pa.confMutex.Lock()
 	pa.conf = newConf
 	pa.confMutex.Unlock()
+
+	if pa.conf.Record {
+		if pa.stream != nil && pa.recordAgent == nil {
+			pa.startRecording()
+		}
+	} else if pa.recordAgent != nil {
+		pa.recordAgent.Close()
+		pa.recordAgent = nil
+	}
 }
 
 func (pa *path) doDescribe(req defs.PathDescribeReq) {
@@ -283,6 +340,14 @@ func (pa *path) doDescribe(req defs.PathDescribeReq) {
 		return
 	}
 
+	if pa.hasOnDemandPublisher() {
+		if pa.onDemandPublisherState == pathOnDemandStateInitial {
+			pa.onDemandPublisherStart()
+		}
+		pa.describeRequestsOnHold = append(pa.describeRequestsOnHold, req)
+		return
+	}
+
 	if pa.conf.Fallback != "" {
 		fallbackURL := func() string {
 			if strings.HasPrefix(pa.conf.Fallback, "/") {
@@ -311,7 +376,14 @@ func (pa *path) doRemovePublisher(req defs.PathRemovePublisherReq) {
 }
 
 func (pa *path) doAddPublisher(req defs.PathAddPublisherReq) {
-	if pa.conf.Source != "publisher" {
+	if pa.conf.Source == pathSourceInProcess {
+		if _, ok := req.Author.(*Publisher); !ok {
+			req.Res <- defs.PathAddPublisherRes{
+				Err: fmt.Errorf("can't publish to path '%s' since it is published in-process", pa.name),
+			}
+			return
+		}
+	} else if pa.conf.Source != "publisher" {
 		req.Res <- defs.PathAddPublisherRes{
 			Err: fmt.Errorf("can't publish to path '%s' since 'source' is not 'publisher'", pa.name),
 		}
@@ -341,23 +413,60 @@ func (pa *path) doStartPublisher(req defs.PathStartPublisherReq) {
 		return
 	}
 
+	// stream has been kept for this publisher by handover
+	if pa.stream != nil {
+		if pa.handoverPending && descCompatible(pa.stream.Desc(), req.Desc) {
+			pa.handoverPending = false
+			pa.handoverTimer.Stop()
+			pa.handoverTimer = emptyTimer()
+
+			updateStreamParams(pa.stream.Desc(), req.Desc)
+
+			req.Author.Log(logger.Info, "took over path '%s', %d readers kept, %s",
+				pa.name,
+				len(pa.readers),
+				defs.MediasInfo(req.Desc.Medias))
+
+			pa.parent.publisherReady(pa)
+
+			pa.onDemandPublisherReady()
+			pa.consumeOnHoldRequests()
+
+			req.Res <- defs.PathStartPublisherRes{Stream: pa.stream}
+			return
+		}
+
+		pa.Log(logger.Info, "publisher tracks have changed, readers are closed")
+		pa.setNotReady()
+	}
+
 	err := pa.setReady(req.Desc, req.GenerateRTPPackets)
 	if err != nil {
 		req.Res <- defs.PathStartPublisherRes{Err: err}
 		return
 	}
 
+	// there was no stream to keep
+	if pa.handoverPending {
+		pa.handoverPending = false
+		pa.handoverTimer.Stop()
+		pa.handoverTimer = emptyTimer()
+	}
+
 	req.Author.Log(logger.Info, "is publishing to path '%s', %s",
 		pa.name,
 		defs.MediasInfo(req.Desc.Medias))
 
+	pa.parent.publisherReady(pa)
+
+	pa.onDemandPublisherReady()
 	pa.consumeOnHoldRequests()
 
 	req.Res <- defs.PathStartPublisherRes{Stream: pa.stream}
 }
 
 func (pa *path) doStopPublisher(req defs.PathStopPublisherReq) {
-	if req.Author == pa.source && pa.stream != nil {
+	if req.Author == pa.source && pa.stream != nil && !pa.handoverPending {
 		pa.setNotReady()
 	}
 	close(req.Res)
@@ -369,6 +478,14 @@ func (pa *path) doAddReader(req defs.PathAddReaderReq) {
 		return
 	}
 
+	if pa.hasOnDemandPublisher() {
+		if pa.onDemandPublisherState == pathOnDemandStateInitial {
+			pa.onDemandPublisherStart()
+		}
+		pa.readerAddRequestsOnHold = append(pa.readerAddRequestsOnHold, req)
+		return
+	}
+
 	req.Res <- defs.PathAddReaderRes{Err: defs.PathNoOnePublishingError{PathName: pa.name}}
 }
 
@@ -417,6 +534,18 @@ func (pa *path) doAPIPathsGet(req pathAPIPathsGetReq) {
 				}
 				return pa.stream.BytesSent()
 			}(),
+			RTPPacketsLost: func() uint64 {
+				if pa.stream == nil {
+					return 0
+				}
+				return pa.stream.RTPPacketsLost()
+			}(),
+			DecodeErrors: func() uint64 {
+				if pa.stream == nil {
+					return 0
+				}
+				return pa.stream.DecodeErrors()
+			}(),
 			Readers: func() []defs.APIPathSourceOrReader {
 				ret := []defs.APIPathSourceOrReader{}
 				for r := range pa.readers {
@@ -442,13 +571,66 @@ func (pa *path) shouldClose() bool {
 		len(pa.readerAddRequestsOnHold) == 0
 }
 
+func (pa *path) hasOnDemandPublisher() bool {
+	return (pa.conf.Source == "publisher" || pa.conf.Source == pathSourceInProcess) && pa.conf.SourceOnDemand
+}
+
+func (pa *path) onDemandPublisherStart() {
+	pa.Log(logger.Info, "starting on-demand publisher")
+
+	pa.onDemandPublisherReadyTimer.Stop()
+	pa.onDemandPublisherReadyTimer = time.NewTimer(time.Duration(pa.conf.SourceOnDemandStartTimeout))
+
+	pa.onDemandPublisherState = pathOnDemandStateWaitingReady
+
+	pa.parent.onDemandPublisherStart(pa)
+}
+
+// onDemandPublisherReady is called when publisher starts publishing, publisher could show up without demand as well
+func (pa *path) onDemandPublisherReady() {
+	if !pa.hasOnDemandPublisher() {
+		return
+	}
+
+	if pa.onDemandPublisherState == pathOnDemandStateWaitingReady {
+		pa.onDemandPublisherReadyTimer.Stop()
+		pa.onDemandPublisherReadyTimer = emptyTimer()
+	}
+
+	pa.onDemandPublisherState = pathOnDemandStateReady
+
+	if len(pa.readers) == 0 && len(pa.readerAddRequestsOnHold) == 0 {
+		pa.onDemandPublisherScheduleClose()
+	}
+}
+
+func (pa *path) onDemandPublisherScheduleClose() {
+	pa.onDemandPublisherCloseTimer.Stop()
+	pa.onDemandPublisherCloseTimer = time.NewTimer(time.Duration(pa.conf.SourceOnDemandCloseAfter))
+
+	pa.onDemandPublisherState = pathOnDemandStateClosing
+}
+
 func (pa *path) onDemandPublisherStop(reason string) {
+	if pa.onDemandPublisherState == pathOnDemandStateInitial {
+		return
+	}
+
+	if pa.onDemandPublisherState == pathOnDemandStateWaitingReady {
+		pa.onDemandPublisherReadyTimer.Stop()
+		pa.onDemandPublisherReadyTimer = emptyTimer()
+	}
+
 	if pa.onDemandPublisherState == pathOnDemandStateClosing {
 		pa.onDemandPublisherCloseTimer.Stop()
 		pa.onDemandPublisherCloseTimer = emptyTimer()
 	}
 
 	pa.onDemandPublisherState = pathOnDemandStateInitial
+
+	pa.Log(logger.Info, "stopping on-demand publisher: %s", reason)
+
+	pa.parent.onDemandPublisherStop(pa, reason)
 }
 
 func (pa *path) setReady(desc *description.Session, allocateEncoder bool) error {
@@ -465,11 +647,29 @@ func (pa *path) setReady(desc *description.Session, allocateEncoder bool) error
 
 	pa.readyTime = time.Now()
 
+	if pa.conf.Record {
+		pa.startRecording()
+	}
+
 	pa.parent.pathReady(pa)
 
 	return nil
 }
 
+func (pa *path) startRecording() {
+	pa.recordAgent = &record.Agent{
+		WriteQueueSize:  pa.writeQueueSize,
+		PathFormat:      pa.conf.RecordPath,
+		Format:          pa.conf.RecordFormat,
+		PartDuration:    time.Duration(pa.conf.RecordPartDuration),
+		SegmentDuration: time.Duration(pa.conf.RecordSegmentDuration),
+		PathName:        pa.name,
+		Stream:          pa.stream,
+		Parent:          pa,
+	}
+	pa.recordAgent.Initialize()
+}
+
 func (pa *path) consumeOnHoldRequests() {
 	for _, req := range pa.describeRequestsOnHold {
 		req.Res <- defs.PathDescribeRes{
@@ -492,6 +692,11 @@ func (pa *path) setNotReady() {
 		r.Close()
 	}
 
+	if pa.recordAgent != nil {
+		pa.recordAgent.Close()
+		pa.recordAgent = nil
+	}
+
 	if pa.stream != nil {
 		pa.stream.Close()
 		pa.stream = nil
@@ -500,13 +705,24 @@ func (pa *path) setNotReady() {
 
 func (pa *path) executeRemoveReader(r defs.Reader) {
 	delete(pa.readers, r)
+	pa.parent.readerRemoved(pa, r)
+
+	if len(pa.readers) == 0 && pa.hasOnDemandPublisher() &&
+		pa.onDemandPublisherState == pathOnDemandStateReady {
+		pa.onDemandPublisherScheduleClose()
+	}
 }
 
 func (pa *path) executeRemovePublisher() {
-	if pa.stream != nil {
+	// keep stream and its readers for the next publisher
+	if pa.stream != nil && !pa.handoverPending {
 		pa.setNotReady()
 	}
 
+	if !pa.handoverPending && pa.hasOnDemandPublisher() {
+		pa.onDemandPublisherStop("publisher has been removed")
+	}
+
 	pa.source = nil
 }
 
@@ -524,7 +740,14 @@ func (pa *path) addReaderPost(req defs.PathAddReaderReq) {
 		return
 	}
 
+	if pa.onDemandPublisherState == pathOnDemandStateClosing {
+		pa.onDemandPublisherCloseTimer.Stop()
+		pa.onDemandPublisherCloseTimer = emptyTimer()
+		pa.onDemandPublisherState = pathOnDemandStateReady
+	}
+
 	pa.readers[req.Author] = struct{}{}
+	pa.parent.readerAdded(pa, req.Author)
 
 	req.Res <- defs.PathAddReaderRes{
 		Path:   pa,
@@ -532,6 +755,65 @@ func (pa *path) addReaderPost(req defs.PathAddReaderReq) {
 	}
 }
 
+// descCompatible reports whether publisher with desc could write into stream created with streamDesc
+func descCompatible(streamDesc *description.Session, desc *description.Session) bool {
+	if len(streamDesc.Medias) != len(desc.Medias) {
+		return false
+	}
+
+	for i, sm := range streamDesc.Medias {
+		m := desc.Medias[i]
+		if sm.Type != m.Type || len(sm.Formats) != len(m.Formats) {
+			return false
+		}
+
+		for j, sf := range sm.Formats {
+			f := m.Formats[j]
+			if sf.Codec() != f.Codec() || sf.ClockRate() != f.ClockRate() || sf.PayloadType() != f.PayloadType() {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// updateStreamParams copies parameter sets of new publisher into kept stream,
+// so they are sent to readers before key frames
+func updateStreamParams(streamDesc *description.Session, desc *description.Session) {
+	for i, sm := range streamDesc.Medias {
+		for j, sf := range sm.Formats {
+			switch f := desc.Medias[i].Formats[j].(type) {
+			case *format.H264:
+				sps, pps := f.SafeParams()
+				if sps != nil && pps != nil {
+					sf.(*format.H264).SafeSetParams(sps, pps)
+				}
+
+			case *format.H265:
+				vps, sps, pps := f.SafeParams()
+				if vps != nil && sps != nil && pps != nil {
+					sf.(*format.H265).SafeSetParams(vps, sps, pps)
+				}
+			}
+		}
+	}
+}
+
+// handover is called by pathManager.
+func (pa *path) handover(timeout time.Duration) {
+	req := pathHandoverReq{
+		timeout: timeout,
+		res:     make(chan struct{}),
+	}
+
+	select {
+	case pa.chHandover <- req:
+		<-req.res
+	case <-pa.ctx.Done():
+	}
+}
+
 // reloadConf is called by pathManager.
 func (pa *path) reloadConf(newConf *conf.Path) {
 	select {
@@ -10,11 +10,15 @@ import (
 	"fmt"
 	"sort"
 	"sync"
+	"time"
 )
 
 func pathConfCanBeUpdated(oldPathConf *conf.Path, newPathConf *conf.Path) bool {
 	clone := oldPathConf.Clone()
 
+	// recording is started and stopped without recreating path
+	clone.Record = newPathConf.Record
+
 	return newPathConf.Equal(clone)
 }
 
@@ -33,6 +37,11 @@ type pathManager struct {
 	udpMaxPayloadSize int
 	pathConfs         map[string]*conf.Path
 	parent            logger.Writer
+	onDemandStart     func(name string)
+	onDemandStop      func(name string, reason string)
+	onPathReady       func(name string)
+	onReaderAdd       func(name string, reader defs.APIPathSourceOrReader)
+	onReaderRemove    func(name string, reader defs.APIPathSourceOrReader)
 
 	ctx         context.Context
 	ctxCancel   func()
@@ -372,6 +381,42 @@ func (pm *pathManager) pathNotReady(pa *path) {
 	}
 }
 
+// onDemandPublisherStart is called by path.
+func (pm *pathManager) onDemandPublisherStart(pa *path) {
+	if pm.onDemandStart != nil {
+		// path must not wait for publisher
+		go pm.onDemandStart(pa.name)
+	}
+}
+
+// onDemandPublisherStop is called by path.
+func (pm *pathManager) onDemandPublisherStop(pa *path, reason string) {
+	if pm.onDemandStop != nil {
+		go pm.onDemandStop(pa.name, reason)
+	}
+}
+
+// publisherReady is called by path.
+func (pm *pathManager) publisherReady(pa *path) {
+	if pm.onPathReady != nil {
+		pm.onPathReady(pa.name)
+	}
+}
+
+// readerAdded is called by path.
+func (pm *pathManager) readerAdded(pa *path, r defs.Reader) {
+	if pm.onReaderAdd != nil {
+		pm.onReaderAdd(pa.name, r.APIReaderDescribe())
+	}
+}
+
+// readerRemoved is called by path.
+func (pm *pathManager) readerRemoved(pa *path, r defs.Reader) {
+	if pm.onReaderRemove != nil {
+		pm.onReaderRemove(pa.name, r.APIReaderDescribe())
+	}
+}
+
 // closePath is called by path.
 func (pm *pathManager) closePath(pa *path) {
 	select {
@@ -491,6 +536,29 @@ func (pm *pathManager) APIPathsList() (*defs.APIPathList, error) {
 	}
 }
 
+// HandoverPublisher keeps path stream and readers when current publisher leaves,
+// so next publisher with the same tracks continues the stream. Stream is closed if nobody publishes within timeout.
+func (pm *pathManager) HandoverPublisher(name string, timeout time.Duration) error {
+	req := pathAPIPathsGetReq{
+		name: name,
+		res:  make(chan pathAPIPathsGetRes),
+	}
+
+	select {
+	case pm.chAPIPathsGet <- req:
+		res := <-req.res
+		if res.err != nil {
+			return res.err
+		}
+
+		res.path.handover(timeout)
+		return nil
+
+	case <-pm.ctx.Done():
+		return fmt.Errorf("terminated")
+	}
+}
+
 // APIPathsGet is called by api.
 func (pm *pathManager) APIPathsGet(name string) (*defs.APIPath, error) {
 	req := pathAPIPathsGetReq{
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"sync"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/google/uuid"
+)
+
+// pathSourceInProcess is a source of path which is published by Publisher only, other publishers are rejected
+const pathSourceInProcess = "inProcess"
+
+// Publisher publishes units into a path from the process itself, see RtspHandler.Publish.
+type Publisher struct {
+	name   string
+	parent logger.Writer
+
+	uuid      uuid.UUID
+	path      defs.Path
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Log implements logger.Writer.
+func (p *Publisher) Log(level logger.Level, format string, args ...interface{}) {
+	p.parent.Log(level, "[in-process publisher %s] "+format, append([]interface{}{p.name}, args...)...)
+}
+
+// Close removes publisher from path, path readers are kept if path handover is pending.
+// Close is also called by path when it's removed.
+func (p *Publisher) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.path.RemovePublisher(defs.PathRemovePublisherReq{Author: p})
+	})
+}
+
+// Done is closed when publisher is closed, by Close or by path.
+func (p *Publisher) Done() <-chan struct{} {
+	return p.done
+}
+
+// APISourceDescribe implements source.
+func (p *Publisher) APISourceDescribe() defs.APIPathSourceOrReader {
+	return defs.APIPathSourceOrReader{
+		Type: "inProcessPublisher",
+		ID:   p.uuid.String(),
+	}
+}
+
+// Publish starts publishing desc into path added by AddInProcessPath, units are written into returned stream
+// until Publisher is closed.
+func (h *RtspHandler) Publish(name string, desc *description.Session) (*Publisher, *stream.Stream, error) {
+	p := &Publisher{
+		name:   name,
+		parent: h.pm,
+		uuid:   uuid.New(),
+		done:   make(chan struct{}),
+	}
+
+	path, err := h.pm.AddPublisher(defs.PathAddPublisherReq{
+		Author: p,
+		AccessRequest: defs.PathAccessRequest{
+			Name:     name,
+			Publish:  true,
+			SkipAuth: true,
+			ID:       &p.uuid,
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.path = path
+
+	strm, err := path.StartPublisher(defs.PathStartPublisherReq{
+		Author:             p,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+	})
+	if err != nil {
+		p.Close()
+		return nil, nil, err
+	}
+
+	return p, strm, nil
+}
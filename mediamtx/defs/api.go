@@ -27,15 +27,17 @@ type APIPathSourceOrReader struct {
 
 // APIPath is a path.
 type APIPath struct {
-	Name          string                  `json:"name"`
-	ConfName      string                  `json:"confName"`
-	Source        *APIPathSourceOrReader  `json:"source"`
-	Ready         bool                    `json:"ready"`
-	ReadyTime     *time.Time              `json:"readyTime"`
-	Tracks        []string                `json:"tracks"`
-	BytesReceived uint64                  `json:"bytesReceived"`
-	BytesSent     uint64                  `json:"bytesSent"`
-	Readers       []APIPathSourceOrReader `json:"readers"`
+	Name           string                  `json:"name"`
+	ConfName       string                  `json:"confName"`
+	Source         *APIPathSourceOrReader  `json:"source"`
+	Ready          bool                    `json:"ready"`
+	ReadyTime      *time.Time              `json:"readyTime"`
+	Tracks         []string                `json:"tracks"`
+	BytesReceived  uint64                  `json:"bytesReceived"`
+	BytesSent      uint64                  `json:"bytesSent"`
+	RTPPacketsLost uint64                  `json:"rtpPacketsLost"`
+	DecodeErrors   uint64                  `json:"decodeErrors"`
+	Readers        []APIPathSourceOrReader `json:"readers"`
 }
 
 // APIPathList is a list of paths.
@@ -36,6 +36,12 @@ func New(
 	case *format.H264:
 		return newH264(udpMaxPayloadSize, forma, generateRTPPackets)
 
+	case *format.MPEG4Audio:
+		return newMPEG4Audio(udpMaxPayloadSize, forma, generateRTPPackets)
+
+	case *format.Opus:
+		return newOpus(udpMaxPayloadSize, forma, generateRTPPackets)
+
 	default:
 		return newGeneric(udpMaxPayloadSize, forma, generateRTPPackets)
 	}
@@ -0,0 +1,136 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/auth"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type httpServer struct {
+	address     string
+	allowOrigin string
+	readTimeout conf.StringDuration
+	pathManager serverPathManager
+	parent      *Server
+
+	inner *http.Server
+}
+
+func (s *httpServer) initialize() error {
+	ln, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+
+	s.inner = &http.Server{
+		Handler:           http.HandlerFunc(s.onRequest),
+		ReadHeaderTimeout: time.Duration(s.readTimeout),
+		ErrorLog:          log.New(io.Discard, "", 0),
+	}
+
+	go s.inner.Serve(ln) //nolint:errcheck
+
+	return nil
+}
+
+func (s *httpServer) close() {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+
+	_ = s.inner.Shutdown(ctx)
+}
+
+func (s *httpServer) onRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", s.allowOrigin)
+	// browsers reject credentials allowed for any origin
+	if s.allowOrigin != "*" {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET")
+		w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+		w.WriteHeader(http.StatusNoContent)
+		return
+
+	case http.MethodGet:
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// path name is everything before the requested file, i.e. {id}/index.m3u8, {id}/720p/stream.m3u8
+	pa := strings.TrimPrefix(r.URL.Path, "/")
+
+	i := strings.LastIndexByte(pa, '/')
+	if i <= 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	dir, fname := pa[:i], pa[i+1:]
+
+	if !strings.HasSuffix(fname, ".m3u8") && !strings.HasSuffix(fname, ".ts") && !strings.HasSuffix(fname, ".mp4") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	user, pass, hasCredentials := r.BasicAuth()
+
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	_, err := s.pathManager.FindPathConf(defs.PathFindPathConfReq{
+		AccessRequest: defs.PathAccessRequest{
+			Name:    dir,
+			Query:   r.URL.RawQuery,
+			Publish: false,
+			IP:      net.ParseIP(host),
+			User:    user,
+			Pass:    pass,
+			Proto:   auth.ProtocolHLS,
+		},
+	})
+	if err != nil {
+		var terr auth.Error
+		if errors.As(err, &terr) {
+			if !hasCredentials {
+				w.Header().Set("WWW-Authenticate", `Basic realm="h265_decoder"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			s.parent.Log(logger.Info, "connection %v failed to authenticate: %v", r.RemoteAddr, terr.Message)
+
+			// wait some seconds to mitigate brute force attacks
+			<-time.After(auth.PauseAfterError)
+
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	mux, err := s.parent.getMuxer(serverGetMuxerReq{
+		path:       dir,
+		remoteAddr: r.RemoteAddr,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	mux.handleRequest(w, r)
+}
@@ -0,0 +1,147 @@
+package hls
+
+import (
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, pm *test.PathManager, alwaysRemux bool) *Server {
+	s := &Server{
+		Address:         "127.0.0.1:0",
+		AllowOrigin:     "*",
+		AlwaysRemux:     alwaysRemux,
+		SegmentCount:    7,
+		SegmentDuration: conf.StringDuration(time.Second),
+		PartDuration:    conf.StringDuration(200 * time.Millisecond),
+		SegmentMaxSize:  50 * 1024 * 1024,
+		ReadTimeout:     conf.StringDuration(10 * time.Second),
+		WriteQueueSize:  512,
+		MuxerCloseAfter: conf.StringDuration(60 * time.Second),
+		PathManager:     pm,
+		Parent:          test.NilLogger,
+	}
+
+	err := s.Initialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestHTTPServerPathName(t *testing.T) {
+	pm := &test.PathManager{Path: &test.Path{PathName: "cam1"}}
+
+	s := newTestServer(t, pm, false)
+	defer s.Close()
+
+	for _, c := range []struct {
+		url    string
+		status int
+		path   string
+	}{
+		{"/index.m3u8", http.StatusNotFound, ""},
+		{"/cam1/index.txt", http.StatusNotFound, ""},
+		{"/cam1/segment.mp4.bak", http.StatusNotFound, ""},
+		{"/cam1/index.m3u8", http.StatusNotFound, "cam1"},
+		{"/cam1/720p/stream.m3u8", http.StatusNotFound, "cam1/720p"},
+		{"/cam1/720p/seg0.mp4", http.StatusNotFound, "cam1/720p"},
+	} {
+		before := len(pm.Requests())
+
+		w := httptest.NewRecorder()
+		s.httpServer.onRequest(w, httptest.NewRequest(http.MethodGet, c.url, nil))
+
+		if w.Code != c.status {
+			t.Errorf("%s: unexpected status %d", c.url, w.Code)
+		}
+
+		reqs := pm.Requests()[before:]
+
+		switch {
+		case c.path == "" && len(reqs) != 0:
+			t.Errorf("%s: path has been requested", c.url)
+
+		case c.path != "" && (len(reqs) == 0 || reqs[0].Name != c.path):
+			t.Errorf("%s: unexpected path requests %+v", c.url, reqs)
+		}
+	}
+}
+
+func TestHTTPServerMethods(t *testing.T) {
+	pm := &test.PathManager{Path: &test.Path{PathName: "cam1"}}
+
+	s := newTestServer(t, pm, false)
+	defer s.Close()
+
+	w := httptest.NewRecorder()
+	s.httpServer.onRequest(w, httptest.NewRequest(http.MethodOptions, "/cam1/index.m3u8", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("unexpected OPTIONS status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.httpServer.onRequest(w, httptest.NewRequest(http.MethodPost, "/cam1/index.m3u8", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected POST status %d", w.Code)
+	}
+
+	if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("credentials are allowed for any origin")
+	}
+
+	if len(pm.Requests()) != 0 {
+		t.Error("path has been requested")
+	}
+}
+
+func TestHTTPServerUnauthorized(t *testing.T) {
+	pm := &test.PathManager{
+		Path: &test.Path{PathName: "cam1"},
+		AuthFunc: func(req defs.PathAccessRequest) error {
+			if req.User != "user" || req.Pass != "pass" {
+				return errors.New("wrong credentials")
+			}
+			return nil
+		},
+	}
+
+	s := newTestServer(t, pm, false)
+	defer s.Close()
+
+	w := httptest.NewRecorder()
+	s.httpServer.onRequest(w, httptest.NewRequest(http.MethodGet, "/cam1/index.m3u8", nil))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal("credentials have not been requested")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/cam1/index.m3u8", nil)
+	r.SetBasicAuth("user", "wrong")
+
+	w = httptest.NewRecorder()
+	s.httpServer.onRequest(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+
+	if w.Header().Get("WWW-Authenticate") != "" {
+		t.Fatal("credentials have been requested again")
+	}
+
+	reqs := pm.Requests()
+	if len(reqs) != 2 || reqs[1].User != "user" || reqs[1].Pass != "wrong" {
+		t.Fatalf("unexpected path requests %+v", reqs)
+	}
+}
@@ -0,0 +1,354 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/asyncwriter"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"fearpro13/h265_transcoder/mediamtx/unit"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluenviron/gohlslib"
+	"github.com/bluenviron/gohlslib/pkg/codecs"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+)
+
+const (
+	closeCheckPeriod = 1 * time.Second
+)
+
+type muxer struct {
+	parentCtx       context.Context
+	remoteAddr      string
+	variant         conf.HLSVariant
+	alwaysRemux     bool
+	segmentCount    int
+	segmentDuration conf.StringDuration
+	partDuration    conf.StringDuration
+	segmentMaxSize  conf.StringSize
+	directory       string
+	writeQueueSize  int
+	closeAfter      conf.StringDuration
+	wg              *sync.WaitGroup
+	pathName        string
+	pathManager     serverPathManager
+	parent          *Server
+
+	ctx             context.Context
+	ctxCancel       func()
+	lastRequestTime *int64
+	instance        atomic.Pointer[gohlslib.Muxer]
+	ready           chan struct{}
+}
+
+func (m *muxer) initialize() {
+	ctx, ctxCancel := context.WithCancel(m.parentCtx)
+
+	m.ctx = ctx
+	m.ctxCancel = ctxCancel
+	m.lastRequestTime = int64Ptr(time.Now().UnixNano())
+	m.ready = make(chan struct{})
+
+	m.Log(logger.Info, "created %s", func() string {
+		if m.remoteAddr == "" {
+			return "automatically"
+		}
+		return "(requested by " + m.remoteAddr + ")"
+	}())
+
+	m.wg.Add(1)
+	go m.run()
+}
+
+// Close closes a muxer.
+func (m *muxer) Close() {
+	m.ctxCancel()
+}
+
+// Log implements logger.Writer.
+func (m *muxer) Log(level logger.Level, format string, args ...interface{}) {
+	m.parent.Log(level, "[muxer %s] "+format, append([]interface{}{m.pathName}, args...)...)
+}
+
+func (m *muxer) run() {
+	defer m.wg.Done()
+
+	err := m.runInner()
+
+	m.ctxCancel()
+
+	select {
+	case <-m.ready:
+	default:
+		close(m.ready)
+	}
+
+	m.parent.closeMuxer(m)
+
+	m.Log(logger.Info, "destroyed: %v", err)
+}
+
+func (m *muxer) runInner() error {
+	path, strm, err := m.pathManager.AddReader(defs.PathAddReaderReq{
+		Author: m,
+		AccessRequest: defs.PathAccessRequest{
+			Name:     m.pathName,
+			SkipAuth: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	defer path.RemoveReader(defs.PathRemoveReaderReq{Author: m})
+
+	writer := asyncwriter.New(m.writeQueueSize, m)
+
+	mux := &gohlslib.Muxer{
+		Variant:            gohlslib.MuxerVariant(m.variant),
+		SegmentCount:       m.segmentCount,
+		SegmentMinDuration: time.Duration(m.segmentDuration),
+		PartMinDuration:    time.Duration(m.partDuration),
+		SegmentMaxSize:     uint64(m.segmentMaxSize),
+		Directory:          m.directory,
+	}
+
+	videoFormat := m.setupVideo(strm, writer, mux)
+	audioFormat := m.setupAudio(strm, writer, mux)
+
+	if videoFormat == nil && audioFormat == nil {
+		strm.RemoveReader(writer)
+		return fmt.Errorf(
+			"the stream doesn't contain any supported codec, which are currently H265, H264, Opus, MPEG-4 Audio")
+	}
+
+	err = mux.Start()
+	if err != nil {
+		strm.RemoveReader(writer)
+		return err
+	}
+	defer mux.Close()
+
+	m.Log(logger.Info, "is converting into HLS, %s", mediaInfo([]format.Format{videoFormat, audioFormat}))
+
+	m.instance.Store(mux)
+	defer m.instance.Store(nil)
+	close(m.ready)
+
+	writer.Start()
+
+	closeCheckTicker := time.NewTicker(closeCheckPeriod)
+	defer closeCheckTicker.Stop()
+
+	for {
+		select {
+		case <-closeCheckTicker.C:
+			if m.remoteAddr != "" || !m.alwaysRemux {
+				t := time.Unix(0, atomic.LoadInt64(m.lastRequestTime))
+				if time.Since(t) >= time.Duration(m.closeAfter) {
+					strm.RemoveReader(writer)
+					writer.Stop()
+					return fmt.Errorf("not used anymore")
+				}
+			}
+
+		case err := <-writer.Error():
+			strm.RemoveReader(writer)
+			return err
+
+		case <-m.ctx.Done():
+			strm.RemoveReader(writer)
+			writer.Stop()
+			return errors.New("terminated")
+		}
+	}
+}
+
+func (m *muxer) setupVideo(strm *stream.Stream, writer *asyncwriter.Writer, mux *gohlslib.Muxer) format.Format {
+	var videoFormatH265 *format.H265
+	videoMedia := strm.Desc().FindFormat(&videoFormatH265)
+
+	if videoFormatH265 != nil {
+		vps, sps, pps := videoFormatH265.SafeParams()
+
+		mux.VideoTrack = &gohlslib.Track{
+			Codec: &codecs.H265{
+				VPS: vps,
+				SPS: sps,
+				PPS: pps,
+			},
+		}
+
+		strm.AddReader(writer, videoMedia, videoFormatH265, func(u unit.Unit) error {
+			tunit := u.(*unit.H265)
+
+			if tunit.AU == nil {
+				return nil
+			}
+
+			err := mux.WriteH265(tunit.NTP, tunit.PTS, tunit.AU)
+			if err != nil {
+				return fmt.Errorf("muxer error: %w", err)
+			}
+
+			return nil
+		})
+
+		return videoFormatH265
+	}
+
+	var videoFormatH264 *format.H264
+	videoMedia = strm.Desc().FindFormat(&videoFormatH264)
+
+	if videoFormatH264 != nil {
+		sps, pps := videoFormatH264.SafeParams()
+
+		mux.VideoTrack = &gohlslib.Track{
+			Codec: &codecs.H264{
+				SPS: sps,
+				PPS: pps,
+			},
+		}
+
+		strm.AddReader(writer, videoMedia, videoFormatH264, func(u unit.Unit) error {
+			tunit := u.(*unit.H264)
+
+			if tunit.AU == nil {
+				return nil
+			}
+
+			err := mux.WriteH264(tunit.NTP, tunit.PTS, tunit.AU)
+			if err != nil {
+				return fmt.Errorf("muxer error: %w", err)
+			}
+
+			return nil
+		})
+
+		return videoFormatH264
+	}
+
+	return nil
+}
+
+func (m *muxer) setupAudio(strm *stream.Stream, writer *asyncwriter.Writer, mux *gohlslib.Muxer) format.Format {
+	var audioFormatMPEG4Audio *format.MPEG4Audio
+	audioMedia := strm.Desc().FindFormat(&audioFormatMPEG4Audio)
+
+	if audioFormatMPEG4Audio != nil && audioFormatMPEG4Audio.GetConfig() != nil {
+		mux.AudioTrack = &gohlslib.Track{
+			Codec: &codecs.MPEG4Audio{
+				Config: *audioFormatMPEG4Audio.GetConfig(),
+			},
+		}
+
+		strm.AddReader(writer, audioMedia, audioFormatMPEG4Audio, func(u unit.Unit) error {
+			tunit := u.(*unit.MPEG4Audio)
+
+			if tunit.AUs == nil {
+				return nil
+			}
+
+			err := mux.WriteMPEG4Audio(tunit.NTP, tunit.PTS, tunit.AUs)
+			if err != nil {
+				return fmt.Errorf("muxer error: %w", err)
+			}
+
+			return nil
+		})
+
+		return audioFormatMPEG4Audio
+	}
+
+	// MPEG-TS variant supports MPEG-4 Audio only
+	if m.variant == conf.HLSVariant(gohlslib.MuxerVariantMPEGTS) {
+		return nil
+	}
+
+	var audioFormatOpus *format.Opus
+	audioMedia = strm.Desc().FindFormat(&audioFormatOpus)
+
+	if audioFormatOpus != nil {
+		mux.AudioTrack = &gohlslib.Track{
+			Codec: &codecs.Opus{
+				ChannelCount: audioFormatOpus.ChannelCount,
+			},
+		}
+
+		strm.AddReader(writer, audioMedia, audioFormatOpus, func(u unit.Unit) error {
+			tunit := u.(*unit.Opus)
+
+			if tunit.Packets == nil {
+				return nil
+			}
+
+			err := mux.WriteOpus(tunit.NTP, tunit.PTS, tunit.Packets)
+			if err != nil {
+				return fmt.Errorf("muxer error: %w", err)
+			}
+
+			return nil
+		})
+
+		return audioFormatOpus
+	}
+
+	return nil
+}
+
+func (m *muxer) handleRequest(w http.ResponseWriter, r *http.Request) {
+	atomic.StoreInt64(m.lastRequestTime, time.Now().UnixNano())
+
+	select {
+	case <-m.ready:
+	case <-r.Context().Done():
+		return
+	}
+
+	mux := m.instance.Load()
+	if mux == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	mux.Handle(w, r)
+}
+
+// APIReaderDescribe implements reader.
+func (m *muxer) APIReaderDescribe() defs.APIPathSourceOrReader {
+	return defs.APIPathSourceOrReader{
+		Type: "hlsMuxer",
+		ID:   "",
+	}
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func mediaInfo(formats []format.Format) string {
+	var names []string
+	for _, forma := range formats {
+		if forma != nil {
+			names = append(names, forma.Codec())
+		}
+	}
+
+	return fmt.Sprintf("%d %s (%s)",
+		len(names),
+		func() string {
+			if len(names) == 1 {
+				return "track"
+			}
+			return "tracks"
+		}(),
+		strings.Join(names, ", "))
+}
@@ -0,0 +1,205 @@
+// Package hls contains a HLS server.
+package hls
+
+import (
+	"context"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"fmt"
+	"sync"
+)
+
+type serverPathManager interface {
+	FindPathConf(req defs.PathFindPathConfReq) (*conf.Path, error)
+	AddReader(req defs.PathAddReaderReq) (defs.Path, *stream.Stream, error)
+}
+
+type serverGetMuxerRes struct {
+	muxer *muxer
+	err   error
+}
+
+type serverGetMuxerReq struct {
+	path       string
+	remoteAddr string
+	res        chan serverGetMuxerRes
+}
+
+// Server is a HLS server.
+type Server struct {
+	Address         string
+	AllowOrigin     string
+	AlwaysRemux     bool
+	Variant         conf.HLSVariant
+	SegmentCount    int
+	SegmentDuration conf.StringDuration
+	PartDuration    conf.StringDuration
+	SegmentMaxSize  conf.StringSize
+	Directory       string
+	ReadTimeout     conf.StringDuration
+	WriteQueueSize  int
+	MuxerCloseAfter conf.StringDuration
+	PathManager     serverPathManager
+	Parent          logger.Writer
+
+	ctx        context.Context
+	ctxCancel  func()
+	wg         sync.WaitGroup
+	httpServer *httpServer
+	muxers     map[string]*muxer
+
+	// in
+	chPathReady    chan defs.Path
+	chPathNotReady chan defs.Path
+	chGetMuxer     chan serverGetMuxerReq
+	chCloseMuxer   chan *muxer
+}
+
+// Initialize initializes the server.
+func (s *Server) Initialize() error {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	s.ctx = ctx
+	s.ctxCancel = ctxCancel
+	s.muxers = make(map[string]*muxer)
+	s.chPathReady = make(chan defs.Path)
+	s.chPathNotReady = make(chan defs.Path)
+	s.chGetMuxer = make(chan serverGetMuxerReq)
+	s.chCloseMuxer = make(chan *muxer)
+
+	s.httpServer = &httpServer{
+		address:     s.Address,
+		allowOrigin: s.AllowOrigin,
+		readTimeout: s.ReadTimeout,
+		pathManager: s.PathManager,
+		parent:      s,
+	}
+	err := s.httpServer.initialize()
+	if err != nil {
+		ctxCancel()
+		return err
+	}
+
+	s.Log(logger.Info, "listener opened on %s", s.Address)
+
+	s.wg.Add(1)
+	go s.run()
+
+	return nil
+}
+
+// Log implements logger.Writer.
+func (s *Server) Log(level logger.Level, format string, args ...interface{}) {
+	s.Parent.Log(level, "[HLS] "+format, args...)
+}
+
+// Close closes the server.
+func (s *Server) Close() {
+	s.Log(logger.Info, "listener is closing")
+	s.ctxCancel()
+	s.wg.Wait()
+}
+
+func (s *Server) run() {
+	defer s.wg.Done()
+
+outer:
+	for {
+		select {
+		case pa := <-s.chPathReady:
+			if s.AlwaysRemux && !pa.SafeConf().SourceOnDemand {
+				if _, ok := s.muxers[pa.Name()]; !ok {
+					s.createMuxer(pa.Name(), "")
+				}
+			}
+
+		case pa := <-s.chPathNotReady:
+			c, ok := s.muxers[pa.Name()]
+			if ok && c.remoteAddr == "" { // created with "always remux"
+				c.Close()
+				delete(s.muxers, pa.Name())
+			}
+
+		case req := <-s.chGetMuxer:
+			mux, ok := s.muxers[req.path]
+			if !ok {
+				mux = s.createMuxer(req.path, req.remoteAddr)
+			}
+			req.res <- serverGetMuxerRes{muxer: mux}
+
+		case c := <-s.chCloseMuxer:
+			if c2, ok := s.muxers[c.pathName]; ok && c2 == c {
+				delete(s.muxers, c.pathName)
+			}
+
+		case <-s.ctx.Done():
+			break outer
+		}
+	}
+
+	s.ctxCancel()
+
+	s.httpServer.close()
+}
+
+func (s *Server) createMuxer(pathName string, remoteAddr string) *muxer {
+	r := &muxer{
+		parentCtx:       s.ctx,
+		remoteAddr:      remoteAddr,
+		variant:         s.Variant,
+		alwaysRemux:     s.AlwaysRemux,
+		segmentCount:    s.SegmentCount,
+		segmentDuration: s.SegmentDuration,
+		partDuration:    s.PartDuration,
+		segmentMaxSize:  s.SegmentMaxSize,
+		directory:       s.Directory,
+		writeQueueSize:  s.WriteQueueSize,
+		closeAfter:      s.MuxerCloseAfter,
+		wg:              &s.wg,
+		pathName:        pathName,
+		pathManager:     s.PathManager,
+		parent:          s,
+	}
+	r.initialize()
+	s.muxers[pathName] = r
+	return r
+}
+
+// closeMuxer is called by muxer.
+func (s *Server) closeMuxer(c *muxer) {
+	select {
+	case s.chCloseMuxer <- c:
+	case <-s.ctx.Done():
+	}
+}
+
+func (s *Server) getMuxer(req serverGetMuxerReq) (*muxer, error) {
+	req.res = make(chan serverGetMuxerRes)
+
+	select {
+	case s.chGetMuxer <- req:
+		res := <-req.res
+		return res.muxer, res.err
+
+	case <-s.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+// PathReady is called by pathManager.
+func (s *Server) PathReady(pa defs.Path) {
+	select {
+	case s.chPathReady <- pa:
+	case <-s.ctx.Done():
+	}
+}
+
+// PathNotReady is called by pathManager.
+func (s *Server) PathNotReady(pa defs.Path) {
+	select {
+	case s.chPathNotReady <- pa:
+	case <-s.ctx.Done():
+	}
+}
@@ -0,0 +1,84 @@
+package hls
+
+import (
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+)
+
+func waitReaders(t *testing.T, pa *test.Path, n int) {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if pa.Readers() == n {
+			return
+		}
+	}
+
+	t.Fatalf("unexpected readers %d, expected %d", pa.Readers(), n)
+}
+
+func TestServerAlwaysRemux(t *testing.T) {
+	strm, err := stream.New(1472, &description.Session{Medias: []*description.Media{test.MediaH264}}, true, test.NilLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strm.Close()
+
+	pa := &test.Path{PathName: "cam1"}
+	pa.SetStream(strm)
+	pm := &test.PathManager{Path: pa}
+
+	s := newTestServer(t, pm, true)
+	defer s.Close()
+
+	s.PathReady(pa)
+	waitReaders(t, pa, 1)
+
+	reqs := pm.Requests()
+	if len(reqs) != 1 || reqs[0].Name != "cam1" || !reqs[0].SkipAuth {
+		t.Fatalf("unexpected path requests %+v", reqs)
+	}
+
+	// muxer of a ready path is not created twice
+	s.PathReady(pa)
+
+	s.PathNotReady(pa)
+	waitReaders(t, pa, 0)
+
+	if len(pm.Requests()) != 1 {
+		t.Fatalf("unexpected path requests %+v", pm.Requests())
+	}
+}
+
+func TestServerAlwaysRemuxOnDemand(t *testing.T) {
+	pa := &test.Path{PathName: "cam1", Conf: &conf.Path{SourceOnDemand: true}}
+	pm := &test.PathManager{Path: pa}
+
+	s := newTestServer(t, pm, true)
+	defer s.Close()
+
+	s.PathReady(pa)
+	s.PathNotReady(pa)
+
+	if len(pm.Requests()) != 0 {
+		t.Fatal("muxer of on-demand path has been created")
+	}
+}
+
+func TestServerNoRemux(t *testing.T) {
+	pa := &test.Path{PathName: "cam1"}
+	pm := &test.PathManager{Path: pa}
+
+	s := newTestServer(t, pm, false)
+	defer s.Close()
+
+	s.PathReady(pa)
+	s.PathNotReady(pa)
+
+	if len(pm.Requests()) != 0 {
+		t.Fatal("muxer has been created without request")
+	}
+}
@@ -0,0 +1,98 @@
+package playback
+
+import (
+	"io"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4/seekablebuffer"
+)
+
+const (
+	fmp4PartDuration = 1 * time.Second
+)
+
+// muxerFMP4 writes samples as a fragmented MP4, parts are written as soon as they are complete
+type muxerFMP4 struct {
+	w    io.Writer
+	init *fmp4.Init
+
+	partStart          time.Duration
+	partTracks         map[*fmp4.InitTrack]*fmp4.PartTrack
+	order              []*fmp4.PartTrack
+	nextSequenceNumber uint32
+}
+
+func (m *muxerFMP4) writeInit() error {
+	m.nextSequenceNumber = 1
+
+	var buf seekablebuffer.Buffer
+	err := m.init.Marshal(&buf)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.w.Write(buf.Bytes())
+	return err
+}
+
+func (m *muxerFMP4) writeSample(s *Sample) error {
+	if m.partTracks != nil && (s.DTS-m.partStart) >= fmp4PartDuration {
+		err := m.flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.partTracks == nil {
+		m.partStart = s.DTS
+		m.partTracks = make(map[*fmp4.InitTrack]*fmp4.PartTrack)
+	}
+
+	payload, err := s.GetPayload()
+	if err != nil {
+		return err
+	}
+
+	pt, ok := m.partTracks[s.Track]
+	if !ok {
+		pt = &fmp4.PartTrack{
+			ID:       s.Track.ID,
+			BaseTime: durationGoToMp4(s.DTS, s.Track.TimeScale),
+		}
+		m.partTracks[s.Track] = pt
+		m.order = append(m.order, pt)
+	}
+
+	pt.Samples = append(pt.Samples, &fmp4.PartSample{
+		Duration:        s.Duration,
+		PTSOffset:       s.PTSOffset,
+		IsNonSyncSample: s.IsNonSyncSample,
+		Payload:         payload,
+	})
+
+	return nil
+}
+
+func (m *muxerFMP4) flush() error {
+	if m.partTracks == nil {
+		return nil
+	}
+
+	part := fmp4.Part{
+		SequenceNumber: m.nextSequenceNumber,
+		Tracks:         m.order,
+	}
+	m.nextSequenceNumber++
+	m.partTracks = nil
+	m.order = nil
+
+	var buf seekablebuffer.Buffer
+	err := part.Marshal(&buf)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.w.Write(buf.Bytes())
+	return err
+}
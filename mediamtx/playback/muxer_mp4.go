@@ -0,0 +1,73 @@
+package playback
+
+import (
+	"io"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/pkg/formats/pmp4"
+)
+
+type muxerMP4Track struct {
+	*pmp4.Track
+	lastDTS    uint64
+	lastSample *pmp4.Sample
+}
+
+// muxerMP4 collects samples into a seekable MP4, payloads are read while the file is written
+type muxerMP4 struct {
+	tracks map[*fmp4.InitTrack]*muxerMP4Track
+	order  []*muxerMP4Track
+}
+
+func (m *muxerMP4) writeSample(s *Sample) {
+	if m.tracks == nil {
+		m.tracks = make(map[*fmp4.InitTrack]*muxerMP4Track)
+	}
+
+	track, ok := m.tracks[s.Track]
+	if !ok {
+		track = &muxerMP4Track{
+			Track: &pmp4.Track{
+				ID:        s.Track.ID,
+				TimeScale: s.Track.TimeScale,
+				Codec:     s.Track.Codec,
+			},
+		}
+		m.tracks[s.Track] = track
+		m.order = append(m.order, track)
+	}
+
+	dts := durationGoToMp4(s.DTS, s.Track.TimeScale)
+
+	// durations are recomputed from DTS, in order to keep tracks in sync across segment boundaries
+	if track.lastSample == nil {
+		track.TimeOffset = int32(dts)
+	} else if dts > track.lastDTS {
+		track.lastSample.Duration = uint32(dts - track.lastDTS)
+	} else {
+		track.lastSample.Duration = 0
+	}
+
+	track.lastSample = &pmp4.Sample{
+		Duration:        s.Duration,
+		PTSOffset:       s.PTSOffset,
+		IsNonSyncSample: s.IsNonSyncSample,
+		PayloadSize:     s.PayloadSize,
+		GetPayload:      s.GetPayload,
+	}
+	track.lastDTS = dts
+	track.Samples = append(track.Samples, track.lastSample)
+}
+
+func (m *muxerMP4) empty() bool {
+	return len(m.order) == 0
+}
+
+func (m *muxerMP4) marshal(w io.Writer) error {
+	presentation := pmp4.Presentation{}
+	for _, track := range m.order {
+		presentation.Tracks = append(presentation.Tracks, track.Track)
+	}
+
+	return presentation.Marshal(w)
+}
@@ -0,0 +1,112 @@
+package playback
+
+import (
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (s *Server) onGet(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	pathName := query.Get("path")
+	if pathName == "" {
+		s.writeError(w, http.StatusBadRequest, errors.New("path is required"))
+		return
+	}
+
+	start, err := time.Parse(time.RFC3339Nano, query.Get("start"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errors.New("invalid start, RFC3339 time is expected"))
+		return
+	}
+
+	seconds, err := strconv.ParseFloat(query.Get("duration"), 64)
+	if err != nil || seconds <= 0 {
+		s.writeError(w, http.StatusBadRequest, errors.New("invalid duration, positive number of seconds is expected"))
+		return
+	}
+
+	format := query.Get("format")
+	switch format {
+	case "":
+		format = "fmp4"
+
+	case "fmp4", "mp4":
+
+	default:
+		s.writeError(w, http.StatusBadRequest, errors.New("invalid format, fmp4 or mp4 is expected"))
+		return
+	}
+
+	pathConf := s.findPathConf(w, r, pathName)
+	if pathConf == nil {
+		return
+	}
+
+	segments := s.findSegments(w, pathConf, pathName)
+	if segments == nil {
+		return
+	}
+
+	reader := &Reader{
+		Segments: segments,
+		Start:    start,
+		Duration: time.Duration(seconds * float64(time.Second)),
+	}
+	err = reader.Initialize()
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	}
+	defer reader.Close()
+
+	if format == "mp4" {
+		// moov precedes samples, so the whole span is read before response is written
+		m := &muxerMP4{}
+
+		err = reader.Read(func(sample *Sample) error {
+			m.writeSample(sample)
+			return nil
+		})
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if m.empty() {
+			s.writeError(w, http.StatusNotFound, ErrNoSegmentsFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "video/mp4")
+		w.WriteHeader(http.StatusOK)
+
+		err = m.marshal(w)
+		if err != nil {
+			s.Log(logger.Warn, "%v", err)
+		}
+		return
+	}
+
+	m := &muxerFMP4{
+		w:    w,
+		init: reader.Init,
+	}
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.WriteHeader(http.StatusOK)
+
+	err = m.writeInit()
+	if err == nil {
+		err = reader.Read(m.writeSample)
+	}
+	if err == nil {
+		err = m.flush()
+	}
+	if err != nil {
+		s.Log(logger.Warn, "%v", err)
+	}
+}
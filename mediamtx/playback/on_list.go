@@ -0,0 +1,110 @@
+package playback
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+)
+
+type listEntry struct {
+	Start time.Time `json:"start"`
+	// Duration is expressed in seconds
+	Duration float64 `json:"duration"`
+}
+
+type listSpan struct {
+	start    time.Time
+	duration time.Duration
+	init     *fmp4.Init
+}
+
+// listSpans merges consecutive segments with the same tracks into spans
+func listSpans(segments []*Segment) []*listSpan {
+	var spans []*listSpan
+
+	for _, seg := range segments {
+		init, duration, err := readSegmentInfo(seg.Fpath)
+		if err != nil || duration == 0 {
+			continue
+		}
+
+		if len(spans) != 0 {
+			last := spans[len(spans)-1]
+			lastEnd := last.start.Add(last.duration)
+
+			if !seg.Start.After(lastEnd.Add(concatenationTolerance)) && reflect.DeepEqual(init, last.init) {
+				last.duration = seg.Start.Add(duration).Sub(last.start)
+				continue
+			}
+		}
+
+		spans = append(spans, &listSpan{
+			start:    seg.Start,
+			duration: duration,
+			init:     init,
+		})
+	}
+
+	return spans
+}
+
+func readSegmentInfo(fpath string) (*fmp4.Init, time.Duration, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	init, err := segmentFMP4ReadInit(f)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	duration, err := segmentFMP4ReadDuration(f, init)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return init, duration, nil
+}
+
+func (s *Server) onList(w http.ResponseWriter, r *http.Request) {
+	pathName := r.URL.Query().Get("path")
+	if pathName == "" {
+		s.writeError(w, http.StatusBadRequest, errors.New("path is required"))
+		return
+	}
+
+	pathConf := s.findPathConf(w, r, pathName)
+	if pathConf == nil {
+		return
+	}
+
+	segments := s.findSegments(w, pathConf, pathName)
+	if segments == nil {
+		return
+	}
+
+	spans := listSpans(segments)
+	if spans == nil {
+		s.writeError(w, http.StatusNotFound, ErrNoSegmentsFound)
+		return
+	}
+
+	entries := make([]listEntry, len(spans))
+	for i, span := range spans {
+		entries[i] = listEntry{
+			Start:    span.start,
+			Duration: span.duration.Seconds(),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,278 @@
+package playback
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+)
+
+const (
+	// maximum gap between consecutive segments, recording restarts leave bigger ones
+	concatenationTolerance = 1 * time.Second
+)
+
+var errReadEnd = errors.New("read end")
+
+func durationGoToMp4(v time.Duration, timeScale uint32) uint64 {
+	if v < 0 {
+		return 0
+	}
+
+	timeScale64 := uint64(timeScale)
+	secs := v / time.Second
+	dec := v % time.Second
+	return uint64(secs)*timeScale64 + uint64(dec)*timeScale64/uint64(time.Second)
+}
+
+func durationMp4ToGo(v uint64, timeScale uint32) time.Duration {
+	timeScale64 := uint64(timeScale)
+	secs := v / timeScale64
+	dec := v % timeScale64
+	return time.Duration(secs)*time.Second + time.Duration(dec)*time.Second/time.Duration(timeScale64)
+}
+
+// Sample is a recorded sample.
+type Sample struct {
+	Track *fmp4.InitTrack
+	// DTS is relative to the beginning of read span
+	DTS time.Duration
+	// PTSOffset, Duration are expressed in track time scale
+	PTSOffset       int32
+	Duration        uint32
+	IsNonSyncSample bool
+	PayloadSize     uint32
+	GetPayload      func() ([]byte, error)
+}
+
+// PTS returns presentation time of sample, relative to the beginning of read span.
+func (s *Sample) PTS() time.Duration {
+	return s.DTS + time.Duration(s.PTSOffset)*time.Second/time.Duration(s.Track.TimeScale)
+}
+
+// Reader reads samples of consecutive recording segments.
+// Read span begins with the last video key frame before Start, or with the first recorded sample after Start.
+type Reader struct {
+	Segments []*Segment
+	Start    time.Time
+	// Duration limits read span, zero reads until recordings end
+	Duration time.Duration
+
+	// Init is the initialization header of read segments, filled by Initialize
+	Init *fmp4.Init
+
+	first int
+	start time.Time
+	files []*os.File
+}
+
+// Initialize finds segment containing Start.
+func (r *Reader) Initialize() error {
+	for i, seg := range r.Segments {
+		if r.Duration != 0 && !seg.Start.Before(r.Start.Add(r.Duration)) {
+			break
+		}
+
+		f, err := os.Open(seg.Fpath)
+		if err != nil {
+			continue
+		}
+
+		init, err := segmentFMP4ReadInit(f)
+		if err != nil {
+			f.Close()
+			continue
+		}
+
+		duration, err := segmentFMP4ReadDuration(f, init)
+		if err != nil || !seg.Start.Add(duration).After(r.Start) {
+			f.Close()
+			continue
+		}
+
+		r.first = i
+		r.Init = init
+		r.files = append(r.files, f)
+
+		r.start = r.Start
+		if seg.Start.After(r.start) {
+			r.start = seg.Start
+		}
+
+		err = r.seekKeyFrame(f, seg)
+		if err != nil {
+			r.Close()
+			return err
+		}
+
+		return nil
+	}
+
+	return ErrNoSegmentsFound
+}
+
+// seekKeyFrame moves beginning of read span to the last video key frame before it
+func (r *Reader) seekKeyFrame(f *os.File, seg *Segment) error {
+	var keyFrame *time.Time
+
+	err := segmentFMP4ReadParts(f, func(tracks []*segmentFMP4PartTrack) error {
+		for _, pt := range tracks {
+			initTrack := findInitTrack(r.Init, pt.id)
+			if initTrack == nil || !initTrack.Codec.IsVideo() {
+				continue
+			}
+
+			dts := pt.baseTime
+			for _, s := range pt.samples {
+				t := seg.Start.Add(durationMp4ToGo(dts, initTrack.TimeScale))
+				if t.After(r.start) {
+					return errReadEnd
+				}
+
+				if !s.isNonSyncSample {
+					keyFrame = &t
+				}
+
+				dts += uint64(s.duration)
+			}
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errReadEnd) {
+		return err
+	}
+
+	if keyFrame != nil {
+		r.start = *keyFrame
+	}
+
+	return nil
+}
+
+// SpanStart returns the absolute time of read span beginning, available after Initialize.
+func (r *Reader) SpanStart() time.Time {
+	return r.start
+}
+
+// Read calls cb for every sample of read span, samples of each part are sorted by DTS.
+func (r *Reader) Read(cb func(s *Sample) error) error {
+	var prevEnd time.Time
+
+	for i := r.first; i < len(r.Segments); i++ {
+		seg := r.Segments[i]
+
+		var f *os.File
+
+		if i == r.first {
+			f = r.files[0]
+		} else {
+			if seg.Start.After(prevEnd.Add(concatenationTolerance)) ||
+				(r.Duration != 0 && !seg.Start.Before(r.Start.Add(r.Duration))) {
+				return nil
+			}
+
+			var err error
+			f, err = os.Open(seg.Fpath)
+			if err != nil {
+				return err
+			}
+			r.files = append(r.files, f)
+
+			// segments with different tracks or codec parameters can't be concatenated
+			init, err := segmentFMP4ReadInit(f)
+			if err != nil || !reflect.DeepEqual(init, r.Init) {
+				return nil
+			}
+		}
+
+		err := r.readSegment(f, seg, &prevEnd, cb)
+		if err != nil {
+			if errors.Is(err, errReadEnd) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Reader) readSegment(f *os.File, seg *Segment, prevEnd *time.Time, cb func(s *Sample) error) error {
+	return segmentFMP4ReadParts(f, func(tracks []*segmentFMP4PartTrack) error {
+		var samples []*Sample
+		end := false
+
+		for _, pt := range tracks {
+			initTrack := findInitTrack(r.Init, pt.id)
+			if initTrack == nil {
+				return fmt.Errorf("track %d not found in initialization header", pt.id)
+			}
+
+			dts := pt.baseTime
+
+			for _, s := range pt.samples {
+				t := seg.Start.Add(durationMp4ToGo(dts, initTrack.TimeScale))
+				dts += uint64(s.duration)
+
+				if sampleEnd := seg.Start.Add(durationMp4ToGo(dts, initTrack.TimeScale)); sampleEnd.After(*prevEnd) {
+					*prevEnd = sampleEnd
+				}
+
+				if t.Before(r.start) {
+					continue
+				}
+
+				if r.Duration != 0 && !t.Before(r.Start.Add(r.Duration)) {
+					end = true
+					continue
+				}
+
+				offset, size := s.offset, s.size
+
+				samples = append(samples, &Sample{
+					Track:           initTrack,
+					DTS:             t.Sub(r.start),
+					PTSOffset:       s.ptsOffset,
+					Duration:        s.duration,
+					IsNonSyncSample: s.isNonSyncSample,
+					PayloadSize:     size,
+					GetPayload: func() ([]byte, error) {
+						buf := make([]byte, size)
+						_, err := f.ReadAt(buf, offset)
+						return buf, err
+					},
+				})
+			}
+		}
+
+		sort.SliceStable(samples, func(i, j int) bool {
+			return samples[i].DTS < samples[j].DTS
+		})
+
+		for _, s := range samples {
+			err := cb(s)
+			if err != nil {
+				return err
+			}
+		}
+
+		if end {
+			return errReadEnd
+		}
+
+		return nil
+	})
+}
+
+// Close closes segment files, payloads of read samples are not available anymore.
+func (r *Reader) Close() {
+	for _, f := range r.files {
+		f.Close()
+	}
+	r.files = nil
+}
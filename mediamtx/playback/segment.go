@@ -0,0 +1,70 @@
+package playback
+
+import (
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/record"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ErrNoSegmentsFound is returned when there are no recordings of path in requested time.
+var ErrNoSegmentsFound = errors.New("no recording segments found")
+
+// Segment is a recording segment file.
+type Segment struct {
+	Fpath string
+	Start time.Time
+}
+
+// FindSegments returns recording segments of path sorted by start time.
+func FindSegments(pathConf *conf.Path, pathName string) ([]*Segment, error) {
+	if pathConf.RecordPath == "" {
+		return nil, errors.New("recording is disabled")
+	}
+
+	if pathConf.RecordFormat != conf.RecordFormatFMP4 {
+		return nil, errors.New("playback supports fmp4 recordings only")
+	}
+
+	// file walk returns clean paths, template must be clean too
+	template := filepath.Clean(record.PathTemplate(pathConf.RecordPath, pathName, record.Extension(pathConf.RecordFormat)))
+	commonPath := record.CommonPath(template)
+
+	var segments []*Segment
+
+	err := filepath.WalkDir(commonPath, func(fpath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		var pa record.Path
+		if pa.Decode(template, fpath) {
+			segments = append(segments, &Segment{
+				Fpath: fpath,
+				Start: pa.Start,
+			})
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if segments == nil {
+		return nil, ErrNoSegmentsFound
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Start.Before(segments[j].Start)
+	})
+
+	return segments, nil
+}
@@ -0,0 +1,353 @@
+package playback
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/abema/go-mp4"
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+)
+
+const (
+	trunFlagDataOffsetPreset      = 0x01
+	trunFlagSampleDurationPresent = 0x100
+	trunFlagSampleSizePresent     = 0x200
+	trunFlagSampleFlagsPresent    = 0x400
+
+	sampleFlagIsNonSyncSample = 1 << 16
+)
+
+var errInitNotFound = errors.New("initialization header not found")
+
+type segmentFMP4Sample struct {
+	duration        uint32
+	ptsOffset       int32
+	isNonSyncSample bool
+	size            uint32
+	// offset of payload inside segment file
+	offset int64
+}
+
+type segmentFMP4PartTrack struct {
+	id       int
+	baseTime uint64
+	samples  []*segmentFMP4Sample
+}
+
+type segmentFMP4Box struct {
+	typ    string
+	offset int64
+	size   int64
+}
+
+// segmentFMP4ScanBoxes calls cb for every complete top-level box until cb returns false,
+// payloads are not read, last box could be incomplete when segment is still being written
+func segmentFMP4ScanBoxes(r io.ReadSeeker, cb func(box segmentFMP4Box) (bool, error)) error {
+	fileSize, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 8)
+	offset := int64(0)
+
+	for offset+8 <= fileSize {
+		_, err = r.Seek(offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return err
+		}
+
+		box := segmentFMP4Box{
+			typ:    string(buf[4:]),
+			offset: offset,
+			size:   int64(binary.BigEndian.Uint32(buf)),
+		}
+
+		if box.size == 1 {
+			_, err = io.ReadFull(r, buf)
+			if err != nil {
+				return err
+			}
+			box.size = int64(binary.BigEndian.Uint64(buf))
+		}
+
+		if box.size < 8 {
+			return fmt.Errorf("invalid size of box '%s'", box.typ)
+		}
+
+		if offset+box.size > fileSize {
+			return nil
+		}
+
+		ok, err := cb(box)
+		if err != nil || !ok {
+			return err
+		}
+
+		offset += box.size
+	}
+
+	return nil
+}
+
+func segmentFMP4ReadBox(r io.ReadSeeker, box segmentFMP4Box) ([]byte, error) {
+	_, err := r.Seek(box.offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, box.size)
+	_, err = io.ReadFull(r, buf)
+	return buf, err
+}
+
+func segmentFMP4ReadInit(r io.ReadSeeker) (*fmp4.Init, error) {
+	var init *fmp4.Init
+
+	err := segmentFMP4ScanBoxes(r, func(box segmentFMP4Box) (bool, error) {
+		switch box.typ {
+		case "moov":
+			buf, err := segmentFMP4ReadBox(r, box)
+			if err != nil {
+				return false, err
+			}
+
+			init = &fmp4.Init{}
+			return false, init.Unmarshal(bytes.NewReader(buf))
+
+		case "moof":
+			return false, errInitNotFound
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if init == nil {
+		return nil, errInitNotFound
+	}
+
+	return init, nil
+}
+
+func segmentFMP4ParseMoof(byts []byte, moofOffset int64) ([]*segmentFMP4PartTrack, error) {
+	var tracks []*segmentFMP4PartTrack
+	var curTrack *segmentFMP4PartTrack
+	var tfhd *mp4.Tfhd
+
+	_, err := mp4.ReadBoxStructure(bytes.NewReader(byts), func(h *mp4.ReadHandle) (interface{}, error) {
+		switch h.BoxInfo.Type.String() {
+		case "moof":
+			return h.Expand()
+
+		case "traf":
+			curTrack = &segmentFMP4PartTrack{}
+			tracks = append(tracks, curTrack)
+			tfhd = nil
+			return h.Expand()
+
+		case "tfhd":
+			if curTrack == nil {
+				return nil, fmt.Errorf("unexpected tfhd")
+			}
+
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, err
+			}
+			tfhd = box.(*mp4.Tfhd)
+
+			curTrack.id = int(tfhd.TrackID)
+
+		case "tfdt":
+			if curTrack == nil {
+				return nil, fmt.Errorf("unexpected tfdt")
+			}
+
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, err
+			}
+			tfdt := box.(*mp4.Tfdt)
+
+			if tfdt.FullBox.Version == 0 {
+				curTrack.baseTime = uint64(tfdt.BaseMediaDecodeTimeV0)
+			} else {
+				curTrack.baseTime = tfdt.BaseMediaDecodeTimeV1
+			}
+
+		case "trun":
+			if curTrack == nil || tfhd == nil {
+				return nil, fmt.Errorf("unexpected trun")
+			}
+
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, err
+			}
+			trun := box.(*mp4.Trun)
+
+			trunFlags := uint16(trun.Flags[1])<<8 | uint16(trun.Flags[2])
+			if (trunFlags & trunFlagDataOffsetPreset) == 0 {
+				return nil, fmt.Errorf("unsupported flags")
+			}
+
+			offset := moofOffset + int64(trun.DataOffset)
+
+			for _, e := range trun.Entries {
+				s := &segmentFMP4Sample{
+					ptsOffset: e.SampleCompositionTimeOffsetV1,
+					offset:    offset,
+				}
+
+				if (trunFlags & trunFlagSampleDurationPresent) != 0 {
+					s.duration = e.SampleDuration
+				} else {
+					s.duration = tfhd.DefaultSampleDuration
+				}
+
+				sampleFlags := tfhd.DefaultSampleFlags
+				if (trunFlags & trunFlagSampleFlagsPresent) != 0 {
+					sampleFlags = e.SampleFlags
+				}
+				s.isNonSyncSample = (sampleFlags & sampleFlagIsNonSyncSample) != 0
+
+				if (trunFlags & trunFlagSampleSizePresent) != 0 {
+					s.size = e.SampleSize
+				} else {
+					s.size = tfhd.DefaultSampleSize
+				}
+
+				offset += int64(s.size)
+				curTrack.samples = append(curTrack.samples, s)
+			}
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tracks, nil
+}
+
+// segmentFMP4ReadParts calls cb for every part, payloads are left in file
+func segmentFMP4ReadParts(r io.ReadSeeker, cb func(tracks []*segmentFMP4PartTrack) error) error {
+	var moof *segmentFMP4Box
+
+	return segmentFMP4ScanBoxes(r, func(box segmentFMP4Box) (bool, error) {
+		switch box.typ {
+		case "moof":
+			moof = &box
+
+		case "mdat":
+			if moof == nil {
+				return true, nil
+			}
+
+			moofOffset := moof.offset
+			buf, err := segmentFMP4ReadBox(r, *moof)
+			if err != nil {
+				return false, err
+			}
+			moof = nil
+
+			tracks, err := segmentFMP4ParseMoof(buf, moofOffset)
+			if err != nil {
+				return false, err
+			}
+
+			for _, track := range tracks {
+				for _, s := range track.samples {
+					if s.offset < box.offset || s.offset+int64(s.size) > box.offset+box.size {
+						return false, fmt.Errorf("invalid sample offset")
+					}
+				}
+			}
+
+			return true, cb(tracks)
+		}
+
+		return true, nil
+	})
+}
+
+// segmentFMP4ReadDuration returns duration of segment, computed from its last part
+func segmentFMP4ReadDuration(r io.ReadSeeker, init *fmp4.Init) (time.Duration, error) {
+	var lastMoof *segmentFMP4Box
+	var moof *segmentFMP4Box
+
+	err := segmentFMP4ScanBoxes(r, func(box segmentFMP4Box) (bool, error) {
+		switch box.typ {
+		case "moof":
+			moof = &box
+
+		case "mdat":
+			if moof != nil {
+				lastMoof = moof
+				moof = nil
+			}
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if lastMoof == nil {
+		return 0, nil
+	}
+
+	buf, err := segmentFMP4ReadBox(r, *lastMoof)
+	if err != nil {
+		return 0, err
+	}
+
+	tracks, err := segmentFMP4ParseMoof(buf, lastMoof.offset)
+	if err != nil {
+		return 0, err
+	}
+
+	var duration time.Duration
+
+	for _, track := range tracks {
+		initTrack := findInitTrack(init, track.id)
+		if initTrack == nil {
+			return 0, fmt.Errorf("track %d not found in initialization header", track.id)
+		}
+
+		end := track.baseTime
+		for _, s := range track.samples {
+			end += uint64(s.duration)
+		}
+
+		d := durationMp4ToGo(end, initTrack.TimeScale)
+		if d > duration {
+			duration = d
+		}
+	}
+
+	return duration, nil
+}
+
+func findInitTrack(init *fmp4.Init, id int) *fmp4.InitTrack {
+	for _, track := range init.Tracks {
+		if track.ID == id {
+			return track
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Package playback contains the playback server of recordings.
+package playback
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/auth"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+type serverPathManager interface {
+	FindPathConf(req defs.PathFindPathConfReq) (*conf.Path, error)
+}
+
+// Server is a playback server, serving recording segments over HTTP.
+type Server struct {
+	Address     string
+	AllowOrigin string
+	ReadTimeout conf.StringDuration
+	PathManager serverPathManager
+	Parent      logger.Writer
+
+	inner *http.Server
+}
+
+// Initialize initializes Server.
+func (s *Server) Initialize() error {
+	ln, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /list", s.onList)
+	mux.HandleFunc("GET /get", s.onGet)
+
+	s.inner = &http.Server{
+		Handler:           s.withCORS(mux),
+		ReadHeaderTimeout: time.Duration(s.ReadTimeout),
+		ErrorLog:          log.New(io.Discard, "", 0),
+	}
+
+	go s.inner.Serve(ln) //nolint:errcheck
+
+	s.Log(logger.Info, "listener opened on %s", s.Address)
+
+	return nil
+}
+
+// Close closes Server.
+func (s *Server) Close() {
+	s.Log(logger.Info, "listener is closing")
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+
+	_ = s.inner.Shutdown(ctx)
+}
+
+// Log implements logger.Writer.
+func (s *Server) Log(level logger.Level, format string, args ...interface{}) {
+	s.Parent.Log(level, "[playback] "+format, args...)
+}
+
+func (s *Server) withCORS(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", s.AllowOrigin)
+		// browsers reject credentials allowed for any origin
+		if s.AllowOrigin != "*" {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET")
+			w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"message": err.Error(),
+	})
+}
+
+// findPathConf authenticates request and returns configuration of path, errors are written into response
+func (s *Server) findPathConf(w http.ResponseWriter, r *http.Request, pathName string) *conf.Path {
+	user, pass, hasCredentials := r.BasicAuth()
+
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	pathConf, err := s.PathManager.FindPathConf(defs.PathFindPathConfReq{
+		AccessRequest: defs.PathAccessRequest{
+			Name:     pathName,
+			Query:    r.URL.RawQuery,
+			Playback: true,
+			IP:       net.ParseIP(host),
+			User:     user,
+			Pass:     pass,
+		},
+	})
+	if err != nil {
+		var terr auth.Error
+		if errors.As(err, &terr) {
+			if !hasCredentials {
+				w.Header().Set("WWW-Authenticate", `Basic realm="h265_decoder"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return nil
+			}
+
+			s.Log(logger.Info, "connection %v failed to authenticate: %v", r.RemoteAddr, terr.Message)
+
+			// wait some seconds to mitigate brute force attacks
+			<-time.After(auth.PauseAfterError)
+
+			w.WriteHeader(http.StatusUnauthorized)
+			return nil
+		}
+
+		s.writeError(w, http.StatusNotFound, err)
+		return nil
+	}
+
+	return pathConf
+}
+
+func (s *Server) findSegments(w http.ResponseWriter, pathConf *conf.Path, pathName string) []*Segment {
+	segments, err := FindSegments(pathConf, pathName)
+	if err != nil {
+		if errors.Is(err, ErrNoSegmentsFound) {
+			s.writeError(w, http.StatusNotFound, err)
+		} else {
+			s.writeError(w, http.StatusBadRequest, err)
+		}
+		return nil
+	}
+
+	return segments
+}
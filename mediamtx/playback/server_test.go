@@ -0,0 +1,182 @@
+package playback
+
+import (
+	"bytes"
+	"encoding/json"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/record"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"fearpro13/h265_transcoder/mediamtx/unit"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+)
+
+// writeRecording records key frames of 100ms each, in segments of 1 second
+func writeRecording(t *testing.T, pathFormat string, pathName string, start time.Time, frames int) {
+	strm, err := stream.New(1472, &description.Session{Medias: []*description.Media{test.MediaH264}}, true, test.NilLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strm.Close()
+
+	a := &record.Agent{
+		WriteQueueSize:  512,
+		PathFormat:      pathFormat,
+		Format:          conf.RecordFormatFMP4,
+		PartDuration:    100 * time.Millisecond,
+		SegmentDuration: time.Second,
+		PathName:        pathName,
+		Stream:          strm,
+		Parent:          test.NilLogger,
+	}
+	a.Initialize()
+
+	idr := append([]byte{0x65, 0x88, 0x84}, bytes.Repeat([]byte{0x01}, 100)...)
+
+	for i := 0; i < frames; i++ {
+		pts := time.Duration(i) * 100 * time.Millisecond
+
+		strm.WriteUnit(test.MediaH264, test.FormatH264, &unit.H264{
+			Base: unit.Base{
+				NTP: start.Add(pts),
+				PTS: pts,
+			},
+			AU: [][]byte{test.FormatH264.SPS, test.FormatH264.PPS, idr},
+		})
+
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	a.Close()
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	pathFormat := filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")
+	start := time.Date(2024, 3, 5, 7, 8, 9, 0, time.Local)
+
+	// two recordings of the path with a gap between them, and a recording of its rendition
+	writeRecording(t, pathFormat, "cam1", start, 100)
+	writeRecording(t, pathFormat, "cam1", start.Add(time.Minute), 50)
+	writeRecording(t, pathFormat, "cam1/720p", start, 50)
+
+	pathConf := &conf.Path{RecordPath: pathFormat, RecordFormat: conf.RecordFormatFMP4}
+
+	segments, err := FindSegments(pathConf, "cam1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, seg := range segments {
+		if filepath.Dir(seg.Fpath) != filepath.Join(dir, "cam1") {
+			t.Fatalf("segment of another path %s", seg.Fpath)
+		}
+		if i > 0 && !seg.Start.After(segments[i-1].Start) {
+			t.Fatal("segments are not sorted")
+		}
+	}
+
+	addr := fmt.Sprintf("127.0.0.1:%d", test.FreeTCPPort(t))
+
+	s := &Server{
+		Address:     addr,
+		AllowOrigin: "*",
+		ReadTimeout: conf.StringDuration(10 * time.Second),
+		PathManager: &test.PathManager{Path: &test.Path{PathName: "cam1", Conf: pathConf}},
+		Parent:      test.NilLogger,
+	}
+	err = s.Initialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	get := func(path string, query url.Values) (int, []byte) {
+		res, err := http.Get("http://" + addr + path + "?" + query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return res.StatusCode, body
+	}
+
+	status, body := get("/list", url.Values{"path": {"cam1"}})
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", status, body)
+	}
+
+	var entries []listEntry
+	err = json.Unmarshal(body, &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 ||
+		entries[0].Start.Sub(start).Abs() > 200*time.Millisecond || entries[0].Duration < 9.5 || entries[0].Duration > 10 ||
+		entries[1].Start.Sub(start.Add(time.Minute)).Abs() > 200*time.Millisecond || entries[1].Duration < 4.5 || entries[1].Duration > 5 {
+		t.Fatalf("unexpected list %+v", entries)
+	}
+
+	status, _ = get("/list", url.Values{"path": {"cam2"}})
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status of missing path %d", status)
+	}
+
+	rfc := func(t time.Time) string { return t.Format(time.RFC3339Nano) }
+
+	for _, c := range []struct {
+		name     string
+		query    url.Values
+		status   int
+		duration time.Duration
+	}{
+		{"range", url.Values{"path": {"cam1"}, "start": {rfc(start.Add(2 * time.Second))}, "duration": {"3"}}, http.StatusOK, 3 * time.Second},
+		{"range over segments", url.Values{"path": {"cam1"}, "start": {rfc(start.Add(2500 * time.Millisecond))}, "duration": {"1.5"}}, http.StatusOK, 1500 * time.Millisecond},
+		{"mp4", url.Values{"path": {"cam1"}, "start": {rfc(start.Add(time.Minute))}, "duration": {"2"}, "format": {"mp4"}}, http.StatusOK, 0},
+		{"gap", url.Values{"path": {"cam1"}, "start": {rfc(start.Add(30 * time.Second))}, "duration": {"1"}, "format": {"mp4"}}, http.StatusNotFound, 0},
+		{"before recordings", url.Values{"path": {"cam1"}, "start": {rfc(start.Add(-time.Hour))}, "duration": {"1"}}, http.StatusNotFound, 0},
+		{"invalid start", url.Values{"path": {"cam1"}, "start": {"yesterday"}, "duration": {"1"}}, http.StatusBadRequest, 0},
+		{"invalid duration", url.Values{"path": {"cam1"}, "start": {rfc(start)}, "duration": {"-1"}}, http.StatusBadRequest, 0},
+		{"invalid format", url.Values{"path": {"cam1"}, "start": {rfc(start)}, "duration": {"1"}, "format": {"mkv"}}, http.StatusBadRequest, 0},
+	} {
+		status, body := get("/get", c.query)
+		if status != c.status {
+			t.Errorf("%s: unexpected status %d: %s", c.name, status, body)
+			continue
+		}
+
+		if c.duration == 0 {
+			continue
+		}
+
+		fpath := filepath.Join(t.TempDir(), "get.mp4")
+		err = os.WriteFile(fpath, body, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, duration, err := readSegmentInfo(fpath)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		if (duration - c.duration).Abs() > 100*time.Millisecond {
+			t.Errorf("%s: unexpected duration %s", c.name, duration)
+		}
+	}
+}
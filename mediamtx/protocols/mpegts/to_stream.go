@@ -0,0 +1,130 @@
+// Package mpegts contains MPEG-TS utilities.
+package mpegts
+
+import (
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"fearpro13/h265_transcoder/mediamtx/unit"
+	"fmt"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+)
+
+// ToStream maps tracks of MPEG-TS reader on medias, units read afterwards are written into stream pointed by strm,
+// which has to be set before first Read. Stream could be kept by path handover, so units are written
+// into stream medias with the same index.
+// Supported codecs are H265, H264, MPEG-4 Audio and Opus, other tracks are skipped.
+func ToStream(r *mpegts.Reader, strm **stream.Stream) ([]*description.Media, error) {
+	var medias []*description.Media
+
+	// timestamps of every track are relative to the first timestamp of the program
+	var td *mpegts.TimeDecoder
+	decodeTime := func(t int64) time.Duration {
+		if td == nil {
+			td = mpegts.NewTimeDecoder(t)
+		}
+		return td.Decode(t)
+	}
+
+	writeUnit := func(i int, u unit.Unit) {
+		medi := (*strm).Desc().Medias[i]
+		(*strm).WriteUnit(medi, medi.Formats[0], u)
+	}
+
+	for _, track := range r.Tracks() {
+		var medi *description.Media
+		i := len(medias)
+
+		switch codec := track.Codec.(type) {
+		case *mpegts.CodecH265:
+			medi = &description.Media{
+				Type:    description.MediaTypeVideo,
+				Formats: []format.Format{&format.H265{PayloadTyp: 96}},
+			}
+
+			r.OnDataH265(track, func(pts int64, _ int64, au [][]byte) error {
+				writeUnit(i, &unit.H265{
+					Base: unit.Base{
+						NTP: time.Now(),
+						PTS: decodeTime(pts),
+					},
+					AU: au,
+				})
+				return nil
+			})
+
+		case *mpegts.CodecH264:
+			medi = &description.Media{
+				Type:    description.MediaTypeVideo,
+				Formats: []format.Format{&format.H264{PayloadTyp: 96, PacketizationMode: 1}},
+			}
+
+			r.OnDataH264(track, func(pts int64, _ int64, au [][]byte) error {
+				writeUnit(i, &unit.H264{
+					Base: unit.Base{
+						NTP: time.Now(),
+						PTS: decodeTime(pts),
+					},
+					AU: au,
+				})
+				return nil
+			})
+
+		case *mpegts.CodecMPEG4Audio:
+			medi = &description.Media{
+				Type: description.MediaTypeAudio,
+				Formats: []format.Format{&format.MPEG4Audio{
+					PayloadTyp:       96,
+					SizeLength:       13,
+					IndexLength:      3,
+					IndexDeltaLength: 3,
+					Config:           &codec.Config,
+				}},
+			}
+
+			r.OnDataMPEG4Audio(track, func(pts int64, aus [][]byte) error {
+				writeUnit(i, &unit.MPEG4Audio{
+					Base: unit.Base{
+						NTP: time.Now(),
+						PTS: decodeTime(pts),
+					},
+					AUs: aus,
+				})
+				return nil
+			})
+
+		case *mpegts.CodecOpus:
+			medi = &description.Media{
+				Type: description.MediaTypeAudio,
+				Formats: []format.Format{&format.Opus{
+					PayloadTyp:   96,
+					ChannelCount: codec.ChannelCount,
+				}},
+			}
+
+			r.OnDataOpus(track, func(pts int64, packets [][]byte) error {
+				writeUnit(i, &unit.Opus{
+					Base: unit.Base{
+						NTP: time.Now(),
+						PTS: decodeTime(pts),
+					},
+					Packets: packets,
+				})
+				return nil
+			})
+
+		default:
+			continue
+		}
+
+		medias = append(medias, medi)
+	}
+
+	if len(medias) == 0 {
+		return nil, fmt.Errorf("no supported tracks found, which are currently H265, H264, MPEG-4 Audio, Opus")
+	}
+
+	return medias, nil
+}
@@ -0,0 +1,283 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	amf0Number      = 0x00
+	amf0Boolean     = 0x01
+	amf0String      = 0x02
+	amf0Object      = 0x03
+	amf0Null        = 0x05
+	amf0Undefined   = 0x06
+	amf0ECMAArray   = 0x08
+	amf0ObjectEnd   = 0x09
+	amf0StrictArray = 0x0A
+	amf0Date        = 0x0B
+	amf0LongString  = 0x0C
+)
+
+// ObjectEntry is an entry of an AMF0 object.
+type ObjectEntry struct {
+	Key   string
+	Value interface{}
+}
+
+// Object is an AMF0 object, order of entries is kept.
+type Object []ObjectEntry
+
+// Get returns the value of an entry.
+func (o Object) Get(key string) (interface{}, bool) {
+	for _, e := range o {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// GetString returns the value of a string entry.
+func (o Object) GetString(key string) (string, bool) {
+	v, ok := o.Get(key)
+	if !ok {
+		return "", false
+	}
+	s, ok := v.(string)
+	return s, ok
+}
+
+// ECMAArray is an AMF0 ECMA array, that is an object with a count of entries.
+type ECMAArray []ObjectEntry
+
+// StrictArray is an AMF0 strict array.
+type StrictArray []interface{}
+
+// Undefined is the AMF0 undefined value, nil is the AMF0 null value.
+type Undefined struct{}
+
+// amf0Marshal encodes values, numbers must be float64
+func amf0Marshal(vals []interface{}) ([]byte, error) {
+	var buf []byte
+
+	for _, v := range vals {
+		var err error
+		buf, err = amf0MarshalValue(buf, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
+}
+
+func amf0MarshalValue(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case float64:
+		buf = append(buf, amf0Number)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(v)), nil
+
+	case bool:
+		if v {
+			return append(buf, amf0Boolean, 1), nil
+		}
+		return append(buf, amf0Boolean, 0), nil
+
+	case string:
+		if len(v) > math.MaxUint16 {
+			buf = append(buf, amf0LongString)
+			buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+			return append(buf, v...), nil
+		}
+		buf = append(buf, amf0String)
+		return amf0MarshalKey(buf, v), nil
+
+	case Object:
+		buf = append(buf, amf0Object)
+		return amf0MarshalEntries(buf, v)
+
+	case ECMAArray:
+		buf = append(buf, amf0ECMAArray)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+		return amf0MarshalEntries(buf, v)
+
+	case StrictArray:
+		buf = append(buf, amf0StrictArray)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+		for _, e := range v {
+			var err error
+			buf, err = amf0MarshalValue(buf, e)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+
+	case nil:
+		return append(buf, amf0Null), nil
+
+	case Undefined:
+		return append(buf, amf0Undefined), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported AMF0 type: %T", v)
+	}
+}
+
+func amf0MarshalKey(buf []byte, key string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(key)))
+	return append(buf, key...)
+}
+
+func amf0MarshalEntries(buf []byte, entries []ObjectEntry) ([]byte, error) {
+	for _, e := range entries {
+		buf = amf0MarshalKey(buf, e.Key)
+
+		var err error
+		buf, err = amf0MarshalValue(buf, e.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return append(buf, 0, 0, amf0ObjectEnd), nil
+}
+
+// amf0Unmarshal decodes every value of buf
+func amf0Unmarshal(buf []byte) ([]interface{}, error) {
+	var vals []interface{}
+
+	for len(buf) > 0 {
+		var v interface{}
+		var err error
+		v, buf, err = amf0UnmarshalValue(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		vals = append(vals, v)
+	}
+
+	return vals, nil
+}
+
+func amf0UnmarshalValue(buf []byte) (interface{}, []byte, error) {
+	if len(buf) < 1 {
+		return nil, nil, fmt.Errorf("not enough bytes")
+	}
+
+	typ := buf[0]
+	buf = buf[1:]
+
+	switch typ {
+	case amf0Number:
+		if len(buf) < 8 {
+			return nil, nil, fmt.Errorf("not enough bytes")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf)), buf[8:], nil
+
+	case amf0Boolean:
+		if len(buf) < 1 {
+			return nil, nil, fmt.Errorf("not enough bytes")
+		}
+		return buf[0] != 0, buf[1:], nil
+
+	case amf0String:
+		return amf0UnmarshalKey(buf)
+
+	case amf0LongString:
+		if len(buf) < 4 {
+			return nil, nil, fmt.Errorf("not enough bytes")
+		}
+		le := binary.BigEndian.Uint32(buf)
+		buf = buf[4:]
+		if uint32(len(buf)) < le {
+			return nil, nil, fmt.Errorf("not enough bytes")
+		}
+		return string(buf[:le]), buf[le:], nil
+
+	case amf0Object:
+		entries, rest, err := amf0UnmarshalEntries(buf)
+		return Object(entries), rest, err
+
+	case amf0ECMAArray:
+		if len(buf) < 4 {
+			return nil, nil, fmt.Errorf("not enough bytes")
+		}
+		// count is not reliable, entries are read until the end marker
+		entries, rest, err := amf0UnmarshalEntries(buf[4:])
+		return ECMAArray(entries), rest, err
+
+	case amf0StrictArray:
+		if len(buf) < 4 {
+			return nil, nil, fmt.Errorf("not enough bytes")
+		}
+		count := binary.BigEndian.Uint32(buf)
+		buf = buf[4:]
+
+		arr := StrictArray{}
+		for i := uint32(0); i < count; i++ {
+			var v interface{}
+			var err error
+			v, buf, err = amf0UnmarshalValue(buf)
+			if err != nil {
+				return nil, nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, buf, nil
+
+	case amf0Date:
+		// milliseconds and time zone
+		if len(buf) < 10 {
+			return nil, nil, fmt.Errorf("not enough bytes")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf)), buf[10:], nil
+
+	case amf0Null:
+		return nil, buf, nil
+
+	case amf0Undefined:
+		return Undefined{}, buf, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported AMF0 type: %d", typ)
+	}
+}
+
+func amf0UnmarshalKey(buf []byte) (interface{}, []byte, error) {
+	if len(buf) < 2 {
+		return nil, nil, fmt.Errorf("not enough bytes")
+	}
+	le := int(binary.BigEndian.Uint16(buf))
+	buf = buf[2:]
+	if len(buf) < le {
+		return nil, nil, fmt.Errorf("not enough bytes")
+	}
+	return string(buf[:le]), buf[le:], nil
+}
+
+func amf0UnmarshalEntries(buf []byte) ([]ObjectEntry, []byte, error) {
+	var entries []ObjectEntry
+
+	for {
+		if len(buf) >= 3 && buf[0] == 0 && buf[1] == 0 && buf[2] == amf0ObjectEnd {
+			return entries, buf[3:], nil
+		}
+
+		key, rest, err := amf0UnmarshalKey(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var v interface{}
+		v, buf, err = amf0UnmarshalValue(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		entries = append(entries, ObjectEntry{Key: key.(string), Value: v})
+	}
+}
@@ -0,0 +1,283 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+)
+
+const (
+	defaultChunkSize = 128
+	// maxMessageSize limits declared message length, buffers grow with received data only
+	maxMessageSize = 4 * 1024 * 1024
+	// maxChunkStreams limits chunk streams of a connection, publishers use a handful of them
+	maxChunkStreams = 32
+	extendedTS      = 0xFFFFFF
+)
+
+// message types
+const (
+	MessageTypeSetChunkSize     = 1
+	MessageTypeAbort            = 2
+	MessageTypeAck              = 3
+	MessageTypeUserControl      = 4
+	MessageTypeWindowAckSize    = 5
+	MessageTypeSetPeerBandwidth = 6
+	MessageTypeAudio            = 8
+	MessageTypeVideo            = 9
+	MessageTypeDataAMF3         = 15
+	MessageTypeCommandAMF3      = 17
+	MessageTypeDataAMF0         = 18
+	MessageTypeCommandAMF0      = 20
+)
+
+// chunk stream IDs used by writers
+const (
+	chunkStreamControl = 2
+	chunkStreamCommand = 3
+	chunkStreamAudio   = 4
+	chunkStreamData    = 5
+	chunkStreamVideo   = 6
+)
+
+// Message is a RTMP message.
+type Message struct {
+	ChunkStreamID uint32
+	Type          uint8
+	Timestamp     uint32
+	StreamID      uint32
+	Body          []byte
+}
+
+type chunkStreamState struct {
+	initialized bool
+	// timestamp of header, absolute for type 0, delta otherwise
+	tsField   uint32
+	timestamp uint32
+	length    uint32
+	typ       uint8
+	streamID  uint32
+	body      []byte
+}
+
+// chunkReader reassembles messages from chunks.
+type chunkReader struct {
+	r         io.Reader
+	chunkSize uint32
+	streams   map[uint32]*chunkStreamState
+	header    [11]byte
+}
+
+func newChunkReader(r io.Reader) *chunkReader {
+	return &chunkReader{
+		r:         r,
+		chunkSize: defaultChunkSize,
+		streams:   make(map[uint32]*chunkStreamState),
+	}
+}
+
+func (cr *chunkReader) read() (*Message, error) {
+	for {
+		msg, err := cr.readChunk()
+		if err != nil {
+			return nil, err
+		}
+
+		if msg != nil {
+			return msg, nil
+		}
+	}
+}
+
+// readChunk reads a chunk, returning the message it completes, if any
+func (cr *chunkReader) readChunk() (*Message, error) {
+	_, err := io.ReadFull(cr.r, cr.header[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	typ := cr.header[0] >> 6
+	csid := uint32(cr.header[0] & 0x3F)
+
+	switch csid {
+	case 0:
+		_, err = io.ReadFull(cr.r, cr.header[:1])
+		if err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(cr.header[0])
+
+	case 1:
+		_, err = io.ReadFull(cr.r, cr.header[:2])
+		if err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(cr.header[0]) + uint32(cr.header[1])*256
+	}
+
+	st, ok := cr.streams[csid]
+	if !ok {
+		if len(cr.streams) >= maxChunkStreams {
+			return nil, fmt.Errorf("too many chunk streams (%d)", len(cr.streams)+1)
+		}
+
+		st = &chunkStreamState{}
+		cr.streams[csid] = st
+	}
+
+	if typ != 0 && !st.initialized {
+		return nil, fmt.Errorf("received chunk of type %d without previous header", typ)
+	}
+
+	continuation := typ == 3 && len(st.body) != 0
+
+	switch typ {
+	case 0:
+		_, err = io.ReadFull(cr.r, cr.header[:11])
+		if err != nil {
+			return nil, err
+		}
+		st.tsField = uint32(cr.header[0])<<16 | uint32(cr.header[1])<<8 | uint32(cr.header[2])
+		st.length = uint32(cr.header[3])<<16 | uint32(cr.header[4])<<8 | uint32(cr.header[5])
+		st.typ = cr.header[6]
+		st.streamID = binary.LittleEndian.Uint32(cr.header[7:])
+
+	case 1:
+		_, err = io.ReadFull(cr.r, cr.header[:7])
+		if err != nil {
+			return nil, err
+		}
+		st.tsField = uint32(cr.header[0])<<16 | uint32(cr.header[1])<<8 | uint32(cr.header[2])
+		st.length = uint32(cr.header[3])<<16 | uint32(cr.header[4])<<8 | uint32(cr.header[5])
+		st.typ = cr.header[6]
+
+	case 2:
+		_, err = io.ReadFull(cr.r, cr.header[:3])
+		if err != nil {
+			return nil, err
+		}
+		st.tsField = uint32(cr.header[0])<<16 | uint32(cr.header[1])<<8 | uint32(cr.header[2])
+	}
+
+	tsField := st.tsField
+
+	// extended timestamp is repeated in chunks of type 3
+	if st.tsField >= extendedTS {
+		_, err = io.ReadFull(cr.r, cr.header[:4])
+		if err != nil {
+			return nil, err
+		}
+		tsField = binary.BigEndian.Uint32(cr.header[:4])
+	}
+
+	if typ != 0 && typ != 3 && len(st.body) != 0 {
+		return nil, fmt.Errorf("received chunk of type %d while a message is incomplete", typ)
+	}
+
+	if !continuation {
+		if typ == 0 {
+			st.timestamp = tsField
+		} else {
+			st.timestamp += tsField
+		}
+
+		if st.length > maxMessageSize {
+			return nil, fmt.Errorf("message size (%d) exceeds maximum (%d)", st.length, maxMessageSize)
+		}
+
+		st.body = nil
+	}
+
+	st.initialized = true
+
+	le := st.length - uint32(len(st.body))
+	if le > cr.chunkSize {
+		le = cr.chunkSize
+	}
+
+	start := len(st.body)
+	st.body = slices.Grow(st.body, int(le))[:start+int(le)]
+	_, err = io.ReadFull(cr.r, st.body[start:])
+	if err != nil {
+		return nil, err
+	}
+
+	if uint32(len(st.body)) < st.length {
+		return nil, nil
+	}
+
+	msg := &Message{
+		ChunkStreamID: csid,
+		Type:          st.typ,
+		Timestamp:     st.timestamp,
+		StreamID:      st.streamID,
+		Body:          st.body,
+	}
+	st.body = nil
+
+	return msg, nil
+}
+
+// chunkWriter splits messages into chunks.
+// the first chunk of every message has a full header, therefore timestamps are absolute
+type chunkWriter struct {
+	w         io.Writer
+	chunkSize uint32
+	buf       []byte
+}
+
+func newChunkWriter(w io.Writer) *chunkWriter {
+	return &chunkWriter{
+		w:         w,
+		chunkSize: defaultChunkSize,
+	}
+}
+
+func (cw *chunkWriter) write(msg *Message) error {
+	if msg.ChunkStreamID < 2 || msg.ChunkStreamID > 63 {
+		return fmt.Errorf("unsupported chunk stream ID: %d", msg.ChunkStreamID)
+	}
+
+	le := uint32(len(msg.Body))
+	extended := msg.Timestamp >= extendedTS
+
+	buf := cw.buf[:0]
+
+	buf = append(buf, byte(msg.ChunkStreamID))
+	if extended {
+		buf = append(buf, 0xFF, 0xFF, 0xFF)
+	} else {
+		buf = append(buf, byte(msg.Timestamp>>16), byte(msg.Timestamp>>8), byte(msg.Timestamp))
+	}
+	buf = append(buf, byte(le>>16), byte(le>>8), byte(le), msg.Type)
+	buf = binary.LittleEndian.AppendUint32(buf, msg.StreamID)
+	if extended {
+		buf = binary.BigEndian.AppendUint32(buf, msg.Timestamp)
+	}
+
+	body := msg.Body
+	for {
+		n := uint32(len(body))
+		if n > cw.chunkSize {
+			n = cw.chunkSize
+		}
+
+		buf = append(buf, body[:n]...)
+		body = body[n:]
+
+		if len(body) == 0 {
+			break
+		}
+
+		buf = append(buf, 3<<6|byte(msg.ChunkStreamID))
+		if extended {
+			buf = binary.BigEndian.AppendUint32(buf, msg.Timestamp)
+		}
+	}
+
+	cw.buf = buf
+
+	_, err := cw.w.Write(buf)
+	return err
+}
@@ -0,0 +1,88 @@
+package rtmp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestChunkReaderWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := newChunkWriter(&buf)
+	msg := &Message{ChunkStreamID: chunkStreamVideo, Type: MessageTypeVideo, Timestamp: 0x1000000, StreamID: 1, Body: bytes.Repeat([]byte{1, 2, 3}, 1000)}
+
+	err := w.write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newChunkReader(&buf)
+	read, err := r.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if read.Timestamp != msg.Timestamp || read.Type != msg.Type || !bytes.Equal(read.Body, msg.Body) {
+		t.Fatalf("unexpected message %+v", read)
+	}
+}
+
+// chunkHeader returns type 0 header of chunk stream declaring message length
+func chunkHeader(csid byte, length uint32) []byte {
+	return []byte{
+		csid,
+		0, 0, 0,
+		byte(length >> 16), byte(length >> 8), byte(length),
+		MessageTypeVideo,
+		1, 0, 0, 0,
+	}
+}
+
+func TestChunkReaderOversizedMessage(t *testing.T) {
+	r := newChunkReader(bytes.NewReader(chunkHeader(chunkStreamVideo, 0xFFFFFF)))
+
+	_, err := r.read()
+	if err == nil || !strings.Contains(err.Error(), "exceeds maximum") {
+		t.Fatalf("oversized message has not been rejected: %v", err)
+	}
+}
+
+func TestChunkReaderAllocation(t *testing.T) {
+	// declared length is not allocated before data arrives
+	r := newChunkReader(bytes.NewReader(append(chunkHeader(chunkStreamVideo, maxMessageSize), make([]byte, defaultChunkSize)...)))
+
+	_, err := r.readChunk()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := cap(r.streams[chunkStreamVideo].body); c >= maxMessageSize {
+		t.Fatalf("buffer of declared length has been allocated: %d", c)
+	}
+}
+
+func TestChunkReaderTooManyStreams(t *testing.T) {
+	var buf bytes.Buffer
+	for i := 0; i < maxChunkStreams+1; i++ {
+		// basic header of 2 bytes, chunk stream ID is 64 + second byte
+		buf.Write([]byte{0, byte(i)})
+		buf.Write(chunkHeader(0, 1000)[1:])
+		buf.Write(make([]byte, defaultChunkSize))
+	}
+
+	r := newChunkReader(&buf)
+
+	var err error
+	for i := 0; i < maxChunkStreams+1 && err == nil; i++ {
+		_, err = r.readChunk()
+	}
+
+	if err == nil || !strings.Contains(err.Error(), "too many chunk streams") {
+		t.Fatalf("too many chunk streams have not been rejected: %v", err)
+	}
+
+	if len(r.streams) > maxChunkStreams {
+		t.Fatalf("chunk streams are not limited: %d", len(r.streams))
+	}
+}
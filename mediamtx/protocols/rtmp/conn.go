@@ -0,0 +1,454 @@
+// Package rtmp contains a RTMP connection, able to read and publish H264 and MPEG-4 audio.
+package rtmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	// chunk size of outgoing messages
+	writeChunkSize = 65536
+	// window acknowledgement size and peer bandwidth sent to peer
+	windowAckSize = 2500000
+	// message stream used by play and publish
+	mediaStreamID = 1
+)
+
+// user control events
+const (
+	userControlStreamBegin  = 0
+	userControlPingRequest  = 6
+	userControlPingResponse = 7
+)
+
+type bytesCounter struct {
+	r io.Reader
+	n uint32
+}
+
+func (bc *bytesCounter) Read(p []byte) (int, error) {
+	n, err := bc.r.Read(p)
+	bc.n += uint32(n)
+	return n, err
+}
+
+// Conn is a RTMP connection.
+type Conn struct {
+	RW io.ReadWriter
+
+	bc            *bytesCounter
+	reader        *chunkReader
+	writer        *chunkWriter
+	writeMutex    sync.Mutex
+	peerWindowAck uint32
+	lastAck       uint32
+	streamID      uint32
+}
+
+func (c *Conn) initialize() {
+	c.bc = &bytesCounter{r: c.RW}
+	c.reader = newChunkReader(c.bc)
+	c.writer = newChunkWriter(c.RW)
+}
+
+// InitializeServer performs the handshake and the command exchange of a server.
+// It returns the URL requested by the client and whether the client wants to publish.
+func (c *Conn) InitializeServer() (*url.URL, bool, error) {
+	c.initialize()
+
+	err := handshakeServer(c.RW)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cmd, err := c.readCommand()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if cmd[0] != "connect" || len(cmd) < 3 {
+		return nil, false, fmt.Errorf("unexpected command: %v", cmd[0])
+	}
+
+	txID := cmd[1]
+	obj, ok := cmd[2].(Object)
+	if !ok {
+		return nil, false, fmt.Errorf("invalid connect command")
+	}
+
+	app, _ := obj.GetString("app")
+	tcURL, _ := obj.GetString("tcUrl")
+
+	err = c.writeControl(MessageTypeWindowAckSize, binary.BigEndian.AppendUint32(nil, windowAckSize))
+	if err != nil {
+		return nil, false, err
+	}
+
+	// dynamic limit type
+	err = c.writeControl(MessageTypeSetPeerBandwidth, append(binary.BigEndian.AppendUint32(nil, windowAckSize), 2))
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = c.setWriteChunkSize()
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = c.writeCommand(0, "_result", txID,
+		Object{
+			{Key: "fmsVer", Value: "LNX 9,0,124,2"},
+			{Key: "capabilities", Value: float64(31)},
+		},
+		Object{
+			{Key: "level", Value: "status"},
+			{Key: "code", Value: "NetConnection.Connect.Success"},
+			{Key: "description", Value: "Connection succeeded."},
+			{Key: "objectEncoding", Value: float64(0)},
+		})
+	if err != nil {
+		return nil, false, err
+	}
+
+	for {
+		cmd, err := c.readCommand()
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch cmd[0] {
+		case "createStream":
+			if len(cmd) < 2 {
+				return nil, false, fmt.Errorf("invalid createStream command")
+			}
+
+			err = c.writeCommand(0, "_result", cmd[1], nil, float64(mediaStreamID))
+			if err != nil {
+				return nil, false, err
+			}
+
+		case "play", "publish":
+			if len(cmd) < 4 {
+				return nil, false, fmt.Errorf("invalid %s command", cmd[0])
+			}
+
+			streamName, ok := cmd[3].(string)
+			if !ok {
+				return nil, false, fmt.Errorf("invalid %s command", cmd[0])
+			}
+
+			c.streamID = mediaStreamID
+			u := requestURL(tcURL, app, streamName)
+
+			if cmd[0] == "publish" {
+				return u, true, c.writeStatus(cmd[1], "NetStream.Publish.Start", "publish start")
+			}
+
+			err = c.writeControl(MessageTypeUserControl,
+				binary.BigEndian.AppendUint32([]byte{0, userControlStreamBegin}, mediaStreamID))
+			if err != nil {
+				return nil, false, err
+			}
+
+			err = c.writeStatus(cmd[1], "NetStream.Play.Reset", "play reset")
+			if err != nil {
+				return nil, false, err
+			}
+
+			return u, false, c.writeStatus(cmd[1], "NetStream.Play.Start", "play start")
+
+		default:
+			// releaseStream, FCPublish, getStreamLength and others don't need a response
+		}
+	}
+}
+
+// requestURL joins application and stream name into an URL, query could be part of both
+func requestURL(tcURL string, app string, streamName string) *url.URL {
+	u, err := url.Parse(tcURL)
+	if err != nil || u.Host == "" {
+		u = &url.URL{Scheme: "rtmp"}
+	}
+
+	app, appQuery, _ := strings.Cut(app, "?")
+	streamName, streamQuery, _ := strings.Cut(streamName, "?")
+
+	u.Path = "/" + strings.Trim(strings.Trim(app, "/")+"/"+strings.Trim(streamName, "/"), "/")
+	u.RawPath = ""
+	u.RawQuery = streamQuery
+	if u.RawQuery == "" {
+		u.RawQuery = appQuery
+	}
+
+	return u
+}
+
+// InitializeClient performs the handshake and the command exchange of a client.
+// As in ffmpeg, the first path element is the application when path contains more than one element.
+func (c *Conn) InitializeClient(u *url.URL, publish bool) error {
+	c.initialize()
+
+	err := handshakeClient(c.RW)
+	if err != nil {
+		return err
+	}
+
+	app, streamName, ok := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if !ok {
+		app, streamName = "", app
+	}
+	if u.RawQuery != "" {
+		streamName += "?" + u.RawQuery
+	}
+
+	err = c.setWriteChunkSize()
+	if err != nil {
+		return err
+	}
+
+	err = c.writeCommand(0, "connect", float64(1), Object{
+		{Key: "app", Value: app},
+		{Key: "flashVer", Value: "LNX 9,0,124,2"},
+		{Key: "tcUrl", Value: u.Scheme + "://" + u.Host + "/" + app},
+		{Key: "fpad", Value: false},
+		{Key: "capabilities", Value: float64(15)},
+		{Key: "audioCodecs", Value: float64(4071)},
+		{Key: "videoCodecs", Value: float64(252)},
+		{Key: "videoFunction", Value: float64(1)},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.readResult()
+	if err != nil {
+		return err
+	}
+
+	err = c.writeCommand(0, "createStream", float64(2), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.readResult()
+	if err != nil {
+		return err
+	}
+
+	streamID, ok := res[len(res)-1].(float64)
+	if !ok {
+		return fmt.Errorf("invalid createStream result")
+	}
+	c.streamID = uint32(streamID)
+
+	if publish {
+		err = c.writeCommand(c.streamID, "publish", float64(3), nil, streamName, "live")
+		if err != nil {
+			return err
+		}
+
+		return c.readStatus("NetStream.Publish.Start")
+	}
+
+	err = c.writeCommand(c.streamID, "play", float64(3), nil, streamName)
+	if err != nil {
+		return err
+	}
+
+	return c.readStatus("NetStream.Play.Start")
+}
+
+func (c *Conn) setWriteChunkSize() error {
+	err := c.writeControl(MessageTypeSetChunkSize, binary.BigEndian.AppendUint32(nil, writeChunkSize))
+	if err != nil {
+		return err
+	}
+
+	c.writeMutex.Lock()
+	c.writer.chunkSize = writeChunkSize
+	c.writeMutex.Unlock()
+
+	return nil
+}
+
+// ReadMessage reads a message. Protocol control messages are handled internally.
+func (c *Conn) ReadMessage() (*Message, error) {
+	for {
+		msg, err := c.reader.read()
+		if err != nil {
+			return nil, err
+		}
+
+		if c.peerWindowAck != 0 && c.bc.n-c.lastAck >= c.peerWindowAck {
+			c.lastAck = c.bc.n
+			err = c.writeControl(MessageTypeAck, binary.BigEndian.AppendUint32(nil, c.bc.n))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		switch msg.Type {
+		case MessageTypeSetChunkSize:
+			if len(msg.Body) != 4 {
+				return nil, fmt.Errorf("invalid set chunk size message")
+			}
+
+			size := binary.BigEndian.Uint32(msg.Body) & 0x7FFFFFFF
+			if size == 0 {
+				return nil, fmt.Errorf("invalid chunk size")
+			}
+			c.reader.chunkSize = size
+
+		case MessageTypeWindowAckSize:
+			if len(msg.Body) != 4 {
+				return nil, fmt.Errorf("invalid window acknowledgement size message")
+			}
+			c.peerWindowAck = binary.BigEndian.Uint32(msg.Body)
+
+		case MessageTypeUserControl:
+			if len(msg.Body) == 6 && binary.BigEndian.Uint16(msg.Body) == userControlPingRequest {
+				err = c.writeControl(MessageTypeUserControl,
+					append([]byte{0, userControlPingResponse}, msg.Body[2:]...))
+				if err != nil {
+					return nil, err
+				}
+			}
+
+		case MessageTypeAbort, MessageTypeAck, MessageTypeSetPeerBandwidth:
+
+		default:
+			return msg, nil
+		}
+	}
+}
+
+// WriteMessage writes a message. It can be called by multiple goroutines.
+func (c *Conn) WriteMessage(msg *Message) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	return c.writer.write(msg)
+}
+
+func (c *Conn) writeControl(typ uint8, body []byte) error {
+	return c.WriteMessage(&Message{
+		ChunkStreamID: chunkStreamControl,
+		Type:          typ,
+		Body:          body,
+	})
+}
+
+func (c *Conn) writeCommand(streamID uint32, vals ...interface{}) error {
+	body, err := amf0Marshal(vals)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteMessage(&Message{
+		ChunkStreamID: chunkStreamCommand,
+		Type:          MessageTypeCommandAMF0,
+		StreamID:      streamID,
+		Body:          body,
+	})
+}
+
+func (c *Conn) writeStatus(txID interface{}, code string, description string) error {
+	return c.writeCommand(c.streamID, "onStatus", txID, nil, Object{
+		{Key: "level", Value: "status"},
+		{Key: "code", Value: code},
+		{Key: "description", Value: description},
+	})
+}
+
+// readCommand reads the next command, skipping other messages.
+// the first value of the command is always its name.
+func (c *Conn) readCommand() ([]interface{}, error) {
+	for {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		body := msg.Body
+
+		switch msg.Type {
+		case MessageTypeCommandAMF3:
+			// AMF3 commands start with a marker and then contain AMF0 values
+			if len(body) < 1 {
+				return nil, fmt.Errorf("invalid AMF3 command")
+			}
+			body = body[1:]
+
+		case MessageTypeCommandAMF0:
+
+		default:
+			continue
+		}
+
+		vals, err := amf0Unmarshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(vals) < 2 {
+			return nil, fmt.Errorf("invalid command")
+		}
+
+		if _, ok := vals[0].(string); !ok {
+			return nil, fmt.Errorf("invalid command")
+		}
+
+		return vals, nil
+	}
+}
+
+func (c *Conn) readResult() ([]interface{}, error) {
+	for {
+		cmd, err := c.readCommand()
+		if err != nil {
+			return nil, err
+		}
+
+		switch cmd[0] {
+		case "_result":
+			return cmd, nil
+
+		case "_error":
+			return nil, fmt.Errorf("server returned an error: %v", cmd[len(cmd)-1])
+		}
+	}
+}
+
+func (c *Conn) readStatus(code string) error {
+	for {
+		cmd, err := c.readCommand()
+		if err != nil {
+			return err
+		}
+
+		if cmd[0] != "onStatus" || len(cmd) < 4 {
+			continue
+		}
+
+		info, ok := cmd[3].(Object)
+		if !ok {
+			return fmt.Errorf("invalid onStatus command")
+		}
+
+		level, _ := info.GetString("level")
+		recvCode, _ := info.GetString("code")
+
+		if level == "error" {
+			return fmt.Errorf("server returned an error: %s", recvCode)
+		}
+
+		if recvCode == code {
+			return nil
+		}
+	}
+}
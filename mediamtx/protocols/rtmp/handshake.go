@@ -0,0 +1,71 @@
+package rtmp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+const (
+	handshakeVersion = 3
+	handshakeSize    = 1536
+)
+
+// handshakeServer performs the plain handshake.
+// version of S1 is zero, therefore clients don't validate the digest of Flash Player handshake
+func handshakeServer(rw io.ReadWriter) error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	_, err := io.ReadFull(rw, c0c1)
+	if err != nil {
+		return err
+	}
+
+	if c0c1[0] != handshakeVersion {
+		return fmt.Errorf("unsupported handshake version: %d", c0c1[0])
+	}
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	s0s1s2[0] = handshakeVersion
+	_, err = rand.Read(s0s1s2[1+8 : 1+handshakeSize])
+	if err != nil {
+		return err
+	}
+	copy(s0s1s2[1+handshakeSize:], c0c1[1:])
+
+	_, err = rw.Write(s0s1s2)
+	if err != nil {
+		return err
+	}
+
+	c2 := make([]byte, handshakeSize)
+	_, err = io.ReadFull(rw, c2)
+	return err
+}
+
+func handshakeClient(rw io.ReadWriter) error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	c0c1[0] = handshakeVersion
+	_, err := rand.Read(c0c1[1+8:])
+	if err != nil {
+		return err
+	}
+
+	_, err = rw.Write(c0c1)
+	if err != nil {
+		return err
+	}
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	_, err = io.ReadFull(rw, s0s1s2)
+	if err != nil {
+		return err
+	}
+
+	if s0s1s2[0] != handshakeVersion {
+		return fmt.Errorf("unsupported handshake version: %d", s0s1s2[0])
+	}
+
+	// C2 is an echo of S1
+	_, err = rw.Write(s0s1s2[1 : 1+handshakeSize])
+	return err
+}
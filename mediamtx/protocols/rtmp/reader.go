@@ -0,0 +1,330 @@
+package rtmp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/mpeg4audio"
+)
+
+const (
+	// maximum number of messages read while tracks are being detected
+	analyzeMaxMessages = 256
+)
+
+// OnDataH264Func is the prototype of the callback passed to OnDataH264.
+type OnDataH264Func func(pts time.Duration, au [][]byte)
+
+// OnDataMPEG4AudioFunc is the prototype of the callback passed to OnDataMPEG4Audio.
+type OnDataMPEG4AudioFunc func(pts time.Duration, au []byte)
+
+func timestampToDuration(v uint32) time.Duration {
+	return time.Duration(v) * time.Millisecond
+}
+
+// Reader reads H264 and MPEG-4 audio tracks from a publishing connection.
+type Reader struct {
+	conn       *Conn
+	videoTrack *format.H264
+	audioTrack *format.MPEG4Audio
+	onVideo    OnDataH264Func
+	onAudio    OnDataMPEG4AudioFunc
+
+	// messages read during detection of tracks
+	pending []*Message
+	// parameters to prepend to next access unit, after a sequence header is received again
+	params [][]byte
+}
+
+// NewReader allocates a Reader and detects tracks.
+// Tracks listed in metadata are waited for, otherwise tracks are the ones which sequence header
+// is received before the first frame.
+func NewReader(conn *Conn) (*Reader, error) {
+	r := &Reader{
+		conn:    conn,
+		onVideo: func(time.Duration, [][]byte) {},
+		onAudio: func(time.Duration, []byte) {},
+	}
+
+	err := r.detectTracks()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Reader) detectTracks() error {
+	hasMetadata := false
+	wantVideo := false
+	wantAudio := false
+
+	for i := 0; i < analyzeMaxMessages; i++ {
+		msg, err := r.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		switch msg.Type {
+		case MessageTypeDataAMF0:
+			vals, err := amf0Unmarshal(msg.Body)
+			if err != nil {
+				return err
+			}
+
+			metadata, ok := metadataOf(vals)
+			if !ok {
+				continue
+			}
+
+			hasMetadata = true
+			wantVideo, err = metadataCodec(metadata, "videocodecid", codecH264, "avc1")
+			if err != nil {
+				return err
+			}
+			wantAudio, err = metadataCodec(metadata, "audiocodecid", codecAAC, "mp4a")
+			if err != nil {
+				return err
+			}
+
+		case MessageTypeVideo:
+			if len(msg.Body) < 5 {
+				continue
+			}
+
+			if msg.Body[0]&0x0F != codecH264 {
+				return fmt.Errorf("unsupported video codec: %d", msg.Body[0]&0x0F)
+			}
+
+			if msg.Body[1] != packetTypeSequenceHeader {
+				r.pending = append(r.pending, msg)
+				if !hasMetadata {
+					return r.checkTracks()
+				}
+				continue
+			}
+
+			sps, pps, err := unmarshalAVCDecoderConfigurationRecord(msg.Body[5:])
+			if err != nil {
+				return err
+			}
+
+			r.videoTrack = &format.H264{
+				PayloadTyp:        96,
+				SPS:               sps,
+				PPS:               pps,
+				PacketizationMode: 1,
+			}
+
+		case MessageTypeAudio:
+			if len(msg.Body) < 2 {
+				continue
+			}
+
+			if msg.Body[0]>>4 != codecAAC {
+				return fmt.Errorf("unsupported audio codec: %d", msg.Body[0]>>4)
+			}
+
+			if msg.Body[1] != packetTypeSequenceHeader {
+				r.pending = append(r.pending, msg)
+				if !hasMetadata {
+					return r.checkTracks()
+				}
+				continue
+			}
+
+			var config mpeg4audio.Config
+			err := config.Unmarshal(msg.Body[2:])
+			if err != nil {
+				return err
+			}
+
+			r.audioTrack = &format.MPEG4Audio{
+				PayloadTyp:       96,
+				Config:           &config,
+				SizeLength:       13,
+				IndexLength:      3,
+				IndexDeltaLength: 3,
+			}
+		}
+
+		if hasMetadata && (!wantVideo || r.videoTrack != nil) && (!wantAudio || r.audioTrack != nil) {
+			return r.checkTracks()
+		}
+	}
+
+	return fmt.Errorf("unable to detect tracks after %d messages", analyzeMaxMessages)
+}
+
+func (r *Reader) checkTracks() error {
+	if r.videoTrack == nil && r.audioTrack == nil {
+		return fmt.Errorf("no supported tracks found")
+	}
+	return nil
+}
+
+// metadataOf returns metadata of onMetaData or @setDataFrame data messages
+func metadataOf(vals []interface{}) (ECMAArray, bool) {
+	if len(vals) >= 1 && vals[0] == "@setDataFrame" {
+		vals = vals[1:]
+	}
+
+	if len(vals) < 2 || vals[0] != "onMetaData" {
+		return nil, false
+	}
+
+	switch metadata := vals[1].(type) {
+	case ECMAArray:
+		return metadata, true
+	case Object:
+		return ECMAArray(metadata), true
+	}
+
+	return nil, false
+}
+
+// metadataCodec returns whether metadata contains a codec, which must be the supported one
+func metadataCodec(metadata ECMAArray, key string, id float64, fourCC string) (bool, error) {
+	v, ok := Object(metadata).Get(key)
+	if !ok {
+		return false, nil
+	}
+
+	switch v := v.(type) {
+	case float64:
+		if v == 0 {
+			return false, nil
+		}
+		if v == id {
+			return true, nil
+		}
+
+	case string:
+		if v == fourCC {
+			return true, nil
+		}
+	}
+
+	return false, fmt.Errorf("unsupported codec: %s %v", key, v)
+}
+
+func unmarshalAVCDecoderConfigurationRecord(buf []byte) ([]byte, []byte, error) {
+	// version, profile, compatibility, level, length size, SPS count, SPS length
+	if len(buf) < 8 {
+		return nil, nil, fmt.Errorf("invalid AVCDecoderConfigurationRecord")
+	}
+
+	if buf[5]&0x1F < 1 {
+		return nil, nil, fmt.Errorf("SPS not found")
+	}
+
+	pos := 6
+	le := int(buf[pos])<<8 | int(buf[pos+1])
+	pos += 2
+	if len(buf) < pos+le+3 {
+		return nil, nil, fmt.Errorf("invalid AVCDecoderConfigurationRecord")
+	}
+	sps := buf[pos : pos+le]
+	pos += le
+
+	// only the first SPS is used
+	for i := 1; i < int(buf[5]&0x1F); i++ {
+		le = int(buf[pos])<<8 | int(buf[pos+1])
+		pos += 2 + le
+		if len(buf) < pos+3 {
+			return nil, nil, fmt.Errorf("invalid AVCDecoderConfigurationRecord")
+		}
+	}
+
+	if buf[pos] < 1 {
+		return nil, nil, fmt.Errorf("PPS not found")
+	}
+	pos++
+
+	le = int(buf[pos])<<8 | int(buf[pos+1])
+	pos += 2
+	if len(buf) < pos+le {
+		return nil, nil, fmt.Errorf("invalid AVCDecoderConfigurationRecord")
+	}
+	pps := buf[pos : pos+le]
+
+	return sps, pps, nil
+}
+
+// Tracks returns detected tracks, one of them could be nil.
+func (r *Reader) Tracks() (*format.H264, *format.MPEG4Audio) {
+	return r.videoTrack, r.audioTrack
+}
+
+// OnDataH264 sets a callback that is called when H264 data is received.
+func (r *Reader) OnDataH264(cb OnDataH264Func) {
+	r.onVideo = cb
+}
+
+// OnDataMPEG4Audio sets a callback that is called when MPEG-4 audio data is received.
+func (r *Reader) OnDataMPEG4Audio(cb OnDataMPEG4AudioFunc) {
+	r.onAudio = cb
+}
+
+// Read reads a message and calls callbacks.
+func (r *Reader) Read() error {
+	var msg *Message
+
+	if len(r.pending) != 0 {
+		msg = r.pending[0]
+		r.pending = r.pending[1:]
+	} else {
+		var err error
+		msg, err = r.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+	}
+
+	switch msg.Type {
+	case MessageTypeVideo:
+		if r.videoTrack == nil || len(msg.Body) < 5 || msg.Body[0]&0x0F != codecH264 {
+			return nil
+		}
+
+		if msg.Body[1] == packetTypeSequenceHeader {
+			sps, pps, err := unmarshalAVCDecoderConfigurationRecord(msg.Body[5:])
+			if err != nil {
+				return err
+			}
+			r.params = [][]byte{sps, pps}
+			return nil
+		}
+
+		if msg.Body[1] != packetTypeData {
+			return nil
+		}
+
+		// composition time is a signed 24 bit integer
+		cts := int32(uint32(msg.Body[2])<<16|uint32(msg.Body[3])<<8|uint32(msg.Body[4])) << 8 >> 8
+
+		au, err := h264.AVCCUnmarshal(msg.Body[5:])
+		if err != nil {
+			return err
+		}
+
+		if r.params != nil {
+			au = append(r.params, au...)
+			r.params = nil
+		}
+
+		r.onVideo(timestampToDuration(msg.Timestamp)+time.Duration(cts)*time.Millisecond, au)
+
+	case MessageTypeAudio:
+		if r.audioTrack == nil || len(msg.Body) < 2 || msg.Body[0]>>4 != codecAAC ||
+			msg.Body[1] != packetTypeData {
+			return nil
+		}
+
+		r.onAudio(timestampToDuration(msg.Timestamp), msg.Body[2:])
+	}
+
+	return nil
+}
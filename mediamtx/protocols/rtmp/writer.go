@@ -0,0 +1,215 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+)
+
+// FLV codec IDs
+const (
+	codecH264 = 7
+	codecAAC  = 10
+)
+
+// FLV packet types of H264 and AAC
+const (
+	packetTypeSequenceHeader = 0
+	packetTypeData           = 1
+)
+
+// AAC, 44 kHz, 16 bit, stereo. sound rate, size and type are ignored by decoders when codec is AAC
+const audioHeaderAAC = codecAAC<<4 | 3<<2 | 1<<1 | 1
+
+func durationToTimestamp(v time.Duration) uint32 {
+	return uint32(v / time.Millisecond)
+}
+
+// Writer writes H264 and MPEG-4 audio tracks to a connection.
+type Writer struct {
+	conn       *Conn
+	videoTrack *format.H264
+	audioTrack *format.MPEG4Audio
+
+	sps []byte
+	pps []byte
+}
+
+// NewWriter allocates a Writer. Metadata and the audio sequence header are written immediately,
+// the video sequence header is written as soon as parameters are known.
+func NewWriter(conn *Conn, videoTrack *format.H264, audioTrack *format.MPEG4Audio) (*Writer, error) {
+	w := &Writer{
+		conn:       conn,
+		videoTrack: videoTrack,
+		audioTrack: audioTrack,
+	}
+
+	err := w.writeMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	if videoTrack != nil {
+		sps, pps := videoTrack.SafeParams()
+		if sps != nil && pps != nil {
+			err = w.writeVideoSequenceHeader(sps, pps)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if audioTrack != nil {
+		config, err := audioTrack.GetConfig().Marshal()
+		if err != nil {
+			return nil, err
+		}
+
+		err = w.conn.WriteMessage(&Message{
+			ChunkStreamID: chunkStreamAudio,
+			Type:          MessageTypeAudio,
+			StreamID:      w.conn.streamID,
+			Body:          append([]byte{audioHeaderAAC, packetTypeSequenceHeader}, config...),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+func (w *Writer) writeMetadata() error {
+	metadata := ECMAArray{}
+
+	if w.videoTrack != nil {
+		metadata = append(metadata,
+			ObjectEntry{Key: "videodatarate", Value: float64(0)},
+			ObjectEntry{Key: "videocodecid", Value: float64(codecH264)})
+	}
+
+	if w.audioTrack != nil {
+		metadata = append(metadata,
+			ObjectEntry{Key: "audiodatarate", Value: float64(0)},
+			ObjectEntry{Key: "audiocodecid", Value: float64(codecAAC)})
+	}
+
+	body, err := amf0Marshal([]interface{}{"onMetaData", metadata})
+	if err != nil {
+		return err
+	}
+
+	return w.conn.WriteMessage(&Message{
+		ChunkStreamID: chunkStreamData,
+		Type:          MessageTypeDataAMF0,
+		StreamID:      w.conn.streamID,
+		Body:          body,
+	})
+}
+
+// writeVideoSequenceHeader writes an AVCDecoderConfigurationRecord
+func (w *Writer) writeVideoSequenceHeader(sps []byte, pps []byte) error {
+	if len(sps) < 4 {
+		return fmt.Errorf("invalid SPS")
+	}
+
+	body := []byte{
+		1<<4 | codecH264, packetTypeSequenceHeader, 0, 0, 0,
+		1, sps[1], sps[2], sps[3],
+		0xFC | 3, // NALU length size is 4
+		0xE0 | 1, // one SPS
+	}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(sps)))
+	body = append(body, sps...)
+	body = append(body, 1) // one PPS
+	body = binary.BigEndian.AppendUint16(body, uint16(len(pps)))
+	body = append(body, pps...)
+
+	err := w.conn.WriteMessage(&Message{
+		ChunkStreamID: chunkStreamVideo,
+		Type:          MessageTypeVideo,
+		StreamID:      w.conn.streamID,
+		Body:          body,
+	})
+	if err != nil {
+		return err
+	}
+
+	w.sps = sps
+	w.pps = pps
+
+	return nil
+}
+
+// WriteH264 writes an H264 access unit. Timestamps must not be negative.
+// Access units are skipped until parameters are known.
+func (w *Writer) WriteH264(pts time.Duration, dts time.Duration, idr bool, au [][]byte) error {
+	var sps, pps []byte
+	for _, nalu := range au {
+		if len(nalu) == 0 {
+			continue
+		}
+
+		switch h264.NALUType(nalu[0] & 0x1F) {
+		case h264.NALUTypeSPS:
+			sps = nalu
+		case h264.NALUTypePPS:
+			pps = nalu
+		}
+	}
+
+	// parameters are sent within the sequence header, which is written again when they change
+	if sps != nil && pps != nil && (!bytes.Equal(sps, w.sps) || !bytes.Equal(pps, w.pps)) {
+		err := w.writeVideoSequenceHeader(sps, pps)
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.sps == nil {
+		return nil
+	}
+
+	avcc, err := h264.AVCCMarshal(au)
+	if err != nil {
+		return err
+	}
+
+	frameType := byte(2)
+	if idr {
+		frameType = 1
+	}
+
+	cts := durationToTimestamp(pts - dts)
+
+	body := make([]byte, 5+len(avcc))
+	body[0] = frameType<<4 | codecH264
+	body[1] = packetTypeData
+	body[2] = byte(cts >> 16)
+	body[3] = byte(cts >> 8)
+	body[4] = byte(cts)
+	copy(body[5:], avcc)
+
+	return w.conn.WriteMessage(&Message{
+		ChunkStreamID: chunkStreamVideo,
+		Type:          MessageTypeVideo,
+		Timestamp:     durationToTimestamp(dts),
+		StreamID:      w.conn.streamID,
+		Body:          body,
+	})
+}
+
+// WriteMPEG4Audio writes a MPEG-4 audio access unit. Timestamp must not be negative.
+func (w *Writer) WriteMPEG4Audio(pts time.Duration, au []byte) error {
+	return w.conn.WriteMessage(&Message{
+		ChunkStreamID: chunkStreamAudio,
+		Type:          MessageTypeAudio,
+		Timestamp:     durationToTimestamp(pts),
+		StreamID:      w.conn.streamID,
+		Body:          append([]byte{audioHeaderAAC, packetTypeData}, au...),
+	})
+}
@@ -0,0 +1,141 @@
+// Package record contains the recording system.
+package record
+
+import (
+	"context"
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/asyncwriter"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"time"
+)
+
+const (
+	restartPause = 2 * time.Second
+)
+
+var errNoSupportedCodecs = errors.New(
+	"the stream doesn't contain any supported codec, which are currently H265, H264, Opus, MPEG-4 Audio")
+
+func durationGoToMp4(v time.Duration, timeScale uint32) uint64 {
+	if v < 0 {
+		return 0
+	}
+
+	timeScale64 := uint64(timeScale)
+	secs := v / time.Second
+	dec := v % time.Second
+	return uint64(secs)*timeScale64 + uint64(dec)*timeScale64/uint64(time.Second)
+}
+
+// Extension returns file extension of segments written in format.
+func Extension(format conf.RecordFormat) string {
+	if format == conf.RecordFormatMPEGTS {
+		return ".ts"
+	}
+	return ".mp4"
+}
+
+type recordFormat interface {
+	initialize(writer *asyncwriter.Writer) error
+	close()
+}
+
+// Agent writes a stream into segment files until closed.
+type Agent struct {
+	WriteQueueSize  int
+	PathFormat      string
+	Format          conf.RecordFormat
+	PartDuration    time.Duration
+	SegmentDuration time.Duration
+	PathName        string
+	Stream          *stream.Stream
+	Parent          logger.Writer
+
+	template  string
+	ctx       context.Context
+	ctxCancel func()
+	done      chan struct{}
+}
+
+// Initialize initializes Agent.
+func (a *Agent) Initialize() {
+	a.template = PathTemplate(a.PathFormat, a.PathName, Extension(a.Format))
+	a.ctx, a.ctxCancel = context.WithCancel(context.Background())
+	a.done = make(chan struct{})
+
+	go a.run()
+}
+
+// Close closes the Agent, current segment is finalized.
+func (a *Agent) Close() {
+	a.Log(logger.Info, "recording stopped")
+
+	a.ctxCancel()
+	<-a.done
+}
+
+// Log implements logger.Writer.
+func (a *Agent) Log(level logger.Level, format string, args ...interface{}) {
+	a.Parent.Log(level, "[record] "+format, args...)
+}
+
+func (a *Agent) run() {
+	defer close(a.done)
+
+	for {
+		err := a.runInstance()
+		if err == nil {
+			return
+		}
+
+		if errors.Is(err, errNoSupportedCodecs) {
+			a.Log(logger.Warn, "recording is not possible: %v", err)
+			return
+		}
+
+		a.Log(logger.Error, "%v", err)
+
+		select {
+		case <-time.After(restartPause):
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
+// runInstance records until an error occurs, returns nil when agent is closed
+func (a *Agent) runInstance() error {
+	writer := asyncwriter.New(a.WriteQueueSize, a)
+
+	var f recordFormat
+	if a.Format == conf.RecordFormatMPEGTS {
+		f = &formatMPEGTS{a: a}
+	} else {
+		f = &formatFMP4{a: a}
+	}
+
+	err := f.initialize(writer)
+	if err != nil {
+		a.Stream.RemoveReader(writer)
+		return err
+	}
+
+	a.Log(logger.Info, "recording to %s", a.template)
+
+	writer.Start()
+
+	select {
+	case err = <-writer.Error():
+		a.Stream.RemoveReader(writer)
+		f.close()
+		return err
+
+	case <-a.ctx.Done():
+		a.Stream.RemoveReader(writer)
+		writer.Stop()
+		f.close()
+		return nil
+	}
+}
@@ -0,0 +1,100 @@
+package record
+
+import (
+	"bytes"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"fearpro13/h265_transcoder/mediamtx/unit"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+)
+
+func TestAgentSegments(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		format conf.RecordFormat
+	}{
+		{"fmp4", conf.RecordFormatFMP4},
+		{"mpegts", conf.RecordFormatMPEGTS},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			strm, err := stream.New(1472, &description.Session{Medias: []*description.Media{test.MediaH264}}, true, test.NilLogger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer strm.Close()
+
+			a := &Agent{
+				WriteQueueSize:  512,
+				PathFormat:      filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f"),
+				Format:          ca.format,
+				PartDuration:    100 * time.Millisecond,
+				SegmentDuration: time.Second,
+				PathName:        "cam1/720p",
+				Stream:          strm,
+				Parent:          test.NilLogger,
+			}
+			a.Initialize()
+
+			// every frame is a key frame, so segments are rotated exactly after segment duration
+			start := time.Date(2024, 3, 5, 7, 8, 9, 0, time.Local)
+			idr := append([]byte{0x65, 0x88, 0x84}, bytes.Repeat([]byte{0x01}, 100)...)
+
+			for i := 0; i < 100; i++ {
+				pts := time.Duration(i) * 100 * time.Millisecond
+
+				strm.WriteUnit(test.MediaH264, test.FormatH264, &unit.H264{
+					Base: unit.Base{
+						NTP: start.Add(pts),
+						PTS: pts,
+					},
+					AU: [][]byte{test.FormatH264.SPS, test.FormatH264.PPS, idr},
+				})
+
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			a.Close()
+
+			entries, err := os.ReadDir(filepath.Join(dir, "cam1", "720p"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			template := PathTemplate(a.PathFormat, a.PathName, Extension(ca.format))
+
+			var starts []time.Time
+			for _, e := range entries {
+				var p Path
+				if !p.Decode(template, filepath.Join(dir, "cam1", "720p", e.Name())) {
+					t.Fatalf("unexpected segment %s", e.Name())
+				}
+				starts = append(starts, p.Start)
+			}
+
+			sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+			if len(starts) < 3 {
+				t.Fatalf("segments are not rotated: %v", starts)
+			}
+
+			for i := 1; i < len(starts); i++ {
+				if d := starts[i].Sub(starts[i-1]); d != time.Second {
+					t.Fatalf("unexpected segment duration %s", d)
+				}
+			}
+
+			if starts[len(starts)-1].After(start.Add(9900 * time.Millisecond)) {
+				t.Fatalf("unexpected last segment %s", starts[len(starts)-1])
+			}
+		})
+	}
+}
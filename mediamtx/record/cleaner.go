@@ -0,0 +1,106 @@
+package record
+
+import (
+	"context"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	cleanerInterval = 30 * time.Second
+)
+
+// Cleaner removes recording segments older than DeleteAfter.
+// Segments of every path are matched, including paths that do not exist anymore.
+type Cleaner struct {
+	PathFormat  string
+	Format      conf.RecordFormat
+	DeleteAfter time.Duration
+	Parent      logger.Writer
+
+	ctx       context.Context
+	ctxCancel func()
+	done      chan struct{}
+}
+
+// Initialize initializes Cleaner.
+func (c *Cleaner) Initialize() {
+	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
+	c.done = make(chan struct{})
+
+	go c.run()
+}
+
+// Close closes the Cleaner.
+func (c *Cleaner) Close() {
+	c.ctxCancel()
+	<-c.done
+}
+
+// Log implements logger.Writer.
+func (c *Cleaner) Log(level logger.Level, format string, args ...interface{}) {
+	c.Parent.Log(level, "[record cleaner] "+format, args...)
+}
+
+func (c *Cleaner) run() {
+	defer close(c.done)
+
+	c.doRun()
+
+	ticker := time.NewTicker(cleanerInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.doRun()
+
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Cleaner) doRun() {
+	template := filepath.Clean(c.PathFormat + Extension(c.Format))
+	commonPath := CommonPath(template)
+	now := time.Now()
+
+	var removed []string
+
+	filepath.WalkDir(commonPath, func(fpath string, entry fs.DirEntry, err error) error { //nolint:errcheck
+		if err != nil {
+			return nil
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		var pa Path
+		if pa.Decode(template, fpath) && now.Sub(pa.Start) > c.DeleteAfter {
+			c.Log(logger.Debug, "removing %s", fpath)
+			err = os.Remove(fpath)
+			if err != nil {
+				c.Log(logger.Warn, "%v", err)
+			} else {
+				removed = append(removed, fpath)
+			}
+		}
+
+		return nil
+	})
+
+	// remove directories left empty, only non-empty ones fail to be removed
+	for _, fpath := range removed {
+		for dir := filepath.Dir(fpath); dir != commonPath && dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+}
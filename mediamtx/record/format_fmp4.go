@@ -0,0 +1,419 @@
+package record
+
+import (
+	"fearpro13/h265_transcoder/mediamtx/asyncwriter"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/unit"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/pkg/codecs/opus"
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4/seekablebuffer"
+)
+
+type sample struct {
+	*fmp4.PartSample
+	dts time.Duration
+	ntp time.Time
+}
+
+type formatFMP4Track struct {
+	f          *formatFMP4
+	initTrack  *fmp4.InitTrack
+	codec      func() fmp4.Codec
+	nextSample *sample
+}
+
+// write holds sample until the next one arrives, since sample duration is the difference of their DTS
+func (t *formatFMP4Track) write(s *sample) error {
+	prev := t.nextSample
+	t.nextSample = s
+	if prev == nil {
+		return nil
+	}
+
+	if s.dts < prev.dts {
+		prev.Duration = 0
+	} else {
+		prev.Duration = uint32(durationGoToMp4(s.dts-prev.dts, t.initTrack.TimeScale))
+	}
+
+	if t.f.currentSegment == nil {
+		t.f.currentSegment = &formatFMP4Segment{
+			f:        t.f,
+			startDTS: prev.dts,
+			startNTP: prev.ntp,
+		}
+	}
+
+	err := t.f.currentSegment.write(t, prev)
+	if err != nil {
+		return err
+	}
+
+	// segments start with a video key frame, or with any sample when there is no video
+	if (!t.f.hasVideo || t.initTrack.Codec.IsVideo()) &&
+		!s.IsNonSyncSample &&
+		(s.dts-t.f.currentSegment.startDTS) >= t.f.a.SegmentDuration {
+		err = t.f.currentSegment.close()
+		t.f.currentSegment = &formatFMP4Segment{
+			f:        t.f,
+			startDTS: s.dts,
+			startNTP: s.ntp,
+		}
+		return err
+	}
+
+	return nil
+}
+
+type formatFMP4Part struct {
+	startDTS   time.Duration
+	partTracks map[*formatFMP4Track]*fmp4.PartTrack
+	order      []*fmp4.PartTrack
+}
+
+type formatFMP4Segment struct {
+	f        *formatFMP4
+	startDTS time.Duration
+	startNTP time.Time
+
+	fi          *os.File
+	currentPart *formatFMP4Part
+}
+
+func (s *formatFMP4Segment) write(track *formatFMP4Track, smp *sample) error {
+	if s.currentPart == nil {
+		s.currentPart = &formatFMP4Part{
+			startDTS:   smp.dts,
+			partTracks: make(map[*formatFMP4Track]*fmp4.PartTrack),
+		}
+	} else if (smp.dts - s.currentPart.startDTS) >= s.f.a.PartDuration {
+		err := s.flushPart()
+		if err != nil {
+			return err
+		}
+
+		s.currentPart = &formatFMP4Part{
+			startDTS:   smp.dts,
+			partTracks: make(map[*formatFMP4Track]*fmp4.PartTrack),
+		}
+	}
+
+	pt, ok := s.currentPart.partTracks[track]
+	if !ok {
+		pt = &fmp4.PartTrack{
+			ID:       track.initTrack.ID,
+			BaseTime: durationGoToMp4(smp.dts-s.startDTS, track.initTrack.TimeScale),
+		}
+		s.currentPart.partTracks[track] = pt
+		s.currentPart.order = append(s.currentPart.order, pt)
+	}
+
+	pt.Samples = append(pt.Samples, smp.PartSample)
+
+	return nil
+}
+
+// open creates segment file and writes initialization header,
+// codec parameters are read now since they could be received in-band after recording starts
+func (s *formatFMP4Segment) open() error {
+	path := Path{Start: s.startNTP}.Encode(s.f.a.template)
+
+	s.f.a.Log(logger.Debug, "creating segment %s", path)
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	init := fmp4.Init{}
+	for _, track := range s.f.tracks {
+		track.initTrack.Codec = track.codec()
+		init.Tracks = append(init.Tracks, track.initTrack)
+	}
+
+	var buf seekablebuffer.Buffer
+	err = init.Marshal(&buf)
+	if err == nil {
+		_, err = fi.Write(buf.Bytes())
+	}
+	if err != nil {
+		fi.Close()
+		os.Remove(path) //nolint:errcheck
+		return err
+	}
+
+	s.fi = fi
+
+	return nil
+}
+
+func (s *formatFMP4Segment) flushPart() error {
+	if s.currentPart == nil {
+		return nil
+	}
+
+	if s.fi == nil {
+		err := s.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	part := fmp4.Part{
+		SequenceNumber: s.f.nextSequenceNumber,
+		Tracks:         s.currentPart.order,
+	}
+	s.f.nextSequenceNumber++
+	s.currentPart = nil
+
+	var buf seekablebuffer.Buffer
+	err := part.Marshal(&buf)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.fi.Write(buf.Bytes())
+	return err
+}
+
+func (s *formatFMP4Segment) close() error {
+	err := s.flushPart()
+
+	if s.fi != nil {
+		err2 := s.fi.Close()
+		if err == nil {
+			err = err2
+		}
+	}
+
+	return err
+}
+
+type formatFMP4 struct {
+	a *Agent
+
+	tracks             []*formatFMP4Track
+	hasVideo           bool
+	videoStarted       bool
+	currentSegment     *formatFMP4Segment
+	nextSequenceNumber uint32
+}
+
+func (f *formatFMP4) addTrack(timeScale uint32, codec func() fmp4.Codec) *formatFMP4Track {
+	track := &formatFMP4Track{
+		f: f,
+		initTrack: &fmp4.InitTrack{
+			ID:        len(f.tracks) + 1,
+			TimeScale: timeScale,
+			Codec:     codec(),
+		},
+		codec: codec,
+	}
+	f.tracks = append(f.tracks, track)
+
+	return track
+}
+
+func (f *formatFMP4) initialize(writer *asyncwriter.Writer) error {
+	f.nextSequenceNumber = 1
+
+	f.setupVideo(writer)
+	f.setupAudio(writer)
+
+	if len(f.tracks) == 0 {
+		return errNoSupportedCodecs
+	}
+
+	return nil
+}
+
+func (f *formatFMP4) setupVideo(writer *asyncwriter.Writer) {
+	strm := f.a.Stream
+
+	var videoFormatH265 *format.H265
+	videoMedia := strm.Desc().FindFormat(&videoFormatH265)
+
+	if videoFormatH265 != nil {
+		f.hasVideo = true
+		track := f.addTrack(90000, func() fmp4.Codec {
+			vps, sps, pps := videoFormatH265.SafeParams()
+			return &fmp4.CodecH265{VPS: vps, SPS: sps, PPS: pps}
+		})
+
+		var dtsExtractor *h265.DTSExtractor
+
+		strm.AddReader(writer, videoMedia, videoFormatH265, func(u unit.Unit) error {
+			tunit := u.(*unit.H265)
+			if tunit.AU == nil {
+				return nil
+			}
+
+			randomAccess := h265.IsRandomAccess(tunit.AU)
+
+			if dtsExtractor == nil {
+				if !randomAccess {
+					return nil
+				}
+				dtsExtractor = h265.NewDTSExtractor()
+				f.videoStarted = true
+			}
+
+			dts, err := dtsExtractor.Extract(tunit.AU, tunit.PTS)
+			if err != nil {
+				return err
+			}
+
+			return f.writeH26x(track, tunit.PTS, dts, tunit.NTP, randomAccess, tunit.AU)
+		})
+
+		return
+	}
+
+	var videoFormatH264 *format.H264
+	videoMedia = strm.Desc().FindFormat(&videoFormatH264)
+
+	if videoFormatH264 != nil {
+		f.hasVideo = true
+		track := f.addTrack(90000, func() fmp4.Codec {
+			sps, pps := videoFormatH264.SafeParams()
+			return &fmp4.CodecH264{SPS: sps, PPS: pps}
+		})
+
+		var dtsExtractor *h264.DTSExtractor
+
+		strm.AddReader(writer, videoMedia, videoFormatH264, func(u unit.Unit) error {
+			tunit := u.(*unit.H264)
+			if tunit.AU == nil {
+				return nil
+			}
+
+			randomAccess := h264.IDRPresent(tunit.AU)
+
+			if dtsExtractor == nil {
+				if !randomAccess {
+					return nil
+				}
+				dtsExtractor = h264.NewDTSExtractor()
+				f.videoStarted = true
+			}
+
+			dts, err := dtsExtractor.Extract(tunit.AU, tunit.PTS)
+			if err != nil {
+				return err
+			}
+
+			return f.writeH26x(track, tunit.PTS, dts, tunit.NTP, randomAccess, tunit.AU)
+		})
+	}
+}
+
+func (f *formatFMP4) writeH26x(
+	track *formatFMP4Track,
+	pts time.Duration,
+	dts time.Duration,
+	ntp time.Time,
+	randomAccess bool,
+	au [][]byte,
+) error {
+	ps, err := fmp4.NewPartSampleH26x(int32(durationGoToMp4(pts-dts, 90000)), randomAccess, au)
+	if err != nil {
+		return err
+	}
+
+	return track.write(&sample{PartSample: ps, dts: dts, ntp: ntp})
+}
+
+func (f *formatFMP4) setupAudio(writer *asyncwriter.Writer) {
+	strm := f.a.Stream
+
+	var audioFormatMPEG4Audio *format.MPEG4Audio
+	audioMedia := strm.Desc().FindFormat(&audioFormatMPEG4Audio)
+
+	if audioFormatMPEG4Audio != nil && audioFormatMPEG4Audio.GetConfig() != nil {
+		config := *audioFormatMPEG4Audio.GetConfig()
+		sampleRate := time.Duration(audioFormatMPEG4Audio.ClockRate())
+
+		track := f.addTrack(uint32(audioFormatMPEG4Audio.ClockRate()), func() fmp4.Codec {
+			return &fmp4.CodecMPEG4Audio{Config: config}
+		})
+
+		strm.AddReader(writer, audioMedia, audioFormatMPEG4Audio, func(u unit.Unit) error {
+			tunit := u.(*unit.MPEG4Audio)
+			if tunit.AUs == nil || (f.hasVideo && !f.videoStarted) {
+				return nil
+			}
+
+			for i, au := range tunit.AUs {
+				dts := tunit.PTS + time.Duration(i)*mpeg4audio.SamplesPerAccessUnit*time.Second/sampleRate
+
+				err := track.write(&sample{
+					PartSample: &fmp4.PartSample{Payload: au},
+					dts:        dts,
+					ntp:        tunit.NTP.Add(dts - tunit.PTS),
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		return
+	}
+
+	var audioFormatOpus *format.Opus
+	audioMedia = strm.Desc().FindFormat(&audioFormatOpus)
+
+	if audioFormatOpus != nil {
+		track := f.addTrack(48000, func() fmp4.Codec {
+			return &fmp4.CodecOpus{ChannelCount: audioFormatOpus.ChannelCount}
+		})
+
+		strm.AddReader(writer, audioMedia, audioFormatOpus, func(u unit.Unit) error {
+			tunit := u.(*unit.Opus)
+			if tunit.Packets == nil || (f.hasVideo && !f.videoStarted) {
+				return nil
+			}
+
+			dts := tunit.PTS
+			for _, pkt := range tunit.Packets {
+				err := track.write(&sample{
+					PartSample: &fmp4.PartSample{Payload: pkt},
+					dts:        dts,
+					ntp:        tunit.NTP.Add(dts - tunit.PTS),
+				})
+				if err != nil {
+					return err
+				}
+
+				dts += opus.PacketDuration(pkt)
+			}
+
+			return nil
+		})
+	}
+}
+
+func (f *formatFMP4) close() {
+	if f.currentSegment != nil {
+		err := f.currentSegment.close()
+		if err != nil {
+			f.a.Log(logger.Error, "%v", err)
+		}
+		f.currentSegment = nil
+	}
+}
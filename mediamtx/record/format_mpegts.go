@@ -0,0 +1,302 @@
+package record
+
+import (
+	"bufio"
+	"fearpro13/h265_transcoder/mediamtx/asyncwriter"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/unit"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+)
+
+const (
+	mpegtsMaxBufferSize = 64 * 1024
+)
+
+func durationGoToMPEGTS(v time.Duration) int64 {
+	return int64(durationGoToMp4(v, 90000))
+}
+
+// dynamicWriter redirects MPEG-TS writer output into current segment file
+type dynamicWriter struct {
+	w io.Writer
+}
+
+func (d *dynamicWriter) Write(p []byte) (int, error) {
+	return d.w.Write(p)
+}
+
+type formatMPEGTSSegment struct {
+	f         *formatMPEGTS
+	startDTS  time.Duration
+	startNTP  time.Time
+	lastFlush time.Duration
+
+	fi *os.File
+}
+
+// Write creates segment file on first write.
+func (s *formatMPEGTSSegment) Write(p []byte) (int, error) {
+	if s.fi == nil {
+		path := Path{Start: s.startNTP}.Encode(s.f.a.template)
+
+		s.f.a.Log(logger.Debug, "creating segment %s", path)
+
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			return 0, err
+		}
+
+		fi, err := os.Create(path)
+		if err != nil {
+			return 0, err
+		}
+
+		s.fi = fi
+	}
+
+	return s.fi.Write(p)
+}
+
+func (s *formatMPEGTSSegment) close() error {
+	err := s.f.bw.Flush()
+
+	if s.fi != nil {
+		err2 := s.fi.Close()
+		if err == nil {
+			err = err2
+		}
+	}
+
+	return err
+}
+
+type formatMPEGTS struct {
+	a *Agent
+
+	dw             *dynamicWriter
+	bw             *bufio.Writer
+	mw             *mpegts.Writer
+	hasVideo       bool
+	videoStarted   bool
+	currentSegment *formatMPEGTSSegment
+}
+
+func (f *formatMPEGTS) initialize(writer *asyncwriter.Writer) error {
+	var tracks []*mpegts.Track
+
+	videoTrack := f.setupVideo(writer)
+	if videoTrack != nil {
+		tracks = append(tracks, videoTrack)
+	}
+
+	audioTrack := f.setupAudio(writer)
+	if audioTrack != nil {
+		tracks = append(tracks, audioTrack)
+	}
+
+	if len(tracks) == 0 {
+		return errNoSupportedCodecs
+	}
+
+	f.dw = &dynamicWriter{}
+	f.bw = bufio.NewWriterSize(f.dw, mpegtsMaxBufferSize)
+	f.mw = mpegts.NewWriter(f.bw, tracks)
+
+	return nil
+}
+
+// updateSegment switches segment on key frame after segment duration, buffered data is flushed every part duration
+func (f *formatMPEGTS) updateSegment(dts time.Duration, ntp time.Time, isVideo bool, randomAccess bool) error {
+	switch {
+	case f.currentSegment == nil:
+		f.currentSegment = &formatMPEGTSSegment{
+			f:         f,
+			startDTS:  dts,
+			startNTP:  ntp,
+			lastFlush: dts,
+		}
+		f.dw.w = f.currentSegment
+
+	case (!f.hasVideo || isVideo) && randomAccess && (dts-f.currentSegment.startDTS) >= f.a.SegmentDuration:
+		err := f.currentSegment.close()
+		if err != nil {
+			return err
+		}
+
+		f.currentSegment = &formatMPEGTSSegment{
+			f:         f,
+			startDTS:  dts,
+			startNTP:  ntp,
+			lastFlush: dts,
+		}
+		f.dw.w = f.currentSegment
+
+	case (dts - f.currentSegment.lastFlush) >= f.a.PartDuration:
+		err := f.bw.Flush()
+		if err != nil {
+			return err
+		}
+
+		f.currentSegment.lastFlush = dts
+	}
+
+	return nil
+}
+
+func (f *formatMPEGTS) setupVideo(writer *asyncwriter.Writer) *mpegts.Track {
+	strm := f.a.Stream
+
+	var videoFormatH265 *format.H265
+	videoMedia := strm.Desc().FindFormat(&videoFormatH265)
+
+	if videoFormatH265 != nil {
+		f.hasVideo = true
+		track := &mpegts.Track{Codec: &mpegts.CodecH265{}}
+
+		var dtsExtractor *h265.DTSExtractor
+
+		strm.AddReader(writer, videoMedia, videoFormatH265, func(u unit.Unit) error {
+			tunit := u.(*unit.H265)
+			if tunit.AU == nil {
+				return nil
+			}
+
+			randomAccess := h265.IsRandomAccess(tunit.AU)
+
+			if dtsExtractor == nil {
+				if !randomAccess {
+					return nil
+				}
+				dtsExtractor = h265.NewDTSExtractor()
+				f.videoStarted = true
+			}
+
+			dts, err := dtsExtractor.Extract(tunit.AU, tunit.PTS)
+			if err != nil {
+				return err
+			}
+
+			err = f.updateSegment(dts, tunit.NTP, true, randomAccess)
+			if err != nil {
+				return err
+			}
+
+			return f.mw.WriteH265(track, durationGoToMPEGTS(tunit.PTS), durationGoToMPEGTS(dts), randomAccess, tunit.AU)
+		})
+
+		return track
+	}
+
+	var videoFormatH264 *format.H264
+	videoMedia = strm.Desc().FindFormat(&videoFormatH264)
+
+	if videoFormatH264 != nil {
+		f.hasVideo = true
+		track := &mpegts.Track{Codec: &mpegts.CodecH264{}}
+
+		var dtsExtractor *h264.DTSExtractor
+
+		strm.AddReader(writer, videoMedia, videoFormatH264, func(u unit.Unit) error {
+			tunit := u.(*unit.H264)
+			if tunit.AU == nil {
+				return nil
+			}
+
+			randomAccess := h264.IDRPresent(tunit.AU)
+
+			if dtsExtractor == nil {
+				if !randomAccess {
+					return nil
+				}
+				dtsExtractor = h264.NewDTSExtractor()
+				f.videoStarted = true
+			}
+
+			dts, err := dtsExtractor.Extract(tunit.AU, tunit.PTS)
+			if err != nil {
+				return err
+			}
+
+			err = f.updateSegment(dts, tunit.NTP, true, randomAccess)
+			if err != nil {
+				return err
+			}
+
+			return f.mw.WriteH264(track, durationGoToMPEGTS(tunit.PTS), durationGoToMPEGTS(dts), randomAccess, tunit.AU)
+		})
+
+		return track
+	}
+
+	return nil
+}
+
+func (f *formatMPEGTS) setupAudio(writer *asyncwriter.Writer) *mpegts.Track {
+	strm := f.a.Stream
+
+	var audioFormatMPEG4Audio *format.MPEG4Audio
+	audioMedia := strm.Desc().FindFormat(&audioFormatMPEG4Audio)
+
+	if audioFormatMPEG4Audio != nil && audioFormatMPEG4Audio.GetConfig() != nil {
+		track := &mpegts.Track{Codec: &mpegts.CodecMPEG4Audio{Config: *audioFormatMPEG4Audio.GetConfig()}}
+
+		strm.AddReader(writer, audioMedia, audioFormatMPEG4Audio, func(u unit.Unit) error {
+			tunit := u.(*unit.MPEG4Audio)
+			if tunit.AUs == nil || (f.hasVideo && !f.videoStarted) {
+				return nil
+			}
+
+			err := f.updateSegment(tunit.PTS, tunit.NTP, false, true)
+			if err != nil {
+				return err
+			}
+
+			return f.mw.WriteMPEG4Audio(track, durationGoToMPEGTS(tunit.PTS), tunit.AUs)
+		})
+
+		return track
+	}
+
+	var audioFormatOpus *format.Opus
+	audioMedia = strm.Desc().FindFormat(&audioFormatOpus)
+
+	if audioFormatOpus != nil {
+		track := &mpegts.Track{Codec: &mpegts.CodecOpus{ChannelCount: audioFormatOpus.ChannelCount}}
+
+		strm.AddReader(writer, audioMedia, audioFormatOpus, func(u unit.Unit) error {
+			tunit := u.(*unit.Opus)
+			if tunit.Packets == nil || (f.hasVideo && !f.videoStarted) {
+				return nil
+			}
+
+			err := f.updateSegment(tunit.PTS, tunit.NTP, false, true)
+			if err != nil {
+				return err
+			}
+
+			return f.mw.WriteOpus(track, durationGoToMPEGTS(tunit.PTS), tunit.Packets)
+		})
+
+		return track
+	}
+
+	return nil
+}
+
+func (f *formatMPEGTS) close() {
+	if f.currentSegment != nil {
+		err := f.currentSegment.close()
+		if err != nil {
+			f.a.Log(logger.Error, "%v", err)
+		}
+		f.currentSegment = nil
+	}
+}
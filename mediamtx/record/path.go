@@ -0,0 +1,132 @@
+package record
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Path is a recording segment path, decoded from or encoded into a path template.
+//
+// Template could contain:
+// %path path name, %Y %m %d %H %M %S date and time of segment start,
+// %f microseconds, %s unix time in seconds.
+type Path struct {
+	Start time.Time
+}
+
+func leadingZeros(v int, size int) string {
+	out := strconv.Itoa(v)
+	if len(out) >= size {
+		return out
+	}
+
+	return strings.Repeat("0", size-len(out)) + out
+}
+
+var reTemplateVariable = regexp.MustCompile(`%path|%Y|%m|%d|%H|%M|%S|%f|%s`)
+
+// Decode fills Path from v, which must match template, %path matches any path name.
+func (p *Path) Decode(template string, v string) bool {
+	var groups []string
+	re := "^" + reTemplateVariable.ReplaceAllStringFunc(regexp.QuoteMeta(template), func(s string) string {
+		groups = append(groups, s)
+		switch s {
+		case "%path":
+			return "(.+?)"
+		case "%Y":
+			return "([0-9]{4})"
+		case "%f":
+			return "([0-9]{6})"
+		case "%s":
+			return "([0-9]{1,20})"
+		default:
+			return "([0-9]{2})"
+		}
+	}) + "$"
+
+	m := regexp.MustCompile(re).FindStringSubmatch(v)
+	if m == nil {
+		return false
+	}
+
+	values := make(map[string]int)
+	var unixSec int64
+	hasUnixSec := false
+
+	for i, g := range groups {
+		switch g {
+		case "%path":
+
+		case "%s":
+			tmp, _ := strconv.ParseInt(m[i+1], 10, 64)
+			unixSec = tmp
+			hasUnixSec = true
+
+		default:
+			tmp, _ := strconv.Atoi(m[i+1])
+			values[g] = tmp
+		}
+	}
+
+	if hasUnixSec {
+		p.Start = time.Unix(unixSec, 0)
+		return true
+	}
+
+	p.Start = time.Date(values["%Y"], time.Month(values["%m"]), values["%d"],
+		values["%H"], values["%M"], values["%S"], values["%f"]*1000, time.Local)
+
+	return true
+}
+
+// Encode fills template with path start time, %path must be replaced before.
+func (p Path) Encode(template string) string {
+	return reTemplateVariable.ReplaceAllStringFunc(template, func(s string) string {
+		switch s {
+		case "%Y":
+			return strconv.Itoa(p.Start.Year())
+		case "%m":
+			return leadingZeros(int(p.Start.Month()), 2)
+		case "%d":
+			return leadingZeros(p.Start.Day(), 2)
+		case "%H":
+			return leadingZeros(p.Start.Hour(), 2)
+		case "%M":
+			return leadingZeros(p.Start.Minute(), 2)
+		case "%S":
+			return leadingZeros(p.Start.Second(), 2)
+		case "%f":
+			return leadingZeros(p.Start.Nanosecond()/1000, 6)
+		case "%s":
+			return strconv.FormatInt(p.Start.Unix(), 10)
+		default:
+			return s
+		}
+	})
+}
+
+// CommonPath returns the directory containing every path produced by template.
+func CommonPath(template string) string {
+	i := strings.IndexByte(template, '%')
+	if i < 0 {
+		i = len(template)
+	}
+
+	common := template[:i]
+	j := strings.LastIndexByte(common, '/')
+	if j < 0 {
+		return "."
+	}
+	if j == 0 {
+		return "/"
+	}
+
+	return common[:j]
+}
+
+// PathTemplate returns template of path segments, including file extension of format.
+func PathTemplate(template string, pathName string, ext string) string {
+	return strings.ReplaceAll(template, "%path", pathName) + ext
+}
@@ -0,0 +1,86 @@
+package record
+
+import (
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPathEncodeDecode(t *testing.T) {
+	start := time.Date(2024, 3, 5, 7, 8, 9, 123456000, time.Local)
+
+	for _, c := range []struct {
+		template string
+		encoded  string
+		start    time.Time
+	}{
+		{
+			"/rec/cam1/%Y-%m-%d_%H-%M-%S-%f.mp4",
+			"/rec/cam1/2024-03-05_07-08-09-123456.mp4",
+			start,
+		},
+		{
+			"/rec/cam1/%Y/%m/%d/%H%M%S.ts",
+			"/rec/cam1/2024/03/05/070809.ts",
+			start.Truncate(time.Second),
+		},
+		{
+			"/rec/cam1/%s.mp4",
+			"/rec/cam1/" + strconv.FormatInt(start.Unix(), 10) + ".mp4",
+			time.Unix(start.Unix(), 0),
+		},
+	} {
+		encoded := Path{Start: start}.Encode(c.template)
+		if encoded != c.encoded {
+			t.Errorf("%s: unexpected path %s", c.template, encoded)
+			continue
+		}
+
+		var p Path
+		if !p.Decode(c.template, encoded) {
+			t.Errorf("%s: path %s is not decoded", c.template, encoded)
+			continue
+		}
+
+		if !p.Start.Equal(c.start) {
+			t.Errorf("%s: unexpected start %s", c.template, p.Start)
+		}
+	}
+
+	var p Path
+	if p.Decode("/rec/%path/%Y-%m-%d.mp4", "/rec/cam1/2024-03-05.ts") {
+		t.Error("path of another format has been decoded")
+	}
+
+	if !p.Decode("/rec/%path/%Y-%m-%d.mp4", "/rec/cam1/720p/2024-03-05.mp4") || p.Start.Day() != 5 {
+		t.Error("path of rendition has not been decoded")
+	}
+}
+
+func TestPathTemplate(t *testing.T) {
+	template := "/rec/%path/%Y-%m-%d_%H-%M-%S-%f"
+
+	if v := PathTemplate(template, "cam1/720p", Extension(conf.RecordFormatFMP4)); v != "/rec/cam1/720p/%Y-%m-%d_%H-%M-%S-%f.mp4" {
+		t.Errorf("unexpected template %s", v)
+	}
+
+	if v := PathTemplate(template, "cam1", Extension(conf.RecordFormatMPEGTS)); v != "/rec/cam1/%Y-%m-%d_%H-%M-%S-%f.ts" {
+		t.Errorf("unexpected template %s", v)
+	}
+
+	for _, c := range []struct {
+		template string
+		common   string
+	}{
+		{"/rec/%path/%Y-%m-%d", "/rec"},
+		{"/rec/cam1/%Y/%m", "/rec/cam1"},
+		{"rec_%path", "."},
+		{"/%path", "/"},
+		{"/rec/cam1/file", "/rec/cam1"},
+	} {
+		if v := CommonPath(c.template); v != c.common {
+			t.Errorf("%s: unexpected common path %s", c.template, v)
+		}
+	}
+}
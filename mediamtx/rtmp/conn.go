@@ -0,0 +1,366 @@
+package rtmp
+
+import (
+	"context"
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/asyncwriter"
+	"fearpro13/h265_transcoder/mediamtx/auth"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/protocols/rtmp"
+	"fearpro13/h265_transcoder/mediamtx/unit"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/mpeg4audio"
+	"github.com/google/uuid"
+)
+
+type conn struct {
+	parentCtx      context.Context
+	readTimeout    conf.StringDuration
+	writeTimeout   conf.StringDuration
+	writeQueueSize int
+	nconn          net.Conn
+	pathManager    serverPathManager
+	parent         *Server
+
+	ctx       context.Context
+	ctxCancel func()
+	uuid      uuid.UUID
+	rconn     *rtmp.Conn
+}
+
+func (c *conn) initialize() {
+	c.ctx, c.ctxCancel = context.WithCancel(c.parentCtx)
+	c.uuid = uuid.New()
+}
+
+// Close closes conn.
+func (c *conn) Close() {
+	c.ctxCancel()
+}
+
+// Log implements logger.Writer.
+func (c *conn) Log(level logger.Level, format string, args ...interface{}) {
+	c.parent.Log(level, "[conn %v] "+format, append([]interface{}{c.nconn.RemoteAddr()}, args...)...)
+}
+
+func (c *conn) ip() net.IP {
+	return c.nconn.RemoteAddr().(*net.TCPAddr).IP
+}
+
+func (c *conn) run() {
+	defer c.parent.wg.Done()
+
+	c.Log(logger.Info, "opened")
+
+	// connection is closed when conn is closed by server or path
+	go func() {
+		<-c.ctx.Done()
+		c.nconn.Close()
+	}()
+
+	err := c.runInner()
+
+	c.ctxCancel()
+
+	c.Log(logger.Info, "closed: %v", err)
+}
+
+func (c *conn) runInner() error {
+	c.nconn.SetReadDeadline(time.Now().Add(time.Duration(c.readTimeout)))
+	c.nconn.SetWriteDeadline(time.Now().Add(time.Duration(c.writeTimeout)))
+
+	c.rconn = &rtmp.Conn{RW: c.nconn}
+
+	u, publish, err := c.rconn.InitializeServer()
+	if err != nil {
+		return err
+	}
+
+	if publish {
+		return c.runPublish(u)
+	}
+
+	return c.runRead(u)
+}
+
+// accessRequest returns an access request of URL, credentials are passed with user and pass query parameters
+func (c *conn) accessRequest(u *url.URL, publish bool) defs.PathAccessRequest {
+	query := u.Query()
+
+	return defs.PathAccessRequest{
+		Name:    strings.TrimPrefix(u.Path, "/"),
+		Query:   u.RawQuery,
+		Publish: publish,
+		IP:      c.ip(),
+		User:    query.Get("user"),
+		Pass:    query.Get("pass"),
+		Proto:   auth.ProtocolRTMP,
+		ID:      &c.uuid,
+	}
+}
+
+func (c *conn) handleAuthError(err error) error {
+	var terr auth.Error
+	if errors.As(err, &terr) {
+		// wait some seconds to mitigate brute force attacks
+		<-time.After(auth.PauseAfterError)
+		return terr
+	}
+
+	return err
+}
+
+func (c *conn) runRead(u *url.URL) error {
+	path, strm, err := c.pathManager.AddReader(defs.PathAddReaderReq{
+		Author:        c,
+		AccessRequest: c.accessRequest(u, false),
+	})
+	if err != nil {
+		return c.handleAuthError(err)
+	}
+
+	defer path.RemoveReader(defs.PathRemoveReaderReq{Author: c})
+
+	var videoFormatH264 *format.H264
+	videoMedia := strm.Desc().FindFormat(&videoFormatH264)
+
+	var audioFormatMPEG4Audio *format.MPEG4Audio
+	audioMedia := strm.Desc().FindFormat(&audioFormatMPEG4Audio)
+
+	if audioFormatMPEG4Audio != nil && audioFormatMPEG4Audio.GetConfig() == nil {
+		audioFormatMPEG4Audio = nil
+	}
+
+	if videoFormatH264 == nil && audioFormatMPEG4Audio == nil {
+		return fmt.Errorf(
+			"the stream doesn't contain any supported codec, which are currently H264, MPEG-4 Audio")
+	}
+
+	w, err := rtmp.NewWriter(c.rconn, videoFormatH264, audioFormatMPEG4Audio)
+	if err != nil {
+		return err
+	}
+
+	writer := asyncwriter.New(c.writeQueueSize, c)
+
+	var formats []format.Format
+
+	// timestamps are relative to DTS of first IDR, or to PTS of first audio unit when there's no video
+	var startTS time.Duration
+	started := false
+
+	if videoFormatH264 != nil {
+		var dtsExtractor *h264.DTSExtractor
+
+		strm.AddReader(writer, videoMedia, videoFormatH264, func(u unit.Unit) error {
+			tunit := u.(*unit.H264)
+			if tunit.AU == nil {
+				return nil
+			}
+
+			idr := h264.IDRPresent(tunit.AU)
+
+			if dtsExtractor == nil {
+				if !idr {
+					return nil
+				}
+				dtsExtractor = h264.NewDTSExtractor()
+			}
+
+			dts, err := dtsExtractor.Extract(tunit.AU, tunit.PTS)
+			if err != nil {
+				return err
+			}
+
+			if !started {
+				started = true
+				startTS = dts
+			}
+
+			c.nconn.SetWriteDeadline(time.Now().Add(time.Duration(c.writeTimeout)))
+			return w.WriteH264(tunit.PTS-startTS, dts-startTS, idr, tunit.AU)
+		})
+
+		formats = append(formats, videoFormatH264)
+	}
+
+	if audioFormatMPEG4Audio != nil {
+		sampleRate := time.Duration(audioFormatMPEG4Audio.GetConfig().SampleRate)
+
+		strm.AddReader(writer, audioMedia, audioFormatMPEG4Audio, func(u unit.Unit) error {
+			tunit := u.(*unit.MPEG4Audio)
+			if tunit.AUs == nil {
+				return nil
+			}
+
+			if !started {
+				if videoFormatH264 != nil {
+					return nil
+				}
+				started = true
+				startTS = tunit.PTS
+			}
+
+			for i, au := range tunit.AUs {
+				pts := tunit.PTS - startTS + time.Duration(i)*mpeg4audio.SamplesPerAccessUnit*time.Second/sampleRate
+				if pts < 0 {
+					continue
+				}
+
+				c.nconn.SetWriteDeadline(time.Now().Add(time.Duration(c.writeTimeout)))
+				err := w.WriteMPEG4Audio(pts, au)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		formats = append(formats, audioFormatMPEG4Audio)
+	}
+
+	c.Log(logger.Info, "is reading from path '%s', %s", path.Name(), defs.FormatsInfo(formats))
+
+	// readers only send acknowledgements and control messages, which are read to detect disconnections
+	c.nconn.SetReadDeadline(time.Time{})
+
+	readErr := make(chan error)
+	go func() {
+		for {
+			_, err := c.rconn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	writer.Start()
+
+	select {
+	case err := <-writer.Error():
+		strm.RemoveReader(writer)
+		c.nconn.Close()
+		<-readErr
+		return err
+
+	case err := <-readErr:
+		strm.RemoveReader(writer)
+		writer.Stop()
+		return err
+
+	case <-c.ctx.Done():
+		strm.RemoveReader(writer)
+		writer.Stop()
+		<-readErr
+		return fmt.Errorf("terminated")
+	}
+}
+
+func (c *conn) runPublish(u *url.URL) error {
+	path, err := c.pathManager.AddPublisher(defs.PathAddPublisherReq{
+		Author:        c,
+		AccessRequest: c.accessRequest(u, true),
+	})
+	if err != nil {
+		return c.handleAuthError(err)
+	}
+
+	defer path.RemovePublisher(defs.PathRemovePublisherReq{Author: c})
+
+	r, err := rtmp.NewReader(c.rconn)
+	if err != nil {
+		return err
+	}
+
+	videoTrack, audioTrack := r.Tracks()
+
+	desc := &description.Session{}
+
+	if videoTrack != nil {
+		desc.Medias = append(desc.Medias, &description.Media{
+			Type:    description.MediaTypeVideo,
+			Formats: []format.Format{videoTrack},
+		})
+	}
+
+	if audioTrack != nil {
+		desc.Medias = append(desc.Medias, &description.Media{
+			Type:    description.MediaTypeAudio,
+			Formats: []format.Format{audioTrack},
+		})
+	}
+
+	strm, err := path.StartPublisher(defs.PathStartPublisherReq{
+		Author:             c,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	// stream could be kept from previous publisher, its medias are matched by position
+	if videoTrack != nil {
+		smedi := strm.Desc().Medias[0]
+
+		r.OnDataH264(func(pts time.Duration, au [][]byte) {
+			strm.WriteUnit(smedi, smedi.Formats[0], &unit.H264{
+				Base: unit.Base{
+					NTP: time.Now(),
+					PTS: pts,
+				},
+				AU: au,
+			})
+		})
+	}
+
+	if audioTrack != nil {
+		smedi := strm.Desc().Medias[len(desc.Medias)-1]
+
+		r.OnDataMPEG4Audio(func(pts time.Duration, au []byte) {
+			strm.WriteUnit(smedi, smedi.Formats[0], &unit.MPEG4Audio{
+				Base: unit.Base{
+					NTP: time.Now(),
+					PTS: pts,
+				},
+				AUs: [][]byte{au},
+			})
+		})
+	}
+
+	for {
+		c.nconn.SetReadDeadline(time.Now().Add(time.Duration(c.readTimeout)))
+		err := r.Read()
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return fmt.Errorf("terminated")
+			}
+			return err
+		}
+	}
+}
+
+// APIReaderDescribe implements reader.
+func (c *conn) APIReaderDescribe() defs.APIPathSourceOrReader {
+	return defs.APIPathSourceOrReader{
+		Type: "rtmpConn",
+		ID:   c.uuid.String(),
+	}
+}
+
+// APISourceDescribe implements source.
+func (c *conn) APISourceDescribe() defs.APIPathSourceOrReader {
+	return c.APIReaderDescribe()
+}
@@ -0,0 +1,90 @@
+// Package rtmp contains a RTMP server, serving paths to readers and accepting publishers.
+package rtmp
+
+import (
+	"context"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"net"
+	"sync"
+)
+
+type serverPathManager interface {
+	AddReader(req defs.PathAddReaderReq) (defs.Path, *stream.Stream, error)
+	AddPublisher(req defs.PathAddPublisherReq) (defs.Path, error)
+}
+
+// Server is a RTMP server.
+// Path of rtmp://host/{path} is read with play and written with publish.
+type Server struct {
+	Address        string
+	ReadTimeout    conf.StringDuration
+	WriteTimeout   conf.StringDuration
+	WriteQueueSize int
+	PathManager    serverPathManager
+	Parent         logger.Writer
+
+	ctx       context.Context
+	ctxCancel func()
+	wg        sync.WaitGroup
+	ln        net.Listener
+}
+
+// Initialize initializes Server.
+func (s *Server) Initialize() error {
+	ln, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		return err
+	}
+
+	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
+	s.ln = ln
+
+	s.wg.Add(1)
+	go s.runAccept()
+
+	s.Log(logger.Info, "listener opened on %s", s.Address)
+
+	return nil
+}
+
+// Close closes Server.
+func (s *Server) Close() {
+	s.Log(logger.Info, "listener is closing")
+
+	s.ctxCancel()
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+// Log implements logger.Writer.
+func (s *Server) Log(level logger.Level, format string, args ...interface{}) {
+	s.Parent.Log(level, "[RTMP] "+format, args...)
+}
+
+func (s *Server) runAccept() {
+	defer s.wg.Done()
+
+	for {
+		nconn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &conn{
+			parentCtx:      s.ctx,
+			readTimeout:    s.ReadTimeout,
+			writeTimeout:   s.WriteTimeout,
+			writeQueueSize: s.WriteQueueSize,
+			nconn:          nconn,
+			pathManager:    s.PathManager,
+			parent:         s,
+		}
+		c.initialize()
+
+		s.wg.Add(1)
+		go c.run()
+	}
+}
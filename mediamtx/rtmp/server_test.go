@@ -0,0 +1,168 @@
+package rtmp
+
+import (
+	"bytes"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/protocols/rtmp"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func dialRTMP(t *testing.T, rawURL string, publish bool) (net.Conn, *rtmp.Conn) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nconn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nconn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	conn := &rtmp.Conn{RW: nconn}
+	err = conn.InitializeClient(u, publish)
+	if err != nil {
+		nconn.Close()
+		t.Fatal(err)
+	}
+
+	return nconn, conn
+}
+
+func TestServerPublishRead(t *testing.T) {
+	addr := fmt.Sprintf("127.0.0.1:%d", test.FreeTCPPort(t))
+
+	pa := &test.Path{PathName: "cam1"}
+
+	s := &Server{
+		Address:        addr,
+		ReadTimeout:    conf.StringDuration(5 * time.Second),
+		WriteTimeout:   conf.StringDuration(5 * time.Second),
+		WriteQueueSize: 512,
+		PathManager:    &test.PathManager{Path: pa},
+		Parent:         test.NilLogger,
+	}
+	err := s.Initialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	pubConn, pub := dialRTMP(t, "rtmp://"+addr+"/cam1", true)
+	defer pubConn.Close()
+
+	w, err := rtmp.NewWriter(pub, test.FormatH264, test.FormatMPEG4Audio)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idr := append([]byte{0x65, 0x88, 0x84}, bytes.Repeat([]byte{0x01}, 100000)...)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(40 * time.Millisecond):
+			}
+
+			ts := time.Duration(i) * 40 * time.Millisecond
+
+			err := w.WriteH264(ts, ts, true, [][]byte{idr})
+			if err != nil {
+				return
+			}
+
+			err = w.WriteMPEG4Audio(ts, []byte{0x01, 0x02, 0x03, 0x04})
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// reader connects after publisher has started
+	var readConn net.Conn
+	var read *rtmp.Conn
+	for i := 0; ; i++ {
+		if pa.Stream() != nil {
+			readConn, read = dialRTMP(t, "rtmp://"+addr+"/cam1", false)
+			break
+		}
+
+		if i == 100 {
+			t.Fatal("publisher not started")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	defer readConn.Close()
+
+	r, err := rtmp.NewReader(read)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	videoTrack, audioTrack := r.Tracks()
+	if videoTrack == nil || audioTrack == nil {
+		t.Fatalf("tracks not detected: %v %v", videoTrack, audioTrack)
+	}
+
+	if !bytes.Equal(videoTrack.SPS, test.FormatH264.SPS) || !bytes.Equal(videoTrack.PPS, test.FormatH264.PPS) {
+		t.Fatal("unexpected video parameters")
+	}
+
+	if audioTrack.Config.SampleRate != 44100 || audioTrack.Config.ChannelCount != 2 {
+		t.Fatalf("unexpected audio config: %+v", audioTrack.Config)
+	}
+
+	var videoAU [][]byte
+	var audioAU []byte
+
+	r.OnDataH264(func(_ time.Duration, au [][]byte) {
+		videoAU = au
+	})
+	r.OnDataMPEG4Audio(func(_ time.Duration, au []byte) {
+		audioAU = au
+	})
+
+	for videoAU == nil || audioAU == nil {
+		err = r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(videoAU[len(videoAU)-1], idr) {
+		t.Fatal("unexpected video access unit")
+	}
+
+	if !bytes.Equal(audioAU, []byte{0x01, 0x02, 0x03, 0x04}) {
+		t.Fatalf("unexpected audio access unit: %x", audioAU)
+	}
+
+	// missing path is refused
+	u, _ := url.Parse("rtmp://" + addr + "/cam2")
+	nconn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nconn.Close()
+	nconn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	missing := &rtmp.Conn{RW: nconn}
+	err = missing.InitializeClient(u, false)
+	if err == nil {
+		_, err = missing.ReadMessage()
+	}
+	if err == nil {
+		t.Fatal("reading a missing path should fail")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fearpro13/h265_transcoder/mediamtx/certloader"
 	"fearpro13/h265_transcoder/mediamtx/conf"
 	"fearpro13/h265_transcoder/mediamtx/defs"
 	"fearpro13/h265_transcoder/mediamtx/logger"
@@ -72,13 +73,14 @@ type Server struct {
 	PathManager       serverPathManager
 	Parent            logger.Writer
 
-	ctx       context.Context
-	ctxCancel func()
-	wg        sync.WaitGroup
-	srv       *gortsplib.Server
-	mutex     sync.RWMutex
-	conns     map[*gortsplib.ServerConn]*conn
-	sessions  map[*gortsplib.ServerSession]*session
+	ctx        context.Context
+	ctxCancel  func()
+	wg         sync.WaitGroup
+	srv        *gortsplib.Server
+	certLoader *certloader.CertLoader
+	mutex      sync.RWMutex
+	conns      map[*gortsplib.ServerConn]*conn
+	sessions   map[*gortsplib.ServerSession]*session
 }
 
 // Initialize initializes the server.
@@ -108,16 +110,25 @@ func (s *Server) Initialize() error {
 	}
 
 	if s.IsTLS {
-		cert, err := tls.LoadX509KeyPair(s.ServerCert, s.ServerKey)
+		// certificate is reloaded when its files change, without closing connections
+		s.certLoader = &certloader.CertLoader{
+			CertPath: s.ServerCert,
+			KeyPath:  s.ServerKey,
+			Parent:   s,
+		}
+		err := s.certLoader.Initialize()
 		if err != nil {
 			return err
 		}
 
-		s.srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		s.srv.TLSConfig = &tls.Config{GetCertificate: s.certLoader.GetCertificate}
 	}
 
 	err := s.srv.Start()
 	if err != nil {
+		if s.certLoader != nil {
+			s.certLoader.Close()
+		}
 		return err
 	}
 
@@ -146,6 +157,10 @@ func (s *Server) Close() {
 	s.Log(logger.Info, "listener is closing")
 	s.ctxCancel()
 	s.wg.Wait()
+
+	if s.certLoader != nil {
+		s.certLoader.Close()
+	}
 }
 
 func (s *Server) run() {
@@ -3,10 +3,12 @@ package rtsp
 import (
 	"encoding/hex"
 	"errors"
+	"fearpro13/h265_transcoder/mediamtx/asyncwriter"
 	"fearpro13/h265_transcoder/mediamtx/auth"
 	"fearpro13/h265_transcoder/mediamtx/conf"
 	"fearpro13/h265_transcoder/mediamtx/defs"
 	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/playback"
 	"fearpro13/h265_transcoder/mediamtx/stream"
 	"fmt"
 	"net"
@@ -16,6 +18,8 @@ import (
 	"github.com/bluenviron/gortsplib/v4"
 	rtspauth "github.com/bluenviron/gortsplib/v4/pkg/auth"
 	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/headers"
+	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
 	"github.com/google/uuid"
 	"github.com/pion/rtp"
 )
@@ -40,6 +44,12 @@ type session struct {
 	query           string
 	decodeErrLogger logger.Writer
 	writeErrLogger  logger.Writer
+
+	// private stream is used when path recordings could be played back
+	rstream        *gortsplib.ServerStream
+	writer         *asyncwriter.Writer
+	playbackCancel func()
+	playbackDone   chan struct{}
 }
 
 func (s *session) initialize() {
@@ -69,6 +79,12 @@ func (s *session) Log(level logger.Level, format string, args ...interface{}) {
 
 // onClose is called by rtspServer.
 func (s *session) onClose(err error) {
+	if s.rstream != nil {
+		s.stopFeeding()
+		s.rstream.Close()
+		s.rstream = nil
+	}
+
 	switch s.rsession.State() {
 	case gortsplib.ServerSessionStatePrePlay, gortsplib.ServerSessionStatePlay:
 		s.path.RemoveReader(defs.PathRemoveReaderReq{Author: s})
@@ -214,7 +230,9 @@ func (s *session) onSetup(c *conn, ctx *gortsplib.ServerHandlerOnSetupCtx,
 		s.mutex.Unlock()
 
 		var rstream *gortsplib.ServerStream
-		if !s.isTLS {
+		if canPlayback(path.SafeConf()) {
+			rstream = s.privateStream()
+		} else if !s.isTLS {
 			rstream = stream.RTSPStream(s.rserver)
 		} else {
 			rstream = stream.RTSPSStream(s.rserver)
@@ -232,9 +250,35 @@ func (s *session) onSetup(c *conn, ctx *gortsplib.ServerHandlerOnSetupCtx,
 }
 
 // onPlay is called by rtspServer.
-func (s *session) onPlay(_ *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
+func (s *session) onPlay(ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
 	h := make(base.Header)
 
+	if s.rstream != nil {
+		s.stopFeeding()
+
+		var rng headers.Range
+		err := rng.Unmarshal(ctx.Request.Header["Range"])
+
+		if v, ok := rng.Value.(*headers.RangeUTC); err == nil && ok {
+			err = s.startPlayback(v)
+			if err != nil {
+				if errors.Is(err, playback.ErrNoSegmentsFound) {
+					return &base.Response{
+						StatusCode: base.StatusNotFound,
+					}, err
+				}
+
+				return &base.Response{
+					StatusCode: base.StatusBadRequest,
+				}, err
+			}
+
+			h["Range"] = ctx.Request.Header["Range"]
+		} else {
+			s.startLive()
+		}
+	}
+
 	if s.rsession.State() == gortsplib.ServerSessionStatePrePlay {
 		s.Log(logger.Info, "is reading from path '%s', with %s, %s",
 			s.path.Name(),
@@ -268,18 +312,22 @@ func (s *session) onRecord(_ *gortsplib.ServerHandlerOnRecordCtx) (*base.Respons
 
 	s.stream = stream
 
-	for _, medi := range s.rsession.AnnouncedDescription().Medias {
-		for _, forma := range medi.Formats {
+	// stream could be kept from previous publisher, its medias are matched by position
+	streamMedias := stream.Desc().Medias
+
+	for i, medi := range s.rsession.AnnouncedDescription().Medias {
+		for j, forma := range medi.Formats {
 			cmedi := medi
-			cforma := forma
+			smedi := streamMedias[i]
+			sforma := smedi.Formats[j]
 
-			s.rsession.OnPacketRTP(cmedi, cforma, func(pkt *rtp.Packet) {
+			s.rsession.OnPacketRTP(cmedi, forma, func(pkt *rtp.Packet) {
 				pts, ok := s.rsession.PacketPTS(cmedi, pkt)
 				if !ok {
 					return
 				}
 
-				stream.WriteRTPPacket(cmedi, cforma, pkt, time.Now(), pts)
+				stream.WriteRTPPacket(smedi, sforma, pkt, time.Now(), pts)
 			})
 		}
 	}
@@ -298,6 +346,9 @@ func (s *session) onRecord(_ *gortsplib.ServerHandlerOnRecordCtx) (*base.Respons
 func (s *session) onPause(_ *gortsplib.ServerHandlerOnPauseCtx) (*base.Response, error) {
 	switch s.rsession.State() {
 	case gortsplib.ServerSessionStatePlay:
+		if s.rstream != nil {
+			s.stopFeeding()
+		}
 
 		s.mutex.Lock()
 		s.state = gortsplib.ServerSessionStatePrePlay
@@ -336,14 +387,32 @@ func (s *session) APISourceDescribe() defs.APIPathSourceOrReader {
 
 // onPacketLost is called by rtspServer.
 func (s *session) onPacketLost(ctx *gortsplib.ServerHandlerOnPacketLostCtx) {
+	if s.publishing() {
+		lost := uint64(1)
+		var lerr liberrors.ErrServerRTPPacketsLost
+		if errors.As(ctx.Error, &lerr) {
+			lost = uint64(lerr.Lost)
+		}
+		s.stream.AddRTPPacketsLost(lost)
+	}
+
 	s.decodeErrLogger.Log(logger.Warn, ctx.Error.Error())
 }
 
 // onDecodeError is called by rtspServer.
 func (s *session) onDecodeError(ctx *gortsplib.ServerHandlerOnDecodeErrorCtx) {
+	if s.publishing() {
+		s.stream.AddDecodeError()
+	}
+
 	s.decodeErrLogger.Log(logger.Warn, ctx.Error.Error())
 }
 
+// publishing reports whether session publishes into stream, errors of readers are not counted by stream
+func (s *session) publishing() bool {
+	return s.stream != nil && s.rsession.State() == gortsplib.ServerSessionStateRecord
+}
+
 // onStreamWriteError is called by rtspServer.
 func (s *session) onStreamWriteError(ctx *gortsplib.ServerHandlerOnStreamWriteErrorCtx) {
 	s.writeErrLogger.Log(logger.Warn, ctx.Error.Error())
@@ -0,0 +1,334 @@
+package rtsp
+
+import (
+	"context"
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/asyncwriter"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/playback"
+	"fearpro13/h265_transcoder/mediamtx/unit"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/headers"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+	"github.com/pion/rtp"
+)
+
+func durationGoToRTP(v time.Duration, clockRate int) uint32 {
+	clockRate64 := int64(clockRate)
+	secs := int64(v / time.Second)
+	dec := int64(v % time.Second)
+	return uint32(secs*clockRate64 + dec*clockRate64/int64(time.Second))
+}
+
+// canPlayback returns whether recordings of path could be played back,
+// readers of such paths get a private stream, since live packets must not reach sessions playing recordings
+func canPlayback(pathConf *conf.Path) bool {
+	return pathConf.RecordPath != "" && pathConf.RecordFormat == conf.RecordFormatFMP4
+}
+
+type playbackEncoder struct {
+	media     *description.Media
+	clockRate int
+	encode    func(payload []byte, randomAccess bool) ([]*rtp.Packet, error)
+}
+
+// prependParams adds parameters of initialization header to key frames which do not contain them
+func prependParams(au [][]byte, params [][]byte) [][]byte {
+	for _, p := range params {
+		if len(p) == 0 {
+			return au
+		}
+	}
+	return append(params, au...)
+}
+
+func newPlaybackEncoder(medi *description.Media, forma format.Format, codec fmp4.Codec) *playbackEncoder {
+	switch codec := codec.(type) {
+	case *fmp4.CodecH265:
+		forma, ok := forma.(*format.H265)
+		if !ok {
+			return nil
+		}
+
+		enc, err := forma.CreateEncoder()
+		if err != nil {
+			return nil
+		}
+
+		return &playbackEncoder{
+			media:     medi,
+			clockRate: forma.ClockRate(),
+			encode: func(payload []byte, randomAccess bool) ([]*rtp.Packet, error) {
+				au, err := h264.AVCCUnmarshal(payload)
+				if err != nil {
+					return nil, err
+				}
+
+				if randomAccess && !hasH265Params(au) {
+					au = prependParams(au, [][]byte{codec.VPS, codec.SPS, codec.PPS})
+				}
+
+				return enc.Encode(au)
+			},
+		}
+
+	case *fmp4.CodecH264:
+		forma, ok := forma.(*format.H264)
+		if !ok {
+			return nil
+		}
+
+		enc, err := forma.CreateEncoder()
+		if err != nil {
+			return nil
+		}
+
+		return &playbackEncoder{
+			media:     medi,
+			clockRate: forma.ClockRate(),
+			encode: func(payload []byte, randomAccess bool) ([]*rtp.Packet, error) {
+				au, err := h264.AVCCUnmarshal(payload)
+				if err != nil {
+					return nil, err
+				}
+
+				if randomAccess && !hasH264Params(au) {
+					au = prependParams(au, [][]byte{codec.SPS, codec.PPS})
+				}
+
+				return enc.Encode(au)
+			},
+		}
+
+	case *fmp4.CodecMPEG4Audio:
+		forma, ok := forma.(*format.MPEG4Audio)
+		if !ok {
+			return nil
+		}
+
+		enc, err := forma.CreateEncoder()
+		if err != nil {
+			return nil
+		}
+
+		return &playbackEncoder{
+			media:     medi,
+			clockRate: forma.ClockRate(),
+			encode: func(payload []byte, _ bool) ([]*rtp.Packet, error) {
+				return enc.Encode([][]byte{payload})
+			},
+		}
+
+	case *fmp4.CodecOpus:
+		forma, ok := forma.(*format.Opus)
+		if !ok {
+			return nil
+		}
+
+		enc, err := forma.CreateEncoder()
+		if err != nil {
+			return nil
+		}
+
+		return &playbackEncoder{
+			media:     medi,
+			clockRate: forma.ClockRate(),
+			encode: func(payload []byte, _ bool) ([]*rtp.Packet, error) {
+				pkt, err := enc.Encode(payload)
+				if err != nil {
+					return nil, err
+				}
+				return []*rtp.Packet{pkt}, nil
+			},
+		}
+	}
+
+	return nil
+}
+
+func hasH264Params(au [][]byte) bool {
+	for _, nalu := range au {
+		if len(nalu) != 0 && h264.NALUType(nalu[0]&0x1F) == h264.NALUTypeSPS {
+			return true
+		}
+	}
+	return false
+}
+
+func hasH265Params(au [][]byte) bool {
+	for _, nalu := range au {
+		if len(nalu) != 0 && h265.NALUType((nalu[0]>>1)&0b111111) == h265.NALUType_SPS_NUT {
+			return true
+		}
+	}
+	return false
+}
+
+// startLive forwards the live stream into the private stream of session
+func (s *session) startLive() {
+	s.writer = asyncwriter.New(s.parent.WriteQueueSize, s)
+
+	for _, medi := range s.rsession.SetuppedMedias() {
+		for _, forma := range medi.Formats {
+			cmedi := medi
+
+			s.stream.AddReader(s.writer, medi, forma, func(u unit.Unit) error {
+				ntp := u.GetNTP()
+				for _, pkt := range u.GetRTPPackets() {
+					s.rstream.WritePacketRTPWithNTP(cmedi, pkt, ntp) //nolint:errcheck
+				}
+				return nil
+			})
+		}
+	}
+
+	s.writer.Start()
+}
+
+// startPlayback feeds the private stream of session with recordings, in real time
+func (s *session) startPlayback(rng *headers.RangeUTC) error {
+	segments, err := playback.FindSegments(s.path.SafeConf(), s.path.Name())
+	if err != nil {
+		return err
+	}
+
+	reader := &playback.Reader{
+		Segments: segments,
+		Start:    rng.Start,
+	}
+	if rng.End != nil {
+		if !rng.End.After(rng.Start) {
+			return errors.New("invalid range")
+		}
+		reader.Duration = rng.End.Sub(rng.Start)
+	}
+
+	err = reader.Initialize()
+	if err != nil {
+		return err
+	}
+
+	// medias are matched with recorded tracks by codec
+	encoders := make(map[*fmp4.InitTrack]*playbackEncoder)
+
+	for _, medi := range s.rsession.SetuppedMedias() {
+	outer:
+		for _, forma := range medi.Formats {
+			for _, track := range reader.Init.Tracks {
+				if _, ok := encoders[track]; ok {
+					continue
+				}
+
+				enc := newPlaybackEncoder(medi, forma, track.Codec)
+				if enc != nil {
+					encoders[track] = enc
+					break outer
+				}
+			}
+		}
+	}
+
+	if len(encoders) == 0 {
+		reader.Close()
+		return errors.New("recorded tracks do not match stream medias")
+	}
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	s.playbackCancel = ctxCancel
+	s.playbackDone = make(chan struct{})
+
+	go s.runPlayback(ctx, reader, encoders)
+
+	return nil
+}
+
+func (s *session) runPlayback(ctx context.Context, reader *playback.Reader, encoders map[*fmp4.InitTrack]*playbackEncoder) {
+	defer close(s.playbackDone)
+	defer reader.Close()
+
+	s.Log(logger.Info, "is playing recordings of path '%s' from %s", s.path.Name(), reader.SpanStart().Format(time.RFC3339))
+
+	started := time.Now()
+	timer := time.NewTimer(0)
+	<-timer.C
+
+	err := reader.Read(func(sample *playback.Sample) error {
+		enc, ok := encoders[sample.Track]
+		if !ok {
+			return nil
+		}
+
+		wait := time.Until(started.Add(sample.DTS))
+		if wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		payload, err := sample.GetPayload()
+		if err != nil {
+			return err
+		}
+
+		pkts, err := enc.encode(payload, !sample.IsNonSyncSample)
+		if err != nil {
+			s.writeErrLogger.Log(logger.Warn, err.Error())
+			return nil
+		}
+
+		pts := sample.PTS()
+		ts := durationGoToRTP(pts, enc.clockRate)
+		ntp := reader.SpanStart().Add(pts)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = ts
+			s.rstream.WritePacketRTPWithNTP(enc.media, pkt, ntp) //nolint:errcheck
+		}
+
+		return nil
+	})
+
+	switch {
+	case ctx.Err() != nil:
+	case err != nil:
+		s.Log(logger.Warn, "playback failed: %v", err)
+	default:
+		s.Log(logger.Info, "playback of recordings finished")
+	}
+}
+
+// stopFeeding stops forwarding live stream or recordings into the private stream of session
+func (s *session) stopFeeding() {
+	if s.writer != nil {
+		s.stream.RemoveReader(s.writer)
+		s.writer.Stop()
+		s.writer = nil
+	}
+
+	if s.playbackCancel != nil {
+		s.playbackCancel()
+		<-s.playbackDone
+		s.playbackCancel = nil
+	}
+}
+
+// privateStream returns the private stream of session, which is fed when PLAY is received
+func (s *session) privateStream() *gortsplib.ServerStream {
+	if s.rstream == nil {
+		s.rstream = gortsplib.NewServerStream(s.rserver, s.stream.Desc())
+	}
+	return s.rstream
+}
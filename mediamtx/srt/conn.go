@@ -0,0 +1,355 @@
+package srt
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/asyncwriter"
+	"fearpro13/h265_transcoder/mediamtx/auth"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"fearpro13/h265_transcoder/mediamtx/unit"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+	srt "github.com/datarhei/gosrt"
+	"github.com/google/uuid"
+)
+
+func durationGoToMPEGTS(v time.Duration) int64 {
+	secs := v / time.Second
+	dec := v % time.Second
+	return int64(secs)*90000 + int64(dec)*90000/int64(time.Second)
+}
+
+type conn struct {
+	parentCtx         context.Context
+	writeQueueSize    int
+	udpMaxPayloadSize int
+	req               srt.ConnRequest
+	pathManager       serverPathManager
+	parent            *Server
+
+	ctx          context.Context
+	ctxCancel    func()
+	uuid         uuid.UUID
+	bw           *bufio.Writer
+	mw           *mpegts.Writer
+	hasVideo     bool
+	videoStarted bool
+}
+
+func (c *conn) initialize() {
+	c.ctx, c.ctxCancel = context.WithCancel(c.parentCtx)
+	c.uuid = uuid.New()
+}
+
+// Close closes conn.
+func (c *conn) Close() {
+	c.ctxCancel()
+}
+
+// Log implements logger.Writer.
+func (c *conn) Log(level logger.Level, format string, args ...interface{}) {
+	c.parent.Log(level, "[conn %v] "+format, append([]interface{}{c.req.RemoteAddr()}, args...)...)
+}
+
+func (c *conn) ip() net.IP {
+	return c.req.RemoteAddr().(*net.UDPAddr).IP
+}
+
+func (c *conn) run() {
+	defer c.parent.wg.Done()
+
+	c.Log(logger.Info, "opened")
+
+	err := c.runInner()
+
+	c.ctxCancel()
+
+	c.Log(logger.Info, "closed: %v", err)
+}
+
+func (c *conn) runInner() error {
+	var sid streamID
+	err := sid.unmarshal(c.req.StreamId())
+	if err != nil {
+		c.req.Reject(srt.REJX_BAD_REQUEST)
+		return fmt.Errorf("invalid stream ID '%s': %w", c.req.StreamId(), err)
+	}
+
+	if sid.mode != streamIDModeRead {
+		c.req.Reject(srt.REJX_BAD_MODE)
+		return fmt.Errorf("publishing is not supported")
+	}
+
+	return c.runRead(&sid)
+}
+
+// checkPassphrase checks that connection is encrypted with SRTReadPassphrase of path, or not encrypted when there's none
+func (c *conn) checkPassphrase(pathConf *conf.Path) error {
+	if pathConf.SRTReadPassphrase == "" {
+		if c.req.IsEncrypted() {
+			c.req.Reject(srt.REJ_UNSECURE)
+			return fmt.Errorf("connection is encrypted, but no passphrase is defined")
+		}
+		return nil
+	}
+
+	if !c.req.IsEncrypted() {
+		c.req.Reject(srt.REJ_UNSECURE)
+		return fmt.Errorf("connection is not encrypted")
+	}
+
+	err := c.req.SetPassphrase(pathConf.SRTReadPassphrase)
+	if err != nil {
+		c.req.Reject(srt.REJ_BADSECRET)
+		return fmt.Errorf("invalid passphrase")
+	}
+
+	return nil
+}
+
+func (c *conn) runRead(sid *streamID) error {
+	path, strm, err := c.pathManager.AddReader(defs.PathAddReaderReq{
+		Author: c,
+		AccessRequest: defs.PathAccessRequest{
+			Name:  sid.path,
+			Query: sid.query,
+			IP:    c.ip(),
+			User:  sid.user,
+			Pass:  sid.pass,
+			Proto: auth.ProtocolSRT,
+			ID:    &c.uuid,
+		},
+	})
+	if err != nil {
+		var terr auth.Error
+		if errors.As(err, &terr) {
+			// wait some seconds to mitigate brute force attacks
+			<-time.After(auth.PauseAfterError)
+			c.req.Reject(srt.REJX_UNAUTHORIZED)
+			return terr
+		}
+
+		c.req.Reject(srt.REJX_NOTFOUND)
+		return err
+	}
+
+	defer path.RemoveReader(defs.PathRemoveReaderReq{Author: c})
+
+	err = c.checkPassphrase(path.SafeConf())
+	if err != nil {
+		return err
+	}
+
+	sconn, err := c.req.Accept()
+	if err != nil {
+		return err
+	}
+	defer sconn.Close()
+
+	writer := asyncwriter.New(c.writeQueueSize, c)
+
+	// data is flushed after every unit, packets are filled up to SRT payload size
+	c.bw = bufio.NewWriterSize(sconn, srtMaxPayloadSize(c.udpMaxPayloadSize))
+
+	var tracks []*mpegts.Track
+	var formats []format.Format
+
+	videoTrack, videoFormat := c.setupVideo(strm, writer)
+	if videoTrack != nil {
+		tracks = append(tracks, videoTrack)
+		formats = append(formats, videoFormat)
+	}
+
+	audioTrack, audioFormat := c.setupAudio(strm, writer)
+	if audioTrack != nil {
+		tracks = append(tracks, audioTrack)
+		formats = append(formats, audioFormat)
+	}
+
+	if len(tracks) == 0 {
+		strm.RemoveReader(writer)
+		return fmt.Errorf(
+			"the stream doesn't contain any supported codec, which are currently H265, H264, MPEG-4 Audio, Opus")
+	}
+
+	c.mw = mpegts.NewWriter(c.bw, tracks)
+
+	c.Log(logger.Info, "is reading from path '%s', %s", path.Name(), defs.FormatsInfo(formats))
+
+	// readers don't send data, reads are used to detect disconnections
+	readErr := make(chan error)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			_, err := sconn.Read(buf)
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	writer.Start()
+
+	select {
+	case err := <-writer.Error():
+		strm.RemoveReader(writer)
+		sconn.Close()
+		<-readErr
+		return err
+
+	case err := <-readErr:
+		strm.RemoveReader(writer)
+		writer.Stop()
+		return err
+
+	case <-c.ctx.Done():
+		strm.RemoveReader(writer)
+		writer.Stop()
+		sconn.Close()
+		<-readErr
+		return fmt.Errorf("terminated")
+	}
+}
+
+// flush sends buffered data after a unit has been written
+func (c *conn) flush(err error) error {
+	if err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+func (c *conn) setupVideo(strm *stream.Stream, writer *asyncwriter.Writer) (*mpegts.Track, format.Format) {
+	var videoFormatH265 *format.H265
+	videoMedia := strm.Desc().FindFormat(&videoFormatH265)
+
+	if videoFormatH265 != nil {
+		c.hasVideo = true
+		track := &mpegts.Track{Codec: &mpegts.CodecH265{}}
+
+		var dtsExtractor *h265.DTSExtractor
+
+		strm.AddReader(writer, videoMedia, videoFormatH265, func(u unit.Unit) error {
+			tunit := u.(*unit.H265)
+			if tunit.AU == nil {
+				return nil
+			}
+
+			randomAccess := h265.IsRandomAccess(tunit.AU)
+
+			if dtsExtractor == nil {
+				if !randomAccess {
+					return nil
+				}
+				dtsExtractor = h265.NewDTSExtractor()
+				c.videoStarted = true
+			}
+
+			dts, err := dtsExtractor.Extract(tunit.AU, tunit.PTS)
+			if err != nil {
+				return err
+			}
+
+			return c.flush(c.mw.WriteH265(track, durationGoToMPEGTS(tunit.PTS), durationGoToMPEGTS(dts), randomAccess, tunit.AU))
+		})
+
+		return track, videoFormatH265
+	}
+
+	var videoFormatH264 *format.H264
+	videoMedia = strm.Desc().FindFormat(&videoFormatH264)
+
+	if videoFormatH264 != nil {
+		c.hasVideo = true
+		track := &mpegts.Track{Codec: &mpegts.CodecH264{}}
+
+		var dtsExtractor *h264.DTSExtractor
+
+		strm.AddReader(writer, videoMedia, videoFormatH264, func(u unit.Unit) error {
+			tunit := u.(*unit.H264)
+			if tunit.AU == nil {
+				return nil
+			}
+
+			randomAccess := h264.IDRPresent(tunit.AU)
+
+			if dtsExtractor == nil {
+				if !randomAccess {
+					return nil
+				}
+				dtsExtractor = h264.NewDTSExtractor()
+				c.videoStarted = true
+			}
+
+			dts, err := dtsExtractor.Extract(tunit.AU, tunit.PTS)
+			if err != nil {
+				return err
+			}
+
+			return c.flush(c.mw.WriteH264(track, durationGoToMPEGTS(tunit.PTS), durationGoToMPEGTS(dts), randomAccess, tunit.AU))
+		})
+
+		return track, videoFormatH264
+	}
+
+	return nil, nil
+}
+
+func (c *conn) setupAudio(strm *stream.Stream, writer *asyncwriter.Writer) (*mpegts.Track, format.Format) {
+	var audioFormatMPEG4Audio *format.MPEG4Audio
+	audioMedia := strm.Desc().FindFormat(&audioFormatMPEG4Audio)
+
+	if audioFormatMPEG4Audio != nil && audioFormatMPEG4Audio.GetConfig() != nil {
+		track := &mpegts.Track{Codec: &mpegts.CodecMPEG4Audio{Config: *audioFormatMPEG4Audio.GetConfig()}}
+
+		strm.AddReader(writer, audioMedia, audioFormatMPEG4Audio, func(u unit.Unit) error {
+			tunit := u.(*unit.MPEG4Audio)
+			if tunit.AUs == nil || (c.hasVideo && !c.videoStarted) {
+				return nil
+			}
+
+			return c.flush(c.mw.WriteMPEG4Audio(track, durationGoToMPEGTS(tunit.PTS), tunit.AUs))
+		})
+
+		return track, audioFormatMPEG4Audio
+	}
+
+	var audioFormatOpus *format.Opus
+	audioMedia = strm.Desc().FindFormat(&audioFormatOpus)
+
+	if audioFormatOpus != nil {
+		track := &mpegts.Track{Codec: &mpegts.CodecOpus{ChannelCount: audioFormatOpus.ChannelCount}}
+
+		strm.AddReader(writer, audioMedia, audioFormatOpus, func(u unit.Unit) error {
+			tunit := u.(*unit.Opus)
+			if tunit.Packets == nil || (c.hasVideo && !c.videoStarted) {
+				return nil
+			}
+
+			return c.flush(c.mw.WriteOpus(track, durationGoToMPEGTS(tunit.PTS), tunit.Packets))
+		})
+
+		return track, audioFormatOpus
+	}
+
+	return nil, nil
+}
+
+// APIReaderDescribe implements reader.
+func (c *conn) APIReaderDescribe() defs.APIPathSourceOrReader {
+	return defs.APIPathSourceOrReader{
+		Type: "srtConn",
+		ID:   c.uuid.String(),
+	}
+}
@@ -0,0 +1,99 @@
+// Package srt contains a SRT server, serving paths to readers as MPEG-TS.
+package srt
+
+import (
+	"context"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"sync"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// srtMaxPayloadSize returns the biggest multiple of MPEG-TS packet size that fits into an UDP packet with SRT header
+func srtMaxPayloadSize(udpMaxPayloadSize int) int {
+	return ((udpMaxPayloadSize - 16) / 188) * 188
+}
+
+type serverPathManager interface {
+	AddReader(req defs.PathAddReaderReq) (defs.Path, *stream.Stream, error)
+}
+
+// Server is a SRT server.
+// Paths are read with stream ID read:{path}, connections are encrypted with SRTReadPassphrase of path.
+type Server struct {
+	Address           string
+	ReadTimeout       conf.StringDuration
+	WriteQueueSize    int
+	UDPMaxPayloadSize int
+	PathManager       serverPathManager
+	Parent            logger.Writer
+
+	ctx       context.Context
+	ctxCancel func()
+	wg        sync.WaitGroup
+	ln        srt.Listener
+}
+
+// Initialize initializes Server.
+func (s *Server) Initialize() error {
+	config := srt.DefaultConfig()
+	config.ConnectionTimeout = time.Duration(s.ReadTimeout)
+	config.PayloadSize = uint32(srtMaxPayloadSize(s.UDPMaxPayloadSize))
+
+	ln, err := srt.Listen("srt", s.Address, config)
+	if err != nil {
+		return err
+	}
+
+	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
+	s.ln = ln
+
+	s.wg.Add(1)
+	go s.runAccept()
+
+	s.Log(logger.Info, "listener opened on %s (UDP)", s.Address)
+
+	return nil
+}
+
+// Close closes Server.
+func (s *Server) Close() {
+	s.Log(logger.Info, "listener is closing")
+
+	s.ctxCancel()
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+// Log implements logger.Writer.
+func (s *Server) Log(level logger.Level, format string, args ...interface{}) {
+	s.Parent.Log(level, "[SRT] "+format, args...)
+}
+
+func (s *Server) runAccept() {
+	defer s.wg.Done()
+
+	for {
+		req, err := s.ln.Accept2()
+		if err != nil {
+			return
+		}
+
+		c := &conn{
+			parentCtx:         s.ctx,
+			writeQueueSize:    s.WriteQueueSize,
+			udpMaxPayloadSize: s.UDPMaxPayloadSize,
+			req:               req,
+			pathManager:       s.PathManager,
+			parent:            s,
+		}
+		c.initialize()
+
+		s.wg.Add(1)
+		go c.run()
+	}
+}
@@ -0,0 +1,155 @@
+package srt
+
+import (
+	"bytes"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"fearpro13/h265_transcoder/mediamtx/unit"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+	srt "github.com/datarhei/gosrt"
+)
+
+const testSRTPassphrase = "passphrase1234"
+
+// dialRejection returns reason of connection rejection, gosrt formats it in base 32
+func dialRejection(t *testing.T, source string) srt.RejectionReason {
+	config := srt.DefaultConfig()
+	address, err := config.UnmarshalURL(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := srt.Dial("srt", address, config)
+	if err == nil {
+		conn.Close()
+		t.Fatalf("%s: connection is not rejected", source)
+	}
+
+	msg := err.Error()
+	i := strings.Index(msg, "REJECT (")
+	if i < 0 || !strings.HasSuffix(msg, ")") {
+		t.Fatalf("%s: unexpected error %v", source, err)
+	}
+
+	reason, err := strconv.ParseUint(msg[i+len("REJECT ("):len(msg)-1], 32, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return srt.RejectionReason(reason)
+}
+
+func TestServerRead(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{test.MediaH264}}
+
+	strm, err := stream.New(1472, desc, true, test.NilLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strm.Close()
+
+	pa := &test.Path{PathName: "cam1", Conf: &conf.Path{SRTReadPassphrase: testSRTPassphrase}}
+	pa.SetStream(strm)
+
+	addr := fmt.Sprintf("127.0.0.1:%d", test.FreeUDPPort(t))
+
+	s := &Server{
+		Address:           addr,
+		ReadTimeout:       conf.StringDuration(5 * time.Second),
+		WriteQueueSize:    512,
+		UDPMaxPayloadSize: 1472,
+		PathManager:       &test.PathManager{Path: pa},
+		Parent:            test.NilLogger,
+	}
+	err = s.Initialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	source := "srt://" + addr + "?streamid=read:cam1&passphrase=" + testSRTPassphrase
+
+	// wrong passphrase and missing path are refused
+	switch reason := dialRejection(t, "srt://"+addr+"?streamid=read:cam1&passphrase=wrongpassphrase"); reason {
+	case srt.REJ_BADSECRET, srt.REJ_UNSECURE, srt.REJX_UNAUTHORIZED:
+	default:
+		t.Fatalf("unexpected rejection of wrong passphrase: %v", reason)
+	}
+
+	if reason := dialRejection(t, "srt://"+addr+"?streamid=read:cam2&passphrase="+testSRTPassphrase); reason != srt.REJX_NOTFOUND {
+		t.Fatalf("unexpected rejection of missing path: %v", reason)
+	}
+
+	idr := append([]byte{0x65, 0x88, 0x84}, bytes.Repeat([]byte{0x01}, 5000)...)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(40 * time.Millisecond):
+			}
+
+			strm.WriteUnit(test.MediaH264, test.FormatH264, &unit.H264{
+				Base: unit.Base{
+					NTP: time.Now(),
+					PTS: time.Duration(i) * 40 * time.Millisecond,
+				},
+				AU: [][]byte{test.FormatH264.SPS, test.FormatH264.PPS, idr},
+			})
+		}
+	}()
+
+	config := srt.DefaultConfig()
+	address, err := config.UnmarshalURL(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := srt.Dial("srt", address, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r, err := mpegts.NewReader(mpegts.NewBufferedReader(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracks := r.Tracks()
+	if len(tracks) != 1 {
+		t.Fatalf("unexpected tracks: %v", tracks)
+	}
+	if _, ok := tracks[0].Codec.(*mpegts.CodecH264); !ok {
+		t.Fatalf("unexpected codec %T", tracks[0].Codec)
+	}
+
+	var au [][]byte
+	r.OnDataH264(tracks[0], func(_ int64, _ int64, a [][]byte) error {
+		au = a
+		return nil
+	})
+
+	for au == nil {
+		err = r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(au[len(au)-1], idr) {
+		t.Fatal("unexpected access unit")
+	}
+}
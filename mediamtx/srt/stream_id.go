@@ -0,0 +1,94 @@
+package srt
+
+import (
+	"fmt"
+	"strings"
+)
+
+type streamIDMode int
+
+const (
+	streamIDModeRead streamIDMode = iota
+	streamIDModePublish
+)
+
+// streamID is the stream ID of a SRT connection, in one of these formats:
+// read:{path}[:{user}:{pass}][:{query}]
+// #!::r={path},m=request,u={user},s={pass}
+type streamID struct {
+	mode  streamIDMode
+	path  string
+	query string
+	user  string
+	pass  string
+}
+
+func (s *streamID) unmarshal(raw string) error {
+	// standard syntax
+	// https://github.com/Haivision/srt/blob/master/docs/features/access-control.md
+	if strings.HasPrefix(raw, "#!::") {
+		for _, kv := range strings.Split(raw[len("#!::"):], ",") {
+			key, value, ok := strings.Cut(kv, "=")
+			if !ok {
+				return fmt.Errorf("invalid value (%v)", kv)
+			}
+
+			switch key {
+			case "u":
+				s.user = value
+
+			case "r":
+				s.path = value
+
+			case "s":
+				s.pass = value
+
+			case "m":
+				switch value {
+				case "request":
+					s.mode = streamIDModeRead
+
+				case "publish":
+					s.mode = streamIDModePublish
+
+				default:
+					return fmt.Errorf("unsupported mode '%s'", value)
+				}
+			}
+		}
+	} else {
+		parts := strings.Split(raw, ":")
+		if len(parts) < 2 || len(parts) > 5 {
+			return fmt.Errorf("stream ID can't be decoded")
+		}
+
+		switch parts[0] {
+		case "read":
+			s.mode = streamIDModeRead
+
+		case "publish":
+			s.mode = streamIDModePublish
+
+		default:
+			return fmt.Errorf("stream ID can't be decoded")
+		}
+
+		s.path = parts[1]
+
+		if len(parts) == 4 || len(parts) == 5 {
+			s.user, s.pass = parts[2], parts[3]
+		}
+
+		if len(parts) == 3 {
+			s.query = parts[2]
+		} else if len(parts) == 5 {
+			s.query = parts[4]
+		}
+	}
+
+	if s.path == "" {
+		return fmt.Errorf("path is missing")
+	}
+
+	return nil
+}
@@ -23,12 +23,14 @@ type ReadFunc func(unit.Unit) error
 type Stream struct {
 	desc *description.Session
 
-	bytesReceived *uint64
-	bytesSent     *uint64
-	smedias       map[*description.Media]*streamMedia
-	mutex         sync.RWMutex
-	rtspStream    *gortsplib.ServerStream
-	rtspsStream   *gortsplib.ServerStream
+	bytesReceived  *uint64
+	bytesSent      *uint64
+	rtpPacketsLost *uint64
+	decodeErrors   *uint64
+	smedias        map[*description.Media]*streamMedia
+	mutex          sync.RWMutex
+	rtspStream     *gortsplib.ServerStream
+	rtspsStream    *gortsplib.ServerStream
 }
 
 // New allocates a Stream.
@@ -39,9 +41,11 @@ func New(
 	decodeErrLogger logger.Writer,
 ) (*Stream, error) {
 	s := &Stream{
-		desc:          desc,
-		bytesReceived: new(uint64),
-		bytesSent:     new(uint64),
+		desc:           desc,
+		bytesReceived:  new(uint64),
+		bytesSent:      new(uint64),
+		rtpPacketsLost: new(uint64),
+		decodeErrors:   new(uint64),
 	}
 
 	s.smedias = make(map[*description.Media]*streamMedia)
@@ -92,6 +96,26 @@ func (s *Stream) BytesSent() uint64 {
 	return bytesSent
 }
 
+// RTPPacketsLost returns RTP packets lost by publishers.
+func (s *Stream) RTPPacketsLost() uint64 {
+	return atomic.LoadUint64(s.rtpPacketsLost)
+}
+
+// AddRTPPacketsLost is called by publishers when they detect lost RTP packets.
+func (s *Stream) AddRTPPacketsLost(n uint64) {
+	atomic.AddUint64(s.rtpPacketsLost, n)
+}
+
+// DecodeErrors returns decode errors of publishers.
+func (s *Stream) DecodeErrors() uint64 {
+	return atomic.LoadUint64(s.decodeErrors)
+}
+
+// AddDecodeError is called by publishers when they fail to decode a packet.
+func (s *Stream) AddDecodeError() {
+	atomic.AddUint64(s.decodeErrors, 1)
+}
+
 // RTSPStream returns the RTSP stream.
 func (s *Stream) RTSPStream(server *gortsplib.Server) *gortsplib.ServerStream {
 	s.mutex.Lock()
@@ -0,0 +1,162 @@
+package test
+
+import (
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/auth"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"fmt"
+	"sync"
+)
+
+// Path is a test path, stream of publisher is created by StartPublisher.
+type Path struct {
+	PathName string
+	// Conf defaults to empty configuration
+	Conf *conf.Path
+
+	mutex   sync.Mutex
+	stream  *stream.Stream
+	readers int
+}
+
+// Name implements defs.Path.
+func (p *Path) Name() string {
+	return p.PathName
+}
+
+// SafeConf implements defs.Path.
+func (p *Path) SafeConf() *conf.Path {
+	if p.Conf == nil {
+		return &conf.Path{}
+	}
+	return p.Conf
+}
+
+// StartPublisher implements defs.Path.
+func (p *Path) StartPublisher(req defs.PathStartPublisherReq) (*stream.Stream, error) {
+	strm, err := stream.New(1472, req.Desc, req.GenerateRTPPackets, NilLogger)
+	if err != nil {
+		return nil, err
+	}
+
+	p.SetStream(strm)
+
+	return strm, nil
+}
+
+// StopPublisher implements defs.Path.
+func (p *Path) StopPublisher(_ defs.PathStopPublisherReq) {
+}
+
+// RemovePublisher implements defs.Path.
+func (p *Path) RemovePublisher(_ defs.PathRemovePublisherReq) {
+}
+
+// RemoveReader implements defs.Path.
+func (p *Path) RemoveReader(_ defs.PathRemoveReaderReq) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.readers--
+}
+
+// SetStream sets stream served to readers.
+func (p *Path) SetStream(strm *stream.Stream) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stream = strm
+}
+
+// Stream returns stream served to readers, nil until it's published.
+func (p *Path) Stream() *stream.Stream {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.stream
+}
+
+// Readers returns count of readers which have not been removed.
+func (p *Path) Readers() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.readers
+}
+
+// PathManager is a test path manager of a single path, it records access requests.
+type PathManager struct {
+	Path *Path
+	// AuthFunc checks requests which do not skip authentication, nil accepts everything
+	AuthFunc func(req defs.PathAccessRequest) error
+
+	mutex    sync.Mutex
+	requests []defs.PathAccessRequest
+}
+
+func (pm *PathManager) access(req defs.PathAccessRequest) error {
+	pm.mutex.Lock()
+	pm.requests = append(pm.requests, req)
+	pm.mutex.Unlock()
+
+	if req.Name != pm.Path.PathName {
+		return fmt.Errorf("path '%s' is not configured", req.Name)
+	}
+
+	if !req.SkipAuth && pm.AuthFunc != nil {
+		err := pm.AuthFunc(req)
+		if err != nil {
+			return auth.Error{Message: err.Error()}
+		}
+	}
+
+	return nil
+}
+
+// Requests returns access requests received so far.
+func (pm *PathManager) Requests() []defs.PathAccessRequest {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	return append([]defs.PathAccessRequest(nil), pm.requests...)
+}
+
+// FindPathConf implements path manager.
+func (pm *PathManager) FindPathConf(req defs.PathFindPathConfReq) (*conf.Path, error) {
+	err := pm.access(req.AccessRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return pm.Path.SafeConf(), nil
+}
+
+// AddReader implements path manager, reading a missing path or a path without stream is refused as not published.
+func (pm *PathManager) AddReader(req defs.PathAddReaderReq) (defs.Path, *stream.Stream, error) {
+	err := pm.access(req.AccessRequest)
+	if err != nil {
+		var aerr auth.Error
+		if !errors.As(err, &aerr) {
+			return nil, nil, defs.PathNoOnePublishingError{PathName: req.AccessRequest.Name}
+		}
+		return nil, nil, err
+	}
+
+	pm.Path.mutex.Lock()
+	defer pm.Path.mutex.Unlock()
+
+	if pm.Path.stream == nil {
+		return nil, nil, defs.PathNoOnePublishingError{PathName: req.AccessRequest.Name}
+	}
+
+	pm.Path.readers++
+
+	return pm.Path, pm.Path.stream, nil
+}
+
+// AddPublisher implements path manager.
+func (pm *PathManager) AddPublisher(req defs.PathAddPublisherReq) (defs.Path, error) {
+	err := pm.access(req.AccessRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return pm.Path, nil
+}
@@ -0,0 +1,50 @@
+package test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+// FreeTCPPort returns a TCP port which is free at the moment.
+func FreeTCPPort(t testing.TB) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// FreeUDPPort returns a UDP port which is free at the moment.
+func FreeUDPPort(t testing.TB) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// FreeUDPPorts returns an even UDP port which is free together with the following one, as RTP and RTCP ports.
+func FreeUDPPorts(t testing.TB) int {
+	for i := 0; i < 100; i++ {
+		port := FreeUDPPort(t)
+		if port%2 != 0 {
+			continue
+		}
+
+		rtcp, err := net.ListenPacket("udp", fmt.Sprintf("127.0.0.1:%d", port+1))
+		if err != nil {
+			continue
+		}
+		rtcp.Close()
+
+		return port
+	}
+
+	t.Fatal("no free UDP ports")
+	return 0
+}
@@ -0,0 +1,7 @@
+package unit
+
+// MPEG4Audio is a MPEG-4 Audio data unit.
+type MPEG4Audio struct {
+	Base
+	AUs [][]byte
+}
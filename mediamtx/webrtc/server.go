@@ -0,0 +1,369 @@
+// Package webrtc contains a WebRTC server, serving paths to browsers with WHEP.
+package webrtc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/auth"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/ice/v4"
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// maximum size of SDP offers
+	offerMaxSize = 64 * 1024
+)
+
+type serverPathManager interface {
+	AddReader(req defs.PathAddReaderReq) (defs.Path, *stream.Stream, error)
+}
+
+// Server is a WebRTC server.
+// Sessions are negotiated with WHEP, media is exchanged over a single UDP port shared by every session.
+type Server struct {
+	Address     string
+	AllowOrigin string
+	// LocalUDPAddress is the address of ICE UDP mux
+	LocalUDPAddress string
+	// AdditionalHosts are IPs or hostnames advertised as host candidates besides IPs of interfaces
+	AdditionalHosts  []string
+	HandshakeTimeout conf.StringDuration
+	ReadTimeout      conf.StringDuration
+	WriteQueueSize   int
+	PathManager      serverPathManager
+	Parent           logger.Writer
+
+	ctx       context.Context
+	ctxCancel func()
+	wg        sync.WaitGroup
+	inner     *http.Server
+	udpMux    io.Closer
+	udpPort   int
+	api       *webrtc.API
+
+	mutex    sync.Mutex
+	sessions map[uuid.UUID]*session
+}
+
+// Initialize initializes Server.
+func (s *Server) Initialize() error {
+	udpConn, err := net.ListenPacket("udp", s.LocalUDPAddress)
+	if err != nil {
+		return err
+	}
+
+	udpMux := webrtc.NewICEUDPMux(nil, udpConn)
+
+	api, err := s.newAPI(udpMux)
+	if err != nil {
+		udpMux.Close()
+		return err
+	}
+
+	ln, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		udpMux.Close()
+		return err
+	}
+
+	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
+	s.udpMux = udpMux
+	s.udpPort = udpConn.LocalAddr().(*net.UDPAddr).Port
+	s.api = api
+	s.sessions = make(map[uuid.UUID]*session)
+
+	s.inner = &http.Server{
+		Handler:           http.HandlerFunc(s.onRequest),
+		ReadHeaderTimeout: time.Duration(s.ReadTimeout),
+		ErrorLog:          log.New(io.Discard, "", 0),
+	}
+
+	go s.inner.Serve(ln) //nolint:errcheck
+
+	s.Log(logger.Info, "listener opened on %s (HTTP), %s (ICE/UDP)", s.Address, s.LocalUDPAddress)
+
+	return nil
+}
+
+// newAPI returns an API negotiating H264, Opus and G711, with ICE restricted to host candidates of UDP mux
+func (s *Server) newAPI(udpMux ice.UDPMux) (*webrtc.API, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+
+	for _, codec := range []struct {
+		params webrtc.RTPCodecParameters
+		typ    webrtc.RTPCodecType
+	}{
+		{
+			params: webrtc.RTPCodecParameters{
+				RTPCodecCapability: videoCapabilityH264,
+				PayloadType:        105,
+			},
+			typ: webrtc.RTPCodecTypeVideo,
+		},
+		{
+			params: webrtc.RTPCodecParameters{
+				RTPCodecCapability: audioCapabilityOpus,
+				PayloadType:        111,
+			},
+			typ: webrtc.RTPCodecTypeAudio,
+		},
+		{
+			params: webrtc.RTPCodecParameters{
+				RTPCodecCapability: audioCapabilityPCMU,
+				PayloadType:        0,
+			},
+			typ: webrtc.RTPCodecTypeAudio,
+		},
+		{
+			params: webrtc.RTPCodecParameters{
+				RTPCodecCapability: audioCapabilityPCMA,
+				PayloadType:        8,
+			},
+			typ: webrtc.RTPCodecTypeAudio,
+		},
+	} {
+		err := mediaEngine.RegisterCodec(codec.params, codec.typ)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// NACK responder and RTCP reports
+	interceptorRegistry := &interceptor.Registry{}
+	err := webrtc.RegisterDefaultInterceptors(mediaEngine, interceptorRegistry)
+	if err != nil {
+		return nil, err
+	}
+
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.SetICEUDPMux(udpMux)
+	settingEngine.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6})
+
+	return webrtc.NewAPI(
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
+		webrtc.WithSettingEngine(settingEngine),
+	), nil
+}
+
+// Close closes Server.
+func (s *Server) Close() {
+	s.Log(logger.Info, "listener is closing")
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ctxCancel()
+
+	_ = s.inner.Shutdown(ctx)
+
+	s.ctxCancel()
+	s.wg.Wait()
+
+	s.udpMux.Close()
+}
+
+// Log implements logger.Writer.
+func (s *Server) Log(level logger.Level, format string, args ...interface{}) {
+	s.Parent.Log(level, "[WebRTC] "+format, args...)
+}
+
+func (s *Server) onRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", s.AllowOrigin)
+	// browsers reject credentials allowed for any origin
+	if s.AllowOrigin != "*" {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, POST, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+		w.Header().Set("Access-Control-Expose-Headers", "Location")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// path name could contain slashes, i.e. {id}/whep, {id}/720p/whep, {id}/whep/{session}
+	pa := strings.TrimPrefix(r.URL.Path, "/")
+
+	if pathName, ok := strings.CutSuffix(pa, "/whep"); ok && pathName != "" {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		s.onWHEPPost(w, r, pathName)
+		return
+	}
+
+	if i := strings.LastIndex(pa, "/whep/"); i > 0 {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		s.onWHEPDelete(w, pa[:i], pa[i+len("/whep/"):])
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func (s *Server) onWHEPPost(w http.ResponseWriter, r *http.Request, pathName string) {
+	if r.Header.Get("Content-Type") != "application/sdp" {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid Content-Type"))
+		return
+	}
+
+	offer, err := io.ReadAll(io.LimitReader(r.Body, offerMaxSize))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, pass, hasCredentials := r.BasicAuth()
+
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	sess := &session{
+		parentCtx:        s.ctx,
+		api:              s.api,
+		handshakeTimeout: s.HandshakeTimeout,
+		writeQueueSize:   s.WriteQueueSize,
+		additionalHosts:  s.additionalHostCandidates(),
+		pathName:         pathName,
+		remoteAddr:       r.RemoteAddr,
+		secret:           uuid.New(),
+		parent:           s,
+	}
+	sess.initialize()
+
+	path, strm, err := s.PathManager.AddReader(defs.PathAddReaderReq{
+		Author: sess,
+		AccessRequest: defs.PathAccessRequest{
+			Name:  pathName,
+			Query: r.URL.RawQuery,
+			IP:    net.ParseIP(host),
+			User:  user,
+			Pass:  pass,
+			Proto: auth.ProtocolWebRTC,
+			ID:    &sess.secret,
+		},
+	})
+	if err != nil {
+		sess.Close()
+
+		var terr auth.Error
+		if errors.As(err, &terr) {
+			if !hasCredentials {
+				w.Header().Set("WWW-Authenticate", `Basic realm="h265_decoder"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			s.Log(logger.Info, "connection %v failed to authenticate: %v", r.RemoteAddr, terr.Message)
+
+			// wait some seconds to mitigate brute force attacks
+			<-time.After(auth.PauseAfterError)
+
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	answer, err := sess.start(path, strm, string(offer))
+	if err != nil {
+		sess.Close()
+		path.RemoveReader(defs.PathRemoveReaderReq{Author: sess})
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mutex.Lock()
+	s.sessions[sess.secret] = sess
+	s.mutex.Unlock()
+
+	s.wg.Add(1)
+	go sess.run()
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/"+pathName+"/whep/"+sess.secret.String())
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte(answer))
+}
+
+func (s *Server) onWHEPDelete(w http.ResponseWriter, pathName string, secret string) {
+	id, err := uuid.Parse(secret)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid session"))
+		return
+	}
+
+	s.mutex.Lock()
+	sess, ok := s.sessions[id]
+	s.mutex.Unlock()
+
+	if !ok || sess.pathName != pathName {
+		s.writeError(w, http.StatusNotFound, fmt.Errorf("session not found"))
+		return
+	}
+
+	sess.Close()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// closeSession is called by session.
+func (s *Server) closeSession(sess *session) {
+	s.mutex.Lock()
+	delete(s.sessions, sess.secret)
+	s.mutex.Unlock()
+}
+
+// additionalHostCandidates returns IPs of additional hosts, hostnames are resolved on every session
+func (s *Server) additionalHostCandidates() []string {
+	var ips []string
+
+	for _, host := range s.AdditionalHosts {
+		if net.ParseIP(host) != nil {
+			ips = append(ips, host)
+			continue
+		}
+
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			s.Log(logger.Warn, "unable to resolve additional host '%s': %v", host, err)
+			continue
+		}
+
+		ips = append(ips, addrs...)
+	}
+
+	return ips
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"message": err.Error(),
+	})
+}
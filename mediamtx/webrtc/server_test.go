@@ -0,0 +1,200 @@
+package webrtc
+
+import (
+	"bytes"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph264"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestServerWHEP(t *testing.T) {
+	formatG711 := &format.G711{PayloadTyp: 0, MULaw: true, SampleRate: 8000, ChannelCount: 1}
+	mediaG711 := &description.Media{Type: description.MediaTypeAudio, Formats: []format.Format{formatG711}}
+
+	desc := &description.Session{Medias: []*description.Media{test.MediaH264, mediaG711}}
+
+	strm, err := stream.New(1472, desc, false, test.NilLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strm.Close()
+
+	pa := &test.Path{PathName: "cam1"}
+	pa.SetStream(strm)
+
+	addr := fmt.Sprintf("127.0.0.1:%d", test.FreeTCPPort(t))
+
+	s := &Server{
+		Address:          addr,
+		AllowOrigin:      "*",
+		LocalUDPAddress:  ":0",
+		AdditionalHosts:  []string{"127.0.0.1"},
+		HandshakeTimeout: conf.StringDuration(10 * time.Second),
+		ReadTimeout:      conf.StringDuration(5 * time.Second),
+		WriteQueueSize:   512,
+		PathManager:      &test.PathManager{Path: pa},
+		Parent:           test.NilLogger,
+	}
+	err = s.Initialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// loopback client, acting as a browser
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.SetIncludeLoopbackCandidate(true)
+	settingEngine.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+
+	mediaEngine := &webrtc.MediaEngine{}
+	err = mediaEngine.RegisterDefaultCodecs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithSettingEngine(settingEngine))
+
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close() //nolint:errcheck
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		_, err = pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	received := make(chan string, 2)
+
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		_, _, err := track.ReadRTP()
+		if err == nil {
+			received <- track.Codec().MimeType
+		}
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gatheringDone := webrtc.GatheringCompletePromise(pc)
+	err = pc.SetLocalDescription(offer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-gatheringDone
+
+	res, err := http.Post("http://"+addr+"/cam2/whep", "application/sdp",
+		strings.NewReader(pc.LocalDescription().SDP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status of missing path: %d", res.StatusCode)
+	}
+
+	res, err = http.Post("http://"+addr+"/cam1/whep", "application/sdp",
+		strings.NewReader(pc.LocalDescription().SDP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status: %d, %s", res.StatusCode, answer)
+	}
+	location := res.Header.Get("Location")
+	if !strings.HasPrefix(location, "/cam1/whep/") {
+		t.Fatalf("unexpected location: %s", location)
+	}
+
+	err = pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(answer)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// packets are written until both tracks are received, as a RTSP publisher does
+	encoder := &rtph264.Encoder{PayloadType: 96, PacketizationMode: 1, PayloadMaxSize: 1400}
+	err = encoder.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			pts := time.Duration(i) * 20 * time.Millisecond
+
+			pkts, _ := encoder.Encode([][]byte{
+				test.FormatH264.SPS,
+				test.FormatH264.PPS,
+				append([]byte{0x65}, bytes.Repeat([]byte{0x01}, 3000)...), // IDR, bigger than MTU
+			})
+			for _, pkt := range pkts {
+				pkt.Timestamp = uint32(i * 1800)
+				strm.WriteRTPPacket(test.MediaH264, test.FormatH264, pkt, time.Now(), pts)
+			}
+
+			strm.WriteRTPPacket(mediaG711, formatG711, &rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					PayloadType:    0,
+					SequenceNumber: uint16(i),
+					Timestamp:      uint32(i * 160),
+					SSRC:           1234,
+				},
+				Payload: bytes.Repeat([]byte{0xff}, 160),
+			}, time.Now(), pts)
+		}
+	}()
+
+	mimeTypes := map[string]bool{}
+	for len(mimeTypes) < 2 {
+		select {
+		case mimeType := <-received:
+			mimeTypes[mimeType] = true
+		case <-time.After(10 * time.Second):
+			t.Fatalf("tracks not received, got %v", mimeTypes)
+		}
+	}
+
+	if !mimeTypes[webrtc.MimeTypeH264] || !mimeTypes[webrtc.MimeTypePCMU] {
+		t.Fatalf("unexpected tracks: %v", mimeTypes)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, "http://"+addr+location, nil)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status of delete: %d", res.StatusCode)
+	}
+}
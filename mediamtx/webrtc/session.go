@@ -0,0 +1,414 @@
+package webrtc
+
+import (
+	"context"
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/asyncwriter"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/defs"
+	"fearpro13/h265_transcoder/mediamtx/logger"
+	"fearpro13/h265_transcoder/mediamtx/stream"
+	"fearpro13/h265_transcoder/mediamtx/unit"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// 1200 (safe MTU of WebRTC) - 12 (RTP header)
+	payloadMaxSize = 1188
+)
+
+var (
+	videoCapabilityH264 = webrtc.RTPCodecCapability{
+		MimeType:    webrtc.MimeTypeH264,
+		ClockRate:   90000,
+		SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+	}
+	audioCapabilityOpus = webrtc.RTPCodecCapability{
+		MimeType:    webrtc.MimeTypeOpus,
+		ClockRate:   48000,
+		Channels:    2,
+		SDPFmtpLine: "minptime=10;useinbandfec=1",
+	}
+	audioCapabilityPCMU = webrtc.RTPCodecCapability{
+		MimeType:  webrtc.MimeTypePCMU,
+		ClockRate: 8000,
+	}
+	audioCapabilityPCMA = webrtc.RTPCodecCapability{
+		MimeType:  webrtc.MimeTypePCMA,
+		ClockRate: 8000,
+	}
+)
+
+func durationGoToRTP(v time.Duration, clockRate int) uint32 {
+	clockRate64 := int64(clockRate)
+	secs := int64(v / time.Second)
+	dec := int64(v % time.Second)
+	return uint32(secs*clockRate64 + dec*clockRate64/int64(time.Second))
+}
+
+// outgoingTrack is a track sent to peer, fed by a format of stream
+type outgoingTrack struct {
+	media  *description.Media
+	format format.Format
+	track  *webrtc.TrackLocalStaticRTP
+	onUnit func(u unit.Unit) error
+}
+
+type session struct {
+	parentCtx        context.Context
+	api              *webrtc.API
+	handshakeTimeout conf.StringDuration
+	writeQueueSize   int
+	additionalHosts  []string
+	pathName         string
+	remoteAddr       string
+	secret           uuid.UUID
+	parent           *Server
+
+	ctx       context.Context
+	ctxCancel func()
+	path      defs.Path
+	stream    *stream.Stream
+	pc        *webrtc.PeerConnection
+	tracks    []*outgoingTrack
+	connected chan struct{}
+	failed    chan struct{}
+}
+
+func (s *session) initialize() {
+	s.ctx, s.ctxCancel = context.WithCancel(s.parentCtx)
+}
+
+// start creates the peer connection and returns the SDP answer to offer
+func (s *session) start(path defs.Path, strm *stream.Stream, offer string) (string, error) {
+	s.path = path
+	s.stream = strm
+
+	s.tracks = append(s.setupVideo(), s.setupAudio()...)
+	if s.tracks == nil {
+		return "", fmt.Errorf(
+			"the stream doesn't contain any supported codec, which are currently H264, Opus, G711")
+	}
+
+	pc, err := s.api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return "", err
+	}
+	s.pc = pc
+
+	answer, err := s.negotiate(offer)
+	if err != nil {
+		pc.Close() //nolint:errcheck
+		return "", err
+	}
+
+	return answer, nil
+}
+
+func (s *session) negotiate(offer string) (string, error) {
+	err := s.pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offer,
+	})
+	if err != nil {
+		return "", fmt.Errorf("invalid offer: %w", err)
+	}
+
+	for _, t := range s.tracks {
+		sender, err := s.pc.AddTrack(t.track)
+		if err != nil {
+			return "", err
+		}
+
+		// RTCP must be read for interceptors to work
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				if _, _, err := sender.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	s.connected = make(chan struct{})
+	s.failed = make(chan struct{})
+
+	var once sync.Once
+
+	s.pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			select {
+			case <-s.connected:
+			default:
+				close(s.connected)
+			}
+
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			once.Do(func() { close(s.failed) })
+		}
+	})
+
+	answer, err := s.pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+
+	// candidates are sent within the answer, since trickle ICE is not supported
+	gatheringDone := webrtc.GatheringCompletePromise(s.pc)
+
+	err = s.pc.SetLocalDescription(answer)
+	if err != nil {
+		return "", err
+	}
+
+	select {
+	case <-gatheringDone:
+	case <-time.After(time.Duration(s.handshakeTimeout)):
+		return "", fmt.Errorf("deadline exceeded while gathering candidates")
+	}
+
+	sent := 0
+	for _, tr := range s.pc.GetTransceivers() {
+		// tracks without mid are not part of answer
+		if tr.Mid() != "" && tr.Sender() != nil && tr.Sender().Track() != nil {
+			sent++
+		}
+	}
+	if sent == 0 {
+		return "", fmt.Errorf("offer doesn't accept any of the stream tracks")
+	}
+
+	return s.addHostCandidates(s.pc.LocalDescription().SDP), nil
+}
+
+// addHostCandidates appends host candidates of additional hosts to every media of answer
+func (s *session) addHostCandidates(answer string) string {
+	if len(s.additionalHosts) == 0 {
+		return answer
+	}
+
+	var candidates strings.Builder
+	for i, ip := range s.additionalHosts {
+		fmt.Fprintf(&candidates, "a=candidate:%d 1 udp %d %s %d typ host\r\n",
+			1000+i, 2130706431-i, ip, s.parent.udpPort)
+	}
+
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(answer, "\r\n") {
+		if line == "a=end-of-candidates\r\n" {
+			b.WriteString(candidates.String())
+		}
+		b.WriteString(line)
+	}
+
+	return b.String()
+}
+
+func (s *session) setupVideo() []*outgoingTrack {
+	var videoFormatH264 *format.H264
+	videoMedia := s.stream.Desc().FindFormat(&videoFormatH264)
+
+	if videoFormatH264 == nil {
+		return nil
+	}
+
+	track, err := webrtc.NewTrackLocalStaticRTP(videoCapabilityH264, "video", "h265_transcoder")
+	if err != nil {
+		return nil
+	}
+
+	encoder := &rtph264.Encoder{
+		PayloadType:       105,
+		PayloadMaxSize:    payloadMaxSize,
+		PacketizationMode: 1,
+	}
+	err = encoder.Init()
+	if err != nil {
+		return nil
+	}
+
+	// packets of publisher could be bigger than MTU of WebRTC, therefore access units are packetized again
+	firstReceived := false
+
+	return []*outgoingTrack{{
+		media:  videoMedia,
+		format: videoFormatH264,
+		track:  track,
+		onUnit: func(u unit.Unit) error {
+			tunit := u.(*unit.H264)
+
+			if tunit.AU == nil {
+				return nil
+			}
+
+			if !firstReceived {
+				if !h264.IDRPresent(tunit.AU) {
+					return nil
+				}
+				firstReceived = true
+			}
+
+			pkts, err := encoder.Encode(tunit.AU)
+			if err != nil {
+				return nil //nolint:nilerr
+			}
+
+			ts := durationGoToRTP(tunit.PTS, videoFormatH264.ClockRate())
+
+			for _, pkt := range pkts {
+				pkt.Timestamp = ts
+				track.WriteRTP(pkt) //nolint:errcheck
+			}
+
+			return nil
+		},
+	}}
+}
+
+func (s *session) setupAudio() []*outgoingTrack {
+	var audioFormatOpus *format.Opus
+	audioMedia := s.stream.Desc().FindFormat(&audioFormatOpus)
+
+	if audioFormatOpus != nil {
+		return s.setupAudioTrack(audioMedia, audioFormatOpus, audioCapabilityOpus)
+	}
+
+	var audioFormatG711 *format.G711
+	audioMedia = s.stream.Desc().FindFormat(&audioFormatG711)
+
+	// WebRTC supports 8 kHz mono G711 only
+	if audioFormatG711 != nil && audioFormatG711.SampleRate == 8000 && audioFormatG711.ChannelCount == 1 {
+		if audioFormatG711.MULaw {
+			return s.setupAudioTrack(audioMedia, audioFormatG711, audioCapabilityPCMU)
+		}
+		return s.setupAudioTrack(audioMedia, audioFormatG711, audioCapabilityPCMA)
+	}
+
+	return nil
+}
+
+// setupAudioTrack returns a track forwarding packets of publisher, which are small enough for WebRTC
+func (s *session) setupAudioTrack(
+	medi *description.Media,
+	forma format.Format,
+	capability webrtc.RTPCodecCapability,
+) []*outgoingTrack {
+	track, err := webrtc.NewTrackLocalStaticRTP(capability, "audio", "h265_transcoder")
+	if err != nil {
+		return nil
+	}
+
+	return []*outgoingTrack{{
+		media:  medi,
+		format: forma,
+		track:  track,
+		onUnit: func(u unit.Unit) error {
+			for _, pkt := range u.GetRTPPackets() {
+				track.WriteRTP(pkt) //nolint:errcheck
+			}
+			return nil
+		},
+	}}
+}
+
+// Close closes session.
+func (s *session) Close() {
+	s.ctxCancel()
+}
+
+// Log implements logger.Writer.
+func (s *session) Log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[session %s] "+format, append([]interface{}{s.secret.String()[:8]}, args...)...)
+}
+
+func (s *session) run() {
+	defer s.parent.wg.Done()
+
+	err := s.runInner()
+
+	s.ctxCancel()
+
+	s.pc.Close() //nolint:errcheck
+	s.path.RemoveReader(defs.PathRemoveReaderReq{Author: s})
+	s.parent.closeSession(s)
+
+	s.Log(logger.Info, "closed: %v", err)
+}
+
+func (s *session) runInner() error {
+	select {
+	case <-s.connected:
+	case <-s.failed:
+		return errors.New("peer connection failed")
+	case <-time.After(time.Duration(s.handshakeTimeout)):
+		return errors.New("deadline exceeded while waiting connection")
+	case <-s.ctx.Done():
+		return errors.New("terminated")
+	}
+
+	writer := asyncwriter.New(s.writeQueueSize, s)
+
+	var formats []format.Format
+	for _, t := range s.tracks {
+		s.stream.AddReader(writer, t.media, t.format, t.onUnit)
+		formats = append(formats, t.format)
+	}
+
+	s.Log(logger.Info, "created by %s, is reading from path '%s', %s",
+		s.remoteAddr, s.pathName, mediaInfo(formats))
+
+	writer.Start()
+
+	select {
+	case err := <-writer.Error():
+		s.stream.RemoveReader(writer)
+		return err
+
+	case <-s.failed:
+		s.stream.RemoveReader(writer)
+		writer.Stop()
+		return errors.New("peer connection closed")
+
+	case <-s.ctx.Done():
+		s.stream.RemoveReader(writer)
+		writer.Stop()
+		return errors.New("terminated")
+	}
+}
+
+// APIReaderDescribe implements reader.
+func (s *session) APIReaderDescribe() defs.APIPathSourceOrReader {
+	return defs.APIPathSourceOrReader{
+		Type: "webRTCSession",
+		ID:   s.secret.String(),
+	}
+}
+
+func mediaInfo(formats []format.Format) string {
+	var names []string
+	for _, forma := range formats {
+		names = append(names, forma.Codec())
+	}
+
+	return fmt.Sprintf("%d %s (%s)",
+		len(names),
+		func() string {
+			if len(names) == 1 {
+				return "track"
+			}
+			return "tracks"
+		}(),
+		strings.Join(names, ", "))
+}
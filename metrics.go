@@ -0,0 +1,166 @@
+package h265_transcoder
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricFamily is a Prometheus metric with its samples
+type metricFamily struct {
+	name    string
+	typ     string
+	help    string
+	samples []metricSample
+}
+
+type metricSample struct {
+	labels []string // label name and value pairs
+	value  float64
+}
+
+func (f *metricFamily) add(value float64, labels ...string) {
+	f.samples = append(f.samples, metricSample{labels: labels, value: value})
+}
+
+// writeMetrics writes families in Prometheus text exposition format, families without samples are skipped
+func writeMetrics(w io.Writer, families []*metricFamily) error {
+	var b strings.Builder
+
+	for _, f := range families {
+		if len(f.samples) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.typ)
+
+		for _, s := range f.samples {
+			b.WriteString(f.name)
+
+			if len(s.labels) > 0 {
+				b.WriteByte('{')
+				for i := 0; i+1 < len(s.labels); i += 2 {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", s.labels[i], escapeLabelValue(s.labels[i+1]))
+				}
+				b.WriteByte('}')
+			}
+
+			b.WriteByte(' ')
+			b.WriteString(strconv.FormatFloat(s.value, 'f', -1, 64))
+			b.WriteByte('\n')
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+// writeMetrics writes metrics of every unit, its ffmpeg process and its paths
+func (instance *Instance) writeMetrics(w io.Writer) error {
+	var (
+		status       = &metricFamily{name: "h265_unit_status", typ: "gauge", help: "Unit status, 1 for the current one."}
+		restarts     = &metricFamily{name: "h265_unit_restarts_total", typ: "counter", help: "Unit transcoder restarts."}
+		uptime       = &metricFamily{name: "h265_unit_uptime_seconds", typ: "gauge", help: "Time since unit transcoder start, 0 when it's not running."}
+		fps          = &metricFamily{name: "h265_ffmpeg_fps", typ: "gauge", help: "Frames per second reported by ffmpeg."}
+		speed        = &metricFamily{name: "h265_ffmpeg_speed", typ: "gauge", help: "Encoding speed reported by ffmpeg, below 1 when unit falls behind realtime."}
+		bitrate      = &metricFamily{name: "h265_ffmpeg_bitrate_bits_per_second", typ: "gauge", help: "Output bitrate reported by ffmpeg."}
+		cpu          = &metricFamily{name: "h265_ffmpeg_cpu_seconds_total", typ: "counter", help: "User and system cpu time of ffmpeg process."}
+		rss          = &metricFamily{name: "h265_ffmpeg_resident_memory_bytes", typ: "gauge", help: "Resident memory size of ffmpeg process."}
+		ready        = &metricFamily{name: "h265_path_ready", typ: "gauge", help: "Whether path is published."}
+		received     = &metricFamily{name: "h265_path_bytes_received_total", typ: "counter", help: "Bytes received from path publisher."}
+		sent         = &metricFamily{name: "h265_path_bytes_sent_total", typ: "counter", help: "Bytes sent to path readers."}
+		readers      = &metricFamily{name: "h265_path_readers", typ: "gauge", help: "Path readers."}
+		packetsLost  = &metricFamily{name: "h265_path_rtp_packets_lost_total", typ: "counter", help: "RTP packets lost by RTSP publisher of path."}
+		decodeErrors = &metricFamily{name: "h265_path_decode_errors_total", typ: "counter", help: "Packets RTSP publisher of path failed to decode."}
+	)
+
+	for _, u := range instance.unitsList() {
+		paths := []string{u.id}
+		for _, r := range u.path.renditions {
+			paths = append(paths, renditionPath(u.id, r.name))
+		}
+		if u.ingests() {
+			paths = append(paths, ingestPath(u.id))
+		}
+
+		for _, p := range paths {
+			stats, exist := instance.rtspHandler.PathStats(p)
+			if !exist {
+				continue
+			}
+
+			ready.add(boolMetric(stats.Ready), "id", u.id, "path", p)
+			received.add(float64(stats.BytesReceived), "id", u.id, "path", p)
+			sent.add(float64(stats.BytesSent), "id", u.id, "path", p)
+			readers.add(float64(len(stats.Readers)), "id", u.id, "path", p)
+			packetsLost.add(float64(stats.RTPPacketsLost), "id", u.id, "path", p)
+			decodeErrors.add(float64(stats.DecodeErrors), "id", u.id, "path", p)
+		}
+
+		instance.m.Lock()
+		t, te := instance.transcoders[u.id]
+		h, he := instance.health[u.id]
+
+		unitStatus := StatusStopped
+		if te && t != nil {
+			unitStatus = t.Status()
+		} else if u.conf.OnDemand {
+			unitStatus = StatusIdle
+		}
+
+		var unitUptime time.Duration
+		if he {
+			if h.terminal != "" {
+				unitStatus = h.terminal
+			}
+			if unitStatus == StatusOk {
+				unitUptime = time.Since(h.started)
+			}
+			restarts.add(float64(h.restarts), "id", u.id)
+		}
+		instance.m.Unlock()
+
+		status.add(1, "id", u.id, "status", unitStatus)
+		uptime.add(unitUptime.Seconds(), "id", u.id)
+
+		if !te || t == nil {
+			continue
+		}
+
+		if p := t.Progress(); p != nil {
+			fps.add(p.FPS, "id", u.id)
+			speed.add(p.Speed, "id", u.id)
+			bitrate.add(p.Bitrate*1000, "id", u.id)
+		}
+
+		if ps, ok := t.ProcessStats(); ok {
+			cpu.add(ps.CPUSeconds, "id", u.id)
+			rss.add(float64(ps.RSSBytes), "id", u.id)
+		}
+	}
+
+	return writeMetrics(w, []*metricFamily{
+		status, restarts, uptime,
+		fps, speed, bitrate, cpu, rss,
+		ready, received, sent, readers, packetsLost, decodeErrors,
+	})
+}
+
+func boolMetric(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package h265_transcoder
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	status := &metricFamily{name: "h265_unit_status", typ: "gauge", help: "Unit status."}
+	status.add(1, "id", `cam"1`, "status", "ok")
+	status.add(1, "id", "cam2", "status", "error")
+
+	empty := &metricFamily{name: "h265_ffmpeg_fps", typ: "gauge", help: "Frames per second."}
+
+	restarts := &metricFamily{name: "h265_unit_restarts_total", typ: "counter", help: "Restarts."}
+	restarts.add(1234567, "id", "cam1")
+
+	var b strings.Builder
+	err := writeMetrics(&b, []*metricFamily{status, empty, restarts})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "# HELP h265_unit_status Unit status.\n" +
+		"# TYPE h265_unit_status gauge\n" +
+		"h265_unit_status{id=\"cam\\\"1\",status=\"ok\"} 1\n" +
+		"h265_unit_status{id=\"cam2\",status=\"error\"} 1\n" +
+		"# HELP h265_unit_restarts_total Restarts.\n" +
+		"# TYPE h265_unit_restarts_total counter\n" +
+		"h265_unit_restarts_total{id=\"cam1\"} 1234567\n"
+
+	if b.String() != expected {
+		t.Fatalf("unexpected metrics:\n%s", b.String())
+	}
+}
+
+func TestReadProcessStats(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("procfs is available on Linux only")
+	}
+
+	stats, err := readProcessStats(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.RSSBytes == 0 {
+		t.Fatal("resident memory size is not read")
+	}
+
+	_, err = readProcessStats(-1)
+	if err == nil {
+		t.Fatal("expected error for missing process")
+	}
+}
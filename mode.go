@@ -0,0 +1,22 @@
+package h265_transcoder
+
+import "fmt"
+
+// VideoMode defines whether unit video is re-encoded by profile or copied from source as is
+type VideoMode string
+
+const (
+	// ModeAuto copies video if source is already H.264 and profile keeps source picture
+	ModeAuto      VideoMode = "auto"
+	ModeTranscode VideoMode = "transcode"
+	ModeCopy      VideoMode = "copy"
+)
+
+func (m VideoMode) Validate() error {
+	switch m {
+	case "", ModeAuto, ModeTranscode, ModeCopy:
+		return nil
+	default:
+		return fmt.Errorf("unknown unit mode '%s'", m)
+	}
+}
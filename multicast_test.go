@@ -0,0 +1,167 @@
+package h265_transcoder
+
+import (
+	"context"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/core"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+)
+
+// lanIP returns IPv4 address of a non-loopback interface, multicast stream can't have loopback source
+func lanIP(t *testing.T) string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+	}
+
+	t.Skip("no LAN address")
+	return ""
+}
+
+func TestMulticast(t *testing.T) {
+	port := test.FreeTCPPort(t)
+	udpPort := test.FreeUDPPorts(t)
+	multicastPort := test.FreeUDPPorts(t)
+
+	h := core.NewRtspHandler(context.Background(), uint16(port), true)
+	h.UDP = core.UDPOptions{
+		RTPAddress:        fmt.Sprintf(":%d", udpPort),
+		RTCPAddress:       fmt.Sprintf(":%d", udpPort+1),
+		MulticastIPRange:  "239.255.42.0/24",
+		MulticastRTPPort:  multicastPort,
+		MulticastRTCPPort: multicastPort + 1,
+	}
+
+	err := h.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	err = h.AddPath("cam1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub := gortsplib.Client{}
+	err = pub.StartRecording(fmt.Sprintf("rtsp://%s@127.0.0.1:%d/cam1", setUnitUser(h, "cam1"), port), &description.Session{Medias: []*description.Media{test.MediaH264}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+
+	u, err := base.ParseURL(fmt.Sprintf("rtsp://%s:%d/cam1", lanIP(t), port))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(transport gortsplib.Transport) *gortsplib.Client {
+		c := &gortsplib.Client{Transport: &transport}
+
+		err := c.Start(u.Scheme, u.Host)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		desc, _, err := c.Describe(u)
+		if err != nil {
+			c.Close()
+			t.Fatal(err)
+		}
+
+		err = c.SetupAll(desc.BaseURL, desc.Medias)
+		if err != nil {
+			c.Close()
+			t.Skipf("multicast is not available: %v", err)
+		}
+
+		_, err = c.Play(nil)
+		if err != nil {
+			c.Close()
+			t.Fatal(err)
+		}
+
+		return c
+	}
+
+	mc := read(gortsplib.TransportUDPMulticast)
+	defer mc.Close()
+
+	tc := read(gortsplib.TransportTCP)
+	defer tc.Close()
+
+	transports := map[string]int{}
+	for _, r := range h.PathReaders("cam1") {
+		transports[*r.Transport]++
+	}
+
+	if transports["UDP-multicast"] != 1 || transports["TCP"] != 1 || len(transports) != 2 {
+		t.Fatalf("unexpected readers %v", transports)
+	}
+
+	if len(h.PathReaders("cam2")) != 0 {
+		t.Fatal("readers of another path are reported")
+	}
+}
+
+func TestMulticastConfig(t *testing.T) {
+	cfg := Config{RTPAddress: ":6512", RTCPAddress: ":6513", Multicast: true, MulticastIPRange: "224.1.0.0/16", MulticastRTPPort: 8002, MulticastRTCPPort: 8003}
+
+	err := cfg.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts := cfg.UDPOptions(); opts.MulticastIPRange != "224.1.0.0/16" || opts.RTPAddress != ":6512" {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+
+	cfg.MulticastIPRange = "10.0.0.0/8"
+	if cfg.Validate() == nil {
+		t.Fatal("unicast range must be rejected")
+	}
+
+	cfg.MulticastIPRange = "224.1.0.0/16"
+	cfg.MulticastRTCPPort = 8004
+	if cfg.Validate() == nil {
+		t.Fatal("non-consecutive multicast ports must be rejected")
+	}
+
+	cfg.MulticastRTCPPort = 8003
+	cfg.Encryption = conf.EncryptionStrict
+	cfg.RTSPSPort = 9322
+	cfg.ServerCert = "server.crt"
+	cfg.ServerKey = "server.key"
+	if cfg.Validate() == nil {
+		t.Fatal("strict encryption with multicast must be rejected")
+	}
+
+	cfg = Config{UDP: true, RTPAddress: ":6513", RTCPAddress: ":6514"}
+	if cfg.Validate() == nil {
+		t.Fatal("odd RTP port must be rejected")
+	}
+
+	cfg.RTPAddress, cfg.RTCPAddress = "", ""
+	err = cfg.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Multicast = false
+	if cfg.UDPOptions().MulticastIPRange != "" {
+		t.Fatal("multicast must be disabled")
+	}
+}
@@ -0,0 +1,443 @@
+package h265_transcoder
+
+import (
+	"context"
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/formatprocessor"
+	"fearpro13/h265_transcoder/mediamtx/unit"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"github.com/pion/rtp"
+)
+
+// probeTimeout limits source probing, including connection
+const probeTimeout = 5 * time.Second
+
+// DefaultProbeReadTimeout limits reading of source video while its key frames are awaited
+const DefaultProbeReadTimeout = 10 * time.Second
+
+// ProbeErrorReason classifies probe failures, so operators know what to fix
+type ProbeErrorReason string
+
+const (
+	ProbeInvalidSource    ProbeErrorReason = "invalid_source"
+	ProbeUnreachable      ProbeErrorReason = "unreachable"
+	ProbeAuthFailed       ProbeErrorReason = "auth_failed"
+	ProbeNotFound         ProbeErrorReason = "not_found"
+	ProbeUnsupportedCodec ProbeErrorReason = "unsupported_codec"
+	ProbeFailed           ProbeErrorReason = "failed"
+)
+
+type ProbeError struct {
+	Reason ProbeErrorReason
+	Err    error
+}
+
+func (e *ProbeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Err.Error())
+}
+
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// ProbeTrack describes one source track, fields which could not be detected are omitted
+type ProbeTrack struct {
+	Type  string `json:"type"`
+	Codec string `json:"codec"`
+
+	Profile string  `json:"profile,omitempty"`
+	Level   string  `json:"level,omitempty"`
+	Width   int     `json:"width,omitempty"`
+	Height  int     `json:"height,omitempty"`
+	FPS     float64 `json:"fps,omitempty"`
+	// GOP is the number of frames between two key frames
+	GOP int `json:"gop,omitempty"`
+
+	SampleRate int `json:"sampleRate,omitempty"`
+	Channels   int `json:"channels,omitempty"`
+}
+
+type ProbeResult struct {
+	Source string       `json:"source"`
+	Tracks []ProbeTrack `json:"tracks"`
+}
+
+// classifyProbeError maps rtsp client errors to probe error reasons
+func classifyProbeError(err error) *ProbeError {
+	var badStatus liberrors.ErrClientBadStatusCode
+	if errors.As(err, &badStatus) {
+		switch badStatus.Code {
+		case base.StatusUnauthorized, base.StatusForbidden:
+			return &ProbeError{Reason: ProbeAuthFailed, Err: err}
+		case base.StatusNotFound:
+			return &ProbeError{Reason: ProbeNotFound, Err: err}
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return &ProbeError{Reason: ProbeUnreachable, Err: err}
+	}
+
+	return &ProbeError{Reason: ProbeFailed, Err: err}
+}
+
+// describeSource fetches rtsp session description of the source, client is left started for further requests
+func describeSource(source string, timeout time.Duration) (*gortsplib.Client, *base.URL, *description.Session, error) {
+	u, err := base.ParseURL(source)
+	if err != nil {
+		return nil, nil, nil, &ProbeError{Reason: ProbeInvalidSource, Err: err}
+	}
+
+	c := &gortsplib.Client{
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	}
+
+	err = c.Start(u.Scheme, u.Host)
+	if err != nil {
+		return nil, nil, nil, classifyProbeError(err)
+	}
+
+	desc, _, err := c.Describe(u)
+	if err != nil {
+		c.Close()
+		return nil, nil, nil, classifyProbeError(err)
+	}
+
+	return c, u, desc, nil
+}
+
+// probeVideoCodec returns codec of the first source video track
+func probeVideoCodec(source string, timeout time.Duration) (string, error) {
+	if isSRTSource(source) {
+		return probeSRTVideoCodec(source, timeout)
+	}
+
+	c, _, desc, err := describeSource(source, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	for _, m := range desc.Medias {
+		if m.Type == description.MediaTypeVideo && len(m.Formats) > 0 {
+			return m.Formats[0].Codec(), nil
+		}
+	}
+
+	return "", &ProbeError{Reason: ProbeUnsupportedCodec, Err: errors.New("source has no video track")}
+}
+
+// videoProbe collects access units of one H.264/H.265 track,
+// track parameters are updated from in-band parameter sets by format processor
+type videoProbe struct {
+	forma     format.Format
+	proc      formatprocessor.Processor
+	frames    int
+	firstPTS  time.Duration
+	lastPTS   time.Duration
+	keyFrames int
+	// frames since first key frame
+	sinceKey int
+	gop      int
+}
+
+func (p *videoProbe) onAccessUnit(au [][]byte, pts time.Duration, key bool) {
+	if p.frames == 0 {
+		p.firstPTS = pts
+	}
+	p.frames++
+	p.lastPTS = pts
+
+	if key {
+		p.keyFrames++
+		if p.keyFrames == 2 {
+			p.gop = p.sinceKey
+		}
+		p.sinceKey = 0
+	}
+
+	if p.keyFrames > 0 {
+		p.sinceKey++
+	}
+}
+
+// done reports whether GOP has been measured
+func (p *videoProbe) done() bool {
+	return p.keyFrames >= 2
+}
+
+func (p *videoProbe) fps() float64 {
+	if p.frames < 2 || p.lastPTS <= p.firstPTS {
+		return 0
+	}
+
+	fps := float64(p.frames-1) / (p.lastPTS - p.firstPTS).Seconds()
+
+	return math.Round(fps*100) / 100
+}
+
+// ProbeSource connects to rtsp or srt source and describes its tracks,
+// video tracks of rtsp source are read until two key frames are received or timeout passes
+func ProbeSource(source string, timeout time.Duration) (*ProbeResult, error) {
+	if isSRTSource(source) {
+		return probeSRTSource(source, timeout)
+	}
+
+	c, u, desc, err := describeSource(source, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	probes := map[*description.Media]*videoProbe{}
+	hasVideo := false
+
+	for _, m := range desc.Medias {
+		if m.Type != description.MediaTypeVideo {
+			continue
+		}
+
+		for _, forma := range m.Formats {
+			switch forma.(type) {
+			case *format.H264, *format.H265:
+				proc, err := formatprocessor.New(1472, forma, false)
+				if err != nil {
+					return nil, classifyProbeError(err)
+				}
+				probes[m] = &videoProbe{forma: forma, proc: proc}
+				hasVideo = true
+
+			case *format.Generic:
+			default:
+				hasVideo = true
+			}
+		}
+	}
+
+	if !hasVideo {
+		return nil, &ProbeError{Reason: ProbeUnsupportedCodec, Err: errors.New("source has no supported video track")}
+	}
+
+	if len(probes) > 0 {
+		err = readVideoProbes(c, u, desc, probes, timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := &ProbeResult{Source: source, Tracks: []ProbeTrack{}}
+
+	for _, m := range desc.Medias {
+		for _, forma := range m.Formats {
+			track := describeTrack(m, forma)
+
+			if p, ok := probes[m]; ok && p.forma == forma {
+				track.FPS = p.fps()
+				track.GOP = p.gop
+				describeParams(&track, forma)
+			}
+
+			res.Tracks = append(res.Tracks, track)
+		}
+	}
+
+	return res, nil
+}
+
+func readVideoProbes(c *gortsplib.Client, u *base.URL, desc *description.Session, probes map[*description.Media]*videoProbe, timeout time.Duration) error {
+	medias := make([]*description.Media, 0, len(probes))
+	for m := range probes {
+		medias = append(medias, m)
+	}
+
+	err := c.SetupAll(desc.BaseURL, medias)
+	if err != nil {
+		return classifyProbeError(err)
+	}
+
+	var m sync.Mutex
+	finished := make(chan struct{})
+	closed := false
+
+	for medi, p := range probes {
+		cmedi := medi
+		cp := p
+
+		c.OnPacketRTP(cmedi, cp.forma, func(pkt *rtp.Packet) {
+			pts, ok := c.PacketPTS(cmedi, pkt)
+			if !ok {
+				return
+			}
+
+			m.Lock()
+			defer m.Unlock()
+
+			if closed {
+				return
+			}
+
+			pu, err := cp.proc.ProcessRTPPacket(pkt, time.Now(), pts, true)
+			if err != nil || pu == nil {
+				return
+			}
+
+			switch tu := pu.(type) {
+			case *unit.H264:
+				if tu.AU != nil {
+					cp.onAccessUnit(tu.AU, pts, h264.IDRPresent(tu.AU))
+				}
+			case *unit.H265:
+				if tu.AU != nil {
+					cp.onAccessUnit(tu.AU, pts, h265.IsRandomAccess(tu.AU))
+				}
+			}
+
+			for _, p := range probes {
+				if !p.done() {
+					return
+				}
+			}
+
+			closed = true
+			close(finished)
+		})
+	}
+
+	_, err = c.Play(nil)
+	if err != nil {
+		return classifyProbeError(err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-finished:
+	case <-timer.C:
+		// tracks are described with what has been received so far
+	}
+
+	m.Lock()
+	closed = true
+	m.Unlock()
+
+	return nil
+}
+
+func describeTrack(m *description.Media, forma format.Format) ProbeTrack {
+	track := ProbeTrack{
+		Type:  string(m.Type),
+		Codec: forma.Codec(),
+	}
+
+	if m.Type != description.MediaTypeAudio {
+		return track
+	}
+
+	track.SampleRate = forma.ClockRate()
+
+	switch f := forma.(type) {
+	case *format.MPEG4Audio:
+		if f.Config != nil {
+			track.SampleRate = f.Config.SampleRate
+			track.Channels = f.Config.ChannelCount
+		}
+	case *format.G711:
+		track.SampleRate = f.SampleRate
+		track.Channels = f.ChannelCount
+	case *format.LPCM:
+		track.SampleRate = f.SampleRate
+		track.Channels = f.ChannelCount
+	case *format.AC3:
+		track.SampleRate = f.SampleRate
+		track.Channels = f.ChannelCount
+	case *format.Opus:
+		track.Channels = f.ChannelCount
+	}
+
+	return track
+}
+
+var h264Profiles = map[uint8]string{
+	66:  "Baseline",
+	77:  "Main",
+	88:  "Extended",
+	100: "High",
+	110: "High 10",
+	122: "High 4:2:2",
+	244: "High 4:4:4 Predictive",
+}
+
+var h265Profiles = map[uint8]string{
+	1: "Main",
+	2: "Main 10",
+	3: "Main Still Picture",
+	4: "Range Extensions",
+}
+
+// describeParams fills video track details from its parameter sets
+func describeParams(track *ProbeTrack, forma format.Format) {
+	switch f := forma.(type) {
+	case *format.H264:
+		sps, _ := f.SafeParams()
+		if sps == nil {
+			return
+		}
+
+		var s h264.SPS
+		if s.Unmarshal(sps) != nil {
+			return
+		}
+
+		track.Profile = h264Profiles[s.ProfileIdc]
+		if track.Profile == "" {
+			track.Profile = strconv.Itoa(int(s.ProfileIdc))
+		}
+		track.Level = strconv.FormatFloat(float64(s.LevelIdc)/10, 'f', -1, 64)
+		track.Width = s.Width()
+		track.Height = s.Height()
+
+		if track.FPS == 0 {
+			track.FPS = s.FPS()
+		}
+
+	case *format.H265:
+		_, sps, _ := f.SafeParams()
+		if sps == nil {
+			return
+		}
+
+		var s h265.SPS
+		if s.Unmarshal(sps) != nil {
+			return
+		}
+
+		track.Profile = h265Profiles[s.ProfileTierLevel.GeneralProfileIdc]
+		if track.Profile == "" {
+			track.Profile = strconv.Itoa(int(s.ProfileTierLevel.GeneralProfileIdc))
+		}
+		track.Level = strconv.FormatFloat(float64(s.ProfileTierLevel.GeneralLevelIdc)/30, 'f', -1, 64)
+		track.Width = s.Width()
+		track.Height = s.Height()
+
+		if track.FPS == 0 {
+			track.FPS = s.FPS()
+		}
+	}
+}
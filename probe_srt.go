@@ -0,0 +1,144 @@
+package h265_transcoder
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+	srt "github.com/datarhei/gosrt"
+)
+
+// isSRTSource reports whether source is pulled with SRT instead of RTSP
+func isSRTSource(source string) bool {
+	return strings.HasPrefix(source, "srt://")
+}
+
+// classifySRTError maps srt dial errors to probe error reasons,
+// rejections are reported by gosrt as "REJECT (reason)" with reason formatted in base 32
+func classifySRTError(err error) *ProbeError {
+	msg := err.Error()
+
+	if i := strings.Index(msg, "REJECT ("); i >= 0 && strings.HasSuffix(msg, ")") {
+		reason, perr := strconv.ParseUint(msg[i+len("REJECT ("):len(msg)-1], 32, 32)
+		if perr == nil {
+			switch srt.RejectionReason(reason) {
+			case srt.REJ_BADSECRET, srt.REJ_UNSECURE, srt.REJX_UNAUTHORIZED, srt.REJX_FORBIDDEN:
+				return &ProbeError{Reason: ProbeAuthFailed, Err: err}
+			case srt.REJX_NOTFOUND:
+				return &ProbeError{Reason: ProbeNotFound, Err: err}
+			}
+		}
+	}
+
+	if strings.Contains(msg, "timeout") {
+		return &ProbeError{Reason: ProbeUnreachable, Err: err}
+	}
+
+	return classifyProbeError(err)
+}
+
+// describeSRTSource reads MPEG-TS program of srt source, stream id and passphrase are taken from url query
+func describeSRTSource(source string, timeout time.Duration) ([]*mpegts.Track, error) {
+	config := srt.DefaultConfig()
+	config.ConnectionTimeout = timeout
+
+	address, err := config.UnmarshalURL(source)
+	if err != nil {
+		return nil, &ProbeError{Reason: ProbeInvalidSource, Err: err}
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, &ProbeError{Reason: ProbeInvalidSource, Err: err}
+	}
+
+	conn, err := srt.Dial("srt", address, config)
+	if err != nil {
+		return nil, classifySRTError(err)
+	}
+	defer conn.Close()
+
+	// srt connections don't support deadlines
+	timer := time.AfterFunc(timeout, func() {
+		conn.Close()
+	})
+	defer timer.Stop()
+
+	r, err := mpegts.NewReader(mpegts.NewBufferedReader(conn))
+	if err != nil {
+		if !timer.Stop() {
+			return nil, &ProbeError{Reason: ProbeUnreachable, Err: errors.New("no MPEG-TS program received in time")}
+		}
+		return nil, &ProbeError{Reason: ProbeFailed, Err: err}
+	}
+
+	return r.Tracks(), nil
+}
+
+// describeMPEGTSTrack describes track with names of rtsp formats
+func describeMPEGTSTrack(track *mpegts.Track) ProbeTrack {
+	switch c := track.Codec.(type) {
+	case *mpegts.CodecH264:
+		return ProbeTrack{Type: "video", Codec: "H264"}
+	case *mpegts.CodecH265:
+		return ProbeTrack{Type: "video", Codec: "H265"}
+	case *mpegts.CodecMPEG4Video:
+		return ProbeTrack{Type: "video", Codec: "MPEG-4 Video"}
+	case *mpegts.CodecMPEG1Video:
+		return ProbeTrack{Type: "video", Codec: "MPEG-1/2 Video"}
+	case *mpegts.CodecMPEG4Audio:
+		return ProbeTrack{Type: "audio", Codec: "MPEG-4 Audio", SampleRate: c.Config.SampleRate, Channels: c.Config.ChannelCount}
+	case *mpegts.CodecOpus:
+		return ProbeTrack{Type: "audio", Codec: "Opus", SampleRate: 48000, Channels: c.ChannelCount}
+	case *mpegts.CodecMPEG1Audio:
+		return ProbeTrack{Type: "audio", Codec: "MPEG-1/2 Audio"}
+	case *mpegts.CodecAC3:
+		return ProbeTrack{Type: "audio", Codec: "AC-3", SampleRate: c.SampleRate, Channels: c.ChannelCount}
+	default:
+		return ProbeTrack{Type: "application", Codec: fmt.Sprintf("%T", c)}
+	}
+}
+
+// probeSRTSource describes tracks of srt source program, video is not read
+func probeSRTSource(source string, timeout time.Duration) (*ProbeResult, error) {
+	tracks, err := describeSRTSource(source, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ProbeResult{Source: source, Tracks: []ProbeTrack{}}
+	hasVideo := false
+
+	for _, track := range tracks {
+		t := describeMPEGTSTrack(track)
+		if t.Type == "video" {
+			hasVideo = true
+		}
+		res.Tracks = append(res.Tracks, t)
+	}
+
+	if !hasVideo {
+		return nil, &ProbeError{Reason: ProbeUnsupportedCodec, Err: errors.New("source has no supported video track")}
+	}
+
+	return res, nil
+}
+
+// probeSRTVideoCodec returns codec of the first srt source video track
+func probeSRTVideoCodec(source string, timeout time.Duration) (string, error) {
+	tracks, err := describeSRTSource(source, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	for _, track := range tracks {
+		if t := describeMPEGTSTrack(track); t.Type == "video" {
+			return t.Codec, nil
+		}
+	}
+
+	return "", &ProbeError{Reason: ProbeUnsupportedCodec, Err: errors.New("source has no video track")}
+}
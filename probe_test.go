@@ -0,0 +1,45 @@
+package h265_transcoder
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
+)
+
+func TestVideoProbe(t *testing.T) {
+	p := &videoProbe{}
+
+	// 25 fps, key frame every 10 frames, probe starts in the middle of GOP
+	for i := 0; i < 26; i++ {
+		p.onAccessUnit(nil, time.Duration(i)*40*time.Millisecond, (i+5)%10 == 0)
+	}
+
+	if !p.done() {
+		t.Fatal("expected probe to be done")
+	}
+
+	if p.gop != 10 {
+		t.Errorf("expected gop 10, got %d", p.gop)
+	}
+
+	if p.fps() != 25 {
+		t.Errorf("expected fps 25, got %f", p.fps())
+	}
+}
+
+func TestClassifyProbeError(t *testing.T) {
+	cases := map[ProbeErrorReason]error{
+		ProbeAuthFailed: liberrors.ErrClientBadStatusCode{Code: base.StatusUnauthorized},
+		ProbeNotFound:   liberrors.ErrClientBadStatusCode{Code: base.StatusNotFound},
+		ProbeFailed:     errors.New("unknown"),
+	}
+
+	for reason, err := range cases {
+		if r := classifyProbeError(err).Reason; r != reason {
+			t.Errorf("expected %s, got %s", reason, r)
+		}
+	}
+}
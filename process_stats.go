@@ -0,0 +1,65 @@
+package h265_transcoder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// procClockTicks is USER_HZ, procfs reports cpu times in these ticks on every Linux platform
+const procClockTicks = 100
+
+// ProcessStats is cpu and memory usage of ffmpeg process
+type ProcessStats struct {
+	// CPUSeconds is user and system cpu time consumed since process start
+	CPUSeconds float64
+	// RSSBytes is resident memory size
+	RSSBytes uint64
+}
+
+// readProcessStats reads process usage from procfs, so it's available on Linux only
+func readProcessStats(pid int) (ProcessStats, error) {
+	byts, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return ProcessStats{}, err
+	}
+
+	// command name could contain spaces and parentheses, fields are counted after its closing parenthesis
+	stat := string(byts)
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return ProcessStats{}, errors.New("malformed process stat")
+	}
+
+	// fields start with the 3rd one, state
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 22 {
+		return ProcessStats{}, errors.New("malformed process stat")
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return ProcessStats{}, err
+	}
+
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return ProcessStats{}, err
+	}
+
+	rss, err := strconv.ParseInt(fields[21], 10, 64)
+	if err != nil {
+		return ProcessStats{}, err
+	}
+
+	if rss < 0 {
+		rss = 0
+	}
+
+	return ProcessStats{
+		CPUSeconds: float64(utime+stime) / procClockTicks,
+		RSSBytes:   uint64(rss) * uint64(os.Getpagesize()),
+	}, nil
+}
@@ -0,0 +1,248 @@
+package h265_transcoder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+type AudioPolicy string
+
+const (
+	AudioCopy      AudioPolicy = "copy"
+	AudioTranscode AudioPolicy = "aac"
+	AudioDisabled  AudioPolicy = "none"
+)
+
+const DefaultProfileName = "default"
+
+var (
+	reProfileName = regexp.MustCompile(`^[0-9a-zA-Z_\-.]+$`)
+	reBitrate     = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kKmM]?$`)
+	rePixelFormat = regexp.MustCompile(`^[0-9a-z_]+$`)
+)
+
+var videoCodecs = map[string]struct{}{
+	"libx264":    {},
+	"h264_nvenc": {},
+	"h264_qsv":   {},
+	"h264_vaapi": {},
+}
+
+var x264Presets = map[string]struct{}{
+	"ultrafast": {},
+	"superfast": {},
+	"veryfast":  {},
+	"faster":    {},
+	"fast":      {},
+	"medium":    {},
+	"slow":      {},
+	"slower":    {},
+	"veryslow":  {},
+	"placebo":   {},
+}
+
+// Profile describes encoding settings applied by ffmpeg to a unit.
+// Zero values mean "leave it to the encoder".
+type Profile struct {
+	Name         string      `json:"name"`
+	VideoCodec   string      `json:"videoCodec"`
+	Preset       string      `json:"preset,omitempty"`
+	CRF          int         `json:"crf,omitempty"`
+	Bitrate      string      `json:"bitrate,omitempty"`
+	MaxRate      string      `json:"maxRate,omitempty"`
+	BufSize      string      `json:"bufSize,omitempty"`
+	GOP          int         `json:"gop,omitempty"`
+	Width        int         `json:"width,omitempty"`
+	Height       int         `json:"height,omitempty"`
+	FPS          float64     `json:"fps,omitempty"`
+	PixelFormat  string      `json:"pixelFormat,omitempty"`
+	Audio        AudioPolicy `json:"audio"`
+	AudioBitrate string      `json:"audioBitrate,omitempty"`
+}
+
+// DefaultProfile matches the settings used before profiles were introduced.
+var DefaultProfile = Profile{
+	Name:       DefaultProfileName,
+	VideoCodec: "libx264",
+	CRF:        20,
+	Bitrate:    "500k",
+	Audio:      AudioCopy,
+}
+
+func (p Profile) Validate() error {
+	if !reProfileName.MatchString(p.Name) {
+		return fmt.Errorf("profile '%s': invalid name", p.Name)
+	}
+
+	if _, ok := videoCodecs[p.VideoCodec]; !ok {
+		return fmt.Errorf("profile '%s': unsupported video codec '%s'", p.Name, p.VideoCodec)
+	}
+
+	if p.Preset != "" && p.VideoCodec == "libx264" {
+		if _, ok := x264Presets[p.Preset]; !ok {
+			return fmt.Errorf("profile '%s': unsupported preset '%s'", p.Name, p.Preset)
+		}
+	}
+
+	if p.CRF < 0 || p.CRF > 51 {
+		return fmt.Errorf("profile '%s': crf must be between 0 and 51", p.Name)
+	}
+
+	for name, v := range map[string]string{"bitrate": p.Bitrate, "maxRate": p.MaxRate, "bufSize": p.BufSize, "audioBitrate": p.AudioBitrate} {
+		if v != "" && !reBitrate.MatchString(v) {
+			return fmt.Errorf("profile '%s': invalid %s '%s'", p.Name, name, v)
+		}
+	}
+
+	if p.MaxRate != "" && p.BufSize == "" {
+		return fmt.Errorf("profile '%s': maxRate requires bufSize", p.Name)
+	}
+
+	if p.GOP < 0 {
+		return fmt.Errorf("profile '%s': gop can not be negative", p.Name)
+	}
+
+	if p.Width < 0 || p.Height < 0 || p.Width%2 != 0 || p.Height%2 != 0 {
+		return fmt.Errorf("profile '%s': width and height must be positive even numbers", p.Name)
+	}
+
+	if p.FPS < 0 {
+		return fmt.Errorf("profile '%s': fps can not be negative", p.Name)
+	}
+
+	if p.PixelFormat != "" && !rePixelFormat.MatchString(p.PixelFormat) {
+		return fmt.Errorf("profile '%s': invalid pixel format '%s'", p.Name, p.PixelFormat)
+	}
+
+	switch p.Audio {
+	case "", AudioCopy, AudioTranscode, AudioDisabled:
+	default:
+		return fmt.Errorf("profile '%s': invalid audio policy '%s'", p.Name, p.Audio)
+	}
+
+	return nil
+}
+
+// Args returns ffmpeg output options for the profile, without input and output urls.
+func (p Profile) Args() []string {
+	return p.videoArgs(p.audioArgs(), true)
+}
+
+// RenditionArgs returns ffmpeg output options for filter graph output, picture is scaled by ScaleFilter in the graph.
+func (p Profile) RenditionArgs() []string {
+	return p.videoArgs(p.audioArgs(), false)
+}
+
+// ScaleFilter returns ffmpeg scale filter for profile width and height, "null" filter if picture is not scaled.
+func (p Profile) ScaleFilter() string {
+	if p.Width == 0 && p.Height == 0 {
+		return "null"
+	}
+
+	w, h := p.Width, p.Height
+	if w == 0 {
+		w = -2
+	}
+	if h == 0 {
+		h = -2
+	}
+
+	return fmt.Sprintf("scale=%d:%d", w, h)
+}
+
+func (p Profile) videoArgs(args []string, scale bool) []string {
+	args = append(args, "-c:v", p.VideoCodec)
+
+	if p.Preset != "" {
+		args = append(args, "-preset", p.Preset)
+	}
+
+	if p.CRF != 0 {
+		args = append(args, "-crf", strconv.Itoa(p.CRF))
+	}
+
+	if p.Bitrate != "" {
+		args = append(args, "-b:v", p.Bitrate)
+	}
+
+	if p.MaxRate != "" {
+		args = append(args, "-maxrate", p.MaxRate, "-bufsize", p.BufSize)
+	}
+
+	if p.GOP != 0 {
+		args = append(args, "-g", strconv.Itoa(p.GOP))
+	}
+
+	if scale && (p.Width != 0 || p.Height != 0) {
+		args = append(args, "-vf", p.ScaleFilter())
+	}
+
+	if p.FPS != 0 {
+		args = append(args, "-r", strconv.FormatFloat(p.FPS, 'f', -1, 64))
+	}
+
+	if p.PixelFormat != "" {
+		args = append(args, "-pix_fmt", p.PixelFormat)
+	}
+
+	return append(args, "-max_muxing_queue_size", "1024", "-bf", "0")
+}
+
+// CopyArgs returns ffmpeg output options remuxing source video as is, only audio settings are applied.
+func (p Profile) CopyArgs() []string {
+	return append(p.audioArgs(), "-c:v", "copy", "-max_muxing_queue_size", "1024")
+}
+
+// keepsPicture reports whether profile keeps source resolution and framerate, so video could be copied
+func (p Profile) keepsPicture() bool {
+	return p.Width == 0 && p.Height == 0 && p.FPS == 0
+}
+
+func (p Profile) audioArgs() []string {
+	var args []string
+
+	switch p.Audio {
+	case AudioDisabled:
+		args = append(args, "-an")
+	case AudioTranscode:
+		args = append(args, "-c:a", "aac")
+		if p.AudioBitrate != "" {
+			args = append(args, "-b:a", p.AudioBitrate)
+		}
+	default:
+		args = append(args, "-c:a", "copy")
+	}
+
+	return args
+}
+
+// LoadProfiles reads a JSON array of profiles from file.
+func LoadProfiles(path string) ([]Profile, error) {
+	byts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []Profile
+	err = json.Unmarshal(byts, &profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(profiles) == 0 {
+		return nil, errors.New("no profiles defined")
+	}
+
+	for _, p := range profiles {
+		err = p.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return profiles, nil
+}
@@ -0,0 +1,161 @@
+package h265_transcoder
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestDefaultProfileArgs(t *testing.T) {
+	source, err := NewSource("1", "rtsp://127.0.0.1:8554/in", "rtsp://0.0.0.0:9222/1", DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := source.Args()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"-y", "-fflags", "+igndts", "-rtsp_transport", "tcp", "-i", "rtsp://127.0.0.1:8554/in",
+		"-c:a", "copy", "-c:v", "libx264", "-crf", "20", "-b:v", "500k", "-max_muxing_queue_size", "1024", "-bf", "0",
+		"-f", "rtsp", "-rtsp_transport", "tcp", "rtsp://0.0.0.0:9222/1",
+	}
+
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("unexpected args: %v", args)
+	}
+}
+
+func TestSRTSourceArgs(t *testing.T) {
+	source, err := NewSource("1", "srt://127.0.0.1:8890?streamid=read:in", "rtsp://0.0.0.0:9222/1", DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := source.Args()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"-y", "-fflags", "+igndts", "-i", "srt://127.0.0.1:8890?streamid=read:in",
+		"-c:a", "copy", "-c:v", "libx264", "-crf", "20", "-b:v", "500k", "-max_muxing_queue_size", "1024", "-bf", "0",
+		"-f", "rtsp", "-rtsp_transport", "tcp", "rtsp://0.0.0.0:9222/1",
+	}
+
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("unexpected args: %v", args)
+	}
+}
+
+func TestCopyArgs(t *testing.T) {
+	p := DefaultProfile
+	p.Audio = AudioTranscode
+	p.AudioBitrate = "64k"
+
+	source, err := NewSource("1", "rtsp://127.0.0.1:8554/in", "rtsp://0.0.0.0:9222/1", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.copyVideo = true
+
+	args, err := source.Args()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"-y", "-fflags", "+igndts", "-rtsp_transport", "tcp", "-i", "rtsp://127.0.0.1:8554/in",
+		"-c:a", "aac", "-b:a", "64k", "-c:v", "copy", "-max_muxing_queue_size", "1024",
+		"-f", "rtsp", "-rtsp_transport", "tcp", "rtsp://0.0.0.0:9222/1",
+	}
+
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("unexpected args: %v", args)
+	}
+}
+
+func TestProfileArgs(t *testing.T) {
+	p := Profile{
+		Name:        "small",
+		VideoCodec:  "libx264",
+		Preset:      "veryfast",
+		MaxRate:     "800k",
+		BufSize:     "1600k",
+		GOP:         50,
+		Height:      360,
+		FPS:         12.5,
+		PixelFormat: "yuv420p",
+		Audio:       AudioDisabled,
+	}
+
+	err := p.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"-an", "-c:v", "libx264", "-preset", "veryfast", "-maxrate", "800k", "-bufsize", "1600k",
+		"-g", "50", "-vf", "scale=-2:360", "-r", "12.5", "-pix_fmt", "yuv420p",
+		"-max_muxing_queue_size", "1024", "-bf", "0",
+	}
+
+	if !reflect.DeepEqual(p.Args(), expected) {
+		t.Fatalf("unexpected args: %v", p.Args())
+	}
+}
+
+func TestProfileValidate(t *testing.T) {
+	for _, p := range []Profile{
+		{Name: "bad name", VideoCodec: "libx264"},
+		{Name: "codec", VideoCodec: "libvpx"},
+		{Name: "preset", VideoCodec: "libx264", Preset: "warp"},
+		{Name: "crf", VideoCodec: "libx264", CRF: 60},
+		{Name: "bitrate", VideoCodec: "libx264", Bitrate: "fast"},
+		{Name: "maxrate", VideoCodec: "libx264", MaxRate: "1M"},
+		{Name: "odd", VideoCodec: "libx264", Width: 641},
+		{Name: "audio", VideoCodec: "libx264", Audio: "opus"},
+	} {
+		if p.Validate() == nil {
+			t.Errorf("profile '%s' expected to be invalid", p.Name)
+		}
+	}
+}
+
+func TestRenditionsArgs(t *testing.T) {
+	source, err := NewSource("1", "rtsp://127.0.0.1:8554/in", "rtsp://0.0.0.0:9222/1", DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.copyVideo = true
+
+	for _, r := range []Profile{
+		{Name: "720p", VideoCodec: "libx264", Height: 720, Bitrate: "2M", Audio: AudioDisabled},
+		{Name: "low", VideoCodec: "libx264", CRF: 30, Audio: AudioCopy},
+	} {
+		to, _ := url.Parse("rtsp://0.0.0.0:9222/1/" + r.Name)
+		source.renditions = append(source.renditions, renditionOutput{name: r.Name, to: *to, profile: r})
+	}
+
+	args, err := source.Args()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"-y", "-fflags", "+igndts", "-rtsp_transport", "tcp", "-i", "rtsp://127.0.0.1:8554/in",
+		"-filter_complex", "[0:v:0]split=2[s0][s1];[s0]scale=-2:720[r0];[s1]null[r1]", "-map", "0:v:0", "-map", "0:a?",
+		"-c:a", "copy", "-c:v", "copy", "-max_muxing_queue_size", "1024",
+		"-f", "rtsp", "-rtsp_transport", "tcp", "rtsp://0.0.0.0:9222/1",
+		"-map", "[r0]", "-map", "0:a?", "-an", "-c:v", "libx264", "-b:v", "2M", "-max_muxing_queue_size", "1024", "-bf", "0",
+		"-f", "rtsp", "-rtsp_transport", "tcp", "rtsp://0.0.0.0:9222/1/720p",
+		"-map", "[r1]", "-map", "0:a?", "-c:a", "copy", "-c:v", "libx264", "-crf", "30", "-max_muxing_queue_size", "1024", "-bf", "0",
+		"-f", "rtsp", "-rtsp_transport", "tcp", "rtsp://0.0.0.0:9222/1/low",
+	}
+
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("unexpected args: %v", args)
+	}
+}
@@ -0,0 +1,80 @@
+package h265_transcoder
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress is a snapshot of ffmpeg -progress output
+type Progress struct {
+	Frame      uint64    `json:"frame"`
+	FPS        float64   `json:"fps"`
+	Bitrate    float64   `json:"bitrate"` // kbit/s
+	TotalSize  uint64    `json:"totalSize"`
+	OutTime    string    `json:"outTime"`
+	OutTimeUs  int64     `json:"outTimeUs"`
+	DupFrames  uint64    `json:"dupFrames"`
+	DropFrames uint64    `json:"dropFrames"`
+	Speed      float64   `json:"speed"`
+	Ended      bool      `json:"ended"`
+	Updated    time.Time `json:"updated"`
+}
+
+// setField applies one key=value line, unknown keys and "N/A" values are ignored
+func (p *Progress) setField(key string, value string) {
+	value = strings.TrimSpace(value)
+	if value == "N/A" {
+		return
+	}
+
+	switch key {
+	case "frame":
+		p.Frame, _ = strconv.ParseUint(value, 10, 64)
+	case "fps":
+		p.FPS, _ = strconv.ParseFloat(value, 64)
+	case "bitrate":
+		p.Bitrate, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+	case "total_size":
+		p.TotalSize, _ = strconv.ParseUint(value, 10, 64)
+	case "out_time":
+		p.OutTime = value
+	case "out_time_us":
+		p.OutTimeUs, _ = strconv.ParseInt(value, 10, 64)
+	case "dup_frames":
+		p.DupFrames, _ = strconv.ParseUint(value, 10, 64)
+	case "drop_frames":
+		p.DropFrames, _ = strconv.ParseUint(value, 10, 64)
+	case "speed":
+		p.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	case "progress":
+		p.Ended = value == "end"
+	}
+}
+
+// readProgress parses ffmpeg -progress blocks from r and calls onProgress for every completed block
+func readProgress(r io.Reader, onProgress func(Progress)) error {
+	scanner := bufio.NewScanner(r)
+
+	current := Progress{}
+
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if !found {
+			continue
+		}
+
+		current.setField(strings.TrimSpace(key), value)
+
+		// "progress" key always closes a block
+		if key == "progress" {
+			current.Updated = time.Now()
+			onProgress(current)
+			current = Progress{}
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,35 @@
+package h265_transcoder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadProgress(t *testing.T) {
+	out := "frame=120\nfps=24.98\nstream_0_0_q=29.0\nbitrate= 512.3kbits/s\ntotal_size=314572\n" +
+		"out_time_us=4800000\nout_time=00:00:04.800000\ndup_frames=1\ndrop_frames=3\nspeed=0.98x\nprogress=continue\n" +
+		"frame=N/A\nbitrate=N/A\nspeed=N/A\nprogress=end\n"
+
+	var res []Progress
+	err := readProgress(strings.NewReader(out), func(p Progress) {
+		res = append(res, p)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", len(res))
+	}
+
+	p := res[0]
+	if p.Frame != 120 || p.FPS != 24.98 || p.Bitrate != 512.3 || p.TotalSize != 314572 ||
+		p.OutTimeUs != 4800000 || p.OutTime != "00:00:04.800000" ||
+		p.DupFrames != 1 || p.DropFrames != 3 || p.Speed != 0.98 || p.Ended {
+		t.Fatalf("unexpected progress: %+v", p)
+	}
+
+	if !res[1].Ended || res[1].Frame != 0 {
+		t.Fatalf("unexpected progress: %+v", res[1])
+	}
+}
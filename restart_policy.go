@@ -0,0 +1,109 @@
+package h265_transcoder
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+type RestartMode string
+
+const (
+	RestartAlways    RestartMode = "always"
+	RestartOnFailure RestartMode = "on-failure"
+	RestartNever     RestartMode = "never"
+)
+
+// RestartPolicy decides whether unit is restarted after its transcoder stopped, failed or stalled
+type RestartPolicy struct {
+	Mode RestartMode `json:"mode,omitempty"`
+	// MaxAttempts limits consecutive restarts in on-failure mode, 0 means unlimited
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+}
+
+func (p RestartPolicy) Validate() error {
+	switch p.Mode {
+	case "", RestartAlways, RestartOnFailure, RestartNever:
+	default:
+		return fmt.Errorf("invalid restart mode '%s'", p.Mode)
+	}
+
+	if p.MaxAttempts < 0 {
+		return fmt.Errorf("restart maxAttempts can not be negative")
+	}
+
+	if p.MaxAttempts > 0 && p.Mode != RestartOnFailure {
+		return fmt.Errorf("restart maxAttempts could be used only with '%s' mode", RestartOnFailure)
+	}
+
+	return nil
+}
+
+// shouldRestart returns false and terminal unit status if unit must not be restarted anymore
+func (p RestartPolicy) shouldRestart(failed bool, attempts int) (bool, string) {
+	switch p.Mode {
+	case RestartNever:
+		if failed {
+			return false, StatusFailed
+		}
+		return false, StatusStopped
+
+	case RestartOnFailure:
+		if !failed {
+			return false, StatusStopped
+		}
+		if p.MaxAttempts > 0 && attempts >= p.MaxAttempts {
+			return false, StatusFailed
+		}
+	}
+
+	return true, ""
+}
+
+// Backoff is an exponential restart delay with jitter
+type Backoff struct {
+	// Initial is a delay of the first restart, 0 disables restarts
+	Initial time.Duration
+	// Max limits delay, 0 means unlimited
+	Max time.Duration
+	// StableAfter is a run time after which consecutive restart attempts counter is reset
+	StableAfter time.Duration
+}
+
+var DefaultBackoff = Backoff{
+	Initial:     10 * time.Second,
+	Max:         5 * time.Minute,
+	StableAfter: 2 * time.Minute,
+}
+
+func (b Backoff) Validate() error {
+	if b.Initial < 0 || b.Max < 0 || b.StableAfter < 0 {
+		return errors.New("retryAfter, retryMax and stableAfter can not be negative")
+	}
+
+	return nil
+}
+
+// Enabled returns false if failed units are not restarted
+func (b Backoff) Enabled() bool {
+	return b.Initial > 0
+}
+
+// Delay returns delay before restart attempt, attempts is the number of consecutive attempts already made
+func (b Backoff) Delay(attempts int) time.Duration {
+	d := max(b.Initial, 0)
+	for i := 0; i < attempts && (b.Max <= 0 || d < b.Max) && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+
+	// +-20% jitter, so units failed together are not restarted together
+	jitter := time.Duration(rand.Int63n(int64(d)/5*2+1)) - d/5
+
+	return d + jitter
+}
@@ -0,0 +1,70 @@
+package h265_transcoder
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		backoff  Backoff
+		attempts int
+		expected time.Duration
+	}{
+		{"first", Backoff{Initial: 10 * time.Second, Max: 5 * time.Minute}, 0, 10 * time.Second},
+		{"growth", Backoff{Initial: 10 * time.Second, Max: 5 * time.Minute}, 3, 80 * time.Second},
+		{"cap", Backoff{Initial: 10 * time.Second, Max: 5 * time.Minute}, 10, 5 * time.Minute},
+		{"unlimited", Backoff{Initial: 10 * time.Second}, 10, 10240 * time.Second},
+		{"unlimited overflow", Backoff{Initial: 10 * time.Second}, 1000, 10 * time.Second << 29},
+		{"zero", Backoff{}, 3, 0},
+		{"negative", Backoff{Initial: -time.Second, Max: time.Minute}, 3, 0},
+	} {
+		for i := 0; i < 100; i++ {
+			d := c.backoff.Delay(c.attempts)
+			if d < c.expected-c.expected/5 || d > c.expected+c.expected/5 {
+				t.Fatalf("%s: delay %s is out of %s +-20%%", c.name, d, c.expected)
+			}
+		}
+	}
+}
+
+func TestBackoffValidate(t *testing.T) {
+	if DefaultBackoff.Validate() != nil || (Backoff{}).Validate() != nil {
+		t.Fatal("valid backoff has been rejected")
+	}
+
+	for _, b := range []Backoff{{Initial: -1}, {Max: -1}, {StableAfter: -1}} {
+		if b.Validate() == nil {
+			t.Errorf("negative backoff %+v has been accepted", b)
+		}
+	}
+
+	if (Backoff{}).Enabled() || !DefaultBackoff.Enabled() {
+		t.Fatal("restarts must be disabled only by zero initial delay")
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	for _, c := range []struct {
+		policy   RestartPolicy
+		failed   bool
+		attempts int
+		restart  bool
+		status   string
+	}{
+		{RestartPolicy{}, false, 0, true, ""},
+		{RestartPolicy{Mode: RestartAlways}, true, 100, true, ""},
+		{RestartPolicy{Mode: RestartNever}, true, 0, false, StatusFailed},
+		{RestartPolicy{Mode: RestartNever}, false, 0, false, StatusStopped},
+		{RestartPolicy{Mode: RestartOnFailure}, false, 0, false, StatusStopped},
+		{RestartPolicy{Mode: RestartOnFailure}, true, 100, true, ""},
+		{RestartPolicy{Mode: RestartOnFailure, MaxAttempts: 3}, true, 2, true, ""},
+		{RestartPolicy{Mode: RestartOnFailure, MaxAttempts: 3}, true, 3, false, StatusFailed},
+	} {
+		restart, status := c.policy.shouldRestart(c.failed, c.attempts)
+		if restart != c.restart || status != c.status {
+			t.Errorf("%+v failed=%v attempts=%d: unexpected %v '%s'", c.policy, c.failed, c.attempts, restart, status)
+		}
+	}
+}
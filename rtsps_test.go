@@ -0,0 +1,222 @@
+package h265_transcoder
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fearpro13/h265_transcoder/mediamtx/certloader"
+	"fearpro13/h265_transcoder/mediamtx/conf"
+	"fearpro13/h265_transcoder/mediamtx/core"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+)
+
+// writeTestCert writes self-signed certificate with serial and its key to PEM files
+func writeTestCert(t *testing.T, certPath string, keyPath string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "h265"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRTSPS(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "server.crt")
+	keyPath := filepath.Join(dir, "server.key")
+	writeTestCert(t, certPath, keyPath, 1)
+
+	port := test.FreeTCPPort(t)
+	tlsPort := test.FreeTCPPort(t)
+
+	h := core.NewRtspHandler(context.Background(), uint16(port), false)
+	h.RTSPS = core.RTSPSOptions{
+		Encryption: conf.EncryptionStrict,
+		Address:    fmt.Sprintf(":%d", tlsPort),
+		ServerCert: certPath,
+		ServerKey:  keyPath,
+	}
+
+	err := h.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	err = h.AddPath("cam1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// plain server is still used by the service itself
+	pub := gortsplib.Client{}
+	err = pub.StartRecording(fmt.Sprintf("rtsp://%s@127.0.0.1:%d/cam1", setUnitUser(h, "cam1"), port), &description.Session{Medias: []*description.Media{test.MediaH264}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+
+	u, err := base.ParseURL(fmt.Sprintf("rtsps://127.0.0.1:%d/cam1", tlsPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var serial *big.Int
+	c := gortsplib.Client{
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				serial = cs.PeerCertificates[0].SerialNumber
+				return nil
+			},
+		},
+	}
+
+	err = c.Start(u.Scheme, u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	desc, _, err := c.Describe(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(desc.Medias) != 1 || desc.Medias[0].Formats[0].Codec() != "H264" {
+		t.Fatalf("unexpected medias %v", desc.Medias)
+	}
+
+	if serial == nil || serial.Int64() != 1 {
+		t.Fatalf("unexpected certificate serial %v", serial)
+	}
+}
+
+func TestCertLoader(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "server.crt")
+	keyPath := filepath.Join(dir, "server.key")
+	writeTestCert(t, certPath, keyPath, 1)
+
+	cl := &certloader.CertLoader{
+		CertPath:      certPath,
+		KeyPath:       keyPath,
+		CheckInterval: 20 * time.Millisecond,
+		Parent:        test.NilLogger,
+	}
+
+	err := cl.Initialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	serial := func() int64 {
+		cert, _ := cl.GetCertificate(nil)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.SerialNumber.Int64()
+	}
+
+	waitSerial := func(expected int64) {
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(20 * time.Millisecond) {
+			if serial() == expected {
+				return
+			}
+		}
+		t.Fatalf("certificate %d has not been loaded, current is %d", expected, serial())
+	}
+
+	waitSerial(1)
+
+	writeTestCert(t, certPath, keyPath, 2)
+	waitSerial(2)
+
+	// invalid files keep the previous certificate
+	err = os.WriteFile(keyPath, []byte("invalid"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if serial() != 2 {
+		t.Fatal("invalid certificate has replaced the previous one")
+	}
+
+	writeTestCert(t, certPath, keyPath, 3)
+	waitSerial(3)
+}
+
+func TestRTSPSConfig(t *testing.T) {
+	cfg := Config{RTSPPort: 9222, Encryption: conf.EncryptionOptional, RTSPSPort: 9322}
+	if cfg.Validate() == nil {
+		t.Fatal("encryption without certificate must be rejected")
+	}
+
+	cfg.ServerCert = "server.crt"
+	cfg.ServerKey = "server.key"
+
+	err := cfg.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts := cfg.RTSPSOptions(); opts.Address != ":9322" || opts.ServerCert != "server.crt" {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+
+	cfg.Encryption = conf.EncryptionStrict
+	cfg.UDP = true
+	if cfg.Validate() == nil {
+		t.Fatal("strict encryption with udp must be rejected")
+	}
+
+	cfg.Encryption = conf.EncryptionNo
+	if cfg.RTSPSOptions() != (core.RTSPSOptions{}) {
+		t.Fatal("RTSPS server must be disabled")
+	}
+}
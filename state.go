@@ -0,0 +1,237 @@
+package h265_transcoder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// stateVersion is the current on-disk state format version
+const stateVersion = 1
+
+type stateFile struct {
+	Version int          `json:"version"`
+	Saved   time.Time    `json:"saved"`
+	Units   []UnitConfig `json:"units"`
+}
+
+// stateMigrations upgrade raw state of version N to version N+1
+var stateMigrations = map[int]func(raw []byte) ([]byte, error){
+	// version 0 is a bare array of units, as it is posted to /create
+	0: func(raw []byte) ([]byte, error) {
+		var units []UnitConfig
+		err := json.Unmarshal(raw, &units)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(stateFile{Version: 1, Units: units})
+	},
+}
+
+func stateFileVersion(raw []byte) (int, error) {
+	var probe any
+	err := json.Unmarshal(raw, &probe)
+	if err != nil {
+		return 0, err
+	}
+
+	switch v := probe.(type) {
+	case []any:
+		return 0, nil
+	case map[string]any:
+		version, ok := v["version"].(float64)
+		if !ok {
+			return 0, errors.New("state version is missing")
+		}
+		return int(version), nil
+	default:
+		return 0, errors.New("unexpected state format")
+	}
+}
+
+// decodeState returns valid and invalid units of the state, invalid units do not fail the whole state
+func decodeState(raw []byte) ([]UnitConfig, []UnitConfig, error) {
+	version, err := stateFileVersion(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if version > stateVersion {
+		return nil, nil, fmt.Errorf("state version %d is newer than supported version %d", version, stateVersion)
+	}
+
+	for ; version < stateVersion; version++ {
+		migrate, exist := stateMigrations[version]
+		if !exist {
+			return nil, nil, fmt.Errorf("no migration from state version %d", version)
+		}
+
+		raw, err = migrate(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("state migration from version %d failed: %w", version, err)
+		}
+	}
+
+	var state stateFile
+	err = json.Unmarshal(raw, &state)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var units, invalid []UnitConfig
+	for _, u := range state.Units {
+		err = u.Validate()
+		if err != nil {
+			log.Printf("state: unit '%s' is skipped: %s\n", u.ID, err)
+			invalid = append(invalid, u)
+			continue
+		}
+		units = append(units, u)
+	}
+
+	return units, invalid, nil
+}
+
+// StateStore keeps unit configurations in a file, so they survive service restarts.
+// Copy of the state is kept as <path>.bak and used if main file is missing or corrupted.
+type StateStore struct {
+	path string
+	m    sync.Mutex
+}
+
+func NewStateStore(path string) *StateStore {
+	return &StateStore{path: path}
+}
+
+func (s *StateStore) backupPath() string {
+	return s.path + ".bak"
+}
+
+// Load returns stored units and units which are invalid, missing state file is not an error
+func (s *StateStore) Load() ([]UnitConfig, []UnitConfig, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	units, invalid, err := s.loadFile(s.path)
+	if err == nil {
+		return units, invalid, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		log.Printf("state: %s is corrupted: %s\n", s.path, err)
+		s.quarantine(s.path)
+	}
+
+	backupUnits, backupInvalid, backupErr := s.loadFile(s.backupPath())
+	if backupErr == nil {
+		log.Printf("state: restored from backup %s\n", s.backupPath())
+		return backupUnits, backupInvalid, nil
+	}
+
+	if errors.Is(backupErr, os.ErrNotExist) {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	log.Printf("state: %s is corrupted: %s\n", s.backupPath(), backupErr)
+	s.quarantine(s.backupPath())
+
+	return nil, nil, err
+}
+
+func (s *StateStore) loadFile(path string) ([]UnitConfig, []UnitConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return decodeState(raw)
+}
+
+// quarantine moves corrupted file aside, so it is not overwritten and could be inspected
+func (s *StateStore) quarantine(path string) {
+	dst := fmt.Sprintf("%s.corrupt-%d", path, time.Now().Unix())
+
+	err := os.Rename(path, dst)
+	if err != nil {
+		log.Printf("state: %s\n", err)
+		return
+	}
+
+	log.Printf("state: corrupted file moved to %s\n", dst)
+}
+
+// Save atomically replaces state file with given units
+func (s *StateStore) Save(units []UnitConfig) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	sort.Slice(units, func(i, j int) bool {
+		return units[i].ID < units[j].ID
+	})
+
+	raw, err := json.MarshalIndent(stateFile{
+		Version: stateVersion,
+		Saved:   time.Now(),
+		Units:   units,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(s.path, raw)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.backupPath(), raw)
+}
+
+// writeFileAtomic writes data to temporary file in the same directory and renames it over path
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	// make rename durable, not supported on every platform
+	d, err := os.Open(dir)
+	if err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+
+	return nil
+}
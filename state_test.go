@@ -0,0 +1,94 @@
+package h265_transcoder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store := NewStateStore(path)
+
+	units, _, err := store.Load()
+	if err != nil || len(units) != 0 {
+		t.Fatalf("unexpected result for missing state: %v, %v", units, err)
+	}
+
+	err = store.Save([]UnitConfig{
+		{ID: "b", Source: "rtsp://127.0.0.1/b", Restart: RestartPolicy{Mode: RestartNever}},
+		{ID: "a", Source: "rtsp://127.0.0.1/a", Profile: "small"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	units, _, err = store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 2 || units[0].ID != "a" || units[0].Profile != "small" || units[1].Restart.Mode != RestartNever {
+		t.Fatalf("unexpected units: %+v", units)
+	}
+
+	// corrupted state is replaced by backup
+	err = os.WriteFile(path, []byte("{\"version\":1,"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	units, _, err = store.Load()
+	if err != nil || len(units) != 2 {
+		t.Fatalf("unexpected result for corrupted state: %v, %v", units, err)
+	}
+}
+
+func TestStateMigration(t *testing.T) {
+	units, _, err := decodeState([]byte(`[{"id":"a","source":"rtsp://127.0.0.1/a"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 1 || units[0].ID != "a" {
+		t.Fatalf("unexpected units: %+v", units)
+	}
+
+	_, _, err = decodeState([]byte(`{"version":99,"units":[]}`))
+	if err == nil {
+		t.Fatal("newer state version expected to fail")
+	}
+}
+
+func TestStateInvalidUnit(t *testing.T) {
+	units, invalid, err := decodeState([]byte(`{"version":1,"units":[{"id":"a","source":"rtsp://127.0.0.1/a"},{"id":"b"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 1 || units[0].ID != "a" || len(invalid) != 1 || invalid[0].ID != "b" {
+		t.Fatalf("unexpected units: %+v, invalid: %+v", units, invalid)
+	}
+}
+
+func TestAddUnrestoredUnit(t *testing.T) {
+	instance := NewInstance(context.Background(), 0, 0, DefaultBackoff, 0, false)
+	instance.SetStateFile(filepath.Join(t.TempDir(), "state.json"))
+
+	conf := UnitConfig{ID: "a", Source: "rtsp://127.0.0.1/a"}
+	instance.unrestored[conf.ID] = conf
+
+	_, err := instance.AddUnit(conf)
+	if err == nil || !strings.Contains(err.Error(), "not restored") {
+		t.Fatalf("unit kept in state has been replaced: %v", err)
+	}
+
+	err = instance.RemoveUnit(conf.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	units, _, err := instance.state.Load()
+	if err != nil || len(units) != 0 {
+		t.Fatalf("unexpected state: %+v, %v", units, err)
+	}
+}
@@ -4,12 +4,15 @@ import (
 	"bufio"
 	"context"
 	"errors"
+	"fearpro13/h265_transcoder/mediamtx/core"
 	"fmt"
 	"io"
 	"log"
 	"net/url"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 )
@@ -18,6 +21,9 @@ const (
 	StatusStopped = "stopped"
 	StatusOk      = "ok"
 	StatusError   = "error"
+	StatusFailed  = "failed"
+	// StatusIdle on-demand unit is waiting for readers
+	StatusIdle = "idle"
 )
 
 var FFMpegPath = "/usr/bin/ffmpeg"
@@ -27,45 +33,154 @@ var IdrInterval uint64 = 60
 var TranscodeUseGPU = false
 
 type Source struct {
-	id   string
-	from url.URL
-	to   url.URL
+	id      string
+	from    url.URL
+	to      url.URL
+	profile Profile
+	// copyVideo remuxes source video instead of encoding it by profile
+	copyVideo bool
+	// renditions are encoded from split source video and published next to the unit stream
+	renditions []renditionOutput
+	// pipe makes ffmpeg write outputs as MPEG-TS to pipes instead of publishing them to rtsp server
+	pipe bool
+	// ingest is true when source is read from unit ingest path of rtsp server
+	ingest bool
+	// user is unit credential of rtsp server, it's added to urls passed to ffmpeg only, so it's never reported
+	user *url.Userinfo
+}
+
+type renditionOutput struct {
+	name    string
+	to      url.URL
+	profile Profile
 }
 
 type Transcoder struct {
-	source  Source
-	proc    *exec.Cmd
-	status  string
-	running atomic.Bool
-	ctx     context.Context
-	ctxF    context.CancelFunc
-	stdErr  io.ReadCloser
+	source      Source
+	proc        *exec.Cmd
+	running     atomic.Bool
+	ctx         context.Context
+	ctxF        context.CancelFunc
+	stdErr      io.ReadCloser
+	progressOut io.ReadCloser
+	progress    atomic.Pointer[Progress]
+
+	// status is written by Start and by ffmpeg exit, it's read by supervisor, status, metrics and events concurrently
+	status atomic.Value
+
+	// publisher publishes outputs of pipe ingest, see Source.pipe
+	publisher       ingestPublisher
+	publishers      []*core.Publisher
+	publishersMutex sync.Mutex
+
+	// onExit is called with ffmpeg exit code and last stderr lines once ffmpeg exits, stopped is true when it's killed by Stop
+	onExit     func(code int, stderr []string, stopped bool)
+	stderrTail []string
+	tailMutex  sync.Mutex
 }
 
-func NewSource(id string, from string, to string) Source {
+// stderrTailSize is how many last ffmpeg stderr lines are reported on exit
+const stderrTailSize = 10
+
+func NewSource(id string, from string, to string, profile Profile) (Source, error) {
 	fromParsed, err := url.Parse(from)
 	if err != nil {
-		log.Fatal(err)
+		return Source{}, err
 	}
 
 	toParsed, err := url.Parse(to)
 	if err != nil {
-		log.Fatal(err)
+		return Source{}, err
 	}
 
 	return Source{
-		id:   id,
-		from: *fromParsed,
-		to:   *toParsed,
+		id:      id,
+		from:    *fromParsed,
+		to:      *toParsed,
+		profile: profile,
+	}, nil
+}
+
+func (s Source) Args() ([]string, error) {
+	err := s.profile.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"-y", "-fflags", "+igndts"}
+	if s.from.Scheme == "rtsp" || s.from.Scheme == "rtsps" {
+		args = append(args, "-rtsp_transport", "tcp")
+	}
+	from := s.input()
+	args = append(args, "-i", from.String())
+
+	if len(s.renditions) > 0 {
+		// source video is decoded once and split between renditions
+		args = append(args, "-filter_complex", s.renditionsFilter(), "-map", "0:v:0", "-map", "0:a?")
+	}
+
+	if s.copyVideo {
+		args = append(args, s.profile.CopyArgs()...)
+	} else {
+		args = append(args, s.profile.Args()...)
+	}
+
+	args = append(args, s.output(s.to, 1)...)
+
+	for i, r := range s.renditions {
+		err = r.profile.Validate()
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, "-map", fmt.Sprintf("[r%d]", i), "-map", "0:a?")
+		args = append(args, r.profile.RenditionArgs()...)
+		args = append(args, s.output(r.to, pipeRenditionsFD+i)...)
+	}
+
+	return args, nil
+}
+
+// output returns ffmpeg arguments of output published to rtsp url or written to pipe with descriptor fd
+func (s Source) output(to url.URL, fd int) []string {
+	if s.pipe {
+		return []string{"-f", "mpegts", fmt.Sprintf("pipe:%d", fd)}
 	}
+	to.User = s.user
+	return []string{"-f", "rtsp", "-rtsp_transport", "tcp", to.String()}
+}
+
+// input returns source url ffmpeg reads, unit credential is added if source is read from rtsp server
+func (s Source) input() url.URL {
+	from := s.from
+	if s.ingest {
+		from.User = s.user
+	}
+	return from
+}
+
+// renditionsFilter returns filter graph splitting source video into scaled renditions [r0], [r1]...
+func (s Source) renditionsFilter() string {
+	var split, scale strings.Builder
+
+	split.WriteString(fmt.Sprintf("[0:v:0]split=%d", len(s.renditions)))
+
+	for i, r := range s.renditions {
+		split.WriteString(fmt.Sprintf("[s%d]", i))
+		scale.WriteString(fmt.Sprintf(";[s%d]%s[r%d]", i, r.profile.ScaleFilter(), i))
+	}
+
+	return split.String() + scale.String()
 }
 
 func NewTranscoder(source Source) *Transcoder {
-	return &Transcoder{
+	t := &Transcoder{
 		source:  source,
-		status:  StatusStopped,
 		running: atomic.Bool{},
 	}
+	t.status.Store(StatusStopped)
+
+	return t
 }
 
 func (t *Transcoder) Start(ctx context.Context) error {
@@ -74,41 +189,102 @@ func (t *Transcoder) Start(ctx context.Context) error {
 	}
 
 	// start ffmpeg
-	argsStr := fmt.Sprintf("-y -fflags +igndts -rtsp_transport tcp -i %s -c:a copy -c:v libx264 -crf 20 -b:v 500k -max_muxing_queue_size 1024 -bf 0 -f rtsp -rtsp_transport tcp %s", t.source.from.String(), t.source.to.String())
+	args, err := t.source.Args()
+	if err != nil {
+		t.status.Store(StatusError)
+		return err
+	}
+
+	// machine-readable progress is written to stdout or to its own pipe, stderr keeps only warnings and errors
+	progressOutput := "pipe:1"
+	if t.source.pipe {
+		progressOutput = fmt.Sprintf("pipe:%d", pipeProgressFD)
+	}
+
+	args = append([]string{"-nostats", "-progress", progressOutput}, args...)
 
-	argsSplit := strings.Split(argsStr, " ")
-	cmd := exec.Command(FFMpegPath, argsSplit...)
+	cmd := exec.Command(FFMpegPath, args...)
 
 	stdErr, err := cmd.StderrPipe()
 	if err != nil {
-		t.status = StatusError
+		t.status.Store(StatusError)
 
 		return err
 	}
 
 	t.stdErr = stdErr
 
+	stdOut, err := cmd.StdoutPipe()
+	if err != nil {
+		t.status.Store(StatusError)
+
+		return err
+	}
+
+	t.progressOut = stdOut
+
+	var ingests []pipeIngest
+	var pipeWriters []*os.File
+
+	if t.source.pipe {
+		t.progressOut, ingests, pipeWriters, err = t.setupPipes(cmd, stdOut)
+		if err != nil {
+			t.status.Store(StatusError)
+			return err
+		}
+	}
+
+	t.progress.Store(nil)
+
 	err = cmd.Start()
+
+	// ffmpeg holds its own copies of pipe writers
+	for _, w := range pipeWriters {
+		_ = w.Close()
+	}
+
 	if err != nil {
-		t.status = StatusError
+		if t.source.pipe {
+			_ = t.progressOut.Close()
+			for _, in := range ingests[1:] {
+				_ = in.r.Close()
+			}
+		}
+
+		t.status.Store(StatusError)
 		return err
 	}
 
 	t.proc = cmd
+	t.status.Store(StatusOk)
+	t.ctx, t.ctxF = context.WithCancel(ctx)
 	t.running.Store(true)
 
 	go t.run()
+	go t.runProgress()
 
-	t.ctx, t.ctxF = context.WithCancel(ctx)
+	for _, in := range ingests {
+		go t.runIngest(in)
+	}
 
 	go func() {
 		err := cmd.Wait()
+		stopped := !t.running.Load()
+
+		// killed by Stop
+		if err != nil && stopped {
+			err = nil
+		}
+
+		if t.onExit != nil {
+			t.onExit(cmd.ProcessState.ExitCode(), t.lastStderr(), stopped)
+		}
 
 		if err != nil {
-			t.status = StatusError
+			t.status.Store(StatusError)
 			log.Println(fmt.Sprintf("transcoder #%s(%s): %s", t.source.id, t.source.from.String(), err))
 		} else {
-			t.status = StatusStopped
+			t.status.Store(StatusStopped)
 		}
 
 		t.ctxF()
@@ -128,8 +304,6 @@ func (t *Transcoder) run() {
 	var line []byte
 	var pErr, err error
 
-	t.status = StatusOk
-
 	reader := bufio.NewReader(t.stdErr)
 
 	for t.running.Load() {
@@ -147,10 +321,41 @@ func (t *Transcoder) run() {
 			}
 		} else {
 			log.Println(fmt.Sprintf("transcoder #%s(%s): %s", t.source.id, t.source.from.String(), line))
+			t.addStderr(string(line))
 		}
 	}
 }
 
+func (t *Transcoder) addStderr(line string) {
+	t.tailMutex.Lock()
+	defer t.tailMutex.Unlock()
+
+	if len(t.stderrTail) == stderrTailSize {
+		t.stderrTail = t.stderrTail[1:]
+	}
+	t.stderrTail = append(t.stderrTail, line)
+}
+
+// lastStderr returns copy of last ffmpeg stderr lines
+func (t *Transcoder) lastStderr() []string {
+	t.tailMutex.Lock()
+	defer t.tailMutex.Unlock()
+
+	return append([]string{}, t.stderrTail...)
+}
+
+func (t *Transcoder) runProgress() {
+	defer t.progressOut.Close()
+
+	err := readProgress(t.progressOut, func(p Progress) {
+		t.progress.Store(&p)
+	})
+
+	if err != nil && t.running.Load() {
+		log.Println(fmt.Sprintf("transcoder #%s(%s): progress: %s", t.source.id, t.source.from.String(), err))
+	}
+}
+
 func (t *Transcoder) Stop() error {
 	if !t.running.Load() {
 		return errors.New("not running")
@@ -159,12 +364,34 @@ func (t *Transcoder) Stop() error {
 	t.running.Store(false)
 
 	t.ctxF()
-	_ = t.proc.Process.Release()
+	// released process could not be killed, it is reaped by cmd.Wait
 	_ = t.proc.Process.Kill()
 
+	t.closePublishers()
+
 	return nil
 }
 
 func (t *Transcoder) Status() string {
-	return t.status
+	status, _ := t.status.Load().(string)
+	return status
+}
+
+// ProcessStats returns ffmpeg process usage, false if ffmpeg is not running or usage is not available
+func (t *Transcoder) ProcessStats() (ProcessStats, bool) {
+	if !t.running.Load() {
+		return ProcessStats{}, false
+	}
+
+	stats, err := readProcessStats(t.proc.Process.Pid)
+	if err != nil {
+		return ProcessStats{}, false
+	}
+
+	return stats, true
+}
+
+// Progress returns last ffmpeg progress snapshot, nil if ffmpeg has not reported yet
+func (t *Transcoder) Progress() *Progress {
+	return t.progress.Load()
 }
@@ -0,0 +1,33 @@
+package h265_transcoder
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTranscoderStatus(t *testing.T) {
+	// invalid profile fails the start before ffmpeg is run
+	tc := NewTranscoder(Source{id: "cam1"})
+	if tc.Status() != StatusStopped {
+		t.Fatalf("unexpected status %s", tc.Status())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = tc.Status()
+		}
+	}()
+
+	err := tc.Start(context.Background())
+	<-done
+
+	if err == nil {
+		t.Fatal("transcoder with invalid profile has been started")
+	}
+
+	if tc.Status() != StatusError {
+		t.Fatalf("unexpected status %s", tc.Status())
+	}
+}
@@ -0,0 +1,179 @@
+package h265_transcoder
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SourcePublisher is a unit source which is published to path {id}/ingest with RTMP or RTSP, instead of being pulled
+const SourcePublisher = "publisher"
+
+// ingestName is the path name of published source, relative to unit path
+const ingestName = "ingest"
+
+// UnitConfig is everything needed to (re)create a unit
+type UnitConfig struct {
+	ID      string        `json:"id"`
+	Source  string        `json:"source"`
+	Profile string        `json:"profile,omitempty"`
+	Restart RestartPolicy `json:"restart"`
+	// OnDemand unit starts transcoder on first reader and stops it after the last one leaves
+	OnDemand bool      `json:"onDemand,omitempty"`
+	Mode     VideoMode `json:"mode,omitempty"`
+	// Renditions are produced by the same ffmpeg in addition to unit stream
+	Renditions []Rendition `json:"renditions,omitempty"`
+	// Record writes unit stream into segment files while unit is running
+	Record bool `json:"record,omitempty"`
+}
+
+// Rendition is an additional unit stream encoded by its own profile and published to path {id}/{name}
+type Rendition struct {
+	Name string `json:"name"`
+	// Profile defaults to rendition name
+	Profile string `json:"profile,omitempty"`
+}
+
+func (r Rendition) ProfileName() string {
+	if r.Profile == "" {
+		return r.Name
+	}
+	return r.Profile
+}
+
+// profileNames returns names of all profiles used by unit, including renditions ones
+func (c UnitConfig) profileNames() []string {
+	names := []string{profileName(c.Profile)}
+	for _, r := range c.Renditions {
+		names = append(names, r.ProfileName())
+	}
+	return names
+}
+
+func (c UnitConfig) Validate() error {
+	if c.ID == "" {
+		return errors.New("unit id is required")
+	}
+
+	// unit id is the first segment of its paths, renditions and ingest paths are nested in it
+	if !reProfileName.MatchString(c.ID) || strings.Trim(c.ID, ".") == "" {
+		return fmt.Errorf("unit '%s': invalid id, only letters, digits, '_', '-' and '.' are allowed", c.ID)
+	}
+
+	if c.Source == "" {
+		return errors.New("unit source is required")
+	}
+
+	_, err := url.Parse(c.Source)
+	if err != nil {
+		return fmt.Errorf("unit source: %w", err)
+	}
+
+	err = c.Mode.Validate()
+	if err != nil {
+		return err
+	}
+
+	if len(c.Renditions) > 0 && c.OnDemand {
+		return errors.New("renditions are not supported by on-demand units")
+	}
+
+	names := make(map[string]struct{}, len(c.Renditions))
+	for _, r := range c.Renditions {
+		if !reProfileName.MatchString(r.Name) {
+			return fmt.Errorf("rendition '%s': invalid name", r.Name)
+		}
+
+		if r.Name == ingestName {
+			return fmt.Errorf("rendition '%s': name is reserved for published source", r.Name)
+		}
+
+		if _, exist := names[r.Name]; exist {
+			return fmt.Errorf("rendition '%s' is declared twice", r.Name)
+		}
+		names[r.Name] = struct{}{}
+	}
+
+	return c.Restart.Validate()
+}
+
+// UnitPatch holds unit settings changed by update, missing fields are kept as is
+type UnitPatch struct {
+	Source   *string        `json:"source,omitempty"`
+	Profile  *string        `json:"profile,omitempty"`
+	Restart  *RestartPolicy `json:"restart,omitempty"`
+	OnDemand *bool          `json:"onDemand,omitempty"`
+	Mode     *VideoMode     `json:"mode,omitempty"`
+	// Renditions replace all unit renditions, empty list removes them
+	Renditions *[]Rendition `json:"renditions,omitempty"`
+	Record     *bool        `json:"record,omitempty"`
+}
+
+func (p UnitPatch) Apply(conf UnitConfig) UnitConfig {
+	if p.Source != nil {
+		conf.Source = *p.Source
+	}
+
+	if p.Profile != nil {
+		conf.Profile = *p.Profile
+	}
+
+	if p.Restart != nil {
+		conf.Restart = *p.Restart
+	}
+
+	if p.OnDemand != nil {
+		conf.OnDemand = *p.OnDemand
+	}
+
+	if p.Mode != nil {
+		conf.Mode = *p.Mode
+	}
+
+	if p.Renditions != nil {
+		conf.Renditions = *p.Renditions
+	}
+
+	if p.Record != nil {
+		conf.Record = *p.Record
+	}
+
+	return conf
+}
+
+type Unit struct {
+	id   string
+	path Source
+	conf UnitConfig
+	// managed unit is declared by configuration file
+	managed bool
+}
+
+// ingests reports whether unit source is published to unit ingest path
+func (u Unit) ingests() bool {
+	return u.conf.Source == SourcePublisher
+}
+
+// paths returns names of unit path, its renditions paths and its ingest path
+func (u Unit) paths() []string {
+	paths := []string{u.id}
+	for _, r := range u.path.renditions {
+		paths = append(paths, renditionPath(u.id, r.name))
+	}
+
+	if u.ingests() {
+		paths = append(paths, ingestPath(u.id))
+	}
+
+	return paths
+}
+
+func (u Unit) hasRendition(name string) bool {
+	for _, r := range u.path.renditions {
+		if r.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package h265_transcoder
+
+import (
+	"context"
+	"errors"
+	"fearpro13/h265_transcoder/mediamtx/test"
+	"testing"
+)
+
+func TestUnitConfigValidate(t *testing.T) {
+	for _, c := range []struct {
+		conf  UnitConfig
+		valid bool
+	}{
+		{UnitConfig{ID: "cam1", Source: "rtsp://10.0.0.5/stream1"}, true},
+		{UnitConfig{ID: "cam1", Source: SourcePublisher}, true},
+		{UnitConfig{ID: "cam1"}, false},
+		{UnitConfig{Source: "rtsp://10.0.0.5/stream1"}, false},
+		{UnitConfig{ID: "cam-1_hd.v2", Source: "rtsp://10.0.0.5/stream1"}, true},
+		{UnitConfig{ID: "cam/720p", Source: "rtsp://10.0.0.5/stream1"}, false},
+		{UnitConfig{ID: "cam 1", Source: "rtsp://10.0.0.5/stream1"}, false},
+		{UnitConfig{ID: "..", Source: "rtsp://10.0.0.5/stream1"}, false},
+		{UnitConfig{ID: "cam1", Source: "rtsp://10.0.0.5:port/stream1"}, false},
+		{UnitConfig{ID: "cam1", Source: "rtsp://[::1/stream1"}, false},
+		{UnitConfig{ID: "cam1", Source: "rtsp://10.0.0.5/%zz"}, false},
+		{UnitConfig{ID: "cam1", Source: "rtsp://10.0.0.5/stream1", Renditions: []Rendition{{Name: ingestName}}}, false},
+	} {
+		err := c.conf.Validate()
+		if (err == nil) != c.valid {
+			t.Errorf("%+v: unexpected result %v", c.conf, err)
+		}
+	}
+}
+
+func TestAddUnitPathExists(t *testing.T) {
+	instance := NewInstance(context.Background(), uint16(test.FreeTCPPort(t)), 0, DefaultBackoff, 0, false)
+
+	err := instance.rtspHandler.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.rtspHandler.Stop()
+
+	// path is used by somebody else
+	err = instance.rtspHandler.AddPath("cam1/720p")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = instance.AddUnit(UnitConfig{
+		ID:         "cam1",
+		Source:     "rtsp://10.0.0.5/stream1",
+		Renditions: []Rendition{{Name: "720p", Profile: DefaultProfileName}},
+	})
+	if !errors.Is(err, errPathExists) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if instance.GetUnit("cam1") != nil || instance.rtspHandler.PathExist("cam1") {
+		t.Fatal("unit has been added")
+	}
+
+	if !instance.rtspHandler.PathExist("cam1/720p") {
+		t.Fatal("existing path has been removed")
+	}
+}
+
+func TestAddUnitConcurrently(t *testing.T) {
+	instance := NewInstance(context.Background(), uint16(test.FreeTCPPort(t)), 0, DefaultBackoff, 0, false)
+
+	err := instance.rtspHandler.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.rtspHandler.Stop()
+
+	// on-demand unit is started without ffmpeg
+	conf := UnitConfig{ID: "cam1", Source: "rtsp://10.0.0.5/stream1", OnDemand: true}
+
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := instance.addUnit(conf, false)
+			errs <- err
+		}()
+	}
+
+	added := 0
+	for i := 0; i < cap(errs); i++ {
+		if <-errs == nil {
+			added++
+		}
+	}
+
+	if added != 1 || len(instance.unitsList()) != 1 {
+		t.Fatalf("unit has been added %d times", added)
+	}
+
+	err = instance.RemoveUnit(conf.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// id reserved by failed addition is released
+	err = instance.rtspHandler.AddPath(conf.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = instance.addUnit(conf, false)
+	if !errors.Is(err, errPathExists) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	err = instance.rtspHandler.RemovePath(conf.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = instance.addUnit(conf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,113 @@
+package h265_transcoder
+
+import (
+	"fmt"
+	"time"
+)
+
+// unitHealth keeps unit activity and restart history between unit restarts
+type unitHealth struct {
+	lastBytesReceived uint64
+	lastFrame         uint64
+	lastActivity      time.Time
+	started           time.Time
+	restarts          int
+	lastRestart       time.Time
+	lastRestartReason string
+
+	// consecutive restart attempts, reset after stable run
+	attempts      int
+	nextAttempt   time.Time
+	pendingReason string
+	// terminal status, unit is not supervised anymore when set
+	terminal       string
+	terminalReason string
+}
+
+func newUnitHealth() *unitHealth {
+	return &unitHealth{
+		lastActivity: time.Now(),
+		started:      time.Now(),
+	}
+}
+
+// reset is called every time unit transcoder is (re)started, giving it a full silence window to start up
+func (h *unitHealth) reset() {
+	h.lastBytesReceived = 0
+	h.lastFrame = 0
+	h.lastActivity = time.Now()
+	h.started = time.Now()
+}
+
+func (h *unitHealth) restarted(reason string) {
+	h.restarts++
+	h.attempts++
+	h.lastRestart = time.Now()
+	h.lastRestartReason = reason
+}
+
+func (h *unitHealth) schedule(delay time.Duration, reason string) {
+	h.nextAttempt = time.Now().Add(delay)
+	h.pendingReason = reason
+}
+
+func (h *unitHealth) terminate(status string, reason string) {
+	h.terminal = status
+	h.terminalReason = reason
+	h.nextAttempt = time.Time{}
+}
+
+// stable resets consecutive attempts counter once unit has been running long enough
+func (h *unitHealth) stable(after time.Duration) {
+	if h.attempts > 0 && time.Since(h.started) >= after {
+		h.attempts = 0
+	}
+}
+
+// observe records publisher byte counter and ffmpeg frame counter,
+// unit is considered active while any of them keeps growing
+func (h *unitHealth) observe(bytesReceived uint64, frame uint64) {
+	if bytesReceived != h.lastBytesReceived || frame != h.lastFrame {
+		h.lastBytesReceived = bytesReceived
+		h.lastFrame = frame
+		h.lastActivity = time.Now()
+	}
+}
+
+// stalled returns reason if unit has been silent longer than timeout, zero timeout disables detection
+func (h *unitHealth) stalled(timeout time.Duration) (bool, string) {
+	if timeout <= 0 {
+		return false, ""
+	}
+
+	silence := time.Since(h.lastActivity)
+	if silence < timeout {
+		return false, ""
+	}
+
+	return true, fmt.Sprintf("unit is stalled, no frames or bytes received for %s", silence.Truncate(time.Second))
+}
+
+func (h *unitHealth) status() map[string]any {
+	res := map[string]any{
+		"restarts":     h.restarts,
+		"attempts":     h.attempts,
+		"lastActivity": h.lastActivity,
+	}
+
+	if h.restarts > 0 {
+		res["lastRestart"] = h.lastRestart
+		res["lastRestartReason"] = h.lastRestartReason
+	}
+
+	if !h.nextAttempt.IsZero() {
+		res["nextAttempt"] = h.nextAttempt
+		res["nextAttemptReason"] = h.pendingReason
+	}
+
+	if h.terminal != "" {
+		res["terminalReason"] = h.terminalReason
+	}
+
+	return res
+}
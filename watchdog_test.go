@@ -0,0 +1,73 @@
+package h265_transcoder
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUnitHealthStalled(t *testing.T) {
+	for _, c := range []struct {
+		name          string
+		silence       time.Duration
+		bytesReceived uint64
+		frame         uint64
+		timeout       time.Duration
+		stalled       bool
+	}{
+		{"active", time.Second, 0, 0, 30 * time.Second, false},
+		{"silent", time.Minute, 0, 0, 30 * time.Second, true},
+		{"bytes received", time.Minute, 100, 0, 30 * time.Second, false},
+		{"frame encoded", time.Minute, 0, 5, 30 * time.Second, false},
+		{"detection disabled", time.Minute, 0, 0, 0, false},
+	} {
+		h := newUnitHealth()
+		h.lastActivity = time.Now().Add(-c.silence)
+
+		h.observe(c.bytesReceived, c.frame)
+
+		stalled, reason := h.stalled(c.timeout)
+		if stalled != c.stalled || (reason != "") != c.stalled {
+			t.Errorf("%s: unexpected %v '%s'", c.name, stalled, reason)
+		}
+	}
+}
+
+func TestUnitHealthObserve(t *testing.T) {
+	h := newUnitHealth()
+	h.observe(100, 5)
+
+	// unchanged counters are not an activity
+	h.lastActivity = time.Now().Add(-time.Minute)
+	h.observe(100, 5)
+
+	stalled, _ := h.stalled(30 * time.Second)
+	if !stalled {
+		t.Fatal("unit with unchanged counters is not stalled")
+	}
+
+	// counters are reset by transcoder restart
+	h.reset()
+	h.observe(0, 0)
+
+	stalled, _ = h.stalled(30 * time.Second)
+	if stalled {
+		t.Fatal("restarted unit is stalled")
+	}
+}
+
+func TestUnitHealthStable(t *testing.T) {
+	h := newUnitHealth()
+	h.restarted("failed")
+	h.restarted("failed")
+
+	h.stable(time.Minute)
+	if h.attempts != 2 {
+		t.Fatalf("attempts have been reset too early: %d", h.attempts)
+	}
+
+	h.started = time.Now().Add(-2 * time.Minute)
+	h.stable(time.Minute)
+	if h.attempts != 0 || h.restarts != 2 {
+		t.Fatalf("unexpected attempts %d, restarts %d", h.attempts, h.restarts)
+	}
+}